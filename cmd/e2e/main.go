@@ -0,0 +1,172 @@
+// e2e поднимает сервис, заглушку системы расчета и временную базу Postgres и прогоняет по ним
+// сценарий пользователя: регистрация, загрузка заказа, начисление, списание, проверка баланса.
+// Запускается из корня репозитория: go run ./cmd/e2e
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/selftest"
+)
+
+// accrualStub - начисление заглушки системы расчета по любому заказу
+const accrualStub = 500
+
+func main() {
+	bin := flag.String("bin", "", "gophermart binary, empty - built from ./cmd/gophermart")
+	pgBin := flag.String("pg-bin", "", "directory with initdb and pg_ctl, empty - looked up in PATH")
+	uri := flag.String("d", "", "uri of a running postgres: a temporary database is created there instead of starting a server")
+	timeout := flag.Duration("timeout", 2*time.Minute, "how long to wait for the service and for the accrual")
+	flag.Parse()
+
+	if err := run(*bin, *pgBin, *uri, *timeout); err != nil {
+		log.Fatal("e2e failed: ", err)
+	}
+
+	log.Print("e2e passed")
+}
+
+func run(bin, pgBin, uri string, timeout time.Duration) error {
+	dir, err := os.MkdirTemp("", "gophermart-e2e")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	var stop func()
+	if uri == "" {
+		uri, stop, err = startPostgres(pgBin, dir)
+	} else {
+		uri, stop, err = createDatabase(uri)
+	}
+	if err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	defer stop()
+
+	accrual := httptest.NewServer(stub())
+	defer accrual.Close()
+
+	if bin == "" {
+		bin = filepath.Join(dir, "gophermart")
+		build := exec.Command("go", "build", "-o", bin, "./cmd/gophermart")
+		build.Stdout, build.Stderr = os.Stderr, os.Stderr
+		if err = build.Run(); err != nil {
+			return fmt.Errorf("build: %w", err)
+		}
+	}
+
+	addr, err := freeAddr()
+	if err != nil {
+		return err
+	}
+
+	service := exec.Command(bin)
+	service.Env = append(os.Environ(),
+		"RUN_ADDRESS="+addr,
+		"DATABASE_URI="+uri,
+		"ACCRUAL_SYSTEM_ADDRESS="+accrual.URL,
+		"ACCRUAL_POINT_RATE=1",
+		"SHUTDOWN_DRAIN=0s",
+	)
+	service.Stdout, service.Stderr = os.Stderr, os.Stderr
+	if err = service.Start(); err != nil {
+		return fmt.Errorf("start service: %w", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- service.Wait()
+	}()
+	defer func() {
+		_ = service.Process.Signal(os.Interrupt)
+		<-exited
+	}()
+
+	base := "http://" + addr
+	if err = waitReady(base, exited, timeout); err != nil {
+		return err
+	}
+
+	return selftest.Run(base, timeout, 1)
+}
+
+// stub - заглушка системы расчета: первый запрос по заказу отвечает PROCESSING, следующие -
+// PROCESSED с начислением accrualStub, чтобы сценарий проходил и через повторный опрос
+func stub() http.Handler {
+	var polled sync.Map
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number, ok := strings.CutPrefix(r.URL.Path, "/api/orders/")
+		if !ok || number == "" || r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		resp := map[string]any{"order": number, "status": "PROCESSING"}
+		if _, seen := polled.LoadOrStore(number, struct{}{}); seen {
+			resp["status"], resp["accrual"] = "PROCESSED", accrualStub
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// waitReady ждет, пока сервис ответит 200 на /api/ready, или завершения его процесса
+func waitReady(base string, exited <-chan error, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/api/ready", nil)
+		if err != nil {
+			return err
+		}
+
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+
+		select {
+		case err = <-exited:
+			if err == nil {
+				err = errors.New("exited")
+			}
+			return fmt.Errorf("service: %w", err)
+		case <-ctx.Done():
+			return fmt.Errorf("service not ready in %s", timeout)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// freeAddr возвращает свободный локальный адрес для сервиса
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+
+	addr := l.Addr().String()
+	return addr, l.Close()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// startPostgres создает кластер Postgres в dir и запускает его на свободном порту. stop
+// останавливает сервер, файлы кластера удаляются вместе с dir.
+func startPostgres(binDir, dir string) (uri string, stop func(), err error) {
+	initdb, err := pgTool(binDir, "initdb")
+	if err != nil {
+		return "", nil, err
+	}
+
+	pgCtl, err := pgTool(binDir, "pg_ctl")
+	if err != nil {
+		return "", nil, err
+	}
+
+	data := filepath.Join(dir, "pgdata")
+	if err = pgRun(initdb, "-D", data, "-U", "postgres", "-A", "trust", "--no-sync"); err != nil {
+		return "", nil, fmt.Errorf("initdb: %w", err)
+	}
+
+	addr, err := freeAddr()
+	if err != nil {
+		return "", nil, err
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// сокет в dir: каталог сокетов по умолчанию может быть недоступен на запись, fsync не нужен
+	options := "-h 127.0.0.1 -p " + port + " -k " + dir + " -F"
+	if err = pgRun(pgCtl, "-D", data, "-l", filepath.Join(dir, "postgres.log"), "-o", options, "-w", "start"); err != nil {
+		return "", nil, fmt.Errorf("pg_ctl start: %w", err)
+	}
+
+	stop = func() {
+		if err := pgRun(pgCtl, "-D", data, "-m", "immediate", "-w", "stop"); err != nil {
+			log.Print("e2e: pg_ctl stop err: ", err.Error())
+		}
+	}
+
+	return "postgres://postgres@127.0.0.1:" + port + "/postgres?sslmode=disable", stop, nil
+}
+
+// createDatabase создает временную базу на сервере uri, stop удаляет ее
+func createDatabase(uri string) (string, func(), error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", nil, err
+	}
+
+	b := make([]byte, 6)
+	if _, err = rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	name := "gophermart_e2e_" + hex.EncodeToString(b)
+
+	if err = pgExec(uri, "CREATE DATABASE "+name); err != nil {
+		return "", nil, err
+	}
+
+	stop := func() {
+		if err := pgExec(uri, "DROP DATABASE IF EXISTS "+name+" WITH (FORCE)"); err != nil {
+			log.Print("e2e: drop database err: ", err.Error())
+		}
+	}
+
+	u.Path = "/" + name
+	return u.String(), stop, nil
+}
+
+// pgExec выполняет запрос на отдельном соединении: CREATE DATABASE нельзя выполнить в транзакции
+func pgExec(uri, query string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, uri)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	_, err = conn.Exec(ctx, query)
+	return err
+}
+
+// pgTool ищет программу Postgres в binDir, пустой binDir - в PATH
+func pgTool(binDir, name string) (string, error) {
+	if binDir == "" {
+		return exec.LookPath(name)
+	}
+
+	path := filepath.Join(binDir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// pgRun запускает программу Postgres, ее вывод попадает в журнал только при ошибке
+func pgRun(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+
+	return nil
+}
@@ -7,5 +7,7 @@ import (
 )
 
 func main() {
-	log.Print(server.StartServer())
+	if err := server.StartServer(); err != nil {
+		log.Fatal(err)
+	}
 }
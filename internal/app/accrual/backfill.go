@@ -0,0 +1,89 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/domain"
+)
+
+type Mismatch struct {
+	Order  string  `json:"order"`
+	Login  string  `json:"login"`
+	Stored float64 `json:"stored"`
+	Actual float64 `json:"actual"`
+	Fixed  bool    `json:"fixed"`
+}
+
+type BackfillReport struct {
+	Checked    int        `json:"checked"`
+	Mismatches []Mismatch `json:"mismatches"`
+	Failed     []string   `json:"failed"`
+}
+
+// BackfillStorage - заказы и журнал начислений, которые сверяет Backfill
+type BackfillStorage interface {
+	GetProcessedOrders(from, to time.Time) ([]database.Order, error)
+	CorrectAccrual(number string, accrual float64) error
+}
+
+// Backfill повторно опрашивает систему расчета по обработанным заказам, загруженным в [from, to),
+// и сверяет начисления. С fix расхождения исправляются компенсирующими записями журнала.
+func (p *Pool) Backfill(ctx context.Context, db BackfillStorage, from, to time.Time, fix bool) (BackfillReport, error) {
+	orders, err := db.GetProcessedOrders(from, to)
+	if err != nil {
+		return BackfillReport{}, err
+	}
+
+	report := BackfillReport{Mismatches: []Mismatch{}, Failed: []string{}}
+	for _, o := range orders {
+		actual, err := p.fetch(ctx, o.Number)
+		if err != nil {
+			log.Printf("backfill number: %s, err: %s", o.Number, err.Error())
+			report.Failed = append(report.Failed, o.Number)
+			continue
+		}
+
+		report.Checked++
+		if actual.Status != domain.StatusProcessed || actual.Accrual == o.Accrual {
+			continue
+		}
+
+		mismatch := Mismatch{Order: o.Number, Login: o.Login, Stored: o.Accrual, Actual: actual.Accrual}
+		if fix {
+			if err = db.CorrectAccrual(o.Number, actual.Accrual); err != nil {
+				log.Printf("backfill number: %s, err: %s", o.Number, err.Error())
+			} else {
+				mismatch.Fixed = true
+			}
+		}
+
+		log.Printf("backfill number: %s, stored: %g, actual: %g, fixed: %t",
+			o.Number, mismatch.Stored, mismatch.Actual, mismatch.Fixed)
+		report.Mismatches = append(report.Mismatches, mismatch)
+	}
+
+	return report, nil
+}
+
+// fetch запрашивает заказ number у системы расчета, после 429 повторяет запрос: клиент дождется конца паузы
+func (p *Pool) fetch(ctx context.Context, number string) (Order, error) {
+	for {
+		order, result, err := p.client.GetOrder(ctx, number)
+		if err != nil {
+			return Order{}, err
+		}
+
+		switch result {
+		case RateLimited:
+			continue
+		case NotRegistered:
+			return Order{}, errors.New("order is not registered in the accrual system")
+		default:
+			return order, nil
+		}
+	}
+}
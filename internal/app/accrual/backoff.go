@@ -0,0 +1,33 @@
+package accrual
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// backoff - пауза перед повторным опросом заказа без окончательного ответа: экспоненциальная
+// от baseDelay до maxDelay, пауза выбирается случайно в [предел/2, предел), чтобы повторы заказов,
+// загруженных вместе, не приходили в систему расчета одновременно. baseDelay 0 - без паузы.
+type backoff struct {
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// delay возвращает паузу перед повторным опросом номер attempt (с 1)
+func (b backoff) delay(attempt int) time.Duration {
+	if b.baseDelay <= 0 {
+		return 0
+	}
+
+	limit := b.baseDelay << min(attempt-1, 62)
+	if limit <= 0 || limit > b.maxDelay {
+		limit = b.maxDelay
+	}
+
+	half := limit / 2
+	if half <= 0 {
+		return limit
+	}
+
+	return half + rand.N(limit-half)
+}
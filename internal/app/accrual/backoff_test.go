@@ -0,0 +1,32 @@
+package accrual
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := backoff{baseDelay: time.Second, maxDelay: 10 * time.Second}
+
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{attempt: 1, min: 500 * time.Millisecond, max: time.Second},
+		{attempt: 2, min: time.Second, max: 2 * time.Second},
+		{attempt: 3, min: 2 * time.Second, max: 4 * time.Second},
+		{attempt: 5, min: 5 * time.Second, max: 10 * time.Second},
+		{attempt: 100, min: 5 * time.Second, max: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			if d := b.delay(tt.attempt); d < tt.min || d >= tt.max {
+				t.Fatalf("delay(%d) = %s, want [%s, %s)", tt.attempt, d, tt.min, tt.max)
+			}
+		}
+	}
+
+	if d := (backoff{}).delay(3); d != 0 {
+		t.Errorf("delay() without base delay = %s, want 0", d)
+	}
+}
@@ -0,0 +1,48 @@
+package accrual
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// breaker - автомат отключения опроса системы расчета. После threshold подряд неудачных опросов
+// (сетевая ошибка или 5xx) опрос останавливается на cooldown, затем один пробный опрос решает,
+// закрыть автомат или открыть снова. threshold <= 0 отключает автомат.
+type breaker struct {
+	threshold int64
+	cooldown  time.Duration
+
+	failures  atomic.Int64
+	openUntil atomic.Int64 // момент окончания паузы, unix нс; 0 - автомат закрыт
+}
+
+// down сообщает, что автомат открыт
+func (b *breaker) down() bool {
+	return b.openUntil.Load() != 0
+}
+
+// wait возвращает, сколько осталось до пробного опроса открытого автомата
+func (b *breaker) wait() time.Duration {
+	until := b.openUntil.Load()
+	if until == 0 {
+		return 0
+	}
+
+	return time.Until(time.Unix(0, until))
+}
+
+func (b *breaker) succeeded() {
+	b.failures.Store(0)
+	b.openUntil.Store(0)
+}
+
+func (b *breaker) failed() {
+	if b.threshold <= 0 {
+		return
+	}
+
+	// неудачный пробный опрос снова открывает автомат
+	if b.failures.Add(1) >= b.threshold {
+		b.openUntil.Store(time.Now().Add(b.cooldown).UnixNano())
+	}
+}
@@ -0,0 +1,37 @@
+package accrual
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &breaker{threshold: 3, cooldown: time.Minute}
+
+	b.failed()
+	b.failed()
+	if b.down() {
+		t.Fatal("down() = true after 2 failures, want false")
+	}
+
+	b.failed()
+	if !b.down() {
+		t.Fatal("down() = false after 3 failures, want true")
+	}
+	if wait := b.wait(); wait <= 0 || wait > time.Minute {
+		t.Errorf("wait() = %s, want up to 1m", wait)
+	}
+
+	b.succeeded()
+	if b.down() || b.wait() != 0 {
+		t.Error("down() = true after success, want false")
+	}
+
+	off := &breaker{}
+	for range 10 {
+		off.failed()
+	}
+	if off.down() {
+		t.Error("down() = true with threshold 0, want false")
+	}
+}
@@ -0,0 +1,152 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/domain"
+)
+
+// Result - итог запроса заказа у системы расчета. Нулевое значение - ответа нет, GetOrder вернул ошибку.
+type Result int
+
+const (
+	// Registered - заказ зарегистрирован, расчет не начат. Так же считается неизвестный статус.
+	Registered Result = iota + 1
+	// Processing - расчет начисления идет
+	Processing
+	// Processed - начисление рассчитано
+	Processed
+	// Invalid - заказ не принят к расчету, начисления не будет
+	Invalid
+	// NotRegistered - заказ не зарегистрирован в системе расчета (204)
+	NotRegistered
+	// RateLimited - превышена частота запросов (429), запросы приостановлены на Retry-After
+	RateLimited
+)
+
+func (r Result) String() string {
+	switch r {
+	case Registered:
+		return "registered"
+	case Processing:
+		return "processing"
+	case Processed:
+		return "processed"
+	case Invalid:
+		return "invalid"
+	case NotRegistered:
+		return "not registered"
+	case RateLimited:
+		return "rate limited"
+	default:
+		return "no result"
+	}
+}
+
+// ErrResponseTooLarge - ответ системы расчета больше AccrualMaxBody
+var ErrResponseTooLarge = errors.New("accrual response too large")
+
+// StatusError - ответ системы расчета с непредусмотренным кодом
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return "accrual status: " + e.Status
+}
+
+// Client - запросы к системе расчета. Запросы всех рабочих пула и сверки делят ограничение частоты
+// и автомат отключения.
+type Client struct {
+	address string
+	maxBody int64
+	http    *http.Client
+
+	limit   *limiter
+	breaker *breaker
+}
+
+// NewClient готовит клиент системы расчета, запросы идут через client
+func NewClient(conf config.Config, client *http.Client) *Client {
+	return &Client{
+		address: conf.AccrualSystemAddress,
+		maxBody: conf.AccrualMaxBody,
+		http:    client,
+		limit:   newLimiter(conf.AccrualRateLimit, conf.AccrualRateBurst),
+		breaker: &breaker{threshold: conf.AccrualBreakerFailures, cooldown: conf.AccrualBreakerCooldown},
+	}
+}
+
+// GetOrder запрашивает у системы расчета заказ number. Запрос ждет своей очереди в ограничении
+// частоты, RateLimited приостанавливает запросы всех рабочих. Заказ в ответе есть при Registered,
+// Processing, Processed и Invalid. Ошибка - запрос не дошел, непредусмотренный код (*StatusError),
+// ответ больше лимита (ErrResponseTooLarge) или не разбирается.
+func (c *Client) GetOrder(ctx context.Context, number string) (Order, Result, error) {
+	if !c.limit.wait(ctx) {
+		return Order{}, 0, ctx.Err()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.address+"/api/orders/"+number, nil)
+	if err != nil {
+		return Order{}, 0, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.breaker.failed()
+		return Order{}, 0, err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		c.breaker.failed()
+	} else {
+		c.breaker.succeeded()
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return Order{}, NotRegistered, nil
+	case http.StatusTooManyRequests:
+		c.limit.pause(retryAfter(resp.Header.Get("Retry-After")))
+		return Order{}, RateLimited, nil
+	default:
+		return Order{}, 0, &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	// ответ читается с запасом в байт сверх maxBody, чтобы отличить ответ ровно в лимит от превышающего
+	b, err := io.ReadAll(io.LimitReader(resp.Body, c.maxBody+1))
+	if err != nil {
+		return Order{}, 0, err
+	}
+
+	if int64(len(b)) > c.maxBody {
+		return Order{}, 0, fmt.Errorf("%w: exceeds %d bytes", ErrResponseTooLarge, c.maxBody)
+	}
+
+	order, err := decodeAccrual(resp.Header.Get("Content-Type"), b)
+	if err != nil {
+		return Order{}, 0, err
+	}
+
+	switch order.Status {
+	case domain.StatusProcessing:
+		return order, Processing, nil
+	case domain.StatusProcessed:
+		return order, Processed, nil
+	case domain.StatusInvalid:
+		return order, Invalid, nil
+	default:
+		return order, Registered, nil
+	}
+}
@@ -0,0 +1,72 @@
+package accrual
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientGetOrder(t *testing.T) {
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch number := strings.TrimPrefix(r.URL.Path, "/api/orders/"); number {
+		case "1":
+			_, _ = w.Write([]byte(`{"order":"1","status":"PROCESSED","accrual":500}`))
+		case "2":
+			_, _ = w.Write([]byte(`{"order":"2","status":"PROCESSING"}`))
+		case "3":
+			_, _ = w.Write([]byte(`{"order":"3","status":"REGISTERED"}`))
+		case "4":
+			_, _ = w.Write([]byte(`{"order":"4","status":"INVALID"}`))
+		case "5":
+			w.WriteHeader(http.StatusNoContent)
+		case "6":
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "7":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "8":
+			_, _ = w.Write([]byte(`{"order":"8","status":"PROCESSED","accrual":500` + strings.Repeat(" ", 100) + `}`))
+		}
+	}))
+	defer accrual.Close()
+
+	conf := poolConfig
+	conf.AccrualSystemAddress = accrual.URL
+	conf.AccrualMaxBody = 100
+	c := NewClient(conf, accrual.Client())
+
+	for _, tt := range []struct {
+		number string
+		want   Result
+	}{
+		{"1", Processed},
+		{"2", Processing},
+		{"3", Registered},
+		{"4", Invalid},
+		{"5", NotRegistered},
+		{"6", RateLimited},
+	} {
+		order, result, err := c.GetOrder(t.Context(), tt.number)
+		if err != nil {
+			t.Errorf("GetOrder(%s) err: %s", tt.number, err)
+			continue
+		}
+		if result != tt.want {
+			t.Errorf("GetOrder(%s) result = %s, want %s", tt.number, result, tt.want)
+		}
+		if tt.want == Processed && order.Accrual != 500 {
+			t.Errorf("GetOrder(%s) accrual = %g, want 500", tt.number, order.Accrual)
+		}
+	}
+
+	var statusErr *StatusError
+	if _, _, err := c.GetOrder(t.Context(), "7"); !errors.As(err, &statusErr) || statusErr.Code != http.StatusInternalServerError {
+		t.Errorf("GetOrder(7) err = %v, want status 500", err)
+	}
+
+	if _, _, err := c.GetOrder(t.Context(), "8"); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("GetOrder(8) err = %v, want ErrResponseTooLarge", err)
+	}
+}
@@ -0,0 +1,131 @@
+package accrual
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"sort"
+	"sync"
+)
+
+// accrualSchema - имена полей ответа системы расчета в одной версии схемы
+type accrualSchema struct {
+	order   string
+	status  string
+	accrual string
+}
+
+// accrualSchemas - известные версии схемы ответа. Версию сообщает параметр version заголовка
+// Content-Type (application/json; version=2), без него ответ разбирается по defaultSchema.
+// Новая версия добавляется сюда, прежние остаются, пока их отдает хоть одна система расчета.
+var accrualSchemas = map[string]accrualSchema{
+	"1": {order: "order", status: "status", accrual: "accrual"},
+	"2": {order: "order", status: "status", accrual: "amount"},
+}
+
+const defaultSchema = "1"
+
+var errNoStatus = errors.New("accrual response has no status")
+
+// drifted - уже записанные в журнал предупреждения о расхождении схемы: каждое пишется один раз,
+// а не при каждом опросе
+var drifted sync.Map
+
+// drift записывает в журнал предупреждение о расхождении ответа со схемой
+func drift(format string, args ...any) {
+	warning := fmt.Sprintf(format, args...)
+	if _, seen := drifted.LoadOrStore(warning, struct{}{}); !seen {
+		log.Print("accrual schema drift: ", warning)
+	}
+}
+
+// decodeAccrual разбирает ответ системы расчета с заголовком Content-Type contentType. Незнакомые
+// поля, неизвестная версия и сумма под именем из другой версии не мешают разбору, а записываются
+// в журнал как расхождение схемы. Ошибка - ответ не JSON-объект, нет статуса или поле не того типа.
+func decodeAccrual(contentType string, b []byte) (Order, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return Order{}, err
+	}
+
+	version := defaultSchema
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["version"] != "" {
+		version = params["version"]
+	}
+
+	schema, ok := accrualSchemas[version]
+	if !ok {
+		drift("unknown schema version %q, decoding as %s", version, defaultSchema)
+		schema = accrualSchemas[defaultSchema]
+	}
+
+	var order Order
+	if err := decodeField(fields, schema.order, &order.Number); err != nil {
+		return Order{}, err
+	}
+
+	if err := decodeField(fields, schema.status, &order.Status); err != nil {
+		return Order{}, err
+	}
+
+	if order.Status == "" {
+		return Order{}, errNoStatus
+	}
+
+	accrual := schema.accrual
+	if _, ok = fields[accrual]; !ok {
+		// сумма под именем поля из другой версии: система расчета обновилась раньше сервиса
+		for _, v := range versions() {
+			if name := accrualSchemas[v].accrual; fields[name] != nil {
+				drift("field %q instead of %q in schema version %s", name, accrual, version)
+				accrual = name
+				break
+			}
+		}
+	}
+
+	if err := decodeField(fields, accrual, &order.Accrual); err != nil {
+		return Order{}, err
+	}
+
+	known := map[string]bool{}
+	for _, s := range accrualSchemas {
+		known[s.order], known[s.status], known[s.accrual] = true, true, true
+	}
+
+	for name := range fields {
+		if !known[name] {
+			drift("unknown field %q in schema version %s", name, version)
+		}
+	}
+
+	return order, nil
+}
+
+// decodeField разбирает поле name в v, отсутствующее поле и null оставляют v нулевым
+func decodeField(fields map[string]json.RawMessage, name string, v any) error {
+	raw, ok := fields[name]
+	if !ok {
+		return nil
+	}
+
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("accrual field %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// versions возвращает известные версии схемы по порядку, чтобы выбор поля не зависел от обхода map
+func versions() []string {
+	v := make([]string, 0, len(accrualSchemas))
+	for version := range accrualSchemas {
+		v = append(v, version)
+	}
+
+	sort.Strings(v)
+
+	return v
+}
@@ -0,0 +1,77 @@
+package accrual
+
+import (
+	"testing"
+)
+
+func TestDecodeAccrual(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        Order
+		wantErr     bool
+	}{
+		{
+			name:        "Версия 1",
+			contentType: "application/json",
+			body:        `{"order":"49927398716","status":"PROCESSED","accrual":500}`,
+			want:        Order{Number: "49927398716", Status: "PROCESSED", Accrual: 500},
+		},
+		{
+			name:        "Незнакомые поля",
+			contentType: "application/json",
+			body:        `{"order":"49927398716","status":"PROCESSED","accrual":500,"currency":"RUB","meta":{"a":1}}`,
+			want:        Order{Number: "49927398716", Status: "PROCESSED", Accrual: 500},
+		},
+		{
+			name:        "Версия 2",
+			contentType: "application/json; version=2",
+			body:        `{"order":"49927398716","status":"PROCESSED","amount":500}`,
+			want:        Order{Number: "49927398716", Status: "PROCESSED", Accrual: 500},
+		},
+		{
+			name:        "Поле версии 2 без версии",
+			contentType: "application/json",
+			body:        `{"order":"49927398716","status":"PROCESSED","amount":500}`,
+			want:        Order{Number: "49927398716", Status: "PROCESSED", Accrual: 500},
+		},
+		{
+			name:        "Неизвестная версия",
+			contentType: "application/json; version=9",
+			body:        `{"order":"49927398716","status":"REGISTERED"}`,
+			want:        Order{Number: "49927398716", Status: "REGISTERED"},
+		},
+		{
+			name: "Без Content-Type",
+			body: `{"order":"49927398716","status":"INVALID","accrual":null}`,
+			want: Order{Number: "49927398716", Status: "INVALID"},
+		},
+		{
+			name:    "Без статуса",
+			body:    `{"order":"49927398716","accrual":500}`,
+			wantErr: true,
+		},
+		{
+			name:    "Сумма строкой",
+			body:    `{"order":"49927398716","status":"PROCESSED","accrual":"500"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Не объект",
+			body:    `["PROCESSED"]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeAccrual(tt.contentType, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeAccrual() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("decodeAccrual() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultRetryAfter - пауза после 429 без понятного заголовка Retry-After
+const defaultRetryAfter = 15 * time.Second
+
+// limiter - общий для всех рабочих и сверки token bucket запросов к системе расчета: burst запросов
+// сразу, далее rate запросов в секунду. 429 останавливает запросы всех рабочих на Retry-After.
+// rate <= 0 - частота не ограничивается, остается только пауза после 429.
+type limiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	paused time.Time // конец паузы после 429
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	return &limiter{rate: rate, burst: float64(max(burst, 1))}
+}
+
+// reserve забирает токен и возвращает, сколько ждать до запроса. Токен забирается в долг:
+// ожидающие рабочие выстраиваются друг за другом, а не ждут одного и того же токена.
+func (l *limiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	wait := l.paused.Sub(now)
+	if l.rate > 0 {
+		if l.last.IsZero() {
+			l.tokens, l.last = l.burst, now
+		} else if now.After(l.last) {
+			l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+			l.last = now
+		}
+
+		l.tokens--
+		if l.tokens < 0 {
+			wait = max(wait, time.Duration(-l.tokens/l.rate*float64(time.Second)))
+		}
+	}
+
+	return max(wait, 0)
+}
+
+// wait дожидается своей очереди на запрос, false - отменен ctx
+func (l *limiter) wait(ctx context.Context) bool {
+	if d := l.reserve(time.Now()); d > 0 && !sleep(ctx, d) {
+		return false
+	}
+
+	// пока рабочий ждал токена, другой мог получить 429
+	for {
+		d := l.pauseWait()
+		if d <= 0 {
+			return true
+		}
+
+		if !sleep(ctx, d) {
+			return false
+		}
+	}
+}
+
+// pause останавливает запросы всех рабочих на d
+func (l *limiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(l.paused) {
+		l.paused = until
+	}
+}
+
+// pauseWait возвращает, сколько осталось до конца паузы после 429
+func (l *limiter) pauseWait() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return time.Until(l.paused)
+}
+
+// interval - наименьший промежуток между запросами, 0 - без ограничения
+func (l *limiter) interval() time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+
+	return time.Duration(float64(time.Second) / l.rate)
+}
+
+// retryAfter разбирает заголовок Retry-After: секунды или дата HTTP
+func retryAfter(h string) time.Duration {
+	if seconds, err := strconv.Atoi(h); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(h); err == nil {
+		return max(time.Until(t), 0)
+	}
+
+	return defaultRetryAfter
+}
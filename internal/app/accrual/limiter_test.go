@@ -0,0 +1,48 @@
+package accrual
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(2, 2)
+	now := time.Now()
+
+	// burst 2 сразу, дальше по токену раз в 500ms
+	for i, want := range []time.Duration{0, 0, 500 * time.Millisecond, time.Second} {
+		if got := l.reserve(now); got != want {
+			t.Errorf("reserve() #%d = %s, want %s", i+1, got, want)
+		}
+	}
+
+	// за 2s долг погашен и корзина снова полна
+	if got := l.reserve(now.Add(2 * time.Second)); got != 0 {
+		t.Errorf("reserve() after 2s = %s, want 0", got)
+	}
+
+	l.pause(time.Minute)
+	if got := l.reserve(time.Now()); got < 59*time.Second || got > time.Minute {
+		t.Errorf("reserve() paused = %s, want about 1m", got)
+	}
+
+	unlimited := newLimiter(0, 0)
+	for range 10 {
+		if got := unlimited.reserve(now); got != 0 {
+			t.Fatalf("reserve() unlimited = %s, want 0", got)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if got := retryAfter("30"); got != 30*time.Second {
+		t.Errorf("retryAfter(30) = %s, want 30s", got)
+	}
+	if got := retryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); got < 58*time.Second || got > time.Minute {
+		t.Errorf("retryAfter(date) = %s, want about 1m", got)
+	}
+	if got := retryAfter(""); got != defaultRetryAfter {
+		t.Errorf("retryAfter() = %s, want %s", got, defaultRetryAfter)
+	}
+}
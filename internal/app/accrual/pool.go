@@ -0,0 +1,505 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/domain"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/notify"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/settings"
+)
+
+// Storage - заказы, которые опрашивает пул, и отложенные подозрительные ответы
+type Storage interface {
+	domain.Storage
+	GetNotCheckedOrders() ([]string, error)
+	Quarantine(number, status string, accrual float64, reason string) error
+	GetOrderOwner(number string) (string, error)
+	// ExpireOrder переводит в EXPIRED заказ, срок опроса которого прошел
+	ExpireOrder(number string) (bool, error)
+	// GetOrderRevision - версия заказа перед опросом: ответ сохраняется, только если заказ
+	// за время опроса не изменился
+	GetOrderRevision(number string) (int64, error)
+	// DeferPoll откладывает следующий опрос заказа: отложенный заказ не попадет в GetNotCheckedOrders
+	// раньше at, в том числе после перезапуска
+	DeferPoll(number string, at time.Time) error
+	// Settings - действующие настройки, курс начисления для уведомления
+	Settings() settings.Values
+}
+
+// Order - заказ в очереди опроса и ответ системы расчета по нему
+type Order struct {
+	Number  string  `json:"order"`
+	Status  string  `json:"status"`
+	Accrual float64 `json:"accrual"`
+	// Revision - версия заказа на момент опроса, в ответе системы расчета ее нет
+	Revision int64 `json:"-"`
+	// Attempt - повторные опросы заказа подряд, от них растет пауза перед следующим
+	Attempt int `json:"-"`
+}
+
+// Pool опрашивает систему расчета несколькими рабочими. Новые заказы опрашиваются в первую
+// очередь, повторные опросы - следом. Очереди ограничены: заказ, не поместившийся в очередь,
+// остается в хранилище необработанным, и его подбирает следующий обход.
+type Pool struct {
+	workers int
+	sweep   time.Duration
+	// repoll - через сколько опрашивается заказ, по которому система расчета еще не ответила
+	// окончательно, 0 - после паузы backoff
+	repoll  time.Duration
+	backoff backoff
+
+	db     Storage
+	client *Client
+	orders *domain.Service
+	notify notify.EventNotifier
+
+	input chan Order // новые заказы
+	retry chan Order // повторные опросы и заказы из обхода
+
+	mu sync.Mutex
+	// queued - номера заказов в очередях и у рабочих: обход не ставит их в очередь второй раз
+	queued map[string]struct{}
+	// last - время последнего опроса, в окне обслуживания опросы идут не чаще интервала
+	last time.Time
+
+	quiet *calendar
+	stats queueStats
+
+	started  atomic.Bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// New готовит пул опроса системы расчета. Запросы к ней идут через client.
+func New(conf config.Config, db Storage, client *http.Client, n notify.EventNotifier) (*Pool, error) {
+	quiet, err := quietHours(conf.AccrualQuietHours, conf.AccrualTimezone, conf.AccrualQuietInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pool{
+		workers: max(conf.AccrualWorkers, 1),
+		sweep:   conf.AccrualSweepInterval,
+		repoll:  conf.AccrualRepollDelay,
+		backoff: backoff{baseDelay: conf.AccrualBackoffBase, maxDelay: conf.AccrualBackoffMax},
+		db:      db,
+		client:  NewClient(conf, client),
+		orders:  domain.New(db),
+		notify:  n,
+		input:   make(chan Order, conf.AccrualQueueSize),
+		retry:   make(chan Order, conf.AccrualQueueSize),
+		queued:  make(map[string]struct{}),
+		quiet:   quiet,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	p.orders.MaxAccrual = conf.AccrualMax
+
+	return p, nil
+}
+
+// Run запускает рабочих и обход необработанных заказов и работает до отмены ctx или Stop, затем
+// дожидается, пока рабочие закончат начатые опросы. Вызывается один раз.
+func (p *Pool) Run(ctx context.Context) error {
+	p.started.Store(true)
+	defer close(p.done)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-p.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(p.workers + 1)
+
+	go func() {
+		defer wg.Done()
+		p.sweepOrders(ctx)
+	}()
+
+	for range p.workers {
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+
+	wg.Wait()
+	log.Print("accrual pool stopped")
+
+	return nil
+}
+
+// Stop останавливает Run и дожидается его завершения
+func (p *Pool) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+
+	if p.started.Load() {
+		<-p.done
+	}
+}
+
+// Enqueue ставит новый заказ в очередь и возвращает его позицию в ней. Заказ, который уже
+// в очереди или не поместился в нее, опросит следующий обход необработанных заказов.
+func (p *Pool) Enqueue(o Order) int64 {
+	if p.track(o.Number) {
+		select {
+		case p.input <- o:
+		default:
+			p.release(o.Number)
+			log.Printf("accrual queue is full, order %s waits for the next sweep", o.Number)
+		}
+	}
+
+	return int64(len(p.input))
+}
+
+// sweepOrders сразу и затем раз в интервал обхода ставит в очередь повторных опросов необработанные
+// заказы из хранилища, которых нет в очередях: оставшиеся с прошлого запуска и не поместившиеся в очередь
+func (p *Pool) sweepOrders(ctx context.Context) {
+	for {
+		orders, err := p.db.GetNotCheckedOrders()
+		if err != nil {
+			log.Print("accrual sweep err: ", err.Error())
+		}
+
+	enqueue:
+		for _, number := range orders {
+			if !p.track(number) {
+				continue
+			}
+
+			select {
+			case p.retry <- Order{Number: number}:
+			default:
+				p.release(number)
+				break enqueue
+			}
+		}
+
+		if !sleep(ctx, p.sweep) {
+			return
+		}
+	}
+}
+
+// track отмечает заказ поставленным в очередь, false - он уже в очереди
+func (p *Pool) track(number string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.queued[number]; ok {
+		return false
+	}
+
+	p.queued[number] = struct{}{}
+	return true
+}
+
+// release убирает заказ из очереди: опрос закончен или заказ подождет следующего обхода
+func (p *Pool) release(number string) {
+	p.mu.Lock()
+	delete(p.queued, number)
+	p.mu.Unlock()
+}
+
+// requeue возвращает заказ в очередь повторных опросов, в переполненную очередь заказ
+// вернет следующий обход
+func (p *Pool) requeue(o Order) {
+	select {
+	case p.retry <- o:
+	default:
+		p.release(o.Number)
+	}
+}
+
+// retryLater возвращает заказ в очередь повторных опросов после паузы backoff, но не раньше чем
+// через floor. Пока заказ ждет, он считается в очереди, и обход не ставит его второй раз.
+func (p *Pool) retryLater(o Order, floor time.Duration) {
+	o.Attempt++
+
+	d := max(p.backoff.delay(o.Attempt), floor)
+	if d <= 0 {
+		p.requeue(o)
+		return
+	}
+
+	time.AfterFunc(d, func() {
+		select {
+		case <-p.done:
+			// пул остановлен: заказ остался необработанным в хранилище
+		default:
+			p.requeue(o)
+		}
+	})
+}
+
+// later откладывает опрос заказа без окончательного ответа на repoll: отложенный заказ
+// возвращает в очередь обход, когда подойдет срок. Без repoll заказ возвращается в очередь после паузы backoff.
+func (p *Pool) later(o Order) {
+	if p.repoll <= 0 {
+		p.retryLater(o, 0)
+		return
+	}
+
+	if err := p.db.DeferPoll(o.Number, time.Now().Add(p.repoll)); err != nil {
+		log.Printf("go number: %s, defer poll err: %s", o.Number, err.Error())
+		p.retryLater(o, 0)
+		return
+	}
+
+	p.release(o.Number)
+}
+
+// next возвращает следующий заказ для опроса, отдавая приоритет новым заказам. false - отменен ctx.
+func (p *Pool) next(ctx context.Context) (Order, bool) {
+	select {
+	case o := <-p.input:
+		return o, true
+	default:
+	}
+
+	select {
+	case o := <-p.input:
+		return o, true
+	case o := <-p.retry:
+		return o, true
+	case <-ctx.Done():
+		return Order{}, false
+	}
+}
+
+// work опрашивает заказы из очередей до отмены ctx
+func (p *Pool) work(ctx context.Context) {
+	for {
+		// при открытом автомате и после 429 заказы остаются в очереди до конца паузы
+		if wait := max(p.client.breaker.wait(), p.client.limit.pauseWait()); wait > 0 && !sleep(ctx, wait) {
+			return
+		}
+
+		o, ok := p.next(ctx)
+		if !ok {
+			return
+		}
+
+		p.process(ctx, o)
+	}
+}
+
+// reserve дожидается, когда окно обслуживания разрешит опрос, и отмечает его время. false - отменен ctx.
+func (p *Pool) reserve(ctx context.Context) bool {
+	for {
+		p.mu.Lock()
+		now := time.Now()
+		wait := p.quiet.wait(now, p.last)
+		if wait <= 0 {
+			p.last = now
+			p.mu.Unlock()
+			return true
+		}
+		p.mu.Unlock()
+
+		if !sleep(ctx, wait) {
+			return false
+		}
+	}
+}
+
+// process опрашивает систему расчета по заказу o и сохраняет ответ. Паника записывается
+// в журнал, заказ опросит следующий обход.
+func (p *Pool) process(ctx context.Context, o Order) {
+	defer func() {
+		if x := recover(); x != nil {
+			log.Print("run time panic: ", x)
+			p.release(o.Number)
+		}
+	}()
+
+	// заказ, не обработанный за срок опроса, убирается из очереди
+	if expired, err := p.db.ExpireOrder(o.Number); err != nil {
+		log.Printf("go number: %s, expire err: %s", o.Number, err.Error())
+	} else if expired {
+		log.Printf("go number: %s, status: %s", o.Number, domain.StatusExpired)
+		p.release(o.Number)
+		return
+	}
+
+	revision, err := p.db.GetOrderRevision(o.Number)
+	if err != nil {
+		log.Printf("go number: %s, get revision err: %s", o.Number, err.Error())
+		if errors.Is(err, database.ErrNotFound) {
+			p.release(o.Number)
+		} else {
+			p.retryLater(o, 0)
+		}
+		return
+	}
+	o.Revision = revision
+
+	// в окне обслуживания системы расчета заказ ждет конца окна или интервала опроса
+	if !p.reserve(ctx) {
+		p.requeue(o)
+		return
+	}
+
+	start := time.Now()
+	order, result, err := p.client.GetOrder(ctx, o.Number)
+	if errors.Is(err, ErrResponseTooLarge) {
+		log.Printf("go number: %s, err: %s", o.Number, err.Error())
+		p.quarantine(o, "response too large")
+		return
+	}
+	if err != nil {
+		log.Printf("go number: %s, err: %s", o.Number, err.Error())
+		p.retryLater(o, 0)
+		return
+	}
+
+	p.stats.observe(time.Since(start))
+
+	switch result {
+	case RateLimited:
+		log.Printf("go number: %s, status: %s", o.Number, result)
+
+		// общую паузу поставил клиент, она записывается и в заказ: после перезапуска его не опросят
+		// раньше, чем разрешила система расчета
+		pause := p.client.limit.pauseWait()
+		if err := p.db.DeferPoll(o.Number, time.Now().Add(pause)); err != nil {
+			log.Printf("go number: %s, defer poll err: %s", o.Number, err.Error())
+		}
+		p.retryLater(o, pause)
+	case NotRegistered:
+		log.Printf("go number: %s, status: %s", o.Number, result)
+		if o.Status != domain.StatusProcessing {
+			err := p.orders.ApplyAccrual(o.Number, domain.StatusProcessing, 0, o.Revision)
+			if err != nil {
+				log.Printf("go number: %s, err: %s", o.Number, err.Error())
+				p.retryLater(o, 0)
+				return
+			}
+			o.Status = domain.StatusProcessing
+		}
+		p.later(o)
+	default:
+		order.Number, order.Revision, order.Attempt = o.Number, o.Revision, o.Attempt
+		p.apply(o, order, result)
+	}
+}
+
+// apply сохраняет ответ order с итогом result системы расчета по заказу o, опрошенному в статусе o.Status
+func (p *Pool) apply(o, order Order, result Result) {
+	switch result {
+	case Processing:
+		log.Printf("go number: %s, status: %s", order.Number, order.Status)
+		if o.Status != order.Status {
+			err := p.orders.ApplyAccrual(order.Number, order.Status, order.Accrual, order.Revision)
+			if errors.Is(err, database.ErrConflict) {
+				p.conflict(o)
+				return
+			}
+			if err != nil {
+				log.Printf("go number: %s, err: %s", order.Number, err.Error())
+				p.release(o.Number)
+				return
+			}
+		}
+		p.later(order)
+	case Invalid, Processed:
+		log.Printf("go number: %s, status: %s, accrual: %g", order.Number, order.Status, order.Accrual)
+		if o.Status != order.Status {
+			err := p.orders.ApplyAccrual(order.Number, order.Status, order.Accrual, order.Revision)
+			if errors.Is(err, database.ErrConflict) {
+				p.conflict(o)
+				return
+			}
+			if errors.Is(err, domain.ErrBadSum) || errors.Is(err, domain.ErrAccrualTooLarge) {
+				log.Printf("go number: %s, err: %s", o.Number, err.Error())
+				p.quarantine(order, err.Error())
+				return
+			}
+			if err != nil {
+				log.Printf("go number: %s, err: %s", o.Number, err.Error())
+				p.retryLater(order, 0)
+				return
+			}
+
+			if result == Processed && order.Accrual > 0 {
+				p.notifyAccrual(order)
+			}
+		}
+		p.release(o.Number)
+	default:
+		log.Printf("go number: %s, status: %s", o.Number, order.Status)
+		p.later(o)
+	}
+}
+
+// conflict возвращает в очередь заказ, измененный за время опроса администратором или другим
+// экземпляром: следующий опрос перечитает версию и сохранит свежий ответ поверх изменения
+func (p *Pool) conflict(o Order) {
+	log.Printf("go number: %s, changed during poll, retrying", o.Number)
+	p.requeue(o)
+}
+
+// quarantine откладывает подозрительный ответ системы расчета до ручной проверки, не начисляя баллы.
+// Повторный опрос вернул бы тот же ответ, поэтому заказ из очереди убирается.
+func (p *Pool) quarantine(o Order, reason string) {
+	if err := p.db.Quarantine(o.Number, o.Status, o.Accrual, reason); err != nil {
+		log.Printf("go number: %s, quarantine err: %s", o.Number, err.Error())
+		p.retryLater(o, 0)
+		return
+	}
+
+	log.Printf("go number: %s, quarantined: %s", o.Number, reason)
+	p.release(o.Number)
+}
+
+// notifyAccrual сообщает владельцу заказа о начислении
+func (p *Pool) notifyAccrual(order Order) {
+	login, err := p.db.GetOrderOwner(order.Number)
+	if err != nil {
+		log.Printf("go number: %s, get owner err: %s", order.Number, err.Error())
+		return
+	}
+
+	if login == "" {
+		return
+	}
+
+	err = p.notify.Event(context.Background(), login, notify.EventAccrualCredited,
+		fmt.Sprintf("За заказ %s начислено %g баллов", order.Number, database.Points(order.Accrual, p.db.Settings().AccrualPointRate)))
+	if err != nil {
+		log.Printf("go number: %s, notify err: %s", order.Number, err.Error())
+	}
+}
+
+// sleep ждет d и сообщает false, если ожидание прервала отмена ctx
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
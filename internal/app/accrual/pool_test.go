@@ -0,0 +1,134 @@
+package accrual
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/domain"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/memory"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/notify"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/password"
+)
+
+var poolConfig = config.Config{
+	AccrualMaxBody:       4096,
+	AccrualWorkers:       2,
+	AccrualQueueSize:     10,
+	AccrualSweepInterval: time.Hour,
+	AccrualTimezone:      "UTC",
+	PasswordKDF:          password.KindBcrypt,
+	PasswordKDFCost:      4,
+}
+
+func TestPool(t *testing.T) {
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number := strings.TrimPrefix(r.URL.Path, "/api/orders/")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Order{Number: number, Status: domain.StatusProcessed, Accrual: 500})
+	}))
+	defer accrual.Close()
+
+	db, err := memory.New(poolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Register("username", "password", "", "cookie"); err != nil {
+		t.Fatal(err)
+	}
+
+	// заказ, загруженный до запуска, подбирает первый обход
+	if err = db.AddOrder("username", 1234567812345670); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := poolConfig
+	conf.AccrualSystemAddress = accrual.URL
+	p, err := New(conf, db, accrual.Client(), notify.Log{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		_ = p.Run(t.Context())
+	}()
+
+	if err = db.AddOrder("username", 49927398716); err != nil {
+		t.Fatal(err)
+	}
+	p.Enqueue(Order{Number: "49927398716", Status: domain.StatusNew})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		orders, err := db.GetOrders("username", 0, 0, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		processed := 0
+		for _, o := range orders {
+			if o.Status == domain.StatusProcessed {
+				processed++
+			}
+		}
+		if processed == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("orders = %+v, want both PROCESSED", orders)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop() did not return")
+	}
+
+	if n := len(p.queued); n != 0 {
+		t.Errorf("queued = %d orders after processing, want 0", n)
+	}
+}
+
+func TestEnqueueBounded(t *testing.T) {
+	conf := poolConfig
+	conf.AccrualQueueSize = 1
+
+	p, err := New(conf, nil, http.DefaultClient, notify.Log{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := p.Enqueue(Order{Number: "1"}); got != 1 {
+		t.Errorf("Enqueue() = %d, want 1", got)
+	}
+	// заказ уже в очереди
+	if got := p.Enqueue(Order{Number: "1"}); got != 1 {
+		t.Errorf("Enqueue() again = %d, want 1", got)
+	}
+	// очередь полна: заказ подберет обход
+	if got := p.Enqueue(Order{Number: "2"}); got != 1 {
+		t.Errorf("Enqueue() to full queue = %d, want 1", got)
+	}
+	if _, ok := p.queued["2"]; ok {
+		t.Error("order rejected by a full queue is still tracked")
+	}
+	if got := p.Pending(); got != 1 {
+		t.Errorf("Pending() = %d, want 1", got)
+	}
+
+	// без Run Stop не ждет
+	p.Stop()
+}
@@ -0,0 +1,61 @@
+package accrual
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// defaultPoll - оценка длительности одного опроса, пока не накоплено ни одного измерения
+const defaultPoll = time.Second
+
+// queueStats - показатели опроса для оценки ожидания
+type queueStats struct {
+	poll atomic.Int64 // скользящее среднее длительности опроса, нс
+}
+
+// Pending возвращает количество новых заказов, ожидающих опроса
+func (p *Pool) Pending() int64 {
+	return int64(len(p.input))
+}
+
+// Down сообщает, что система расчета недоступна и начисления по новым заказам задерживаются.
+// nil - опрос не подключен.
+func (p *Pool) Down() bool {
+	return p != nil && p.client.breaker.down()
+}
+
+// Estimate оценивает, через сколько будет опрошен заказ на позиции position
+func (p *Pool) Estimate(position int64) time.Duration {
+	poll := time.Duration(p.stats.poll.Load())
+	if poll <= 0 {
+		poll = defaultPoll
+	}
+
+	// рабочие опрашивают заказы параллельно, но не чаще, чем разрешает ограничение частоты
+	poll = max(poll/time.Duration(p.workers), p.client.limit.interval())
+
+	estimate := poll * time.Duration(position)
+	if pause := p.client.limit.pauseWait(); pause > 0 {
+		estimate += pause
+	}
+	if wait := p.client.breaker.wait(); wait > 0 {
+		estimate += wait
+	}
+
+	return p.quiet.estimate(time.Now(), position, poll, estimate)
+}
+
+// observe добавляет длительность опроса в скользящее среднее с весом 1/8
+func (s *queueStats) observe(d time.Duration) {
+	for {
+		old := s.poll.Load()
+		avg := int64(d)
+		if old > 0 {
+			avg = old + (int64(d)-old)/8
+		}
+
+		if s.poll.CompareAndSwap(old, avg) {
+			return
+		}
+	}
+}
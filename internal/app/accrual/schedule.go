@@ -0,0 +1,192 @@
+package accrual
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	// часовые пояса встроены в бинарник: в образе сервиса может не быть zoneinfo
+	_ "time/tzdata"
+)
+
+// calendar - окна обслуживания системы расчета (например, ночные работы), в которые опрос
+// приостанавливается или, если задан интервал, идет не чаще одного заказа за интервал.
+// nil - окон нет.
+type calendar struct {
+	windows  []window
+	location *time.Location
+	interval time.Duration // 0 - опрос в окне приостановлен
+}
+
+// window - окно с start до end минут от полуночи в дни days. Окно с end <= start
+// переходит через полночь и относится к дню, в который началось.
+type window struct {
+	days       [7]bool
+	start, end int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseCalendar разбирает окна вида "02:00-04:00", "sat,sun 01:00-05:00" или "mon-fri 23:30-00:30"
+// в часовом поясе tz
+func parseCalendar(specs []string, tz string, interval time.Duration) (*calendar, error) {
+	location, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &calendar{location: location, interval: interval}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		w, err := parseWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("quiet hours %q: %w", spec, err)
+		}
+
+		c.windows = append(c.windows, w)
+	}
+
+	return c, nil
+}
+
+func parseWindow(spec string) (window, error) {
+	var w window
+
+	hours := spec
+	if days, rest, ok := strings.Cut(spec, " "); ok {
+		hours = strings.TrimSpace(rest)
+		for _, item := range strings.Split(strings.ToLower(days), ",") {
+			from, to, isRange := strings.Cut(item, "-")
+			if !isRange {
+				to = from
+			}
+
+			first, ok1 := weekdays[from]
+			last, ok2 := weekdays[to]
+			if !ok1 || !ok2 {
+				return window{}, fmt.Errorf("unknown day %q", item)
+			}
+
+			// диапазон может переходить через воскресенье: fri-mon
+			for d := first; ; d = (d + 1) % 7 {
+				w.days[d] = true
+				if d == last {
+					break
+				}
+			}
+		}
+	} else {
+		for d := range w.days {
+			w.days[d] = true
+		}
+	}
+
+	from, to, ok := strings.Cut(hours, "-")
+	if !ok {
+		return window{}, fmt.Errorf("want HH:MM-HH:MM")
+	}
+
+	start, err := time.Parse("15:04", from)
+	if err != nil {
+		return window{}, err
+	}
+
+	end, err := time.Parse("15:04", to)
+	if err != nil {
+		return window{}, err
+	}
+
+	w.start = start.Hour()*60 + start.Minute()
+	w.end = end.Hour()*60 + end.Minute()
+	if w.end <= w.start {
+		w.end += 24 * 60
+	}
+
+	return w, nil
+}
+
+// until возвращает конец окна, в которое попадает t, и false, если t вне окон
+func (c *calendar) until(t time.Time) (time.Time, bool) {
+	t = t.In(c.location)
+
+	var end time.Time
+	for _, w := range c.windows {
+		// окно могло начаться накануне и перейти через полночь
+		for back := 0; back < 2; back++ {
+			day := time.Date(t.Year(), t.Month(), t.Day()-back, 0, 0, 0, 0, c.location)
+			if !w.days[day.Weekday()] {
+				continue
+			}
+
+			from := time.Date(day.Year(), day.Month(), day.Day(), 0, w.start, 0, 0, c.location)
+			to := time.Date(day.Year(), day.Month(), day.Day(), 0, w.end, 0, 0, c.location)
+			if !t.Before(from) && t.Before(to) && to.After(end) {
+				end = to
+			}
+		}
+	}
+
+	return end, !end.IsZero()
+}
+
+// quietHours разбирает окна обслуживания, пустой список - nil
+func quietHours(specs []string, tz string, interval time.Duration) (*calendar, error) {
+	c, err := parseCalendar(specs, tz, interval)
+	if err != nil || len(c.windows) == 0 {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// wait возвращает, сколько ждать до опроса, если now попадает в окно обслуживания.
+// last - время предыдущего опроса.
+func (c *calendar) wait(now, last time.Time) time.Duration {
+	if c == nil {
+		return 0
+	}
+
+	end, ok := c.until(now)
+	if !ok {
+		return 0
+	}
+
+	wait := end.Sub(now)
+	if c.interval > 0 {
+		wait = min(wait, last.Add(c.interval).Sub(now))
+	}
+
+	return max(wait, 0)
+}
+
+// estimate пересчитывает оценку ожидания estimate заказа на позиции position
+// с учетом окна обслуживания, в которое попадает now
+func (c *calendar) estimate(now time.Time, position int64, poll, estimate time.Duration) time.Duration {
+	if c == nil {
+		return estimate
+	}
+
+	end, ok := c.until(now)
+	if !ok {
+		return estimate
+	}
+
+	if c.interval <= 0 {
+		return estimate + end.Sub(now)
+	}
+
+	// в окне заказы опрашиваются не чаще одного за интервал, но не дольше, чем до конца окна
+	if c.interval > poll {
+		slow := min(c.interval*time.Duration(position), end.Sub(now))
+		estimate += slow - min(poll*time.Duration(position), slow)
+	}
+
+	return estimate
+}
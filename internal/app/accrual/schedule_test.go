@@ -0,0 +1,89 @@
+package accrual
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalendarUntil(t *testing.T) {
+	c, err := parseCalendar([]string{"02:00-04:00", "sat,sun 10:00-12:00", "fri-mon 23:30-00:30"}, "Europe/Moscow", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msk := c.location
+	tests := []struct {
+		name string
+		t    time.Time
+		want time.Time
+	}{
+		{name: "daily", t: time.Date(2026, 10, 14, 3, 0, 0, 0, msk), want: time.Date(2026, 10, 14, 4, 0, 0, 0, msk)},
+		{name: "daily end", t: time.Date(2026, 10, 14, 4, 0, 0, 0, msk)},
+		{name: "weekend", t: time.Date(2026, 10, 17, 11, 0, 0, 0, msk), want: time.Date(2026, 10, 17, 12, 0, 0, 0, msk)},
+		{name: "weekday", t: time.Date(2026, 10, 16, 11, 0, 0, 0, msk)},
+		{name: "sunday night", t: time.Date(2026, 10, 18, 23, 45, 0, 0, msk), want: time.Date(2026, 10, 19, 0, 30, 0, 0, msk)},
+		{name: "after midnight", t: time.Date(2026, 10, 20, 0, 15, 0, 0, msk), want: time.Date(2026, 10, 20, 0, 30, 0, 0, msk)},
+		{name: "after tuesday midnight", t: time.Date(2026, 10, 21, 0, 15, 0, 0, msk)},
+		{name: "utc", t: time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC), want: time.Date(2026, 10, 14, 4, 0, 0, 0, msk)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := c.until(tt.t)
+			if ok != !tt.want.IsZero() || !got.Equal(tt.want) {
+				t.Errorf("until(%s) = %s, %v, want %s", tt.t, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCalendarErrors(t *testing.T) {
+	for _, spec := range []string{"2:00", "25:00-04:00", "holiday 02:00-04:00", "mon-xyz 02:00-04:00"} {
+		if _, err := parseCalendar([]string{spec}, "UTC", 0); err == nil {
+			t.Errorf("parseCalendar(%q) err = nil, want error", spec)
+		}
+	}
+
+	if _, err := parseCalendar(nil, "Mars/Olympus", 0); err == nil {
+		t.Error("parseCalendar() with unknown timezone err = nil, want error")
+	}
+}
+
+func TestQuietWait(t *testing.T) {
+	now := time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC)
+
+	paused, err := quietHours([]string{"02:00-04:00"}, "UTC", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := paused.wait(now, now.Add(-time.Hour)); got != time.Hour {
+		t.Errorf("paused wait() = %s, want 1h", got)
+	}
+	if got := paused.wait(now.Add(2*time.Hour), now); got != 0 {
+		t.Errorf("wait() outside window = %s, want 0", got)
+	}
+
+	slowed, err := quietHours([]string{"02:00-04:00"}, "UTC", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slowed.wait(now, now.Add(-20*time.Second)); got != 40*time.Second {
+		t.Errorf("slowed wait() = %s, want 40s", got)
+	}
+	if got := slowed.wait(now, now.Add(-2*time.Minute)); got != 0 {
+		t.Errorf("slowed wait() after interval = %s, want 0", got)
+	}
+	if got := slowed.estimate(now, 10, time.Second, 10*time.Second); got != 10*time.Minute {
+		t.Errorf("estimate() = %s, want 10m", got)
+	}
+
+	none, err := quietHours(nil, "UTC", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if none != nil {
+		t.Fatalf("quietHours(nil) = %v, want nil", none)
+	}
+	if got := none.wait(now, now); got != 0 {
+		t.Errorf("wait() without windows = %s, want 0", got)
+	}
+}
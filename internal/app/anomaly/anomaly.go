@@ -0,0 +1,60 @@
+package anomaly
+
+import (
+	"context"
+	"log"
+	"net"
+)
+
+// Event - сессия пользователя использована с адреса, далекого от того, на котором она создана
+type Event struct {
+	Login            string
+	SessionID        int64
+	SessionIP        string
+	SessionUserAgent string
+	IP               string
+	UserAgent        string
+}
+
+// Hook получает события о подозрительном использовании сессий, например для передачи
+// в систему мониторинга безопасности. Вызывается вне обработки запроса.
+type Hook interface {
+	SessionAnomaly(ctx context.Context, e Event)
+}
+
+// Log пишет события в журнал, используется, пока не подключена внешняя система
+type Log struct{}
+
+func (Log) SessionAnomaly(_ context.Context, e Event) {
+	log.Printf("session anomaly: login: %s, session: %d, created from %s (%s), used from %s (%s)",
+		e.Login, e.SessionID, e.SessionIP, e.SessionUserAgent, e.IP, e.UserAgent)
+}
+
+// Префиксы, в пределах которых смена адреса считается обычной (переподключение к сети
+// того же провайдера)
+const (
+	ipv4Prefix = 16
+	ipv6Prefix = 32
+)
+
+// Distant сообщает, что адреса a и b относятся к разным сетям: разные семейства адресов
+// или разные префиксы /16 для IPv4 и /32 для IPv6. Нераспознанные и пустые адреса не сравниваются.
+func Distant(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return false
+	}
+
+	v4A, v4B := ipA.To4(), ipB.To4()
+	if (v4A == nil) != (v4B == nil) {
+		return true
+	}
+
+	if v4A != nil {
+		mask := net.CIDRMask(ipv4Prefix, 32)
+		return !v4A.Mask(mask).Equal(v4B.Mask(mask))
+	}
+
+	mask := net.CIDRMask(ipv6Prefix, 128)
+	return !ipA.Mask(mask).Equal(ipB.Mask(mask))
+}
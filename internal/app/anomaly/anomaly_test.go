@@ -0,0 +1,28 @@
+package anomaly
+
+import "testing"
+
+func TestDistant(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{name: "Тот же адрес", a: "192.168.1.10", b: "192.168.1.10", want: false},
+		{name: "Та же /16", a: "10.20.1.10", b: "10.20.200.3", want: false},
+		{name: "Другая /16", a: "10.20.1.10", b: "10.21.1.10", want: true},
+		{name: "IPv4 и IPv6", a: "10.20.1.10", b: "2001:db8::1", want: true},
+		{name: "IPv4 в IPv6", a: "10.20.1.10", b: "::ffff:10.20.5.5", want: false},
+		{name: "Та же IPv6 /32", a: "2001:db8:1::1", b: "2001:db8:ffff::2", want: false},
+		{name: "Другая IPv6 /32", a: "2001:db8::1", b: "2001:db9::1", want: true},
+		{name: "Пустой адрес сессии", a: "", b: "10.20.1.10", want: false},
+		{name: "Не адрес", a: "pipe", b: "10.20.1.10", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Distant(tt.a, tt.b); got != tt.want {
+				t.Errorf("Distant(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
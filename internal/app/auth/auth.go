@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+)
+
+// ErrInvalidCredentials - логин или пароль не подошли
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator проверяет пару логин/пароль. Сессии и учетные записи сервиса остаются в базе,
+// проверка пароля может выполняться внешним провайдером.
+type Authenticator interface {
+	Authenticate(ctx context.Context, login, password string) error
+}
+
+const (
+	BackendLocal = "local"
+	BackendOIDC  = "oidc"
+	BackendLDAP  = "ldap"
+)
+
+// New возвращает бэкенд аутентификации, выбранный в AUTH_BACKEND
+func New(c config.Config, db Passwords) (Authenticator, error) {
+	timeout := c.AuthTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	switch c.AuthBackend {
+	case "", BackendLocal:
+		return Local{DB: db}, nil
+	case BackendOIDC:
+		if c.OIDCTokenURL == "" || c.OIDCClientID == "" {
+			return nil, errors.New("auth: oidc requires token url and client id")
+		}
+
+		return OIDC{
+			TokenURL:     c.OIDCTokenURL,
+			ClientID:     c.OIDCClientID,
+			ClientSecret: c.OIDCClientSecret,
+			Scope:        c.OIDCScope,
+			Client:       &http.Client{Timeout: timeout},
+		}, nil
+	case BackendLDAP:
+		if c.LDAPURL == "" || c.LDAPBindDN == "" {
+			return nil, errors.New("auth: ldap requires url and bind dn")
+		}
+
+		return LDAP{URL: c.LDAPURL, BindDN: c.LDAPBindDN, Timeout: timeout}, nil
+	default:
+		return nil, fmt.Errorf("auth: unknown backend %s", c.AuthBackend)
+	}
+}
+
+// Passwords - проверка локальных паролей хранилищем, ошибки - из пакета database
+type Passwords interface {
+	CheckPassword(login, pass string) error
+}
+
+// Local проверяет пароль по таблице пользователей
+type Local struct {
+	DB Passwords
+}
+
+func (l Local) Authenticate(_ context.Context, login, password string) error {
+	err := l.DB.CheckPassword(login, password)
+	if errors.Is(err, database.ErrWrongData) {
+		return ErrInvalidCredentials
+	}
+
+	return err
+}
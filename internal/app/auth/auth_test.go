@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOIDC(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "password" || r.Form.Get("client_id") != "gophermart" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Form.Get("password") {
+		case "right":
+			_, _ = w.Write([]byte(`{"access_token":"x","token_type":"Bearer"}`))
+		case "down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		}
+	}))
+	defer srv.Close()
+
+	o := OIDC{TokenURL: srv.URL, ClientID: "gophermart", Client: srv.Client()}
+
+	tests := []struct {
+		name     string
+		password string
+		want     error
+		wantErr  bool
+	}{
+		{name: "Верный пароль", password: "right"},
+		{name: "Неверный пароль", password: "wrong", want: ErrInvalidCredentials, wantErr: true},
+		{name: "Провайдер недоступен", password: "down", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := o.Authenticate(context.Background(), "user", tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Authenticate() err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// fakeLDAP отвечает на одну привязку кодом результата в зависимости от пароля
+func fakeLDAP(t *testing.T, wantDN string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				b := make([]byte, 512)
+				n, err := conn.Read(b)
+				if err != nil {
+					return
+				}
+
+				_, msg, _, _ := readTLV(b[:n])
+				_, _, msg, _ = readTLV(msg)
+				_, req, _, _ := readTLV(msg)
+				_, _, req, _ = readTLV(req)
+				_, dn, req, _ := readTLV(req)
+				_, password, _, _ := readTLV(req)
+
+				code := byte(ldapInvalidCredentials)
+				if string(dn) == wantDN && string(password) == "right" {
+					code = ldapSuccess
+				}
+
+				resp := ber(0x0a, []byte{code})
+				resp = append(resp, ber(0x04, nil)...)
+				resp = append(resp, ber(0x04, nil)...)
+				msg = append(ber(0x02, []byte{1}), ber(0x61, resp)...)
+				_, _ = conn.Write(ber(0x30, msg))
+			}(conn)
+		}
+	}()
+
+	return "ldap://" + l.Addr().String()
+}
+
+func TestLDAP(t *testing.T) {
+	l := LDAP{
+		URL:     fakeLDAP(t, `uid=a\,b,ou=people,dc=example,dc=org`),
+		BindDN:  "uid=%s,ou=people,dc=example,dc=org",
+		Timeout: time.Second,
+	}
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		want     error
+	}{
+		{name: "Верный пароль", login: "a,b", password: "right"},
+		{name: "Неверный пароль", login: "a,b", password: "wrong", want: ErrInvalidCredentials},
+		{name: "Пустой пароль", login: "a,b", password: "", want: ErrInvalidCredentials},
+		{name: "Другой пользователь", login: "c", password: "right", want: ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := l.Authenticate(context.Background(), tt.login, tt.password); !errors.Is(err, tt.want) {
+				t.Errorf("Authenticate() err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadBindResponse(t *testing.T) {
+	if _, err := readBindResponse(bytes.NewReader([]byte{0x30, 0x84, 0x7f, 0xff, 0xff, 0xff})); !errors.Is(err, errBadResponse) {
+		t.Errorf("readBindResponse() huge length err = %v, want %v", err, errBadResponse)
+	}
+
+	if _, err := readBindResponse(bytes.NewReader([]byte{0x30, 0x03, 0x02, 0x01, 0x01})); !errors.Is(err, errBadResponse) {
+		t.Errorf("readBindResponse() without BindResponse err = %v, want %v", err, errBadResponse)
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	tests := map[string]string{
+		"user":      "user",
+		"a,b":       `a\,b`,
+		"x=y+z":     `x\=y\+z`,
+		"#admin":    `\#admin`,
+		` lead`:     `\ lead`,
+		`trail `:    `trail\ `,
+		`q"<>;\end`: `q\"\<\>\;\\end`,
+	}
+	for in, want := range tests {
+		if got := escapeDN(in); got != want {
+			t.Errorf("escapeDN(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package auth
+
+import "context"
+
+// UserID - клиент запроса: идентификатор сессии и логин, пустой до входа. Impersonator - администратор,
+// вошедший от имени пользователя Login, ReadOnly - изменения от имени пользователя запрещены.
+type UserID struct {
+	ID           string
+	Login        string
+	Impersonator string
+	ReadOnly     bool
+}
+
+// String - вид личности в журнале сервиса
+func (u UserID) String() string {
+	if u.Impersonator == "" {
+		return "{" + u.ID + " " + u.Login + "}"
+	}
+
+	return "{" + u.ID + " " + u.Login + " impersonated by " + u.Impersonator + "}"
+}
+
+// contextKey - ключ личности в контексте запроса. Неэкспортируемый тип исключает
+// совпадение с ключами других пакетов.
+type contextKey struct{}
+
+// NewContext возвращает контекст с личностью клиента, ее кладут middleware аутентификации
+func NewContext(ctx context.Context, id UserID) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext возвращает личность клиента, false - запрос не прошел middleware аутентификации
+func FromContext(ctx context.Context) (UserID, bool) {
+	id, ok := ctx.Value(contextKey{}).(UserID)
+	return id, ok
+}
@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFromContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Error("FromContext() without identity ok = true, want false")
+	}
+
+	// значение под строковым ключом того же имени не должно читаться как личность
+	ctx := context.WithValue(context.Background(), "contextKey", UserID{ID: "x"})
+	if _, ok := FromContext(ctx); ok {
+		t.Error("FromContext() with foreign key ok = true, want false")
+	}
+
+	want := UserID{ID: "session", Login: "username"}
+	got, ok := FromContext(NewContext(context.Background(), want))
+	if !ok || got != want {
+		t.Errorf("FromContext() = %v, %t, want %v, true", got, ok, want)
+	}
+}
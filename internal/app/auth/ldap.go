@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LDAP проверяет пароль простой привязкой (simple bind) к каталогу от имени пользователя.
+// BindDN - шаблон DN, в котором %s заменяется логином, например uid=%s,ou=people,dc=example,dc=org.
+type LDAP struct {
+	URL     string
+	BindDN  string
+	Timeout time.Duration
+}
+
+// Коды результата LDAP (RFC 4511)
+const (
+	ldapSuccess            = 0
+	ldapInvalidCredentials = 49
+)
+
+func (l LDAP) Authenticate(ctx context.Context, login, password string) error {
+	// привязка с пустым паролем в LDAP анонимна и всегда успешна
+	if password == "" {
+		return ErrInvalidCredentials
+	}
+
+	conn, err := l.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	deadline := time.Now().Add(l.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err = conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	dn := fmt.Sprintf(l.BindDN, escapeDN(login))
+	if _, err = conn.Write(bindRequest(1, dn, password)); err != nil {
+		return err
+	}
+
+	code, err := readBindResponse(conn)
+	if err != nil {
+		return err
+	}
+
+	switch code {
+	case ldapSuccess:
+		return nil
+	case ldapInvalidCredentials:
+		return ErrInvalidCredentials
+	default:
+		return fmt.Errorf("ldap bind result code: %d", code)
+	}
+}
+
+func (l LDAP) dial(ctx context.Context) (net.Conn, error) {
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: l.Timeout}
+	switch u.Scheme {
+	case "ldap":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+
+		return dialer.DialContext(ctx, "tcp", host)
+	case "ldaps":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+
+		d := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: u.Hostname()}}
+		return d.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %s", u.Scheme)
+	}
+}
+
+// escapeDN экранирует значение атрибута DN (RFC 4514), чтобы логин не менял структуру DN
+func escapeDN(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			(r == '#' || r == ' ') && i == 0,
+			r == ' ' && i == len(s)-1:
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// BER-кодирование минимально необходимого подмножества: только BindRequest и BindResponse
+
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func ber(tag byte, value []byte) []byte {
+	return append(append([]byte{tag}, berLength(len(value))...), value...)
+}
+
+func bindRequest(id byte, dn, password string) []byte {
+	bind := ber(0x02, []byte{3}) // version
+	bind = append(bind, ber(0x04, []byte(dn))...)
+	bind = append(bind, ber(0x80, []byte(password))...) // simple
+
+	msg := ber(0x02, []byte{id})
+	msg = append(msg, ber(0x60, bind)...) // [APPLICATION 0] BindRequest
+
+	return ber(0x30, msg)
+}
+
+var errBadResponse = errors.New("ldap: malformed bind response")
+
+// maxBindResponse ограничивает размер ответа, который читается от сервера каталога
+const maxBindResponse = 1 << 16
+
+// readTLV разбирает один элемент BER и возвращает его тег, значение и остаток
+func readTLV(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errBadResponse
+	}
+
+	tag, n, b := b[0], int(b[1]), b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(b) < size {
+			return 0, nil, nil, errBadResponse
+		}
+
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+
+	if len(b) < n {
+		return 0, nil, nil, errBadResponse
+	}
+
+	return tag, b[:n], b[n:], nil
+}
+
+func readBindResponse(r io.Reader) (int, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, err
+	}
+
+	// длина сообщения может быть в длинной форме
+	if head[1]&0x80 != 0 {
+		size := int(head[1] & 0x7f)
+		if size == 0 || size > 4 {
+			return 0, errBadResponse
+		}
+
+		ext := make([]byte, size)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return 0, err
+		}
+		head = append(head, ext...)
+	}
+
+	n := int(head[1])
+	if n&0x80 != 0 {
+		n = 0
+		for _, c := range head[2:] {
+			n = n<<8 | int(c)
+		}
+	}
+
+	if n > maxBindResponse {
+		return 0, errBadResponse
+	}
+
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, err
+	}
+
+	tag, msg, _, err := readTLV(append(head, body...))
+	if err != nil || tag != 0x30 {
+		return 0, errBadResponse
+	}
+
+	if _, _, msg, err = readTLV(msg); err != nil { // messageID
+		return 0, err
+	}
+
+	tag, resp, _, err := readTLV(msg)
+	if err != nil || tag != 0x61 { // [APPLICATION 1] BindResponse
+		return 0, errBadResponse
+	}
+
+	tag, code, _, err := readTLV(resp)
+	if err != nil || tag != 0x0a || len(code) == 0 {
+		return 0, errBadResponse
+	}
+
+	result := 0
+	for _, c := range code {
+		result = result<<8 | int(c)
+	}
+
+	return result, nil
+}
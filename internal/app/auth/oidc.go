@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OIDC проверяет пароль у провайдера идентификации через Resource Owner Password Credentials grant.
+// Выданный токен не используется: сервис ведет собственные сессии.
+type OIDC struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scope        string
+	Client       *http.Client
+}
+
+func (o OIDC) Authenticate(ctx context.Context, login, password string) error {
+	form := url.Values{
+		"grant_type": {"password"},
+		"client_id":  {o.ClientID},
+		"username":   {login},
+		"password":   {password},
+	}
+	if o.ClientSecret != "" {
+		form.Set("client_secret", o.ClientSecret)
+	}
+	if o.Scope != "" {
+		form.Set("scope", o.Scope)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusBadRequest, http.StatusUnauthorized:
+		// неверный пароль провайдер возвращает как 400 invalid_grant
+		return ErrInvalidCredentials
+	default:
+		return fmt.Errorf("oidc token endpoint status: %s", resp.Status)
+	}
+}
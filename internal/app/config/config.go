@@ -1,21 +1,155 @@
 package config
 
 import (
-	"errors"
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v6"
-	_ "github.com/lib/pq"
 )
 
 var C Config
 
 type Config struct {
-	RunAddress           string `env:"RUN_ADDRESS"`
-	DataBaseURI          string `env:"DATABASE_URI"`
-	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	RunAddress           string        `env:"RUN_ADDRESS"`
+	DataBaseURI          string        `env:"DATABASE_URI"`
+	DataBaseDialect      string        `env:"DATABASE_DIALECT" envDefault:"postgres"`
+	AccrualSystemAddress string        `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	SessionKey           string        `env:"SESSION_KEY" secret:"true"`
+	AuthMode             string        `env:"AUTH_MODE" envDefault:"cookie"`
+	Mode                 string        `env:"MODE" envDefault:"prod"`
+	OrdersDailyLimit     int           `env:"ORDERS_DAILY_LIMIT"`
+	SessionTTL           time.Duration `env:"SESSION_TTL" envDefault:"1h"`
+	SessionTouchInterval time.Duration `env:"SESSION_TOUCH_INTERVAL" envDefault:"30s"`
+	TokenSource          string        `env:"TOKEN_SOURCE" envDefault:"random"`
+	AdminLogins          []string      `env:"ADMIN_LOGINS" envSeparator:","`
+	QueueSaturation      int64         `env:"QUEUE_SATURATION" envDefault:"100"`
+	AccrualMaxBody       int64         `env:"ACCRUAL_MAX_BODY" envDefault:"4096"`
+	AccrualMax           float64       `env:"ACCRUAL_MAX" envDefault:"100000"`
+	AccrualPointRate     float64       `env:"ACCRUAL_POINT_RATE" envDefault:"1"`
+	MinWithdrawal        float64       `env:"MIN_WITHDRAWAL"`
+	SettingsTTL          time.Duration `env:"SETTINGS_TTL" envDefault:"30s"`
+	DBQueryTimeout       time.Duration `env:"DB_QUERY_TIMEOUT" envDefault:"1s"`
+	DBAuthTimeout        time.Duration `env:"DB_AUTH_TIMEOUT" envDefault:"200ms"`
+	DBListTimeout        time.Duration `env:"DB_LIST_TIMEOUT" envDefault:"2s"`
+	DBAccrualTimeout     time.Duration `env:"DB_ACCRUAL_TIMEOUT" envDefault:"5s"`
+	SelfTest             bool
+	SelfTestTimeout      time.Duration `env:"SELFTEST_TIMEOUT" envDefault:"1m"`
+
+	DBMaxConns        int           `env:"DB_MAX_CONNS" envDefault:"20"`
+	DBMaxConnIdleTime time.Duration `env:"DB_MAX_CONN_IDLE_TIME" envDefault:"5m"`
+	DBMaxConnLifetime time.Duration `env:"DB_MAX_CONN_LIFETIME" envDefault:"1h"`
+	DBRetryAttempts   int           `env:"DB_RETRY_ATTEMPTS" envDefault:"3"`
+	DBRetryBaseDelay  time.Duration `env:"DB_RETRY_BASE_DELAY" envDefault:"20ms"`
+	DBRetryMaxDelay   time.Duration `env:"DB_RETRY_MAX_DELAY" envDefault:"200ms"`
+	DBHealthInterval  time.Duration `env:"DB_HEALTH_INTERVAL" envDefault:"10s"`
+	DBReopenAfter     int           `env:"DB_REOPEN_AFTER" envDefault:"3"`
+
+	HTTP2              bool          `env:"HTTP2" envDefault:"true"`
+	HTTP2MaxStreams    int           `env:"HTTP2_MAX_STREAMS" envDefault:"250"`
+	HTTPKeepAlive      bool          `env:"HTTP_KEEP_ALIVE" envDefault:"true"`
+	HTTPIdleTimeout    time.Duration `env:"HTTP_IDLE_TIMEOUT" envDefault:"2m"`
+	HTTPMaxHeaderBytes int           `env:"HTTP_MAX_HEADER_BYTES" envDefault:"1048576"`
+
+	AccrualBreakerFailures int64         `env:"ACCRUAL_BREAKER_FAILURES" envDefault:"5"`
+	AccrualBreakerCooldown time.Duration `env:"ACCRUAL_BREAKER_COOLDOWN" envDefault:"30s"`
+	AccrualQuietHours      []string      `env:"ACCRUAL_QUIET_HOURS" envSeparator:";"`
+	AccrualQuietInterval   time.Duration `env:"ACCRUAL_QUIET_INTERVAL"`
+	AccrualTimezone        string        `env:"ACCRUAL_TIMEZONE" envDefault:"UTC"`
+	AccrualMaxOrderAge     time.Duration `env:"ACCRUAL_MAX_ORDER_AGE"`
+	AccrualWorkers         int           `env:"ACCRUAL_WORKERS" envDefault:"1"`
+	AccrualQueueSize       int           `env:"ACCRUAL_QUEUE_SIZE" envDefault:"1000"`
+	AccrualSweepInterval   time.Duration `env:"ACCRUAL_SWEEP_INTERVAL" envDefault:"1m"`
+	AccrualRepollDelay     time.Duration `env:"ACCRUAL_REPOLL_DELAY"`
+	AccrualBackoffBase     time.Duration `env:"ACCRUAL_BACKOFF_BASE" envDefault:"1s"`
+	AccrualBackoffMax      time.Duration `env:"ACCRUAL_BACKOFF_MAX" envDefault:"1m"`
+	AccrualTimeout         time.Duration `env:"ACCRUAL_TIMEOUT" envDefault:"10s"`
+	AccrualRateLimit       float64       `env:"ACCRUAL_RATE_LIMIT"`
+	AccrualRateBurst       int           `env:"ACCRUAL_RATE_BURST" envDefault:"1"`
+
+	ErrorBudget            float64       `env:"ERROR_BUDGET"`
+	ErrorBudgetWindow      time.Duration `env:"ERROR_BUDGET_WINDOW" envDefault:"1m"`
+	ErrorBudgetMinRequests int64         `env:"ERROR_BUDGET_MIN_REQUESTS" envDefault:"20"`
+	DegradedDuration       time.Duration `env:"DEGRADED_DURATION" envDefault:"5m"`
+
+	PasswordPepper     string `env:"PASSWORD_PEPPER" secret:"true"`
+	PasswordPepperFile string `env:"PASSWORD_PEPPER_FILE"`
+	PasswordKDF        string `env:"PASSWORD_KDF" envDefault:"scrypt"`
+	PasswordKDFCost    int    `env:"PASSWORD_KDF_COST"`
+
+	AuthRateLimit    float64       `env:"AUTH_RATE_LIMIT" envDefault:"10"`
+	AuthRateBurst    int           `env:"AUTH_RATE_BURST" envDefault:"5"`
+	AuthBackend      string        `env:"AUTH_BACKEND" envDefault:"local"`
+	AuthTimeout      time.Duration `env:"AUTH_TIMEOUT" envDefault:"5s"`
+	OIDCTokenURL     string        `env:"OIDC_TOKEN_URL"`
+	OIDCClientID     string        `env:"OIDC_CLIENT_ID"`
+	OIDCClientSecret string        `env:"OIDC_CLIENT_SECRET" secret:"true"`
+	OIDCScope        string        `env:"OIDC_SCOPE" envDefault:"openid"`
+	LDAPURL          string        `env:"LDAP_URL"`
+	LDAPBindDN       string        `env:"LDAP_BIND_DN"`
+
+	EmailVerification    bool          `env:"EMAIL_VERIFICATION"`
+	EmailVerificationTTL time.Duration `env:"EMAIL_VERIFICATION_TTL" envDefault:"24h"`
+	PublicURL            string        `env:"PUBLIC_URL"`
+	SMTPAddr             string        `env:"SMTP_ADDR"`
+	SMTPFrom             string        `env:"SMTP_FROM"`
+	SMTPUsername         string        `env:"SMTP_USERNAME"`
+	SMTPPassword         string        `env:"SMTP_PASSWORD" secret:"true"`
+
+	CookieHTTPOnly bool   `env:"COOKIE_HTTP_ONLY" envDefault:"true"`
+	CookieSecure   bool   `env:"COOKIE_SECURE"`
+	CookieSameSite string `env:"COOKIE_SAME_SITE" envDefault:"lax"`
+	CookieDomain   string `env:"COOKIE_DOMAIN"`
+	CookiePath     string `env:"COOKIE_PATH" envDefault:"/"`
+
+	FraudVelocityLimit  int           `env:"FRAUD_VELOCITY_LIMIT"`
+	FraudVelocityWindow time.Duration `env:"FRAUD_VELOCITY_WINDOW" envDefault:"1h"`
+	FraudLargeSum       float64       `env:"FRAUD_LARGE_SUM"`
+	FraudNewSession     time.Duration `env:"FRAUD_NEW_SESSION" envDefault:"24h"`
+	FraudCheckOrder     bool          `env:"FRAUD_CHECK_ORDER"`
+
+	BalanceSnapshotInterval time.Duration `env:"BALANCE_SNAPSHOT_INTERVAL" envDefault:"1h"`
+
+	UserRetention   time.Duration `env:"USER_RETENTION" envDefault:"8760h"`
+	OrderArchiveAge time.Duration `env:"ORDER_ARCHIVE_AGE"`
+
+	InactiveUserMonths int           `env:"INACTIVE_USER_MONTHS"`
+	InactiveUserGrace  time.Duration `env:"INACTIVE_USER_GRACE" envDefault:"720h"`
+
+	JSONCompat bool `env:"JSON_COMPAT" envDefault:"true"`
+
+	WarmUpTimeout time.Duration `env:"WARMUP_TIMEOUT" envDefault:"30s"`
+	ShutdownDrain time.Duration `env:"SHUTDOWN_DRAIN" envDefault:"5s"`
 }
 
+const (
+	AuthModeCookie = "cookie"
+	AuthModeJWT    = "jwt"
+)
+
+// Диалекты DATABASE_DIALECT: postgres - собственный сервер, managed - управляемый Postgres за пулером
+// соединений в режиме транзакций (Yandex Managed PostgreSQL)
+const (
+	DialectPostgres = "postgres"
+	DialectManaged  = "managed"
+)
+
+// Профили MODE: в dev ответы 5xx содержат причину ошибки, в prod - только код и номер запроса
+const (
+	ModeDev  = "dev"
+	ModeProd = "prod"
+)
+
+const (
+	SameSiteLax    = "lax"
+	SameSiteStrict = "strict"
+	SameSiteNone   = "none"
+)
+
 func GetConfig() (Config, error) {
 	if err := env.Parse(&C); err != nil {
 		return Config{}, err
@@ -23,11 +157,91 @@ func GetConfig() (Config, error) {
 
 	flag.StringVar(&C.RunAddress, "a", C.RunAddress, "run address")
 	flag.StringVar(&C.DataBaseURI, "d", C.DataBaseURI, "database uri")
+	flag.StringVar(&C.DataBaseDialect, "db-dialect", C.DataBaseDialect, "database dialect: postgres or managed (postgres behind a transaction pooler)")
 	flag.StringVar(&C.AccrualSystemAddress, "r", C.AccrualSystemAddress, "accrual system address")
+	flag.StringVar(&C.SessionKey, "k", C.SessionKey, "session signing key")
+	flag.StringVar(&C.AuthMode, "auth-mode", C.AuthMode, "authentication mode: cookie or jwt")
+	flag.StringVar(&C.Mode, "mode", C.Mode, "profile: dev adds error causes to 5xx responses, prod returns only the code and request id")
+	flag.IntVar(&C.OrdersDailyLimit, "orders-daily-limit", C.OrdersDailyLimit, "max uploaded orders per user per day, 0 - unlimited")
+	flag.DurationVar(&C.SessionTTL, "session-ttl", C.SessionTTL, "session lifetime")
+	flag.DurationVar(&C.SessionTouchInterval, "session-touch-interval", C.SessionTouchInterval, "how often session activity is written in one batch, 0 - not tracked")
+	flag.StringVar(&C.TokenSource, "token-source", C.TokenSource, "session id generator: random, uuidv7 or signed")
+	flag.DurationVar(&C.DBQueryTimeout, "db-query-timeout", C.DBQueryTimeout, "timeout of a single database call")
+	flag.DurationVar(&C.DBAuthTimeout, "db-auth-timeout", C.DBAuthTimeout, "timeout of session and password lookups, 0 - db-query-timeout")
+	flag.DurationVar(&C.DBListTimeout, "db-list-timeout", C.DBListTimeout, "timeout of order, withdrawal, session and report listings, 0 - db-query-timeout")
+	flag.DurationVar(&C.DBAccrualTimeout, "db-accrual-timeout", C.DBAccrualTimeout, "timeout of accrual updates by the polling worker, 0 - db-query-timeout")
+	flag.IntVar(&C.DBMaxConns, "db-max-conns", C.DBMaxConns, "max open connections in the database pool")
+	flag.DurationVar(&C.DBMaxConnIdleTime, "db-max-conn-idle-time", C.DBMaxConnIdleTime, "idle time after which a pooled connection is closed")
+	flag.DurationVar(&C.DBMaxConnLifetime, "db-max-conn-lifetime", C.DBMaxConnLifetime, "lifetime after which a pooled connection is closed")
+	flag.IntVar(&C.DBRetryAttempts, "db-retry-attempts", C.DBRetryAttempts, "attempts of a database call after transient errors, 1 - no retries")
+	flag.DurationVar(&C.DBRetryBaseDelay, "db-retry-base-delay", C.DBRetryBaseDelay, "backoff before the first retry, doubled for each next one")
+	flag.DurationVar(&C.DBRetryMaxDelay, "db-retry-max-delay", C.DBRetryMaxDelay, "max backoff between retries")
+	flag.DurationVar(&C.DBHealthInterval, "db-health-interval", C.DBHealthInterval, "how often the database connection is checked for readiness, 0 - on every readiness probe")
+	flag.IntVar(&C.DBReopenAfter, "db-reopen-after", C.DBReopenAfter, "consecutive failed health checks after which the connection pool is reopened, 0 - never")
+	flag.BoolVar(&C.CookieSecure, "cookie-secure", C.CookieSecure, "send cookies only over https")
+	flag.Int64Var(&C.QueueSaturation, "queue-saturation", C.QueueSaturation, "accrual poll queue depth reported as saturated, 0 - never")
+	flag.Int64Var(&C.AccrualBreakerFailures, "accrual-breaker-failures", C.AccrualBreakerFailures, "consecutive failed accrual polls that pause polling, 0 - never")
+	flag.DurationVar(&C.AccrualQuietInterval, "accrual-quiet-interval", C.AccrualQuietInterval, "min interval between accrual polls during quiet hours, 0 - polling is paused")
+	flag.Float64Var(&C.AccrualMax, "accrual-max", C.AccrualMax, "max accrual per order, larger responses are quarantined, 0 - unlimited")
+	flag.DurationVar(&C.AccrualMaxOrderAge, "accrual-max-order-age", C.AccrualMaxOrderAge, "age after which a NEW or PROCESSING order is marked EXPIRED and no longer polled, 0 - polled until final status")
+	flag.IntVar(&C.AccrualWorkers, "accrual-workers", C.AccrualWorkers, "concurrent accrual polls")
+	flag.IntVar(&C.AccrualQueueSize, "accrual-queue-size", C.AccrualQueueSize, "max orders waiting for an accrual poll, the rest are picked up by the next sweep")
+	flag.DurationVar(&C.AccrualSweepInterval, "accrual-sweep-interval", C.AccrualSweepInterval, "how often unpolled NEW and PROCESSING orders are loaded into the accrual queue")
+	flag.DurationVar(&C.AccrualRepollDelay, "accrual-repoll-delay", C.AccrualRepollDelay, "delay before polling again an order without a final accrual status, kept across restarts and rounded up to the sweep interval, 0 - after accrual-backoff-base")
+	flag.DurationVar(&C.AccrualBackoffBase, "accrual-backoff-base", C.AccrualBackoffBase, "delay before polling again an order without a final accrual status or after an error, doubled for each next poll, 0 - at once")
+	flag.DurationVar(&C.AccrualBackoffMax, "accrual-backoff-max", C.AccrualBackoffMax, "max delay between polls of an order")
+	flag.DurationVar(&C.AccrualTimeout, "accrual-timeout", C.AccrualTimeout, "timeout of a single accrual request")
+	flag.Float64Var(&C.AccrualRateLimit, "accrual-rate-limit", C.AccrualRateLimit, "max accrual requests per second shared by all workers, 0 - unlimited")
+	flag.IntVar(&C.AccrualRateBurst, "accrual-rate-burst", C.AccrualRateBurst, "accrual requests allowed at once above accrual-rate-limit")
+	flag.Float64Var(&C.AccrualPointRate, "accrual-point-rate", C.AccrualPointRate, "loyalty points credited per accrual unit, e.g. 0.1 - 10 units = 1 point")
+	flag.Float64Var(&C.MinWithdrawal, "min-withdrawal", C.MinWithdrawal, "min withdrawal sum, 0 - any positive sum")
+	flag.DurationVar(&C.SettingsTTL, "settings-ttl", C.SettingsTTL, "how long settings changed via the admin API may take to reach other instances, 0 - read on every use")
+	flag.Float64Var(&C.AuthRateLimit, "auth-rate-limit", C.AuthRateLimit, "register and login attempts per minute per client ip and per login, 0 - unlimited")
+	flag.Float64Var(&C.ErrorBudget, "error-budget", C.ErrorBudget, "share of 5xx responses per route in a window that switches the route to read-only, 0 - never")
+	flag.BoolVar(&C.HTTP2, "http2", C.HTTP2, "serve cleartext HTTP/2 (h2c) alongside HTTP/1.1")
+	flag.IntVar(&C.HTTP2MaxStreams, "http2-max-streams", C.HTTP2MaxStreams, "max concurrent HTTP/2 streams per connection")
+	flag.DurationVar(&C.HTTPIdleTimeout, "http-idle-timeout", C.HTTPIdleTimeout, "how long an idle keep-alive connection is kept open")
+	flag.DurationVar(&C.BalanceSnapshotInterval, "balance-snapshot-interval", C.BalanceSnapshotInterval, "how often end-of-day balance snapshots are recorded, 0 - not recorded")
+	flag.DurationVar(&C.UserRetention, "user-retention", C.UserRetention, "how long orders and withdrawals of a deleted account are kept before it is purged, 0 - kept forever")
+	flag.IntVar(&C.InactiveUserMonths, "inactive-user-months", C.InactiveUserMonths, "months without logins and requests after which the user is warned and a zero balance account is anonymized, 0 - never")
+	flag.DurationVar(&C.InactiveUserGrace, "inactive-user-grace", C.InactiveUserGrace, "how long after the inactivity warning the account is anonymized")
+	flag.DurationVar(&C.OrderArchiveAge, "order-archive-age", C.OrderArchiveAge, "age after which processed and invalid orders move to the archive table, 0 - never archived")
+	flag.BoolVar(&C.JSONCompat, "json-compat", C.JSONCompat, "omit zero accruals and format times with the server timezone offset, as before; false - always send accrual, times in UTC")
+	flag.DurationVar(&C.WarmUpTimeout, "warmup-timeout", C.WarmUpTimeout, "how long /api/status and /api/ready answer 503 at start while db connections are opened and the accrual system is checked, 0 - no warm-up")
+	flag.DurationVar(&C.ShutdownDrain, "shutdown-drain", C.ShutdownDrain, "how long /api/status answers 503 after SIGTERM before the server stops accepting connections")
+	flag.BoolVar(&C.SelfTest, "selftest", false, "run register, upload, accrue and withdraw flow against the configured db and accrual system, then exit")
 	flag.Parse()
+	sources = detectSources()
+
+	if err := C.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if C.PasswordPepperFile != "" {
+		b, err := os.ReadFile(C.PasswordPepperFile)
+		if err != nil {
+			return Config{}, err
+		}
+
+		C.PasswordPepper = strings.TrimRight(string(b), "\r\n")
+		sources["PasswordPepper"] = SourceFile
+	}
+
+	if C.PublicURL == "" {
+		C.PublicURL = "http://" + C.RunAddress
+	}
+	C.PublicURL = strings.TrimSuffix(C.PublicURL, "/")
+
+	if C.SessionKey == "" {
+		// без ключа сессии подписываются случайным ключом и не переживают перезапуск
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return Config{}, err
+		}
 
-	if C.RunAddress == "" || C.AccrualSystemAddress == "" || C.DataBaseURI == "" {
-		return Config{}, errors.New("error config")
+		C.SessionKey = hex.EncodeToString(b)
+		sources["SessionKey"] = SourceGenerated
+		log.Print("config: session key is not set, using random key")
 	}
 
 	return C, nil
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"flag"
+	"net/url"
+	"os"
+	"reflect"
+	"time"
+)
+
+// Источники значений конфигурации
+const (
+	SourceDefault   = "default"
+	SourceEnv       = "env"
+	SourceFlag      = "flag"
+	SourceFile      = "file"
+	SourceGenerated = "generated"
+)
+
+const redacted = "[redacted]"
+
+// flagFields - поле Config, которое задает флаг командной строки
+var flagFields = map[string]string{
+	"a":                         "RunAddress",
+	"d":                         "DataBaseURI",
+	"db-dialect":                "DataBaseDialect",
+	"r":                         "AccrualSystemAddress",
+	"k":                         "SessionKey",
+	"auth-mode":                 "AuthMode",
+	"orders-daily-limit":        "OrdersDailyLimit",
+	"session-ttl":               "SessionTTL",
+	"session-touch-interval":    "SessionTouchInterval",
+	"token-source":              "TokenSource",
+	"db-query-timeout":          "DBQueryTimeout",
+	"db-auth-timeout":           "DBAuthTimeout",
+	"db-list-timeout":           "DBListTimeout",
+	"db-accrual-timeout":        "DBAccrualTimeout",
+	"cookie-secure":             "CookieSecure",
+	"queue-saturation":          "QueueSaturation",
+	"accrual-max":               "AccrualMax",
+	"accrual-point-rate":        "AccrualPointRate",
+	"min-withdrawal":            "MinWithdrawal",
+	"settings-ttl":              "SettingsTTL",
+	"accrual-max-order-age":     "AccrualMaxOrderAge",
+	"accrual-workers":           "AccrualWorkers",
+	"accrual-queue-size":        "AccrualQueueSize",
+	"accrual-sweep-interval":    "AccrualSweepInterval",
+	"accrual-repoll-delay":      "AccrualRepollDelay",
+	"accrual-backoff-base":      "AccrualBackoffBase",
+	"accrual-backoff-max":       "AccrualBackoffMax",
+	"accrual-rate-limit":        "AccrualRateLimit",
+	"accrual-rate-burst":        "AccrualRateBurst",
+	"accrual-timeout":           "AccrualTimeout",
+	"accrual-breaker-failures":  "AccrualBreakerFailures",
+	"auth-rate-limit":           "AuthRateLimit",
+	"error-budget":              "ErrorBudget",
+	"http2":                     "HTTP2",
+	"http2-max-streams":         "HTTP2MaxStreams",
+	"http-idle-timeout":         "HTTPIdleTimeout",
+	"db-max-conns":              "DBMaxConns",
+	"db-max-conn-idle-time":     "DBMaxConnIdleTime",
+	"db-max-conn-lifetime":      "DBMaxConnLifetime",
+	"db-retry-attempts":         "DBRetryAttempts",
+	"db-retry-base-delay":       "DBRetryBaseDelay",
+	"db-retry-max-delay":        "DBRetryMaxDelay",
+	"db-health-interval":        "DBHealthInterval",
+	"db-reopen-after":           "DBReopenAfter",
+	"accrual-quiet-interval":    "AccrualQuietInterval",
+	"mode":                      "Mode",
+	"balance-snapshot-interval": "BalanceSnapshotInterval",
+	"user-retention":            "UserRetention",
+	"inactive-user-months":      "InactiveUserMonths",
+	"inactive-user-grace":       "InactiveUserGrace",
+	"order-archive-age":         "OrderArchiveAge",
+	"json-compat":               "JSONCompat",
+	"warmup-timeout":            "WarmUpTimeout",
+	"shutdown-drain":            "ShutdownDrain",
+	"selftest":                  "SelfTest",
+}
+
+// sources - источник значения каждого поля Config, заполняется в GetConfig
+var sources = map[string]string{}
+
+// Setting - действующее значение параметра конфигурации и его источник
+type Setting struct {
+	Name   string      `json:"name"`
+	Env    string      `json:"env,omitempty"`
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// detectSources определяет источник каждого поля: флаг важнее переменной окружения,
+// без обоих действует значение по умолчанию
+func detectSources() map[string]string {
+	set := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		if field, ok := flagFields[f.Name]; ok {
+			set[field] = SourceFlag
+		}
+	})
+
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if _, ok := set[field.Name]; ok {
+			continue
+		}
+
+		set[field.Name] = SourceDefault
+		if name := field.Tag.Get("env"); name != "" {
+			if _, ok := os.LookupEnv(name); ok {
+				set[field.Name] = SourceEnv
+			}
+		}
+	}
+
+	return set
+}
+
+// Describe возвращает параметры конфигурации в порядке объявления, длительности - строкой вида 1m0s.
+// Значения полей с тегом secret скрываются, у адреса базы скрывается только пароль.
+func Describe(c Config) []Setting {
+	v := reflect.ValueOf(c)
+	t := v.Type()
+
+	settings := make([]Setting, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i).Interface()
+
+		switch {
+		case field.Type == reflect.TypeOf(time.Duration(0)):
+			value = value.(time.Duration).String()
+		case field.Name == "DataBaseURI":
+			value = redactURI(c.DataBaseURI)
+		case field.Tag.Get("secret") == "true" && !v.Field(i).IsZero():
+			value = redacted
+		}
+
+		source, ok := sources[field.Name]
+		if !ok {
+			source = SourceDefault
+		}
+
+		settings = append(settings, Setting{Name: field.Name, Env: field.Tag.Get("env"), Value: value, Source: source})
+	}
+
+	return settings
+}
+
+// redactURI скрывает пароль в адресе базы; строку подключения не в виде URL скрывает целиком
+func redactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" {
+		if uri == "" {
+			return ""
+		}
+		return redacted
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+
+	return u.String()
+}
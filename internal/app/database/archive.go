@@ -0,0 +1,43 @@
+package database
+
+import "time"
+
+// archiveBatch - заказов, переносимых в архив одним запросом: блокировки строк держатся недолго
+const archiveBatch = 1000
+
+var (
+	// Архив заказов orders_archive:
+	dbArchiveOrders = `WITH moved AS (
+							DELETE FROM orders WHERE number IN (
+								SELECT number FROM orders
+								WHERE status IN ('PROCESSED', 'INVALID') AND userid IS NOT NULL AND uploaded_at < $1
+								ORDER BY uploaded_at LIMIT $2 FOR UPDATE SKIP LOCKED)
+							RETURNING number, userid, status, accrual, uploaded_at, created_at, updated_at, revision, seq)
+						INSERT INTO orders_archive (number, userid, status, accrual, uploaded_at, created_at, updated_at, revision, seq)
+						SELECT number, userid, status, accrual, uploaded_at, created_at, updated_at, revision, seq FROM moved`
+)
+
+// ArchiveOrders переносит в orders_archive обработанные и отклоненные заказы пользователей,
+// загруженные до before, партиями по archiveBatch. Возвращает число перенесенных заказов.
+func (db *DataBase) ArchiveOrders(before time.Time) (int64, error) {
+	var archived int64
+	for {
+		n, err := db.archiveOrders(before)
+		archived += n
+		if err != nil || n < archiveBatch {
+			return archived, err
+		}
+	}
+}
+
+func (db *DataBase) archiveOrders(before time.Time) (int64, error) {
+	ctx, cancel := db.context("ArchiveOrders")
+	defer cancel()
+
+	exec, err := db.pool().Exec(ctx, dbArchiveOrders, before, archiveBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	return exec.RowsAffected(), nil
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Действия в журнале аудита
+const (
+	AuditImpersonate = "impersonate"
+	AuditRequest     = "request"
+	AuditDenied      = "denied"
+	AuditRename      = "rename"
+)
+
+// Impersonation - вход администратора Admin от имени пользователя Login до ExpiresAt. Session -
+// идентификатор cookie новой сессии, пустой - вход по токену доступа без сессии в базе.
+type Impersonation struct {
+	Admin     string
+	Login     string
+	Session   string
+	ReadOnly  bool
+	ExpiresAt time.Time
+	UserAgent string
+	IP        string
+}
+
+var (
+	// Таблица журнала аудита audit_log:
+	dbAddAudit           = `INSERT INTO audit_log (actor, login, action, detail) VALUES ($1, $2, $3, $4)`
+	dbUserExists         = `SELECT EXISTS (SELECT 1 FROM users WHERE login = $1 AND deleted_at IS NULL)`
+	dbImpersonateSession = `INSERT INTO sessions (id, userid, expires_at, user_agent, ip, impersonator, read_only)
+								SELECT $1, userid, $3, $4, $5, $6, $7 FROM users WHERE login = $2`
+)
+
+// Impersonate записывает в журнал аудита вход администратора от имени пользователя и создает
+// сессию этого входа. Несуществующий пользователь - ErrNotFound.
+func (db *DataBase) Impersonate(i Impersonation) error {
+	ctx, cancel := db.context("Impersonate")
+	defer cancel()
+
+	detail := "read-write until " + i.ExpiresAt.UTC().Format(time.RFC3339)
+	if i.ReadOnly {
+		detail = "read-only until " + i.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+
+	return db.WithTx(ctx, func(tx pgx.Tx) error {
+		var exists bool
+		if err := tx.QueryRow(ctx, dbUserExists, i.Login).Scan(&exists); err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrNotFound
+		}
+
+		if i.Session != "" {
+			_, err := tx.Exec(ctx, dbImpersonateSession, i.Session, i.Login, i.ExpiresAt, i.UserAgent, i.IP, i.Admin, i.ReadOnly)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Exec(ctx, dbAddAudit, i.Admin, i.Login, AuditImpersonate, detail)
+		return err
+	})
+}
+
+// AddAudit записывает действие администратора actor от имени пользователя login
+func (db *DataBase) AddAudit(actor, login, action, detail string) error {
+	ctx, cancel := db.context("AddAudit")
+	defer cancel()
+
+	if _, err := db.pool().Exec(ctx, dbAddAudit, actor, login, action, detail); err != nil {
+		return err
+	}
+
+	return nil
+}
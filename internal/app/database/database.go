@@ -6,66 +6,221 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
-	_ "github.com/lib/pq"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/password"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/settings"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type DataBase struct {
-	DB *sql.DB
+	// db - пул соединений, заменяется новым, если перестал работать (см. RunHealthCheck)
+	db          atomic.Pointer[pgxpool.Pool]
+	poolConfig  *pgxpool.Config
+	dialect     dialect
+	health      health
+	orders      orderLocks
+	timeouts    queryTimeouts
+	retry       retryPolicy
+	settings    *settings.Cache
+	maxOrderAge time.Duration
+	metrics     MetricsRecorder
+	passwords   password.Hasher
+	// dummyHash проверяется вместо хеша несуществующего пользователя, чтобы время ответа
+	// не выдавало, зарегистрирован ли логин
+	dummyHash string
 }
 
-var (
-	ErrUsed             = errors.New("used")
-	ErrEmpty            = errors.New("empty")
-	ErrNoMoney          = errors.New("no money")
-	ErrDuplicate        = errors.New("duplicate")
-	ErrWrongData        = errors.New("wrong data")
-	ErrBadOrderNumber   = errors.New("bad order number")
-	ErrRegisterConflict = errors.New("register conflict")
-)
+func StartDB(c config.Config) (*DataBase, error) {
+	poolConfig, err := pgxpool.ParseConfig(c.DataBaseURI)
+	if err != nil {
+		return nil, fmt.Errorf("pgxpool parse config err: %s", err.Error())
+	}
 
-var dbCreateTables = `CREATE TABLE IF NOT EXISTS users (
-							userid			SERIAL  PRIMARY KEY NOT NULL,
-							login			VARCHAR UNIQUE		NOT NULL,
-							password		VARCHAR 			NOT NULL,
-							cookie			VARCHAR UNIQUE		NULL);
-	
-					CREATE TABLE IF NOT EXISTS orders (
-							number 			VARCHAR PRIMARY KEY NOT NULL,
-							login 			VARCHAR 			NOT NULL,
-							status 			VARCHAR 			NOT NULL	DEFAULT 'NEW',
-							accrual 		NUMERIC 			NULL,
-							uploaded_at 	VARCHAR				NOT NULL);
-	
-					CREATE TABLE IF NOT EXISTS withdraw (
-							orderID 		VARCHAR PRIMARY KEY NOT NULL,
-							login 			VARCHAR 			NOT NULL,
-							sum 			NUMERIC 			NOT NULL,
-							processed_at	VARCHAR 			NOT NULL);`
+	// нулевые значения оставляют pool_max_conns и прочие параметры из DATABASE_URI или умолчания pgxpool
+	if c.DBMaxConns > 0 {
+		poolConfig.MaxConns = int32(c.DBMaxConns)
+	}
+	if c.DBMaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = c.DBMaxConnIdleTime
+	}
+	if c.DBMaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = c.DBMaxConnLifetime
+	}
 
-func StartDB(c config.Config) (*DataBase, error) {
-	db, err := sql.Open("postgres", c.DataBaseURI)
+	queryTimeout := c.DBQueryTimeout
+	if queryTimeout <= 0 {
+		queryTimeout = time.Second
+	}
+
+	dl, err := newDialect(c.DataBaseDialect)
 	if err != nil {
-		return nil, fmt.Errorf("sql open err: %s", err.Error())
+		return nil, err
+	}
+
+	// без подготовки запросов pgx не кеширует их на соединении и отправляет каждый с безымянной подготовкой
+	if !dl.prepare {
+		poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeExec
+	}
+
+	// миграции применяются на отдельном соединении до открытия пула: соединения пула готовят
+	// запросы при открытии, и таблицы этих запросов уже должны быть в нужном виде
+	if err = migrateConn(poolConfig.ConnConfig, queryTimeout); err != nil {
+		return nil, err
+	}
+
+	if dl.prepare {
+		poolConfig.AfterConnect = prepare
 	}
+	poolConfig.ConnConfig.Tracer = queryTracer{}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
 	defer cancel()
 
-	if err = db.PingContext(ctx); err != nil {
+	db, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Ping(ctx); err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	log.Print("DB open")
 
-	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
+	kdf, err := password.NewKDF(c.PasswordKDF, c.PasswordKDFCost)
+	if err != nil {
+		return nil, err
+	}
 
-	if _, err = db.ExecContext(ctx, dbCreateTables); err != nil {
+	passwords := password.Hasher{Pepper: []byte(c.PasswordPepper), KDF: kdf}
+	dummyHash, err := passwords.Hash("")
+	if err != nil {
 		return nil, err
 	}
 
-	return &DataBase{DB: db}, nil
+	d := &DataBase{
+		poolConfig: poolConfig,
+		dialect:    dl,
+		timeouts: queryTimeouts{
+			query:   queryTimeout,
+			auth:    c.DBAuthTimeout,
+			list:    c.DBListTimeout,
+			accrual: c.DBAccrualTimeout,
+		},
+		retry:       retryPolicy{attempts: c.DBRetryAttempts, baseDelay: c.DBRetryBaseDelay, maxDelay: c.DBRetryMaxDelay},
+		maxOrderAge: c.AccrualMaxOrderAge,
+		passwords:   passwords,
+		dummyHash:   dummyHash,
+	}
+	d.settings = settings.NewCache(settings.Defaults(c), d, c.SettingsTTL)
+	d.db.Store(db)
+	d.health.healthy.Store(true)
+
+	return d, nil
+}
+
+// pool возвращает действующий пул соединений
+func (db *DataBase) pool() *pgxpool.Pool {
+	return db.db.Load()
+}
+
+// pointRate - действующий курс начисления баллов
+func (db *DataBase) pointRate() float64 {
+	return db.Settings().AccrualPointRate
+}
+
+// sessionTTL - действующее время жизни сессии
+func (db *DataBase) sessionTTL() time.Duration {
+	return db.Settings().SessionTTL
+}
+
+// Close закрывает пул соединений
+func (db *DataBase) Close() {
+	db.pool().Close()
+}
+
+// migrateConn открывает соединение с базой и применяет на нем миграции
+func migrateConn(c *pgx.ConnConfig, connectTimeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	conn, err := pgx.ConnectConfig(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	// миграции заполняют новые столбцы по всей таблице, таймаута одного запроса им мало
+	ctx, cancel = context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	defer func() {
+		_ = conn.Close(ctx)
+	}()
+
+	return migrate(ctx, conn)
+}
+
+// WithTx выполняет fn в одной транзакции: фиксирует ее, если fn вернула nil, иначе откатывает.
+// После временной ошибки базы транзакция повторяется целиком, поэтому fn заново задает все,
+// что возвращает через замыкание.
+func (db *DataBase) WithTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	err := db.retry.do(ctx, func() error {
+		return db.withTx(ctx, fn)
+	})
+
+	return wrapQuery(ctx, err)
+}
+
+func (db *DataBase) withTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := db.pool().Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Ping проверяет соединение с базой
+func (db *DataBase) Ping() error {
+	ctx, cancel := db.context("Ping")
+	defer cancel()
+
+	return db.pool().Ping(ctx)
+}
+
+// Stats возвращает состояние пула соединений с базой в полях sql.DBStats, в которых его отдают
+// метрики. WaitCount - получения соединения из пустого пула, WaitDuration - суммарное время получения.
+func (db *DataBase) Stats() sql.DBStats {
+	s := db.pool().Stat()
+	return sql.DBStats{
+		MaxOpenConnections: int(s.MaxConns()),
+		OpenConnections:    int(s.TotalConns()),
+		InUse:              int(s.AcquiredConns()),
+		Idle:               int(s.IdleConns()),
+		WaitCount:          s.EmptyAcquireCount(),
+		WaitDuration:       s.AcquireDuration(),
+		MaxIdleTimeClosed:  s.MaxIdleDestroyCount(),
+		MaxLifetimeClosed:  s.MaxLifetimeDestroyCount(),
+	}
+}
+
+// uniqueViolation сообщает, что запрос нарушил уникальность constraint
+func uniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation && pgErr.ConstraintName == constraint
 }
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+)
+
+// dialect - особенности сервера базы, выбранного DATABASE_DIALECT. Запросы сервиса пишутся
+// для Postgres, dialect меняет только то, что на другом сервере не работает.
+type dialect struct {
+	// prepare - запросы preparedStatements готовятся на соединениях пула. Пулер в режиме
+	// транзакций отдает запросу любое серверное соединение, и подготовленного там может не быть.
+	prepare bool
+}
+
+var dialects = map[string]dialect{
+	config.DialectPostgres: {prepare: true},
+	config.DialectManaged:  {},
+}
+
+// newDialect возвращает диалект name, пустое name - Postgres
+func newDialect(name string) (dialect, error) {
+	if name == "" {
+		name = config.DialectPostgres
+	}
+
+	d, ok := dialects[name]
+	if !ok {
+		return dialect{}, fmt.Errorf("unknown database dialect %q", name)
+	}
+
+	return d, nil
+}
+
+// stmt возвращает, что передать вызову вместо запроса preparedStatements[name]: имя
+// подготовленного запроса или, без подготовки, его текст
+func (d dialect) stmt(name string) string {
+	if d.prepare {
+		return name
+	}
+
+	return preparedStatements[name]
+}
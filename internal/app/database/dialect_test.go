@@ -0,0 +1,38 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+)
+
+func TestDialect(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect string
+		stmt    string
+	}{
+		{name: "по умолчанию", stmt: stmtGetLogin},
+		{name: config.DialectPostgres, dialect: config.DialectPostgres, stmt: stmtGetLogin},
+		{name: config.DialectManaged, dialect: config.DialectManaged, stmt: dbGetLogin},
+		{name: "cockroachdb", dialect: "cockroachdb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newDialect(tt.dialect)
+			if tt.stmt == "" {
+				if err == nil {
+					t.Errorf("newDialect(%s) err = nil, want error", tt.dialect)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := d.stmt(stmtGetLogin); got != tt.stmt {
+				t.Errorf("stmt() = %q, want %q", got, tt.stmt)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Ошибки правил хранилища. Сравниваются через errors.Is, обработчики переводят их в коды ответа;
+// остальные ошибки - сбои запросов к базе, транзакция возвращает их как *QueryError.
+var (
+	ErrUsed             = errors.New("used")
+	ErrEmpty            = errors.New("empty")
+	ErrNoMoney          = errors.New("no money")
+	ErrDuplicate        = errors.New("duplicate")
+	ErrNotFound         = errors.New("not found")
+	ErrWrongData        = errors.New("wrong data")
+	ErrBadOrderNumber   = errors.New("bad order number")
+	ErrRegisterConflict = errors.New("register conflict")
+	ErrNotVerified      = errors.New("not verified")
+	// ErrConflict - запись изменилась после чтения, вызывающий перечитывает ее и повторяет
+	ErrConflict = errors.New("conflict")
+)
+
+var ruleErrors = []error{ErrUsed, ErrEmpty, ErrNoMoney, ErrDuplicate, ErrNotFound, ErrWrongData, ErrBadOrderNumber,
+	ErrRegisterConflict, ErrNotVerified, ErrConflict}
+
+// QueryError - сбой запроса к базе в вызове хранилища Method. Query - начало запроса, на котором
+// вызов упал. Исходная ошибка доступна через errors.Is и errors.As, например *pgconn.PgError.
+type QueryError struct {
+	Method string
+	Query  string
+	Err    error
+}
+
+// maxQueryContext ограничивает текст запроса в сообщении об ошибке
+const maxQueryContext = 60
+
+func (e *QueryError) Error() string {
+	if e.Query == "" {
+		return "db " + e.Method + ": " + e.Err.Error()
+	}
+
+	return "db " + e.Method + ": " + e.Err.Error() + " (query: " + e.Query + ")"
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// wrapQuery дополняет сбой запроса именем вызова из контекста DataBase.context и запросом, на котором
+// он упал. Ошибки правил хранилища и ошибки вне вызова хранилища возвращаются как есть.
+func wrapQuery(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	for _, rule := range ruleErrors {
+		if errors.Is(err, rule) {
+			return err
+		}
+	}
+
+	var queryErr *QueryError
+	if errors.As(err, &queryErr) {
+		return err
+	}
+
+	c, ok := ctx.Value(callKey{}).(*call)
+	if !ok {
+		return err
+	}
+
+	return &QueryError{Method: c.method, Query: queryContext(c.query), Err: err}
+}
+
+// queryContext - первая строка запроса со сжатыми пробелами, не длиннее maxQueryContext.
+// Запросы параметризованы, значений пользователя в тексте нет.
+func queryContext(sql string) string {
+	sql, _, _ = strings.Cut(strings.TrimSpace(sql), "\n")
+	sql = strings.Join(strings.Fields(sql), " ")
+	if len(sql) > maxQueryContext {
+		sql = sql[:maxQueryContext] + "..."
+	}
+
+	return sql
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestWrapQuery(t *testing.T) {
+	db := &DataBase{timeouts: queryTimeouts{query: time.Second}}
+	ctx, cancel := db.context("UpdateOrder")
+	defer cancel()
+
+	pgErr := &pgconn.PgError{Code: pgerrcode.UniqueViolation, Message: "duplicate key"}
+	queryTracer{}.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "SELECT 1"})
+	queryTracer{}.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: dbUpdateOrder})
+	queryTracer{}.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: pgErr})
+	// откат после ошибки не заменяет запрос, на котором упал вызов
+	queryTracer{}.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "rollback"})
+
+	t.Run("Сбой запроса", func(t *testing.T) {
+		err := wrapQuery(ctx, fmt.Errorf("update: %w", pgErr))
+
+		var queryErr *QueryError
+		if !errors.As(err, &queryErr) {
+			t.Fatalf("wrapQuery() = %v, want *QueryError", err)
+		}
+		if queryErr.Method != "UpdateOrder" || !strings.HasPrefix(queryErr.Query, "UPDATE orders SET status = $1") {
+			t.Errorf("wrapQuery() = %+v, want UpdateOrder and its query", queryErr)
+		}
+
+		var got *pgconn.PgError
+		if !errors.As(err, &got) || got.Code != pgerrcode.UniqueViolation {
+			t.Errorf("wrapQuery() lost *pgconn.PgError: %v", err)
+		}
+		if again := wrapQuery(ctx, err); again != err {
+			t.Errorf("wrapQuery() wrapped twice: %v", again)
+		}
+	})
+
+	t.Run("Ошибка правил", func(t *testing.T) {
+		err := fmt.Errorf("order: %w", ErrConflict)
+		if got := wrapQuery(ctx, err); got != err {
+			t.Errorf("wrapQuery() = %v, want %v as is", got, err)
+		}
+	})
+
+	t.Run("Вне вызова хранилища", func(t *testing.T) {
+		if got := wrapQuery(context.Background(), pgErr); got != error(pgErr) {
+			t.Errorf("wrapQuery() = %v, want %v as is", got, pgErr)
+		}
+	})
+}
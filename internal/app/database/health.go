@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// health - результат периодической проверки соединения с базой
+type health struct {
+	checking atomic.Bool
+	healthy  atomic.Bool
+	failed   int // неудачные проверки подряд, меняется только горутиной проверки
+}
+
+// observe учитывает результат проверки err и сообщает, что пул пора открыть заново: проверка
+// не прошла failures раз подряд. failures <= 0 - пул заново не открывается.
+func (h *health) observe(err error, failures int) bool {
+	if err == nil {
+		h.failed = 0
+		h.healthy.Store(true)
+		return false
+	}
+
+	h.failed++
+	h.healthy.Store(false)
+
+	return failures > 0 && h.failed >= failures
+}
+
+// RunHealthCheck раз в interval проверяет соединение с базой, результат отдает Healthy. После
+// failures неудачных проверок подряд пул соединений открывается заново: прежний закрывается, когда
+// вернутся взятые из него соединения. failures <= 0 - только проверка. Работает до отмены ctx.
+func (db *DataBase) RunHealthCheck(ctx context.Context, interval time.Duration, failures int) error {
+	db.health.checking.Store(true)
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+			timer.Reset(interval)
+		}
+
+		err := db.Ping()
+		if err != nil {
+			log.Printf("db health: check failed %d times in a row, err: %s", db.health.failed+1, err.Error())
+		} else if db.health.failed > 0 {
+			log.Printf("db health: recovered after %d failed checks", db.health.failed)
+		}
+
+		if !db.health.observe(err, failures) {
+			continue
+		}
+
+		if err = db.reopen(); err != nil {
+			log.Print("db health: reopen err: ", err.Error())
+			continue
+		}
+
+		db.health.observe(nil, failures)
+	}
+}
+
+// Healthy сообщает результат последней проверки RunHealthCheck. Без периодической проверки
+// соединение проверяется при вызове.
+func (db *DataBase) Healthy() bool {
+	if !db.health.checking.Load() {
+		return db.Ping() == nil
+	}
+
+	return db.health.healthy.Load()
+}
+
+// WarmUp открывает соединения пула до его предела и читает настройки в кеш, чтобы первые запросы
+// после запуска не ждали соединения с базой
+func (db *DataBase) WarmUp(ctx context.Context) error {
+	pool := db.pool()
+
+	conns := make([]*pgxpool.Conn, 0, pool.Config().MaxConns)
+	defer func() {
+		for _, conn := range conns {
+			conn.Release()
+		}
+	}()
+
+	for range cap(conns) {
+		conn, err := pool.Acquire(ctx)
+		if err != nil {
+			return err
+		}
+
+		conns = append(conns, conn)
+	}
+
+	db.Settings()
+
+	return nil
+}
+
+// reopen открывает новый пул соединений и заменяет им действующий, если новый отвечает
+func (db *DataBase) reopen() error {
+	ctx, cancel := context.WithTimeout(context.Background(), db.timeouts.query)
+	defer cancel()
+
+	pool, err := pgxpool.NewWithConfig(ctx, db.poolConfig.Copy())
+	if err != nil {
+		return err
+	}
+
+	if err = pool.Ping(ctx); err != nil {
+		pool.Close()
+		return err
+	}
+
+	// Close ждет возврата взятых соединений, запросы на них дорабатываются на прежнем пуле
+	go db.db.Swap(pool).Close()
+
+	log.Print("db health: pool reopened")
+
+	return nil
+}
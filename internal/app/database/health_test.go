@@ -0,0 +1,37 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHealthObserve(t *testing.T) {
+	errPing := errors.New("connection refused")
+
+	var h health
+	h.healthy.Store(true)
+
+	for i := 1; i < 3; i++ {
+		if h.observe(errPing, 3) {
+			t.Fatalf("observe() after %d failures = true, want false", i)
+		}
+		if h.healthy.Load() {
+			t.Fatalf("healthy after %d failures, want unhealthy", i)
+		}
+	}
+
+	if !h.observe(errPing, 3) {
+		t.Error("observe() after 3 failures = false, want reopen")
+	}
+
+	// успешная проверка сбрасывает счетчик неудач
+	if h.observe(nil, 3) || !h.healthy.Load() || h.failed != 0 {
+		t.Errorf("observe(nil) = healthy %t, failed %d, want healthy, 0", h.healthy.Load(), h.failed)
+	}
+
+	for i := 0; i < 10; i++ {
+		if h.observe(errPing, 0) {
+			t.Fatal("observe() with failures 0 = true, want never reopen")
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type WithDrawHold struct {
+	ID        int     `json:"id"`
+	OrderID   string  `json:"order"`
+	Login     string  `json:"login"`
+	Sum       float64 `json:"sum"`
+	Reason    string  `json:"reason"`
+	Status    string  `json:"status"`
+	CreatedAt string  `json:"created_at"`
+	Reference string  `json:"reference,omitempty"`
+}
+
+// Статусы отложенного списания
+const (
+	HoldHeld     = "HELD"
+	HoldApproved = "APPROVED"
+	HoldRejected = "REJECTED"
+)
+
+var (
+	// Таблица отложенных списаний withdraw_holds:
+	dbGetHolds = `SELECT withdraw_holds.id, withdraw_holds.orderID, users.login, withdraw_holds.sum, withdraw_holds.reason,
+						withdraw_holds.status, withdraw_holds.created_at, COALESCE(withdraw_holds.reference, '')
+						FROM withdraw_holds JOIN users ON users.userid = withdraw_holds.userid
+						WHERE withdraw_holds.status = 'HELD' ORDER BY withdraw_holds.id`
+	dbLockHold = `SELECT withdraw_holds.id, withdraw_holds.orderID, users.login, withdraw_holds.sum, withdraw_holds.reason,
+						COALESCE(withdraw_holds.reference, '') FROM withdraw_holds JOIN users ON users.userid = withdraw_holds.userid
+						WHERE withdraw_holds.id = $1 AND withdraw_holds.status = 'HELD' FOR UPDATE OF withdraw_holds`
+	dbResolveHold = `UPDATE withdraw_holds SET status = $1 WHERE id = $2`
+	dbRejectHold  = `UPDATE withdraw_holds SET status = 'REJECTED' FROM users
+						WHERE withdraw_holds.id = $1 AND withdraw_holds.status = 'HELD' AND users.userid = withdraw_holds.userid
+						RETURNING withdraw_holds.id, withdraw_holds.orderID, users.login, withdraw_holds.sum, withdraw_holds.reason, withdraw_holds.status`
+)
+
+func (db *DataBase) GetHolds() ([]WithDrawHold, error) {
+	ctx, cancel := db.context("GetHolds")
+	defer cancel()
+
+	rows, err := db.pool().Query(ctx, dbGetHolds)
+	if err != nil {
+		return nil, err
+	}
+
+	holds, err := scanRows(rows, func(row pgx.Row, hold *WithDrawHold) error {
+		var createdAt time.Time
+		if err := row.Scan(&hold.ID, &hold.OrderID, &hold.Login, &hold.Sum, &hold.Reason, &hold.Status, &createdAt, &hold.Reference); err != nil {
+			return err
+		}
+
+		hold.CreatedAt = createdAt.Format(time.RFC3339)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if holds == nil {
+		return nil, ErrEmpty
+	}
+
+	return holds, nil
+}
+
+// ApproveHold проводит отложенное списание в одной транзакции с проверкой баланса
+func (db *DataBase) ApproveHold(id int) (WithDrawHold, error) {
+	ctx, cancel := db.context("ApproveHold")
+	defer cancel()
+
+	hold := WithDrawHold{Status: HoldApproved}
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, dbLockHold, id).Scan(&hold.ID, &hold.OrderID, &hold.Login, &hold.Sum, &hold.Reason, &hold.Reference)
+		if err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			}
+
+			return ErrNotFound
+		}
+
+		if err = addWithDrawTx(ctx, tx, hold.Login, hold.OrderID, hold.Sum, hold.Reference); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, dbResolveHold, hold.Status, hold.ID)
+		return err
+	})
+	if err != nil {
+		return WithDrawHold{}, err
+	}
+
+	return hold, nil
+}
+
+func (db *DataBase) RejectHold(id int) (WithDrawHold, error) {
+	ctx, cancel := db.context("RejectHold")
+	defer cancel()
+
+	var hold WithDrawHold
+	err := db.pool().QueryRow(ctx, dbRejectHold, id).Scan(&hold.ID, &hold.OrderID, &hold.Login, &hold.Sum, &hold.Reason, &hold.Status)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return WithDrawHold{}, err
+		}
+
+		return WithDrawHold{}, ErrNotFound
+	}
+
+	return hold, nil
+}
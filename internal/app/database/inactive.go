@@ -0,0 +1,189 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Действия очистки неактивных учетных записей в журнале аудита. Действия задания записываются
+// от имени AuditSystem, исключения - от имени администратора.
+const (
+	AuditSystem             = "system"
+	AuditInactiveFlagged    = "inactive_flagged"
+	AuditInactiveAnonymized = "inactive_anonymized"
+	AuditInactivityExempt   = "inactivity_exempt"
+	AuditInactivityUnexempt = "inactivity_unexempt"
+)
+
+var (
+	// Неактивные учетные записи:
+	dbFlagInactive = `WITH flagged AS (UPDATE users SET inactive_notified_at = now()
+							WHERE status = 'active' AND deleted_at IS NULL AND NOT inactivity_exempt
+							AND inactive_notified_at IS NULL AND last_active_at < $1 RETURNING login, last_active_at)
+							INSERT INTO audit_log (actor, login, action, detail)
+							SELECT $2, login, $3, 'last active at ' || last_active_at::text
+							FROM flagged RETURNING login`
+	dbGetInactive = `SELECT login FROM users WHERE deleted_at IS NULL AND NOT inactivity_exempt AND inactive_notified_at <= $1`
+	// учетная запись обезличивается, только если отметка не снята, остаток нулевой и нет заказов
+	// и списаний, которые его еще изменят
+	dbLockInactive = `SELECT userid, inactive_notified_at FROM users WHERE login = $1 AND deleted_at IS NULL
+							AND NOT inactivity_exempt AND inactive_notified_at <= $2 FOR UPDATE`
+	dbInactiveSettled = `SELECT COALESCE((SELECT SUM(amount) FROM ledger WHERE userid = $1), 0) =
+							COALESCE((SELECT SUM(sum) FROM withdraw WHERE userid = $1), 0)
+							AND NOT EXISTS (SELECT 1 FROM orders WHERE userid = $1 AND status IN ('NEW', 'PROCESSING'))
+							AND NOT EXISTS (SELECT 1 FROM withdraw_holds WHERE userid = $1 AND status = 'HELD')`
+	dbSetExempt = `UPDATE users SET inactivity_exempt = $2, inactive_notified_at = NULL
+							WHERE login = $1 AND deleted_at IS NULL`
+	dbGetExempt = `SELECT login FROM users WHERE inactivity_exempt AND deleted_at IS NULL ORDER BY login`
+)
+
+// FlagInactiveUsers отмечает действующие учетные записи без входов и запросов с before, кроме исключений,
+// и записывает отметку в журнал аудита. Возвращает логины отмеченных: им сообщается о предстоящем
+// обезличивании. Отмеченные раньше повторно не возвращаются.
+func (db *DataBase) FlagInactiveUsers(before time.Time) ([]string, error) {
+	ctx, cancel := db.context("FlagInactiveUsers")
+	defer cancel()
+
+	rows, err := db.pool().Query(ctx, dbFlagInactive, before, AuditSystem, AuditInactiveFlagged)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRows(rows, func(row pgx.Row, login *string) error {
+		return row.Scan(login)
+	})
+}
+
+// AnonymizeInactiveUsers обезличивает, как при удалении по просьбе пользователя, учетные записи,
+// отмеченные неактивными не позже notifiedBefore, с нулевым остатком и без заказов и списаний
+// в обработке. Каждое обезличивание записывается в журнал аудита. Возвращает обезличенные логины.
+func (db *DataBase) AnonymizeInactiveUsers(notifiedBefore time.Time) ([]string, error) {
+	ctx, cancel := db.context("AnonymizeInactiveUsers")
+	defer cancel()
+
+	rows, err := db.pool().Query(ctx, dbGetInactive, notifiedBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	logins, err := scanRows(rows, func(row pgx.Row, login *string) error {
+		return row.Scan(login)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var anonymized []string
+	for _, login := range logins {
+		anonymous, err := db.anonymizeInactive(login, notifiedBefore)
+		if err != nil {
+			return anonymized, err
+		}
+
+		if anonymous != "" {
+			anonymized = append(anonymized, anonymous)
+		}
+	}
+
+	return anonymized, nil
+}
+
+// anonymizeInactive обезличивает учетную запись login, если она все еще подходит под условия
+// AnonymizeInactiveUsers: пользователь мог войти или попасть в исключения после выборки.
+// Пустой логин - учетная запись не обезличена.
+func (db *DataBase) anonymizeInactive(login string, notifiedBefore time.Time) (string, error) {
+	ctx, cancel := db.context("AnonymizeInactiveUsers")
+	defer cancel()
+
+	var anonymous string
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		anonymous = ""
+
+		var userid int64
+		var notifiedAt time.Time
+		if err := tx.QueryRow(ctx, dbLockInactive, login, notifiedBefore).Scan(&userid, &notifiedAt); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil
+			}
+
+			return err
+		}
+
+		var settled bool
+		if err := tx.QueryRow(ctx, dbInactiveSettled, userid).Scan(&settled); err != nil || !settled {
+			return err
+		}
+
+		deleted, err := eraseUser(ctx, tx, userid, login)
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(ctx, dbAddAudit, AuditSystem, deleted, AuditInactiveAnonymized,
+			"notified at "+notifiedAt.UTC().Format(time.RFC3339)); err != nil {
+			return err
+		}
+
+		anonymous = deleted
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return anonymous, nil
+}
+
+// SetInactivityExempt добавляет пользователя в исключения очистки неактивных учетных записей
+// или убирает из них и записывает изменение администратора by в журнал аудита. Отметка
+// о неактивности снимается: после возврата из исключений пользователя снова предупредят.
+// Неизвестный пользователь - ErrNotFound.
+func (db *DataBase) SetInactivityExempt(login string, exempt bool, by string) error {
+	ctx, cancel := db.context("SetInactivityExempt")
+	defer cancel()
+
+	action := AuditInactivityExempt
+	if !exempt {
+		action = AuditInactivityUnexempt
+	}
+
+	return db.WithTx(ctx, func(tx pgx.Tx) error {
+		exec, err := tx.Exec(ctx, dbSetExempt, login, exempt)
+		if err != nil {
+			return err
+		}
+
+		if exec.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+
+		_, err = tx.Exec(ctx, dbAddAudit, by, login, action, "")
+		return err
+	})
+}
+
+// GetInactivityExempt возвращает логины пользователей из исключений очистки неактивных учетных записей
+func (db *DataBase) GetInactivityExempt() ([]string, error) {
+	ctx, cancel := db.context("GetInactivityExempt")
+	defer cancel()
+
+	rows, err := db.pool().Query(ctx, dbGetExempt)
+	if err != nil {
+		return nil, err
+	}
+
+	logins, err := scanRows(rows, func(row pgx.Row, login *string) error {
+		return row.Scan(login)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if logins == nil {
+		return nil, ErrEmpty
+	}
+
+	return logins, nil
+}
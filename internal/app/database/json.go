@@ -0,0 +1,17 @@
+package database
+
+import "time"
+
+// JSONCompat - ответы в прежнем виде для старых клиентов: нулевое начисление не отдается,
+// время отдается со смещением часового пояса сервера. Без совместимости начисление отдается
+// всегда, в том числе 0, а время - в UTC.
+var JSONCompat = true
+
+// jsonTime форматирует время ответа в RFC3339 по JSONCompat
+func jsonTime(t time.Time) string {
+	if !JSONCompat {
+		t = t.UTC()
+	}
+
+	return t.Format(time.RFC3339)
+}
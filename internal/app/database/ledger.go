@@ -0,0 +1,210 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Журнал начислений ledger: баланс пользователя - сумма его записей за вычетом списаний из withdraw.
+// Начисление по заказу (kind = accrual) записывается один раз, исправления и переносы -
+// отдельными компенсирующими записями. Остаток, перенесенный из прежней системы лояльности
+// при импорте пользователя, - запись opening без заказа. Начисление и исправление переводятся
+// из единиц системы расчета в баллы по действующему курсу (настройка accrual_point_rate, без нее -
+// ACCRUAL_POINT_RATE), запись хранит исходную сумму и курс.
+const (
+	LedgerAccrual    = "accrual"
+	LedgerCorrection = "correction"
+	LedgerTransfer   = "transfer"
+	LedgerOpening    = "opening"
+)
+
+var (
+	// Таблица журнала ledger:
+	dbAddLedger = `INSERT INTO ledger (userid, amount, kind, order_number)
+						VALUES ((SELECT userid FROM users WHERE login = $1), $2, $3, $4)`
+	dbAddConversion = `INSERT INTO ledger (userid, amount, kind, order_number, accrual, rate)
+						VALUES ((SELECT userid FROM users WHERE login = $1), ROUND($2::numeric * $3::numeric, 2), $4, $5, $2, $3)`
+	dbCreditAccrual = `INSERT INTO ledger (userid, amount, kind, order_number, accrual, rate)
+						SELECT userid, ROUND(accrual * $2::numeric, 2), 'accrual', number, accrual, $2::numeric FROM orders
+						WHERE number = $1 AND accrual > 0 AND userid IS NOT NULL
+						ON CONFLICT (order_number) WHERE kind = 'accrual' DO NOTHING`
+	dbLockOrder = `SELECT COALESCE(users.login, ''), COALESCE(orders.accrual, 0) FROM orders
+						LEFT JOIN users ON users.userid = orders.userid WHERE orders.number = $1 FOR UPDATE OF orders`
+	dbSetOrderAccrual   = `UPDATE orders SET accrual = $1, updated_at = now(), revision = nextval('orders_revision_seq') WHERE number = $2`
+	dbLockArchivedOrder = `SELECT users.login, COALESCE(orders_archive.accrual, 0) FROM orders_archive
+						JOIN users ON users.userid = orders_archive.userid WHERE orders_archive.number = $1 FOR UPDATE OF orders_archive`
+	dbSetArchivedAccrual = `UPDATE orders_archive SET accrual = $1, updated_at = now() WHERE number = $2`
+	dbGetProcessedOrder  = `SELECT o.number, users.login, COALESCE(o.accrual, 0), o.uploaded_at FROM (
+							SELECT number, userid, accrual, uploaded_at, status FROM orders
+							UNION ALL
+							SELECT number, userid, accrual, uploaded_at, status FROM orders_archive) o
+						JOIN users ON users.userid = o.userid
+						WHERE o.status = 'PROCESSED' AND o.uploaded_at >= $1 AND o.uploaded_at < $2
+						ORDER BY o.uploaded_at`
+	dbGetUserExists  = `SELECT EXISTS (SELECT 1 FROM users WHERE login = $1)`
+	dbGetOrderCredit = `SELECT COALESCE(SUM(amount), 0) FROM ledger
+						WHERE order_number = $1 AND userid = (SELECT userid FROM users WHERE login = $2)`
+	dbGetCurrent = `SELECT COALESCE((SELECT SUM(amount) FROM ledger WHERE userid = users.userid), 0) -
+						COALESCE((SELECT SUM(sum) FROM withdraw WHERE userid = users.userid), 0) FROM users WHERE login = $1`
+	dbTransferOrder = `UPDATE orders SET userid = (SELECT userid FROM users WHERE login = $1), updated_at = now(), revision = nextval('orders_revision_seq') WHERE number = $2`
+)
+
+// Points переводит сумму системы расчета в баллы по курсу rate с округлением до копеек, как в журнале
+func Points(accrual, rate float64) float64 {
+	return math.Round(accrual*rate*100) / 100
+}
+
+// Transfer - результат переноса заказа: Amount баллов по заказу теперь числится за To
+type Transfer struct {
+	Number string  `json:"number"`
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+}
+
+// GetProcessedOrders возвращает обработанные заказы, загруженные в промежутке [from, to)
+func (db *DataBase) GetProcessedOrders(from, to time.Time) ([]Order, error) {
+	ctx, cancel := db.context("GetProcessedOrders")
+	defer cancel()
+
+	rows, err := db.pool().Query(ctx, dbGetProcessedOrder, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRows(rows, func(row pgx.Row, o *Order) error {
+		o.Status = "PROCESSED"
+		return row.Scan(&o.Number, &o.Login, &o.Accrual, &o.UploadedAt)
+	})
+}
+
+// CorrectAccrual исправляет начисление по заказу и записывает разницу в журнал
+func (db *DataBase) CorrectAccrual(number string, accrual float64) error {
+	unlock := db.orders.lock(number)
+	defer unlock()
+
+	ctx, cancel := db.context("CorrectAccrual")
+	defer cancel()
+
+	return db.WithTx(ctx, func(tx pgx.Tx) error {
+		var login string
+		var stored float64
+		setAccrual := dbSetOrderAccrual
+		err := tx.QueryRow(ctx, dbLockOrder, number).Scan(&login, &stored)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// исправляется и начисление по заказу из архива
+			setAccrual = dbSetArchivedAccrual
+			err = tx.QueryRow(ctx, dbLockArchivedOrder, number).Scan(&login, &stored)
+		}
+		if err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			}
+
+			return ErrNotFound
+		}
+
+		if stored == accrual {
+			return nil
+		}
+
+		if _, err = tx.Exec(ctx, setAccrual, accrual, number); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, dbAddConversion, login, accrual-stored, db.pointRate(), LedgerCorrection, number)
+		return err
+	})
+}
+
+// TransferOrder переносит заказ number к пользователю to вместе с зачисленными по нему баллами:
+// у прежнего владельца они списываются, новому зачисляются парой записей журнала.
+// Неизвестный заказ - ErrNotFound, неизвестный пользователь - ErrWrongData, заказ уже
+// у пользователя to - ErrDuplicate, баллы по заказу уже потрачены - ErrNoMoney.
+func (db *DataBase) TransferOrder(number, to string) (Transfer, error) {
+	unlock := db.orders.lock(number)
+	defer unlock()
+
+	ctx, cancel := db.context("TransferOrder")
+	defer cancel()
+
+	transfer := Transfer{Number: number, To: to}
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		var accrual float64
+		if err := tx.QueryRow(ctx, dbLockOrder, number).Scan(&transfer.From, &accrual); err != nil {
+			if !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			}
+
+			return ErrNotFound
+		}
+
+		if transfer.From == to {
+			return ErrDuplicate
+		}
+
+		var exists bool
+		if err := tx.QueryRow(ctx, dbGetUserExists, to).Scan(&exists); err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrWrongData
+		}
+
+		// у анонимного заказа нет записей в журнале, начисление по нему зачисляется новому владельцу ниже
+		var credit float64
+		if transfer.From != "" {
+			if err := tx.QueryRow(ctx, dbGetOrderCredit, number, transfer.From).Scan(&credit); err != nil {
+				return err
+			}
+		}
+
+		if credit > 0 {
+			// как и при списании, баланс прежнего владельца проверяется под блокировкой его строки
+			if _, err := tx.Exec(ctx, dbLockUser, transfer.From); err != nil {
+				return err
+			}
+
+			var current float64
+			if err := tx.QueryRow(ctx, dbGetCurrent, transfer.From).Scan(&current); err != nil {
+				return err
+			}
+
+			if current < credit {
+				return ErrNoMoney
+			}
+		}
+
+		if _, err := tx.Exec(ctx, dbTransferOrder, to, number); err != nil {
+			return err
+		}
+
+		if credit != 0 {
+			if _, err := tx.Exec(ctx, dbAddLedger, transfer.From, -credit, LedgerTransfer, number); err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec(ctx, dbAddLedger, to, credit, LedgerTransfer, number); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Exec(ctx, dbCreditAccrual, number, db.pointRate()); err != nil {
+			return err
+		}
+
+		return tx.QueryRow(ctx, dbGetOrderCredit, number, to).Scan(&transfer.Amount)
+	})
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	log.Printf("transfer order: number: %s, from: %s, to: %s, amount: %g", number, transfer.From, to, transfer.Amount)
+
+	return transfer, nil
+}
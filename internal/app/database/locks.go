@@ -0,0 +1,22 @@
+package database
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// orderLocks - полосатая блокировка по номеру заказа: обновления одного заказа
+// (статус и начисление) выполняются строго последовательно
+type orderLocks struct {
+	mu [64]sync.Mutex
+}
+
+func (l *orderLocks) lock(number string) func() {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(number))
+
+	m := &l.mu[h.Sum32()%uint32(len(l.mu))]
+	m.Lock()
+
+	return m.Unlock
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// MetricsRecorder получает показатели каждого вызова хранилища: имя метода DataBase, длительность,
+// число строк, которые вернули или изменили его запросы, и последнюю ошибку запроса к базе.
+// Ошибки правил хранилища (ErrNoMoney, ErrNotFound и другие) ошибками запросов не считаются.
+type MetricsRecorder interface {
+	ObserveQuery(method string, d time.Duration, rows int64, err error)
+}
+
+// SetMetrics подключает учет вызовов хранилища. Вызывается до начала работы с базой.
+func (db *DataBase) SetMetrics(m MetricsRecorder) {
+	db.metrics = m
+}
+
+// call - показатели одного вызова хранилища, накапливаются queryTracer по запросам в его контексте.
+// query - текст последнего начатого запроса, при ошибке - запроса, на котором вызов упал.
+type call struct {
+	method string
+	rows   int64
+	err    error
+	query  string
+	failed bool
+}
+
+type callKey struct{}
+
+// queryTracer относит запросы пула к вызову хранилища, контекст которого создал DataBase.context
+type queryTracer struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if c, ok := ctx.Value(callKey{}).(*call); ok && !c.failed {
+		c.query = data.SQL
+	}
+
+	return ctx
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	c, ok := ctx.Value(callKey{}).(*call)
+	if !ok {
+		return
+	}
+
+	c.rows += data.CommandTag.RowsAffected()
+	if data.Err != nil {
+		c.err, c.failed = data.Err, true
+	}
+}
+
+// context возвращает контекст запросов вызова method с таймаутом его класса (см. queryClasses). По его истечении
+// драйвер отменяет запрос на сервере, в том числе для фоновых задач без HTTP-дедлайна. Отмена
+// контекста завершает вызов и передает его показатели в MetricsRecorder. По контексту сбой
+// запроса получает имя вызова (см. wrapQuery).
+func (db *DataBase) context(method string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), db.timeouts.of(method))
+
+	c, start := &call{method: method}, time.Now()
+	ctx = context.WithValue(ctx, callKey{}, c)
+	if db.metrics == nil {
+		return ctx, cancel
+	}
+
+	return ctx, func() {
+		cancel()
+		db.metrics.ObserveQuery(method, time.Since(start), c.rows, c.err)
+	}
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type observed struct {
+	method string
+	rows   int64
+	err    error
+}
+
+type fakeRecorder []observed
+
+func (r *fakeRecorder) ObserveQuery(method string, _ time.Duration, rows int64, err error) {
+	*r = append(*r, observed{method: method, rows: rows, err: err})
+}
+
+func TestContextMetrics(t *testing.T) {
+	var recorder fakeRecorder
+	db := &DataBase{timeouts: queryTimeouts{query: time.Second}}
+
+	// без учета контекст только ограничивает время запросов
+	ctx, cancel := db.context("GetOrders")
+	queryTracer{}.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("SELECT 1")})
+	cancel()
+
+	db.SetMetrics(&recorder)
+
+	errConn := errors.New("conn closed")
+	ctx, cancel = db.context("UpdateOrder")
+	queryTracer{}.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("UPDATE 1")})
+	queryTracer{}.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: errConn})
+	queryTracer{}.TraceQueryEnd(context.Background(), nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("INSERT 0 5")})
+	cancel()
+
+	ctx, cancel = db.context("GetOrders")
+	queryTracer{}.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("SELECT 3")})
+	cancel()
+
+	if ctx.Err() == nil {
+		t.Error("context() cancel did not cancel context")
+	}
+
+	want := []observed{{method: "UpdateOrder", rows: 1, err: errConn}, {method: "GetOrders", rows: 3}}
+	if len(recorder) != len(want) {
+		t.Fatalf("ObserveQuery() calls = %v, want %v", recorder, want)
+	}
+	for i := range want {
+		if recorder[i].method != want[i].method || recorder[i].rows != want[i].rows || !errors.Is(recorder[i].err, want[i].err) {
+			t.Errorf("ObserveQuery() call %d = %v, want %v", i, recorder[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Схема базы задается миграциями migrations/NNNN_название.sql. Каждая миграция применяется один раз
+// в своей транзакции и записывается в schema_migrations. Примененный файл не меняется: изменение
+// схемы - новый файл со следующим номером.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock - ключ advisory-блокировки: при одновременном запуске нескольких экземпляров
+// миграцию применяет один из них, остальные ждут и видят ее уже примененной
+const migrationLock = 0x6d6967726174
+
+// migrateTimeout ограничивает применение всех миграций при запуске
+const migrateTimeout = time.Minute
+
+var (
+	// Таблица примененных миграций schema_migrations:
+	dbCreateMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+								version 		BIGINT PRIMARY KEY	NOT NULL,
+								name 			VARCHAR 			NOT NULL,
+								applied_at		TIMESTAMPTZ			NOT NULL	DEFAULT now())`
+	dbLockMigrations  = `SELECT pg_advisory_xact_lock($1)`
+	dbMigrationExists = `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`
+	dbAddMigration    = `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`
+)
+
+type migration struct {
+	version int64
+	name    string
+	sql     string
+}
+
+// loadMigrations читает встроенные миграции в порядке номеров
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migration, 0, len(entries))
+	for _, e := range entries {
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if !ok || err != nil || version <= 0 || name == "" {
+			return nil, fmt.Errorf("migration %s: want NNNN_name.sql", e.Name())
+		}
+
+		b, err := migrationFiles.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{version: version, name: name, sql: string(b)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			return nil, fmt.Errorf("migration %d: duplicate version", migrations[i].version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// migrate применяет миграции, которых еще нет в schema_migrations
+func migrate(ctx context.Context, db *pgx.Conn) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		applied, err := applyMigration(ctx, db, m)
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", m.version, m.name, err)
+		}
+
+		if applied {
+			log.Printf("migration applied: %04d_%s", m.version, m.name)
+		}
+	}
+
+	return nil
+}
+
+// applyMigration применяет миграцию под блокировкой, если она еще не применена, и сообщает, была ли она применена
+func applyMigration(ctx context.Context, db *pgx.Conn, m migration) (bool, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if _, err = tx.Exec(ctx, dbLockMigrations, int64(migrationLock)); err != nil {
+		return false, err
+	}
+
+	if _, err = tx.Exec(ctx, dbCreateMigrations); err != nil {
+		return false, err
+	}
+
+	var exists bool
+	if err = tx.QueryRow(ctx, dbMigrationExists, m.version).Scan(&exists); err != nil {
+		return false, err
+	}
+
+	if exists {
+		return false, nil
+	}
+
+	// без аргументов pgx отправляет запрос простым протоколом, поэтому файл может содержать несколько операторов
+	if _, err = tx.Exec(ctx, m.sql); err != nil {
+		return false, err
+	}
+
+	if _, err = tx.Exec(ctx, dbAddMigration, m.version, m.name); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
@@ -0,0 +1,27 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) == 0 {
+		t.Fatal("loadMigrations() returned no migrations")
+	}
+
+	// номера идут подряд с 1: пропуск обычно означает потерянный при слиянии файл
+	for i, m := range migrations {
+		if m.version != int64(i+1) {
+			t.Errorf("migration %d_%s: version = %d, want %d", m.version, m.name, m.version, i+1)
+		}
+		if strings.TrimSpace(m.sql) == "" {
+			t.Errorf("migration %d_%s is empty", m.version, m.name)
+		}
+	}
+}
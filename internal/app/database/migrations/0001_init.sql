@@ -0,0 +1,108 @@
+-- Базовая схема, которую создавал CREATE TABLE IF NOT EXISTS до появления миграций.
+-- Операторы идемпотентны: миграция применяется и к базам, созданным прежними версиями сервиса.
+
+CREATE TABLE IF NOT EXISTS users (
+	userid			SERIAL  PRIMARY KEY NOT NULL,
+	login			VARCHAR UNIQUE		NOT NULL,
+	password		VARCHAR 			NOT NULL);
+
+ALTER TABLE users DROP COLUMN IF EXISTS cookie;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale VARCHAR NOT NULL DEFAULT 'ru-RU';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS currency VARCHAR NOT NULL DEFAULT 'RUB';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email VARCHAR NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR NOT NULL DEFAULT 'active';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sessions_revoked_at TIMESTAMPTZ NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NULL;
+ALTER TABLE users ALTER COLUMN created_at SET DEFAULT now();
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at);
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+	token			VARCHAR PRIMARY KEY NOT NULL,
+	login			VARCHAR 			NOT NULL	REFERENCES users(login) ON DELETE CASCADE,
+	expires_at		TIMESTAMPTZ			NOT NULL);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id				VARCHAR PRIMARY KEY NOT NULL,
+	userid			INTEGER 			NULL		REFERENCES users(userid) ON DELETE CASCADE,
+	created_at		TIMESTAMPTZ			NOT NULL	DEFAULT now(),
+	expires_at		TIMESTAMPTZ			NOT NULL);
+
+CREATE INDEX IF NOT EXISTS sessions_userid_idx ON sessions (userid);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS sid BIGSERIAL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NULL;
+
+CREATE TABLE IF NOT EXISTS orders (
+	number 			VARCHAR PRIMARY KEY NOT NULL,
+	login 			VARCHAR 			NOT NULL,
+	status 			VARCHAR 			NOT NULL	DEFAULT 'NEW',
+	accrual 		NUMERIC 			NULL,
+	uploaded_at 	VARCHAR				NOT NULL);
+
+CREATE SEQUENCE IF NOT EXISTS orders_revision_seq;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT nextval('orders_revision_seq');
+CREATE INDEX IF NOT EXISTS orders_login_revision_idx ON orders (login, revision);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS session VARCHAR NULL;
+CREATE INDEX IF NOT EXISTS orders_session_idx ON orders (session) WHERE session IS NOT NULL;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NULL;
+UPDATE orders SET created_at = uploaded_at::timestamptz WHERE created_at IS NULL;
+ALTER TABLE orders ALTER COLUMN created_at SET DEFAULT now();
+CREATE INDEX IF NOT EXISTS orders_created_at_idx ON orders (created_at);
+
+CREATE TABLE IF NOT EXISTS withdraw (
+	orderID 		VARCHAR PRIMARY KEY NOT NULL,
+	login 			VARCHAR 			NOT NULL,
+	sum 			NUMERIC 			NOT NULL,
+	processed_at	VARCHAR 			NOT NULL);
+
+ALTER TABLE withdraw ADD COLUMN IF NOT EXISTS reference VARCHAR NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS withdraw_reference_idx ON withdraw (reference);
+ALTER TABLE withdraw ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NULL;
+UPDATE withdraw SET created_at = processed_at::timestamptz WHERE created_at IS NULL;
+ALTER TABLE withdraw ALTER COLUMN created_at SET DEFAULT now();
+CREATE INDEX IF NOT EXISTS withdraw_created_at_idx ON withdraw (created_at);
+
+CREATE TABLE IF NOT EXISTS order_quota (
+	login 			VARCHAR 			NOT NULL,
+	day 			DATE 				NOT NULL,
+	count 			INTEGER 			NOT NULL,
+	PRIMARY KEY (login, day));
+
+CREATE TABLE IF NOT EXISTS withdraw_holds (
+	id 				SERIAL  PRIMARY KEY NOT NULL,
+	orderID 		VARCHAR 			NOT NULL,
+	login 			VARCHAR 			NOT NULL,
+	sum 			NUMERIC 			NOT NULL,
+	reason 			VARCHAR 			NOT NULL,
+	status 			VARCHAR 			NOT NULL	DEFAULT 'HELD',
+	created_at		TIMESTAMPTZ			NOT NULL	DEFAULT now());
+
+ALTER TABLE withdraw_holds ADD COLUMN IF NOT EXISTS reference VARCHAR NULL;
+
+CREATE TABLE IF NOT EXISTS accrual_quarantine (
+	number 			VARCHAR PRIMARY KEY NOT NULL,
+	status 			VARCHAR 			NOT NULL,
+	accrual 		NUMERIC 			NOT NULL,
+	reason 			VARCHAR 			NOT NULL,
+	created_at		TIMESTAMPTZ			NOT NULL	DEFAULT now());
+
+CREATE TABLE IF NOT EXISTS ledger (
+	id 				BIGSERIAL PRIMARY KEY NOT NULL,
+	login 			VARCHAR 			NOT NULL,
+	amount 			NUMERIC 			NOT NULL,
+	kind 			VARCHAR 			NOT NULL,
+	order_number 	VARCHAR 			NULL,
+	created_at		TIMESTAMPTZ			NOT NULL	DEFAULT now());
+
+CREATE INDEX IF NOT EXISTS ledger_login_idx ON ledger (login);
+CREATE INDEX IF NOT EXISTS ledger_created_at_idx ON ledger (created_at);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_accrual_idx ON ledger (order_number) WHERE kind = 'accrual';
+
+INSERT INTO ledger (login, amount, kind, order_number)
+	SELECT login, accrual, 'accrual', number FROM orders WHERE accrual > 0 AND login <> ''
+	ON CONFLICT (order_number) WHERE kind = 'accrual' DO NOTHING;
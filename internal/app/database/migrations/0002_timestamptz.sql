@@ -0,0 +1,6 @@
+ALTER TABLE orders ALTER COLUMN uploaded_at TYPE TIMESTAMPTZ USING uploaded_at::timestamptz;
+ALTER TABLE orders ALTER COLUMN uploaded_at SET DEFAULT now();
+CREATE INDEX IF NOT EXISTS orders_uploaded_at_idx ON orders (uploaded_at);
+
+ALTER TABLE withdraw ALTER COLUMN processed_at TYPE TIMESTAMPTZ USING processed_at::timestamptz;
+ALTER TABLE withdraw ALTER COLUMN processed_at SET DEFAULT now();
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS notification_preferences (
+	login 			VARCHAR 			NOT NULL,
+	event 			VARCHAR 			NOT NULL,
+	channel 		VARCHAR 			NOT NULL,
+	PRIMARY KEY (login, event));
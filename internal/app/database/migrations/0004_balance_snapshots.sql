@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS balance_snapshots (
+	login 			VARCHAR 			NOT NULL,
+	day 			DATE 				NOT NULL,
+	balance 		NUMERIC 			NOT NULL,
+	PRIMARY KEY (login, day));
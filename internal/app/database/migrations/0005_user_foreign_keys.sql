@@ -0,0 +1,49 @@
+-- Владелец заказа, списания, отложенного списания и записи журнала - users(userid). Колонка login
+-- остается для журналов сервиса и отчетов и совпадает с логином владельца: логин не меняется.
+-- Пользователя с операциями по счету удалить нельзя (RESTRICT), его настройки и снимки остатков
+-- удаляются вместе с ним (CASCADE).
+
+-- строки без пользователя не связать ключом: их нужно разобрать вручную до обновления
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM orders WHERE login <> '' AND login NOT IN (SELECT login FROM users))
+		OR EXISTS (SELECT 1 FROM withdraw WHERE login NOT IN (SELECT login FROM users))
+		OR EXISTS (SELECT 1 FROM withdraw_holds WHERE login NOT IN (SELECT login FROM users))
+		OR EXISTS (SELECT 1 FROM ledger WHERE login NOT IN (SELECT login FROM users)) THEN
+		RAISE EXCEPTION 'orders, withdraw, withdraw_holds or ledger reference logins missing from users';
+	END IF;
+END $$;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS userid INTEGER NULL REFERENCES users(userid) ON DELETE RESTRICT;
+UPDATE orders SET userid = users.userid FROM users WHERE users.login = orders.login AND orders.userid IS NULL;
+-- заказ без владельца - заказ посетителя без учетной записи, login у него пустой
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_owner_check;
+ALTER TABLE orders ADD CONSTRAINT orders_owner_check CHECK ((userid IS NULL) = (login = ''));
+CREATE INDEX IF NOT EXISTS orders_userid_uploaded_at_idx ON orders (userid, uploaded_at);
+CREATE INDEX IF NOT EXISTS orders_userid_revision_idx ON orders (userid, revision);
+
+ALTER TABLE withdraw ADD COLUMN IF NOT EXISTS userid INTEGER NULL REFERENCES users(userid) ON DELETE RESTRICT;
+UPDATE withdraw SET userid = users.userid FROM users WHERE users.login = withdraw.login AND withdraw.userid IS NULL;
+ALTER TABLE withdraw ALTER COLUMN userid SET NOT NULL;
+CREATE INDEX IF NOT EXISTS withdraw_userid_idx ON withdraw (userid);
+
+ALTER TABLE withdraw_holds ADD COLUMN IF NOT EXISTS userid INTEGER NULL REFERENCES users(userid) ON DELETE RESTRICT;
+UPDATE withdraw_holds SET userid = users.userid FROM users WHERE users.login = withdraw_holds.login AND withdraw_holds.userid IS NULL;
+ALTER TABLE withdraw_holds ALTER COLUMN userid SET NOT NULL;
+
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS userid INTEGER NULL REFERENCES users(userid) ON DELETE RESTRICT;
+UPDATE ledger SET userid = users.userid FROM users WHERE users.login = ledger.login AND ledger.userid IS NULL;
+ALTER TABLE ledger ALTER COLUMN userid SET NOT NULL;
+CREATE INDEX IF NOT EXISTS ledger_userid_idx ON ledger (userid);
+
+-- производные данные без пользователя не нужны. order_quota без ключа: квота посетителя
+-- без учетной записи ведется по его сессии.
+DELETE FROM notification_preferences WHERE login NOT IN (SELECT login FROM users);
+ALTER TABLE notification_preferences DROP CONSTRAINT IF EXISTS notification_preferences_login_fkey;
+ALTER TABLE notification_preferences ADD CONSTRAINT notification_preferences_login_fkey
+	FOREIGN KEY (login) REFERENCES users(login) ON DELETE CASCADE;
+
+DELETE FROM balance_snapshots WHERE login NOT IN (SELECT login FROM users);
+ALTER TABLE balance_snapshots DROP CONSTRAINT IF EXISTS balance_snapshots_login_fkey;
+ALTER TABLE balance_snapshots ADD CONSTRAINT balance_snapshots_login_fkey
+	FOREIGN KEY (login) REFERENCES users(login) ON DELETE CASCADE;
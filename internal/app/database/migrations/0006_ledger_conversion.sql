@@ -0,0 +1,6 @@
+-- Начисления по заказам и их исправления переводятся из единиц системы расчета в баллы по курсу
+-- ACCRUAL_POINT_RATE. Запись журнала хранит исходную сумму accrual и курс rate, amount - баллы.
+-- Прежние начисления зачислялись один к одному.
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS accrual NUMERIC NULL;
+ALTER TABLE ledger ADD COLUMN IF NOT EXISTS rate NUMERIC NULL;
+UPDATE ledger SET accrual = amount, rate = 1 WHERE kind IN ('accrual', 'correction') AND rate IS NULL;
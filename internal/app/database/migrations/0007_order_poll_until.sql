@@ -0,0 +1,4 @@
+-- Заказ NEW или PROCESSING опрашивается в системе расчета не дольше ACCRUAL_MAX_ORDER_AGE с загрузки,
+-- затем получает статус EXPIRED. poll_until - срок, продленный администратором для одного заказа,
+-- он заменяет общий.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS poll_until TIMESTAMPTZ NULL;
@@ -0,0 +1,15 @@
+-- Вход администратора от имени пользователя. Сессия такого входа помечена impersonator - логином
+-- администратора, read_only запрещает в ней изменения. Вход и каждый запрос в такой сессии
+-- записываются в audit_log; журнал не ссылается на users и переживает удаление пользователя.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS impersonator VARCHAR NULL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS read_only BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id				BIGSERIAL PRIMARY KEY,
+	created_at		TIMESTAMPTZ			NOT NULL	DEFAULT now(),
+	actor			VARCHAR 			NOT NULL,
+	login			VARCHAR 			NOT NULL,
+	action			VARCHAR 			NOT NULL,
+	detail			VARCHAR 			NOT NULL	DEFAULT '');
+
+CREATE INDEX IF NOT EXISTS audit_log_login_idx ON audit_log (login, created_at);
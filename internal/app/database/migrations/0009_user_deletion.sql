@@ -0,0 +1,6 @@
+-- Удаление учетной записи по просьбе пользователя. Сразу удаляются сессии, настройки и производные
+-- данные, логин заменяется обезличенным 'deleted <userid>' во всех таблицах, пароль и адрес
+-- стираются, а users.deleted_at запоминает время удаления. Заказы, списания и журнал начислений
+-- остаются для учета и удаляются вместе с пользователем по истечении USER_RETENTION.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ NULL;
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,8 @@
+-- Бизнес-константы, которые администратор меняет без перезапуска: минимальное списание, дневной
+-- лимит загрузки заказов, курс начисления, время жизни сессии. Строка переопределяет значение
+-- из конфигурации, нет строки - действует конфигурация.
+CREATE TABLE IF NOT EXISTS settings (
+	name			VARCHAR PRIMARY KEY	NOT NULL,
+	value			VARCHAR 			NOT NULL,
+	updated_at		TIMESTAMPTZ			NOT NULL	DEFAULT now(),
+	updated_by		VARCHAR 			NOT NULL);
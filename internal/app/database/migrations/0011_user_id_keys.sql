@@ -0,0 +1,32 @@
+-- Логин хранится только в users: заказы, списания, отложенные списания, журнал начислений,
+-- настройки уведомлений, снимки остатков и подтверждения адреса ссылаются на users(userid), поэтому
+-- логин меняется одним UPDATE users. audit_log по-прежнему ведется по логину и не ссылается на users.
+-- Квота пользователя ведется по 'user:<userid>', квота посетителя без учетной записи - по его сессии.
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_owner_check;
+ALTER TABLE orders DROP COLUMN IF EXISTS login;
+ALTER TABLE withdraw DROP COLUMN IF EXISTS login;
+ALTER TABLE withdraw_holds DROP COLUMN IF EXISTS login;
+ALTER TABLE ledger DROP COLUMN IF EXISTS login;
+
+ALTER TABLE notification_preferences ADD COLUMN IF NOT EXISTS userid INTEGER NULL REFERENCES users(userid) ON DELETE CASCADE;
+UPDATE notification_preferences SET userid = users.userid FROM users WHERE users.login = notification_preferences.login;
+ALTER TABLE notification_preferences ALTER COLUMN userid SET NOT NULL;
+ALTER TABLE notification_preferences DROP CONSTRAINT IF EXISTS notification_preferences_pkey;
+ALTER TABLE notification_preferences DROP COLUMN login;
+ALTER TABLE notification_preferences ADD PRIMARY KEY (userid, event);
+
+ALTER TABLE balance_snapshots ADD COLUMN IF NOT EXISTS userid INTEGER NULL REFERENCES users(userid) ON DELETE CASCADE;
+UPDATE balance_snapshots SET userid = users.userid FROM users WHERE users.login = balance_snapshots.login;
+ALTER TABLE balance_snapshots ALTER COLUMN userid SET NOT NULL;
+ALTER TABLE balance_snapshots DROP CONSTRAINT IF EXISTS balance_snapshots_pkey;
+ALTER TABLE balance_snapshots DROP COLUMN login;
+ALTER TABLE balance_snapshots ADD PRIMARY KEY (userid, day);
+
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS userid INTEGER NULL REFERENCES users(userid) ON DELETE CASCADE;
+UPDATE email_verifications SET userid = users.userid FROM users WHERE users.login = email_verifications.login;
+ALTER TABLE email_verifications ALTER COLUMN userid SET NOT NULL;
+ALTER TABLE email_verifications DROP COLUMN login;
+
+ALTER TABLE order_quota RENAME COLUMN login TO owner;
+UPDATE order_quota SET owner = 'user:' || users.userid FROM users WHERE users.login = order_quota.owner;
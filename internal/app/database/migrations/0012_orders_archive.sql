@@ -0,0 +1,17 @@
+-- Архив заказов: обработанные (PROCESSED, INVALID) заказы пользователей старше ORDER_ARCHIVE_AGE
+-- переносятся сюда фоновой задачей, чтобы таблица orders оставалась небольшой. Заказы посетителей
+-- без учетной записи не переносятся: они ждут перехода к пользователю. Номер заказа не повторяется
+-- в обеих таблицах: загрузка проверяет и архив.
+CREATE TABLE IF NOT EXISTS orders_archive (
+	number 			VARCHAR PRIMARY KEY NOT NULL,
+	userid 			INTEGER 			NOT NULL	REFERENCES users(userid) ON DELETE RESTRICT,
+	status 			VARCHAR 			NOT NULL,
+	accrual 		NUMERIC 			NULL,
+	uploaded_at 	TIMESTAMPTZ			NOT NULL,
+	created_at		TIMESTAMPTZ			NULL,
+	updated_at		TIMESTAMPTZ			NOT NULL,
+	revision		BIGINT				NOT NULL,
+	archived_at		TIMESTAMPTZ			NOT NULL	DEFAULT now());
+
+CREATE INDEX IF NOT EXISTS orders_archive_userid_uploaded_at_idx ON orders_archive (userid, uploaded_at);
+CREATE INDEX IF NOT EXISTS orders_archive_created_at_idx ON orders_archive (created_at);
@@ -0,0 +1,5 @@
+-- next_poll_at - не раньше какого момента заказ опрашивается снова: пауза после 429 и отложенный
+-- повторный опрос переживают перезапуск. NULL - опросить при первой возможности.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS next_poll_at TIMESTAMPTZ NULL;
+
+CREATE INDEX IF NOT EXISTS orders_next_poll_idx ON orders (next_poll_at) WHERE status IN ('NEW', 'PROCESSING');
@@ -0,0 +1,12 @@
+-- Очистка неактивных учетных записей. last_active_at - последний вход или запрос пользователя в своей
+-- сессии, входы администратора от его имени не считаются. inactive_notified_at - когда пользователю
+-- сообщили, что учетная запись будет обезличена; вход или запрос снимает отметку. inactivity_exempt -
+-- исключение, которое ведет администратор. До миграции активность восстанавливается по сессиям.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_active_at TIMESTAMPTZ NULL;
+UPDATE users SET last_active_at = COALESCE(GREATEST(created_at,
+	(SELECT MAX(COALESCE(last_seen_at, created_at)) FROM sessions WHERE sessions.userid = users.userid AND impersonator IS NULL)), now())
+	WHERE last_active_at IS NULL;
+ALTER TABLE users ALTER COLUMN last_active_at SET DEFAULT now();
+ALTER TABLE users ADD COLUMN IF NOT EXISTS inactive_notified_at TIMESTAMPTZ NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS inactivity_exempt BOOLEAN NOT NULL DEFAULT false;
+CREATE INDEX IF NOT EXISTS users_last_active_at_idx ON users (last_active_at) WHERE deleted_at IS NULL;
@@ -0,0 +1,35 @@
+-- seq - порядковый номер заказа и списания, выдает база. По нему упорядочены списки и идет
+-- постраничная выдача (after): время загрузки, выставленное разными экземплярами, может совпасть
+-- или пойти назад. Заказ сохраняет seq при переносе в архив. Имеющиеся строки нумеруются в прежнем
+-- порядке выдачи. Порядковый номер записи журнала ledger - ее id.
+CREATE SEQUENCE IF NOT EXISTS orders_seq;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS seq BIGINT NULL;
+ALTER TABLE orders_archive ADD COLUMN IF NOT EXISTS seq BIGINT NULL;
+
+CREATE TEMPORARY TABLE order_seq ON COMMIT DROP AS
+	SELECT number, row_number() OVER (ORDER BY uploaded_at, number) AS seq FROM (
+		SELECT number, uploaded_at FROM orders
+		UNION ALL
+		SELECT number, uploaded_at FROM orders_archive) o;
+
+UPDATE orders SET seq = order_seq.seq FROM order_seq WHERE orders.number = order_seq.number;
+UPDATE orders_archive SET seq = order_seq.seq FROM order_seq WHERE orders_archive.number = order_seq.number;
+SELECT setval('orders_seq', COALESCE((SELECT MAX(seq) FROM order_seq), 0) + 1, false);
+
+ALTER TABLE orders ALTER COLUMN seq SET DEFAULT nextval('orders_seq');
+ALTER TABLE orders ALTER COLUMN seq SET NOT NULL;
+ALTER TABLE orders_archive ALTER COLUMN seq SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS orders_userid_seq_idx ON orders (userid, seq);
+CREATE UNIQUE INDEX IF NOT EXISTS orders_archive_userid_seq_idx ON orders_archive (userid, seq);
+
+CREATE SEQUENCE IF NOT EXISTS withdraw_seq;
+ALTER TABLE withdraw ADD COLUMN IF NOT EXISTS seq BIGINT NULL;
+
+UPDATE withdraw SET seq = numbered.seq FROM (
+	SELECT orderID, row_number() OVER (ORDER BY processed_at, orderID) AS seq FROM withdraw) numbered
+	WHERE withdraw.orderID = numbered.orderID;
+SELECT setval('withdraw_seq', COALESCE((SELECT MAX(seq) FROM withdraw), 0) + 1, false);
+
+ALTER TABLE withdraw ALTER COLUMN seq SET DEFAULT nextval('withdraw_seq');
+ALTER TABLE withdraw ALTER COLUMN seq SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS withdraw_userid_seq_idx ON withdraw (userid, seq);
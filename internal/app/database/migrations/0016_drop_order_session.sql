@@ -0,0 +1,6 @@
+-- Загрузка заказов без учетной записи отменена: заказы, оставшиеся за сессией и не перешедшие
+-- к пользователю, удаляются вместе со столбцом session, их номера снова можно загрузить.
+DELETE FROM orders WHERE session IS NOT NULL AND userid IS NULL;
+
+DROP INDEX IF EXISTS orders_session_idx;
+ALTER TABLE orders DROP COLUMN IF EXISTS session;
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/notify"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// Таблица настроек уведомлений notification_preferences:
+	dbGetNotifyPrefs = `SELECT event, channel FROM notification_preferences
+							WHERE userid = (SELECT userid FROM users WHERE login = $1)`
+	dbSetNotifyPref = `INSERT INTO notification_preferences (userid, event, channel)
+							VALUES ((SELECT userid FROM users WHERE login = $1), $2, $3)
+							ON CONFLICT(userid, event) DO UPDATE SET channel = excluded.channel`
+	dbGetEmail = `SELECT COALESCE(email, '') FROM users WHERE login = $1`
+)
+
+// GetNotificationPreferences возвращает каналы уведомлений пользователя по всем событиям,
+// для событий без настройки - канал по умолчанию
+func (db *DataBase) GetNotificationPreferences(login string) (notify.Preferences, error) {
+	ctx, cancel := db.context("GetNotificationPreferences")
+	defer cancel()
+
+	rows, err := db.pool().Query(ctx, dbGetNotifyPrefs, login)
+	if err != nil {
+		return nil, err
+	}
+
+	// строка - событие и канал
+	set, err := scanRows(rows, func(row pgx.Row, p *[2]string) error {
+		return row.Scan(&p[0], &p[1])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	prefs := notify.Default()
+	for _, p := range set {
+		prefs[p[0]] = p[1]
+	}
+
+	return prefs, nil
+}
+
+// SetNotificationPreferences меняет каналы событий из prefs, остальные события не меняются
+func (db *DataBase) SetNotificationPreferences(login string, prefs notify.Preferences) error {
+	ctx, cancel := db.context("SetNotificationPreferences")
+	defer cancel()
+
+	return db.WithTx(ctx, func(tx pgx.Tx) error {
+		for event, channel := range prefs {
+			if _, err := tx.Exec(ctx, dbSetNotifyPref, login, event, channel); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetEmail возвращает адрес почты пользователя, пустую строку - если адрес не указан
+func (db *DataBase) GetEmail(login string) (string, error) {
+	ctx, cancel := db.context("GetEmail")
+	defer cancel()
+
+	var email string
+	if err := db.pool().QueryRow(ctx, dbGetEmail, login).Scan(&email); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return "", err
+		}
+
+		return "", nil
+	}
+
+	return email, nil
+}
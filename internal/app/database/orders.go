@@ -1,76 +1,156 @@
 package database
 
 import (
-	"context"
+	"encoding/json"
 	"errors"
 	"log"
 	"strconv"
 	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
 type Order struct {
-	Number     string  `json:"number"`
-	Login      string  `json:"login,omitempty"`
-	Status     string  `json:"status"`
-	Accrual    float64 `json:"accrual,omitempty"`
-	UploadedAt string  `json:"uploaded_at,omitempty"`
+	// Seq - порядковый номер заказа, выдает база: по нему упорядочен список заказов и идет постраничная выдача
+	Seq        int64     `json:"seq,omitempty"`
+	Number     string    `json:"number"`
+	Login      string    `json:"login,omitempty"`
+	Status     string    `json:"status"`
+	Accrual    float64   `json:"accrual,omitempty"`
+	UploadedAt time.Time `json:"-"`
+	Revision   int64     `json:"-"`
+	// PollUntil - до какого момента заказ NEW или PROCESSING опрашивается в системе расчета,
+	// нулевой - без срока
+	PollUntil time.Time `json:"-"`
+	// AccrualDelayed - заказ ждет расчета, а система расчета недоступна
+	AccrualDelayed bool `json:"accrual_delayed,omitempty"`
 }
 
-var (
-	// Таблица заказов orders:
-	dbAddOrder            = `INSERT INTO orders (number, login, uploaded_at) VALUES ($1, $2, $3) ON CONFLICT(number) DO NOTHING`
-	dbGetOrders           = `SELECT number, status, COALESCE(accrual, 0), uploaded_at FROM orders WHERE login = $1`
-	dbGetNotCheckedOrders = `SELECT number FROM orders WHERE status = 'NEW' OR status = 'PROCESSING'`
-	dbUpdateOrder         = `UPDATE orders SET status = $1, accrual = $2 WHERE number = $3`
-	dbGetOrderLogin       = `SELECT login FROM orders WHERE number = $1`
-)
+// MarshalJSON отдает uploaded_at и poll_until в RFC3339: в базе время хранится с микросекундами.
+// Нулевое начисление отдается только без JSONCompat.
+func (o Order) MarshalJSON() ([]byte, error) {
+	type order Order
+
+	var uploadedAt string
+	if !o.UploadedAt.IsZero() {
+		uploadedAt = jsonTime(o.UploadedAt)
+	}
 
-var sumOfElementsOfADoubleNumber = [...]int{0, 2, 4, 6, 8, 1, 3, 5, 7, 9}
+	var pollUntil string
+	if !o.PollUntil.IsZero() {
+		pollUntil = jsonTime(o.PollUntil)
+	}
 
-func checkOrderNumber(number int) bool {
-	s := strconv.Itoa(number)
-	odd := len(s) & 1
-	var sum int
-	for i, c := range s {
-		if c < '0' || c > '9' {
-			return false
-		}
-		if i&1 == odd {
-			sum += sumOfElementsOfADoubleNumber[c-'0']
-		} else {
-			sum += int(c - '0')
-		}
+	var accrual *float64
+	if o.Accrual != 0 || !JSONCompat {
+		accrual = &o.Accrual
 	}
-	return sum%10 == 0
+
+	return json.Marshal(struct {
+		order
+		Accrual    *float64 `json:"accrual,omitempty"`
+		UploadedAt string   `json:"uploaded_at,omitempty"`
+		PollUntil  string   `json:"poll_until,omitempty"`
+	}{order: order(o), Accrual: accrual, UploadedAt: uploadedAt, PollUntil: pollUntil})
 }
 
+var (
+	// Таблица заказов orders:
+	// номер из архива занят так же, как номер из orders
+	dbAddOrder = `INSERT INTO orders (number, userid, uploaded_at)
+								SELECT $1, (SELECT userid FROM users WHERE login = $2), $3
+								WHERE NOT EXISTS (SELECT 1 FROM orders_archive WHERE number = $1) ON CONFLICT(number) DO NOTHING`
+	// выборку по владельцу в порядке загрузки обслуживает индекс orders_userid_seq_idx
+	// срок опроса - продленный администратором poll_until или uploaded_at + $4 секунд, 0 - без срока;
+	// $5 - seq последнего заказа предыдущей страницы, 0 - с начала списка
+	dbGetOrders = `SELECT seq, number, status, COALESCE(accrual, 0), uploaded_at,
+								CASE WHEN status IN ('NEW', 'PROCESSING') THEN
+									COALESCE(poll_until, uploaded_at + make_interval(secs => NULLIF($4::float8, 0))) END
+								FROM orders WHERE userid = (SELECT userid FROM users WHERE login = $1)
+								AND seq > $5 ORDER BY seq
+								LIMIT NULLIF($2, 0) OFFSET $3`
+	// то же вместе с архивом, у заказов из архива срока опроса нет
+	dbGetOrdersArchived = `SELECT seq, number, status, accrual, uploaded_at, poll_until FROM (
+									SELECT seq, number, status, COALESCE(accrual, 0) AS accrual, uploaded_at,
+									CASE WHEN status IN ('NEW', 'PROCESSING') THEN
+										COALESCE(poll_until, uploaded_at + make_interval(secs => NULLIF($4::float8, 0))) END AS poll_until
+									FROM orders WHERE userid = (SELECT userid FROM users WHERE login = $1)
+									UNION ALL
+									SELECT seq, number, status, COALESCE(accrual, 0), uploaded_at, NULL FROM orders_archive
+									WHERE userid = (SELECT userid FROM users WHERE login = $1)) o
+								WHERE seq > $5 ORDER BY seq LIMIT NULLIF($2, 0) OFFSET $3`
+	// один заказ пользователя, в том числе из архива
+	dbGetOrder = `SELECT seq, number, status, COALESCE(accrual, 0), uploaded_at,
+								CASE WHEN status IN ('NEW', 'PROCESSING') THEN
+									COALESCE(poll_until, uploaded_at + make_interval(secs => NULLIF($3::float8, 0))) END
+								FROM orders WHERE number = $2 AND userid = (SELECT userid FROM users WHERE login = $1)
+								UNION ALL
+								SELECT seq, number, status, COALESCE(accrual, 0), uploaded_at, NULL FROM orders_archive
+								WHERE number = $2 AND userid = (SELECT userid FROM users WHERE login = $1)`
+	dbGetNotCheckedOrders = `SELECT number FROM orders WHERE (status = 'NEW' OR status = 'PROCESSING')
+								AND (next_poll_at IS NULL OR next_poll_at <= now())
+								AND number NOT IN (SELECT number FROM accrual_quarantine)
+								ORDER BY next_poll_at NULLS FIRST, uploaded_at`
+	dbDeferPoll = `UPDATE orders SET next_poll_at = $2 WHERE number = $1 AND status IN ('NEW', 'PROCESSING')`
+	// revision - версия заказа: меняется при каждом изменении, $4 = 0 - без проверки версии
+	dbUpdateOrder = `UPDATE orders SET status = $1, accrual = $2, updated_at = now(), revision = nextval('orders_revision_seq')
+								WHERE number = $3 AND ($4::bigint = 0 OR revision = $4)`
+	dbGetOrderRevision = `SELECT revision FROM orders WHERE number = $1`
+	dbGetChangedOrders = `SELECT seq, number, status, COALESCE(accrual, 0), uploaded_at, revision FROM orders
+								WHERE userid = (SELECT userid FROM users WHERE login = $1) AND revision > $2 AND updated_at > $3 ORDER BY revision`
+	dbExpireOrder = `UPDATE orders SET status = 'EXPIRED', updated_at = now(), revision = nextval('orders_revision_seq')
+								WHERE number = $1 AND status IN ('NEW', 'PROCESSING')
+								AND COALESCE(poll_until, uploaded_at + make_interval(secs => NULLIF($2::float8, 0))) < now()`
+	dbExtendOrder = `UPDATE orders SET poll_until = $2, status = CASE WHEN old.status = 'EXPIRED' THEN 'NEW' ELSE old.status END,
+								updated_at = now(), revision = nextval('orders_revision_seq')
+								FROM (SELECT number, status FROM orders WHERE number = $1 FOR UPDATE) old
+								WHERE orders.number = old.number AND old.status IN ('NEW', 'PROCESSING', 'EXPIRED')
+								RETURNING old.status, orders.status, COALESCE((SELECT login FROM users WHERE userid = orders.userid), ''), orders.uploaded_at`
+	dbOrderExists   = `SELECT EXISTS (SELECT 1 FROM orders WHERE number = $1)`
+	dbGetOrderOwner = `SELECT COALESCE(users.login, '') FROM orders
+								LEFT JOIN users ON users.userid = orders.userid WHERE orders.number = $1
+								UNION ALL
+								SELECT users.login FROM orders_archive
+								JOIN users ON users.userid = orders_archive.userid WHERE orders_archive.number = $1`
+	// квота пользователя ведется по 'user:<userid>' и не сбрасывается сменой логина
+	dbTakeOrderQuota = `INSERT INTO order_quota (owner, day, count)
+								VALUES (COALESCE((SELECT 'user:' || userid FROM users WHERE login = $1), $1), $2, $4)
+								ON CONFLICT(owner, day) DO UPDATE SET count = order_quota.count + $4
+								WHERE order_quota.count + $4 <= $3 RETURNING count`
+	dbReturnOrderQuota = `UPDATE order_quota SET count = GREATEST(count - $3, 0)
+								WHERE owner = COALESCE((SELECT 'user:' || userid FROM users WHERE login = $1), $1) AND day = $2`
+	// пачка номеров вставляется одним запросом, занятые номера пропускаются и разбираются по владельцу
+	dbAddOrders = `INSERT INTO orders (number, userid, uploaded_at)
+								SELECT batch.number, (SELECT userid FROM users WHERE login = $2), $3 FROM unnest($1::varchar[]) AS batch(number)
+								WHERE NOT EXISTS (SELECT 1 FROM orders_archive WHERE orders_archive.number = batch.number)
+								ON CONFLICT(number) DO NOTHING RETURNING number`
+	dbGetOrderOwners = `SELECT orders.number, COALESCE(users.login, '') FROM orders
+								LEFT JOIN users ON users.userid = orders.userid WHERE orders.number = ANY($1)
+								UNION ALL
+								SELECT orders_archive.number, users.login FROM orders_archive
+								JOIN users ON users.userid = orders_archive.userid WHERE orders_archive.number = ANY($1)`
+)
+
 func (db *DataBase) AddOrder(login string, order int) error {
-	if !checkOrderNumber(order) {
-		return ErrBadOrderNumber
-	}
+	number := strconv.Itoa(order)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := db.context("AddOrder")
 	defer cancel()
 
-	exec, err := db.DB.ExecContext(ctx, dbAddOrder, order, login, time.Now().Format(time.RFC3339))
-	if err != nil {
-		return err
-	}
-
-	affected, err := exec.RowsAffected()
+	exec, err := db.pool().Exec(ctx, db.dialect.stmt(stmtAddOrder), number, login, time.Now())
 	if err != nil {
 		return err
 	}
 
-	if affected != 0 {
+	if exec.RowsAffected() != 0 {
 		return nil
 	}
 
-	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel = db.context("AddOrder")
 	defer cancel()
 
 	var orderLogin string
-	if err = db.DB.QueryRowContext(ctx, dbGetOrderLogin, order).Scan(&orderLogin); err != nil {
+	if err = db.pool().QueryRow(ctx, dbGetOrderOwner, number).Scan(&orderLogin); err != nil {
 		return err
 	}
 
@@ -81,51 +161,177 @@ func (db *DataBase) AddOrder(login string, order int) error {
 	return ErrDuplicate
 }
 
-func (db *DataBase) GetNotCheckedOrders() ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+// AddOrders сохраняет пачку заказов пользователя одним запросом вместо запроса на каждый номер.
+// Для каждого номера возвращает то же, что AddOrder: nil - заказ добавлен, ErrDuplicate - уже
+// загружен этим пользователем, ErrUsed - другим пользователем.
+func (db *DataBase) AddOrders(login string, numbers []string) (map[string]error, error) {
+	ctx, cancel := db.context("AddOrders")
 	defer cancel()
 
-	rows, err := db.DB.QueryContext(ctx, dbGetNotCheckedOrders)
+	var results map[string]error
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		results = make(map[string]error, len(numbers))
+
+		added, err := tx.Query(ctx, dbAddOrders, numbers, login, time.Now())
+		if err != nil {
+			return err
+		}
+
+		numbersAdded, err := scanRows(added, func(row pgx.Row, number *string) error {
+			return row.Scan(number)
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, number := range numbersAdded {
+			results[number] = nil
+		}
+
+		if len(results) == len(numbers) {
+			return nil
+		}
+
+		owners, err := tx.Query(ctx, dbGetOrderOwners, numbers)
+		if err != nil {
+			return err
+		}
+
+		type owner struct{ number, login string }
+		taken, err := scanRows(owners, func(row pgx.Row, o *owner) error {
+			return row.Scan(&o.number, &o.login)
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, o := range taken {
+			if _, ok := results[o.number]; ok {
+				continue
+			}
+
+			results[o.number] = ErrUsed
+			if o.login == login {
+				results[o.number] = ErrDuplicate
+			}
+		}
+
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	var orders []string
-	for rows.Next() {
-		var order string
-		err := rows.Scan(&order)
-		if err != nil {
-			log.Print(err)
-			continue
+	return results, nil
+}
+
+// GetOrderOwner возвращает логин владельца заказа
+func (db *DataBase) GetOrderOwner(number string) (string, error) {
+	ctx, cancel := db.context("GetOrderOwner")
+	defer cancel()
+
+	var login string
+	if err := db.pool().QueryRow(ctx, dbGetOrderOwner, number).Scan(&login); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return "", err
 		}
 
-		orders = append(orders, order)
+		return "", ErrNotFound
 	}
 
-	if err := rows.Err(); err != nil {
-		log.Print(err)
-		return nil, err
+	return login, nil
+}
+
+// TakeOrderQuota учитывает n попыток загрузки заказа в дневной квоте пользователя, возвращает
+// false и ничего не учитывает, если на n попыток квоты на текущие сутки (UTC) не хватает
+func (db *DataBase) TakeOrderQuota(login string, n, limit int) (bool, error) {
+	if n > limit {
+		return false, nil
 	}
 
-	return orders, nil
+	ctx, cancel := db.context("TakeOrderQuota")
+	defer cancel()
+
+	var count int
+	err := db.pool().QueryRow(ctx, dbTakeOrderQuota, login, time.Now().UTC(), limit, n).Scan(&count)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return false, err
+		}
+
+		return false, nil
+	}
+
+	return true, nil
 }
 
-func (db *DataBase) UpdateOrder(number, status string, accrual float64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+// ReturnOrderQuota возвращает в дневную квоту пользователя n попыток, взятых TakeOrderQuota
+// под заказы, которые не были приняты
+func (db *DataBase) ReturnOrderQuota(login string, n int) error {
+	ctx, cancel := db.context("ReturnOrderQuota")
 	defer cancel()
 
-	exec, err := db.DB.ExecContext(ctx, dbUpdateOrder, status, accrual, number)
-	if err != nil {
+	if _, err := db.pool().Exec(ctx, dbReturnOrderQuota, login, time.Now().UTC(), n); err != nil {
 		return err
 	}
 
-	affected, err := exec.RowsAffected()
+	return nil
+}
+
+// GetNotCheckedOrders возвращает заказы NEW и PROCESSING, которые пора опросить (см. DeferPoll):
+// сначала ни разу не отложенные, затем по времени опроса
+func (db *DataBase) GetNotCheckedOrders() ([]string, error) {
+	ctx, cancel := db.context("GetNotCheckedOrders")
+	defer cancel()
+
+	rows, err := db.pool().Query(ctx, dbGetNotCheckedOrders)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if affected == 0 {
-		return errors.New("failed update order")
+	return scanRows(rows, func(row pgx.Row, number *string) error {
+		return row.Scan(number)
+	})
+}
+
+// UpdateOrder сохраняет статус и начисление заказа версии revision. Заказ изменился после чтения
+// версии (администратором или другим экземпляром) - ErrConflict, revision 0 - без проверки версии.
+func (db *DataBase) UpdateOrder(number, status string, accrual float64, revision int64) error {
+	unlock := db.orders.lock(number)
+	defer unlock()
+
+	ctx, cancel := db.context("UpdateOrder")
+	defer cancel()
+
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		exec, err := tx.Exec(ctx, dbUpdateOrder, status, accrual, number, revision)
+		if err != nil {
+			return err
+		}
+
+		if exec.RowsAffected() == 0 {
+			var current int64
+			if err = tx.QueryRow(ctx, dbGetOrderRevision, number).Scan(&current); err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrNotFound
+				}
+
+				return err
+			}
+
+			return ErrConflict
+		}
+
+		if status == "PROCESSED" {
+			if _, err = tx.Exec(ctx, dbCreditAccrual, number, db.pointRate()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	log.Printf("update order: number: %s, status: %s, accrual: %g", number, status, accrual)
@@ -133,26 +339,122 @@ func (db *DataBase) UpdateOrder(number, status string, accrual float64) error {
 	return nil
 }
 
-func (db *DataBase) GetOrders(login string) ([]Order, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+// DeferPoll откладывает следующий опрос заказа NEW или PROCESSING до at
+func (db *DataBase) DeferPoll(number string, at time.Time) error {
+	ctx, cancel := db.context("DeferPoll")
+	defer cancel()
+
+	_, err := db.pool().Exec(ctx, dbDeferPoll, number, at)
+	return err
+}
+
+// GetOrderRevision возвращает версию заказа для UpdateOrder, несуществующий заказ - ErrNotFound
+func (db *DataBase) GetOrderRevision(number string) (int64, error) {
+	ctx, cancel := db.context("GetOrderRevision")
+	defer cancel()
+
+	var revision int64
+	if err := db.pool().QueryRow(ctx, dbGetOrderRevision, number).Scan(&revision); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+
+		return 0, err
+	}
+
+	return revision, nil
+}
+
+// ExpireOrder переводит заказ NEW или PROCESSING в EXPIRED, если срок его опроса прошел.
+// false - срок не прошел или заказ уже не ждет расчета.
+func (db *DataBase) ExpireOrder(number string) (bool, error) {
+	unlock := db.orders.lock(number)
+	defer unlock()
+
+	ctx, cancel := db.context("ExpireOrder")
 	defer cancel()
 
-	rows, err := db.DB.QueryContext(ctx, dbGetOrders, login)
+	exec, err := db.pool().Exec(ctx, dbExpireOrder, number, db.maxOrderAge.Seconds())
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	var orders []Order
-	for rows.Next() {
-		var order Order
-		if err = rows.Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt); err != nil {
-			return nil, err
+	if exec.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	log.Printf("update order: number: %s, status: EXPIRED", number)
+
+	return true, nil
+}
+
+// ExtendOrder продлевает опрос заказа до until. Заказ EXPIRED снова получает статус NEW,
+// resumed - его нужно вернуть в очередь опроса. Заказ с окончательным статусом - ErrWrongData.
+func (db *DataBase) ExtendOrder(number string, until time.Time) (order Order, resumed bool, err error) {
+	unlock := db.orders.lock(number)
+	defer unlock()
+
+	ctx, cancel := db.context("ExtendOrder")
+	defer cancel()
+
+	var oldStatus string
+	err = db.pool().QueryRow(ctx, dbExtendOrder, number, until).Scan(&oldStatus, &order.Status, &order.Login, &order.UploadedAt)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return Order{}, false, err
+		}
+
+		var exists bool
+		if err = db.pool().QueryRow(ctx, dbOrderExists, number).Scan(&exists); err != nil {
+			return Order{}, false, err
 		}
 
-		orders = append(orders, order)
+		if !exists {
+			return Order{}, false, ErrNotFound
+		}
+
+		return Order{}, false, ErrWrongData
+	}
+
+	order.Number, order.PollUntil = number, until
+
+	return order, oldStatus == "EXPIRED", nil
+}
+
+// GetOrders возвращает заказы пользователя от старых к новым по Seq: limit заказов после заказа
+// с Seq after, пропустив первые offset. Нулевой limit - все заказы, нулевой after - с начала списка.
+// archived - вместе с заказами из архива (см. ArchiveOrders).
+func (db *DataBase) GetOrders(login string, limit, offset int, after int64, archived bool) ([]Order, error) {
+	ctx, cancel := db.context("GetOrders")
+	defer cancel()
+
+	query := db.dialect.stmt(stmtGetOrders)
+	if archived {
+		query = dbGetOrdersArchived
 	}
 
-	if err = rows.Err(); err != nil {
+	var orders []Order
+	err := db.retry.do(ctx, func() error {
+		rows, err := db.pool().Query(ctx, query, login, limit, offset, db.maxOrderAge.Seconds(), after)
+		if err != nil {
+			return err
+		}
+
+		orders, err = scanRows(rows, func(row pgx.Row, o *Order) error {
+			var pollUntil *time.Time
+			if err := row.Scan(&o.Seq, &o.Number, &o.Status, &o.Accrual, &o.UploadedAt, &pollUntil); err != nil {
+				return err
+			}
+
+			if pollUntil != nil {
+				o.PollUntil = *pollUntil
+			}
+
+			return nil
+		})
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -162,3 +464,44 @@ func (db *DataBase) GetOrders(login string) ([]Order, error) {
 
 	return orders, nil
 }
+
+// GetOrder возвращает заказ number пользователя login. Заказ другого пользователя, как и
+// незагруженный, - ErrNotFound.
+func (db *DataBase) GetOrder(login, number string) (Order, error) {
+	ctx, cancel := db.context("GetOrder")
+	defer cancel()
+
+	var o Order
+	var pollUntil *time.Time
+	err := db.pool().QueryRow(ctx, dbGetOrder, login, number, db.maxOrderAge.Seconds()).
+		Scan(&o.Seq, &o.Number, &o.Status, &o.Accrual, &o.UploadedAt, &pollUntil)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Order{}, ErrNotFound
+		}
+
+		return Order{}, err
+	}
+
+	if pollUntil != nil {
+		o.PollUntil = *pollUntil
+	}
+
+	return o, nil
+}
+
+// GetChangedOrders возвращает заказы пользователя, изменившиеся после курсора revision и после момента since,
+// в порядке изменения. Курсор - Revision последнего полученного заказа.
+func (db *DataBase) GetChangedOrders(login string, revision int64, since time.Time) ([]Order, error) {
+	ctx, cancel := db.context("GetChangedOrders")
+	defer cancel()
+
+	rows, err := db.pool().Query(ctx, dbGetChangedOrders, login, revision, since)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRows(rows, func(row pgx.Row, o *Order) error {
+		return row.Scan(&o.Seq, &o.Number, &o.Status, &o.Accrual, &o.UploadedAt, &o.Revision)
+	})
+}
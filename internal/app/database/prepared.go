@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Запросы, которые выполняются почти на каждый запрос к сервису, готовятся на каждом соединении
+// пула при его открытии: сервер разбирает и планирует их один раз на соединение. Вызов передает
+// вместо текста запроса имя stmt*.
+const (
+	stmtGetLogin  = "get_login"
+	stmtAddOrder  = "add_order"
+	stmtGetOrders = "get_orders"
+)
+
+var preparedStatements = map[string]string{
+	stmtGetLogin:  dbGetLogin,
+	stmtAddOrder:  dbAddOrder,
+	stmtGetOrders: dbGetOrders,
+}
+
+// prepare готовит preparedStatements на новом соединении пула. Схема к этому моменту должна
+// быть уже обновлена миграциями.
+func prepare(ctx context.Context, conn *pgx.Conn) error {
+	for name, sql := range preparedStatements {
+		if _, err := conn.Prepare(ctx, name, sql); err != nil {
+			return fmt.Errorf("prepare %s err: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type QuarantinedAccrual struct {
+	Number    string  `json:"number"`
+	Status    string  `json:"status"`
+	Accrual   float64 `json:"accrual"`
+	Reason    string  `json:"reason"`
+	CreatedAt string  `json:"created_at"`
+}
+
+var (
+	// Таблица отложенных ответов системы расчета accrual_quarantine:
+	dbQuarantine    = `INSERT INTO accrual_quarantine (number, status, accrual, reason) VALUES ($1, $2, $3, $4) ON CONFLICT(number) DO NOTHING`
+	dbGetQuarantine = `SELECT number, status, accrual, reason, created_at FROM accrual_quarantine ORDER BY created_at`
+)
+
+// Quarantine сохраняет подозрительный ответ системы расчета вместо начисления. Заказ остается
+// в прежнем статусе и больше не опрашивается.
+func (db *DataBase) Quarantine(number, status string, accrual float64, reason string) error {
+	ctx, cancel := db.context("Quarantine")
+	defer cancel()
+
+	if _, err := db.pool().Exec(ctx, dbQuarantine, number, status, accrual, reason); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DataBase) GetQuarantine() ([]QuarantinedAccrual, error) {
+	ctx, cancel := db.context("GetQuarantine")
+	defer cancel()
+
+	rows, err := db.pool().Query(ctx, dbGetQuarantine)
+	if err != nil {
+		return nil, err
+	}
+
+	accruals, err := scanRows(rows, func(row pgx.Row, a *QuarantinedAccrual) error {
+		var createdAt time.Time
+		if err := row.Scan(&a.Number, &a.Status, &a.Accrual, &a.Reason, &createdAt); err != nil {
+			return err
+		}
+
+		a.CreatedAt = createdAt.Format(time.RFC3339)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if accruals == nil {
+		return nil, ErrEmpty
+	}
+
+	return accruals, nil
+}
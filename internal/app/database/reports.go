@@ -0,0 +1,120 @@
+package database
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Отчеты по периодам: каждая метрика - агрегат по индексу created_at своей таблицы,
+// сгруппированный date_trunc по суткам или неделям (с понедельника) в UTC.
+const (
+	ReportByDay  = "day"
+	ReportByWeek = "week"
+)
+
+var (
+	// Отчеты:
+	dbReportAccrued = `SELECT date_trunc($3, created_at AT TIME ZONE 'UTC'), SUM(amount) FROM ledger
+						WHERE kind NOT IN ('transfer', 'opening') AND created_at >= $1 AND created_at < $2 GROUP BY 1`
+	dbReportWithdrawn = `SELECT date_trunc($3, created_at AT TIME ZONE 'UTC'), SUM(sum) FROM withdraw
+						WHERE created_at >= $1 AND created_at < $2 GROUP BY 1`
+	dbReportActive = `SELECT date_trunc($3, created_at AT TIME ZONE 'UTC'), COUNT(DISTINCT userid) FROM (
+							SELECT userid, created_at FROM orders WHERE userid IS NOT NULL AND created_at >= $1 AND created_at < $2
+							UNION ALL
+							SELECT userid, created_at FROM orders_archive WHERE created_at >= $1 AND created_at < $2
+							UNION ALL
+							SELECT userid, created_at FROM withdraw WHERE created_at >= $1 AND created_at < $2) activity
+						GROUP BY 1`
+	dbReportRegistered = `SELECT date_trunc($3, created_at AT TIME ZONE 'UTC'), COUNT(*) FROM users
+						WHERE created_at >= $1 AND created_at < $2 GROUP BY 1`
+)
+
+// ReportBucket - показатели за сутки или неделю, начинающиеся в Start. Активные пользователи -
+// загрузившие заказ или списавшие баллы. Регистрации до появления отчетов не учитываются.
+type ReportBucket struct {
+	Start         time.Time `json:"start"`
+	Accrued       float64   `json:"accrued"`
+	Withdrawn     float64   `json:"withdrawn"`
+	ActiveUsers   int64     `json:"active_users"`
+	Registrations int64     `json:"registrations"`
+}
+
+// ReportStart возвращает начало суток или недели groupBy, в которые попадает t
+func ReportStart(t time.Time, groupBy string) time.Time {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if groupBy == ReportByWeek {
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	}
+
+	return start
+}
+
+// GetReport возвращает показатели за [from, to) по суткам или неделям, включая периоды без событий
+func (db *DataBase) GetReport(from, to time.Time, groupBy string) ([]ReportBucket, error) {
+	step := 1
+	if groupBy == ReportByWeek {
+		step = 7
+	}
+
+	var buckets []ReportBucket
+	index := make(map[int64]*ReportBucket)
+	for start := ReportStart(from, groupBy); start.Before(to); start = start.AddDate(0, 0, step) {
+		buckets = append(buckets, ReportBucket{Start: start})
+	}
+	for i := range buckets {
+		index[buckets[i].Start.Unix()] = &buckets[i]
+	}
+
+	metrics := []struct {
+		query string
+		apply func(b *ReportBucket, v float64)
+	}{
+		{dbReportAccrued, func(b *ReportBucket, v float64) { b.Accrued = v }},
+		{dbReportWithdrawn, func(b *ReportBucket, v float64) { b.Withdrawn = v }},
+		{dbReportActive, func(b *ReportBucket, v float64) { b.ActiveUsers = int64(v) }},
+		{dbReportRegistered, func(b *ReportBucket, v float64) { b.Registrations = int64(v) }},
+	}
+
+	for _, m := range metrics {
+		if err := db.reportMetric(m.query, from, to, groupBy, func(start time.Time, v float64) {
+			// date_trunc возвращает время без зоны, pgx читает его как UTC
+			if b, ok := index[start.Unix()]; ok {
+				m.apply(b, v)
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return buckets, nil
+}
+
+func (db *DataBase) reportMetric(query string, from, to time.Time, groupBy string, apply func(time.Time, float64)) error {
+	ctx, cancel := db.context("reportMetric")
+	defer cancel()
+
+	rows, err := db.pool().Query(ctx, query, from, to, groupBy)
+	if err != nil {
+		return err
+	}
+
+	type point struct {
+		start time.Time
+		v     float64
+	}
+
+	points, err := scanRows(rows, func(row pgx.Row, p *point) error {
+		return row.Scan(&p.start, &p.v)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range points {
+		apply(p.start, p.v)
+	}
+
+	return nil
+}
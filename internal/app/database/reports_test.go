@@ -0,0 +1,31 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReportStart(t *testing.T) {
+	// 2026-10-15 - четверг
+	at := time.Date(2026, 10, 15, 23, 30, 0, 0, time.FixedZone("MSK", 3*60*60))
+
+	tests := []struct {
+		groupBy string
+		want    time.Time
+	}{
+		{ReportByDay, time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+		{ReportByWeek, time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.groupBy, func(t *testing.T) {
+			if got := ReportStart(at, tt.groupBy); !got.Equal(tt.want) {
+				t.Errorf("ReportStart() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	sunday := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	if got := ReportStart(sunday, ReportByWeek); !got.Equal(time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("ReportStart(sunday) = %s, want monday", got)
+	}
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// retryPolicy - повтор вызовов базы после временных ошибок: попытки с экспоненциальной паузой
+// от baseDelay до maxDelay, пауза выбирается случайно в [0, предел), чтобы экземпляры не повторяли
+// запросы одновременно. attempts <= 1 - без повторов.
+type retryPolicy struct {
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// retriable сообщает, что вызов можно повторить: транзакция откатилась из-за конфликта, сервер
+// не принял соединение, или соединение оборвалось до отправки запроса
+func retriable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgerrcode.SerializationFailure, pgerrcode.DeadlockDetected,
+			pgerrcode.TooManyConnections, pgerrcode.CannotConnectNow:
+			return true
+		}
+
+		return false
+	}
+
+	return pgconn.SafeToRetry(err)
+}
+
+// delay возвращает паузу перед повтором номер attempt (с 1)
+func (p retryPolicy) delay(attempt int) time.Duration {
+	limit := p.baseDelay << (attempt - 1)
+	if limit <= 0 || limit > p.maxDelay {
+		limit = p.maxDelay
+	}
+
+	if limit <= 0 {
+		return 0
+	}
+
+	return rand.N(limit)
+}
+
+// do выполняет fn и повторяет ее после временных ошибок, пока не кончатся попытки или ctx.
+// fn должна быть безопасна для повтора: транзакция целиком или чтение.
+func (p retryPolicy) do(ctx context.Context, fn func() error) error {
+	err := fn()
+	for attempt := 1; attempt < p.attempts && err != nil && retriable(err); attempt++ {
+		log.Printf("db retry: attempt %d, err: %s", attempt+1, err.Error())
+
+		timer := time.NewTimer(p.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		err = fn()
+	}
+
+	return err
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	p := retryPolicy{attempts: 3, baseDelay: time.Millisecond, maxDelay: 2 * time.Millisecond}
+	conflict := &pgconn.PgError{Code: pgerrcode.SerializationFailure}
+	unique := &pgconn.PgError{Code: pgerrcode.UniqueViolation}
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   error
+		wantCalls int
+	}{
+		{name: "Успех после конфликтов", errs: []error{conflict, conflict, nil}, wantCalls: 3},
+		{name: "Попытки кончились", errs: []error{conflict, conflict, conflict, nil}, wantErr: conflict, wantCalls: 3},
+		{name: "Постоянная ошибка", errs: []error{ErrNoMoney, nil}, wantErr: ErrNoMoney, wantCalls: 1},
+		{name: "Нарушение уникальности", errs: []error{unique, nil}, wantErr: unique, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			err := p.do(context.Background(), func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("do() error = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("do() calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var calls int
+	err := retryPolicy{attempts: 3, baseDelay: time.Hour, maxDelay: time.Hour}.do(ctx, func() error {
+		calls++
+		return conflict
+	})
+	if !errors.Is(err, conflict) || calls != 1 {
+		t.Errorf("do() after cancel = %v, calls %d, want one call", err, calls)
+	}
+
+	for attempt := 1; attempt < 10; attempt++ {
+		if d := p.delay(attempt); d < 0 || d >= p.maxDelay {
+			t.Errorf("delay(%d) = %s, want [0, %s)", attempt, d, p.maxDelay)
+		}
+	}
+}
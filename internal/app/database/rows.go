@@ -0,0 +1,26 @@
+package database
+
+import "github.com/jackc/pgx/v5"
+
+// scanRows читает все строки результата и закрывает rows. scan разбирает одну строку в v: столбцы,
+// которые могут быть NULL, запрос отдает через COALESCE или scan читает в указатели. Ошибка
+// разбора любой строки или чтения результата возвращается целиком, без частичного списка.
+func scanRows[T any](rows pgx.Rows, scan func(row pgx.Row, v *T) error) ([]T, error) {
+	defer rows.Close()
+
+	var items []T
+	for rows.Next() {
+		var item T
+		if err := scan(rows, &item); err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
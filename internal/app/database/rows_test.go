@@ -0,0 +1,151 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeRows - результат запроса без базы: строки values разбираются Scan, как это делает pgx,
+// NULL (nil) в не-указатель - ошибка
+type fakeRows struct {
+	values [][]any
+	err    error
+	row    int
+	closed bool
+}
+
+func (r *fakeRows) Close()                                       { r.closed = true }
+func (r *fakeRows) Err() error                                   { return r.err }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *fakeRows) RawValues() [][]byte                          { return nil }
+func (r *fakeRows) Conn() *pgx.Conn                              { return nil }
+
+func (r *fakeRows) Next() bool {
+	if r.closed || r.row >= len(r.values) {
+		r.closed = true
+		return false
+	}
+
+	r.row++
+	return true
+}
+
+func (r *fakeRows) Values() ([]any, error) {
+	return r.values[r.row-1], nil
+}
+
+func (r *fakeRows) Scan(dest ...any) error {
+	values := r.values[r.row-1]
+	if len(dest) != len(values) {
+		return fmt.Errorf("number of field descriptions must equal number of destinations, got %d and %d", len(values), len(dest))
+	}
+
+	for i, v := range values {
+		d := reflect.ValueOf(dest[i]).Elem()
+		if v == nil {
+			if d.Kind() != reflect.Pointer {
+				return fmt.Errorf("can't scan into dest[%d]: cannot scan NULL into %s", i, d.Type())
+			}
+
+			d.Set(reflect.Zero(d.Type()))
+			continue
+		}
+
+		value := reflect.ValueOf(v)
+		if d.Kind() == reflect.Pointer {
+			p := reflect.New(d.Type().Elem())
+			p.Elem().Set(value)
+			value = p
+		}
+
+		d.Set(value)
+	}
+
+	return nil
+}
+
+func TestScanRows(t *testing.T) {
+	processedAt := time.Date(2026, 10, 15, 12, 30, 5, 0, time.UTC)
+	scanWithDraw := func(row pgx.Row, w *WithDraw) error {
+		return row.Scan(&w.OrderID, &w.Sum, &w.ProcessedAt, &w.Reference)
+	}
+
+	t.Run("Все строки", func(t *testing.T) {
+		rows := &fakeRows{values: [][]any{
+			{"2377225624", 751.0, processedAt, "ref"},
+			{"49927398716", 100.5, processedAt, ""},
+		}}
+
+		got, err := scanRows(rows, scanWithDraw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []WithDraw{
+			{OrderID: "2377225624", Sum: 751, ProcessedAt: processedAt, Reference: "ref"},
+			{OrderID: "49927398716", Sum: 100.5, ProcessedAt: processedAt},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("scanRows() = %v, want %v", got, want)
+		}
+		if !rows.closed {
+			t.Error("scanRows() did not close rows")
+		}
+	})
+
+	t.Run("Пустой результат", func(t *testing.T) {
+		got, err := scanRows(&fakeRows{}, scanWithDraw)
+		if err != nil || got != nil {
+			t.Errorf("scanRows() = %v, %v, want nil, nil", got, err)
+		}
+	})
+
+	t.Run("NULL в указатель", func(t *testing.T) {
+		rows := &fakeRows{values: [][]any{{"49927398716", nil}, {"1234567812345670", 535.31}}}
+
+		got, err := scanRows(rows, func(row pgx.Row, o *Order) error {
+			var accrual *float64
+			if err := row.Scan(&o.Number, &accrual); err != nil {
+				return err
+			}
+
+			if accrual != nil {
+				o.Accrual = *accrual
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []Order{{Number: "49927398716"}, {Number: "1234567812345670", Accrual: 535.31}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("scanRows() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Ошибка разбора строки", func(t *testing.T) {
+		rows := &fakeRows{values: [][]any{{"2377225624", 751.0, processedAt, "ref"}, {"49927398716", nil, processedAt, ""}}}
+
+		if got, err := scanRows(rows, scanWithDraw); err == nil || got != nil {
+			t.Errorf("scanRows() = %v, %v, want error", got, err)
+		}
+		if !rows.closed {
+			t.Error("scanRows() did not close rows")
+		}
+	})
+
+	t.Run("Ошибка чтения результата", func(t *testing.T) {
+		errRead := errors.New("conn closed")
+		rows := &fakeRows{values: [][]any{{"2377225624", 751.0, processedAt, "ref"}}, err: errRead}
+
+		if got, err := scanRows(rows, scanWithDraw); !errors.Is(err, errRead) || got != nil {
+			t.Errorf("scanRows() = %v, %v, want %v", got, err, errRead)
+		}
+	})
+}
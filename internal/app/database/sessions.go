@@ -0,0 +1,312 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Сессия создается анонимной (userid = NULL) при выдаче cookie. При регистрации или входе она
+// заменяется сессией пользователя с новым идентификатором: идентификатор, известный до входа,
+// после него не действует.
+var (
+	// Таблица сессий sessions:
+	dbNewSession     = `INSERT INTO sessions (id, expires_at, user_agent, ip) VALUES ($1, $2, $3, $4) ON CONFLICT(id) DO NOTHING`
+	dbUpgradeSession = `INSERT INTO sessions (id, userid, expires_at, user_agent, ip)
+							SELECT $1, users.userid, $4, COALESCE(old.user_agent, ''), COALESCE(old.ip, '') FROM users
+							LEFT JOIN sessions old ON old.id = $2 WHERE users.login = $3`
+	dbDellSession    = `DELETE FROM sessions WHERE id = $1`
+	dbDellExpired    = `DELETE FROM sessions WHERE expires_at <= now() AND userid = (SELECT userid FROM users WHERE login = $1)`
+	dbDellAllExpired = `DELETE FROM sessions WHERE expires_at <= now()`
+	dbGetLogin       = `SELECT users.login FROM sessions JOIN users ON users.userid = sessions.userid
+							WHERE sessions.id = $1 AND sessions.expires_at > now()`
+	dbGetSession = `SELECT users.login, sessions.sid, sessions.user_agent, sessions.ip, COALESCE(sessions.impersonator, ''), sessions.read_only
+							FROM sessions JOIN users ON users.userid = sessions.userid WHERE sessions.id = $1 AND sessions.expires_at > now()`
+	dbRefreshSession = `UPDATE sessions SET id = $1, expires_at = $2 WHERE id = $3 AND userid IS NOT NULL AND impersonator IS NULL AND expires_at > now()`
+	dbGetSessionAge  = `SELECT EXTRACT(EPOCH FROM now() - created_at) FROM sessions WHERE id = $1`
+	dbListSessions   = `SELECT sid, id = $2, created_at, expires_at, last_seen_at, user_agent, ip FROM sessions
+							WHERE userid = (SELECT userid FROM users WHERE login = $1) AND expires_at > now()
+							ORDER BY created_at DESC`
+	dbRevokeSession = `DELETE FROM sessions WHERE sid = $1 AND userid = (SELECT userid FROM users WHERE login = $2)`
+	dbMarkRevoked   = `UPDATE users SET sessions_revoked_at = now() WHERE login = $1`
+	dbRevokeAll     = `DELETE FROM sessions WHERE userid = (SELECT userid FROM users WHERE login = $1)`
+	dbGetRevokedAt  = `SELECT GREATEST(sessions_revoked_at, created_at) FROM users WHERE login = $1`
+	// запрос в своей сессии продлевает активность пользователя и снимает отметку о неактивности
+	dbTouchSessions = `WITH touched AS (UPDATE sessions SET last_seen_at = t.seen FROM unnest($1::varchar[], $2::timestamptz[]) AS t(id, seen)
+							WHERE sessions.id = t.id AND (sessions.last_seen_at IS NULL OR sessions.last_seen_at < t.seen)
+							RETURNING sessions.userid, sessions.impersonator, t.seen)
+							UPDATE users SET last_active_at = a.seen, inactive_notified_at = NULL
+							FROM (SELECT userid, MAX(seen) AS seen FROM touched WHERE userid IS NOT NULL AND impersonator IS NULL GROUP BY userid) a
+							WHERE users.userid = a.userid AND (users.last_active_at IS NULL OR users.last_active_at < a.seen)`
+	dbMarkActive = `UPDATE users SET last_active_at = now(), inactive_notified_at = NULL WHERE login = $1`
+)
+
+// Session - сессия пользователя в списке для аудита входов. ID - публичный номер сессии,
+// идентификатор из cookie наружу не отдается.
+type Session struct {
+	ID        int64     `json:"id"`
+	Current   bool      `json:"current"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	// LastSeenAt - последний запрос в сессии с точностью до интервала записи, nil - запросов еще не было
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// NewSession создает анонимную сессию для только что выданной cookie, запоминая клиента
+func (db *DataBase) NewSession(cookie, userAgent, ip string) error {
+	ctx, cancel := db.context("NewSession")
+	defer cancel()
+
+	if _, err := db.pool().Exec(ctx, dbNewSession, cookie, time.Now().Add(db.sessionTTL()), userAgent, ip); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// upgradeSession заменяет сессию cookie сессией newCookie пользователя login с тем же клиентом.
+// Прочие сессии пользователя остаются действующими, истекшие удаляются.
+func (db *DataBase) upgradeSession(cookie, newCookie, login string) error {
+	ctx, cancel := db.context("upgradeSession")
+	defer cancel()
+
+	return db.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, dbDellExpired, login); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, dbUpgradeSession, newCookie, cookie, login, time.Now().Add(db.sessionTTL())); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, dbDellSession, cookie); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx, dbMarkActive, login)
+		return err
+	})
+}
+
+func (db *DataBase) Authentication(cookie string) (string, error) {
+	ctx, cancel := db.context("Authentication")
+	defer cancel()
+
+	var login string
+	err := db.retry.do(ctx, func() error {
+		return db.pool().QueryRow(ctx, db.dialect.stmt(stmtGetLogin), cookie).Scan(&login)
+	})
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return "", err
+		}
+
+		return "", nil
+	}
+
+	return login, nil
+}
+
+// SessionClient - пользователь сессии и устройство, на котором сессия создана. Impersonator -
+// администратор, вошедший от имени пользователя, ReadOnly - изменения в такой сессии запрещены.
+type SessionClient struct {
+	Login        string
+	ID           int64
+	UserAgent    string
+	IP           string
+	Impersonator string
+	ReadOnly     bool
+}
+
+// GetSessionClient возвращает пользователя действующей сессии cookie вместе с данными клиента.
+// Для анонимной или истекшей сессии Login пуст.
+func (db *DataBase) GetSessionClient(cookie string) (SessionClient, error) {
+	ctx, cancel := db.context("GetSessionClient")
+	defer cancel()
+
+	var client SessionClient
+	err := db.pool().QueryRow(ctx, dbGetSession, cookie).Scan(&client.Login, &client.ID, &client.UserAgent, &client.IP,
+		&client.Impersonator, &client.ReadOnly)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return SessionClient{}, err
+		}
+
+		return SessionClient{}, nil
+	}
+
+	return client, nil
+}
+
+// RefreshSession заменяет идентификатор действующей сессии cookie на newCookie и продлевает ее.
+// Сессия входа администратора от имени пользователя не продлевается.
+func (db *DataBase) RefreshSession(cookie, newCookie string) error {
+	ctx, cancel := db.context("RefreshSession")
+	defer cancel()
+
+	exec, err := db.pool().Exec(ctx, dbRefreshSession, newCookie, time.Now().Add(db.sessionTTL()), cookie)
+	if err != nil {
+		return err
+	}
+
+	if exec.RowsAffected() == 0 {
+		return ErrWrongData
+	}
+
+	return nil
+}
+
+// SessionAge возвращает время, прошедшее с начала сессии cookie
+func (db *DataBase) SessionAge(cookie string) (time.Duration, error) {
+	ctx, cancel := db.context("SessionAge")
+	defer cancel()
+
+	var age float64
+	if err := db.pool().QueryRow(ctx, dbGetSessionAge, cookie).Scan(&age); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return 0, err
+		}
+
+		return 0, nil
+	}
+
+	return time.Duration(age * float64(time.Second)), nil
+}
+
+func (db *DataBase) Logout(cookie string) error {
+	ctx, cancel := db.context("Logout")
+	defer cancel()
+
+	if _, err := db.pool().Exec(ctx, dbDellSession, cookie); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpiredSessions удаляет истекшие сессии всех пользователей и анонимные.
+// Возвращает число удаленных сессий.
+func (db *DataBase) DeleteExpiredSessions() (int64, error) {
+	ctx, cancel := db.context("DeleteExpiredSessions")
+	defer cancel()
+
+	exec, err := db.pool().Exec(ctx, dbDellAllExpired)
+	if err != nil {
+		return 0, err
+	}
+
+	return exec.RowsAffected(), nil
+}
+
+// GetSessions возвращает действующие сессии пользователя, отмечая текущую сессию cookie
+func (db *DataBase) GetSessions(login, cookie string) ([]Session, error) {
+	ctx, cancel := db.context("GetSessions")
+	defer cancel()
+
+	rows, err := db.pool().Query(ctx, dbListSessions, login, cookie)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := scanRows(rows, func(row pgx.Row, s *Session) error {
+		return row.Scan(&s.ID, &s.Current, &s.CreatedAt, &s.ExpiresAt, &s.LastSeenAt, &s.UserAgent, &s.IP)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if sessions == nil {
+		sessions = []Session{}
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession завершает сессию id пользователя login, чужая или несуществующая сессия - ErrNotFound
+func (db *DataBase) RevokeSession(login string, id int64) error {
+	ctx, cancel := db.context("RevokeSession")
+	defer cancel()
+
+	exec, err := db.pool().Exec(ctx, dbRevokeSession, id, login)
+	if err != nil {
+		return err
+	}
+
+	if exec.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// RevokeAllSessions завершает все сессии пользователя и запоминает время отзыва, чтобы
+// отвергать выданные до него JWT. Для неизвестного пользователя возвращает ErrNotFound.
+func (db *DataBase) RevokeAllSessions(login string) error {
+	ctx, cancel := db.context("RevokeAllSessions")
+	defer cancel()
+
+	return db.WithTx(ctx, func(tx pgx.Tx) error {
+		exec, err := tx.Exec(ctx, dbMarkRevoked, login)
+		if err != nil {
+			return err
+		}
+
+		if exec.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+
+		_, err = tx.Exec(ctx, dbRevokeAll, login)
+		return err
+	})
+}
+
+// SessionsRevokedAt возвращает время, раньше которого выданные пользователю JWT недействительны:
+// последний отзыв всех сессий или создание учетной записи, чтобы токен удаленного пользователя
+// не подошел новому владельцу того же логина. Нулевое время - ограничения нет, неизвестный
+// пользователь - ErrNotFound.
+func (db *DataBase) SessionsRevokedAt(login string) (time.Time, error) {
+	ctx, cancel := db.context("SessionsRevokedAt")
+	defer cancel()
+
+	var revokedAt *time.Time
+	if err := db.pool().QueryRow(ctx, dbGetRevokedAt, login).Scan(&revokedAt); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, err
+		}
+
+		return time.Time{}, ErrNotFound
+	}
+
+	if revokedAt == nil {
+		return time.Time{}, nil
+	}
+
+	return *revokedAt, nil
+}
+
+// TouchSessions записывает время последнего запроса сразу для пачки сессий одним запросом.
+// Более раннее время не перезаписывает более позднее.
+func (db *DataBase) TouchSessions(seen map[string]time.Time) error {
+	if len(seen) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(seen))
+	times := make([]time.Time, 0, len(seen))
+	for id, t := range seen {
+		ids = append(ids, id)
+		times = append(times, t)
+	}
+
+	ctx, cancel := db.context("TouchSessions")
+	defer cancel()
+
+	if _, err := db.pool().Exec(ctx, dbTouchSessions, ids, times); err != nil {
+		return err
+	}
+
+	return nil
+}
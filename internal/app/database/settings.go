@@ -0,0 +1,92 @@
+package database
+
+import (
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/settings"
+	"github.com/jackc/pgx/v5"
+)
+
+// AuditSetting - изменение настройки администратором
+const AuditSetting = "setting"
+
+var (
+	// Таблица настроек settings:
+	dbGetSettings = `SELECT name, value, updated_at, updated_by FROM settings ORDER BY name`
+	dbPutSetting  = `INSERT INTO settings (name, value, updated_by) VALUES ($1, $2, $3)
+								ON CONFLICT(name) DO UPDATE SET value = $2, updated_at = now(), updated_by = $3`
+	dbDeleteSetting = `DELETE FROM settings WHERE name = $1`
+)
+
+// Settings возвращает действующие значения настроек из кеша процесса
+func (db *DataBase) Settings() settings.Values {
+	return db.settings.Get()
+}
+
+// SettingsDefaults возвращает значения настроек из конфигурации
+func (db *DataBase) SettingsDefaults() settings.Values {
+	return db.settings.Defaults()
+}
+
+// GetSettings возвращает значения, заданные администратором
+func (db *DataBase) GetSettings() ([]settings.Override, error) {
+	ctx, cancel := db.context("GetSettings")
+	defer cancel()
+
+	rows, err := db.pool().Query(ctx, dbGetSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRows(rows, func(row pgx.Row, o *settings.Override) error {
+		return row.Scan(&o.Name, &o.Value, &o.UpdatedAt, &o.UpdatedBy)
+	})
+}
+
+// PutSetting сохраняет значение настройки и записывает изменение в журнал аудита. Значение
+// проверяется вызывающим (settings.Validate).
+func (db *DataBase) PutSetting(name, value, by string) error {
+	ctx, cancel := db.context("PutSetting")
+	defer cancel()
+
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, dbPutSetting, name, value, by); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx, dbAddAudit, by, "", AuditSetting, name+" = "+value)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	db.settings.Invalidate()
+
+	return nil
+}
+
+// DeleteSetting возвращает настройке значение из конфигурации. Значение не задавалось - ErrNotFound.
+func (db *DataBase) DeleteSetting(name, by string) error {
+	ctx, cancel := db.context("DeleteSetting")
+	defer cancel()
+
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		exec, err := tx.Exec(ctx, dbDeleteSetting, name)
+		if err != nil {
+			return err
+		}
+
+		if exec.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+
+		_, err = tx.Exec(ctx, dbAddAudit, by, "", AuditSetting, name+" reset")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	db.settings.Invalidate()
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// Таблица остатков на конец суток balance_snapshots:
+	dbSnapshotBalances = `INSERT INTO balance_snapshots (userid, day, balance)
+							SELECT userid, $1::date, SUM(amount) FROM (
+								SELECT userid, amount FROM ledger WHERE created_at < $2
+								UNION ALL
+								SELECT userid, -sum FROM withdraw WHERE created_at < $2) moves
+							GROUP BY userid
+							ON CONFLICT(userid, day) DO UPDATE SET balance = excluded.balance`
+	dbLastSnapshot      = `SELECT MAX(day) FROM balance_snapshots`
+	dbGetBalanceHistory = `SELECT DISTINCT ON (date_trunc($4, day)) day, balance FROM balance_snapshots
+							WHERE userid = (SELECT userid FROM users WHERE login = $1) AND day >= $2 AND day < $3
+							ORDER BY date_trunc($4, day), day DESC`
+)
+
+// BalancePoint - остаток пользователя на конец суток Day (UTC)
+type BalancePoint struct {
+	Day     time.Time `json:"-"`
+	Balance float64   `json:"balance"`
+}
+
+func (p BalancePoint) MarshalJSON() ([]byte, error) {
+	type point BalancePoint
+
+	return json.Marshal(struct {
+		Date string `json:"date"`
+		point
+	}{Date: p.Day.Format("2006-01-02"), point: point(p)})
+}
+
+// SnapshotBalances записывает остатки всех пользователей на конец суток day (UTC). Остаток считается
+// по журналу и списаниям до конца суток, поэтому снимок можно записать и позже, и повторно.
+func (db *DataBase) SnapshotBalances(day time.Time) error {
+	day = ReportStart(day, ReportByDay)
+
+	ctx, cancel := db.context("SnapshotBalances")
+	defer cancel()
+
+	if _, err := db.pool().Exec(ctx, dbSnapshotBalances, day, day.AddDate(0, 0, 1)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// LastBalanceSnapshot возвращает сутки последнего записанного снимка, нулевое время - снимков нет
+func (db *DataBase) LastBalanceSnapshot() (time.Time, error) {
+	ctx, cancel := db.context("LastBalanceSnapshot")
+	defer cancel()
+
+	var day *time.Time
+	if err := db.pool().QueryRow(ctx, dbLastSnapshot).Scan(&day); err != nil {
+		return time.Time{}, err
+	}
+
+	if day == nil {
+		return time.Time{}, nil
+	}
+
+	return *day, nil
+}
+
+// GetBalanceHistory возвращает остатки пользователя за сутки [from, to) по порядку. С ReportByWeek
+// от каждой недели остается последний снимок.
+func (db *DataBase) GetBalanceHistory(login string, from, to time.Time, granularity string) ([]BalancePoint, error) {
+	ctx, cancel := db.context("GetBalanceHistory")
+	defer cancel()
+
+	rows, err := db.pool().Query(ctx, dbGetBalanceHistory, login, from, to, granularity)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRows(rows, func(row pgx.Row, p *BalancePoint) error {
+		return row.Scan(&p.Day, &p.Balance)
+	})
+}
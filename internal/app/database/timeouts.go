@@ -0,0 +1,72 @@
+package database
+
+import "time"
+
+// queryClass - класс вызова базы со своим таймаутом
+type queryClass int
+
+const (
+	classDefault queryClass = iota
+	classAuth
+	classList
+	classAccrual
+)
+
+// queryClasses - классы вызовов по имени, переданному в context. Вызовы без класса ограничены
+// DB_QUERY_TIMEOUT.
+var queryClasses = map[string]queryClass{
+	// проверка сессии и пароля стоит в начале каждого запроса: лучше быстро ответить ошибкой,
+	// чем держать запрос, пока база не освободится
+	"Authentication":    classAuth,
+	"GetSessionClient":  classAuth,
+	"SessionAge":        classAuth,
+	"SessionsRevokedAt": classAuth,
+	"CheckPassword":     classAuth,
+	"GetTOTP":           classAuth,
+
+	"GetOrders":           classList,
+	"GetChangedOrders":    classList,
+	"GetWithDraw":         classList,
+	"GetBalanceHistory":   classList,
+	"GetSessions":         classList,
+	"GetHolds":            classList,
+	"GetQuarantine":       classList,
+	"GetNotCheckedOrders": classList,
+	"GetProcessedOrders":  classList,
+	"reportMetric":        classList,
+
+	// начисления пишет фоновый опрос, ему можно ждать дольше запросов пользователей
+	"UpdateOrder":      classAccrual,
+	"GetOrderRevision": classAccrual,
+	"CorrectAccrual":   classAccrual,
+	"ExpireOrder":      classAccrual,
+	"DeferPoll":        classAccrual,
+	"Quarantine":       classAccrual,
+}
+
+// queryTimeouts - таймауты классов вызовов, нулевой - таймаут по умолчанию
+type queryTimeouts struct {
+	query   time.Duration
+	auth    time.Duration
+	list    time.Duration
+	accrual time.Duration
+}
+
+// of возвращает таймаут вызова method
+func (t queryTimeouts) of(method string) time.Duration {
+	var timeout time.Duration
+	switch queryClasses[method] {
+	case classAuth:
+		timeout = t.auth
+	case classList:
+		timeout = t.list
+	case classAccrual:
+		timeout = t.accrual
+	}
+
+	if timeout <= 0 {
+		return t.query
+	}
+
+	return timeout
+}
@@ -0,0 +1,28 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueryTimeouts(t *testing.T) {
+	timeouts := queryTimeouts{query: time.Second, auth: 200 * time.Millisecond, list: 2 * time.Second}
+
+	tests := []struct {
+		method string
+		want   time.Duration
+	}{
+		{method: "Authentication", want: 200 * time.Millisecond},
+		{method: "GetOrders", want: 2 * time.Second},
+		{method: "AddWithDraw", want: time.Second},
+		// таймаут класса не задан
+		{method: "UpdateOrder", want: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			if got := timeouts.of(tt.method); got != tt.want {
+				t.Errorf("of(%q) = %s, want %s", tt.method, got, tt.want)
+			}
+		})
+	}
+}
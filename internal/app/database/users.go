@@ -2,9 +2,15 @@ package database
 
 import (
 	"context"
-	"database/sql"
 	"errors"
+	"log"
+	"strconv"
 	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/format"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type User struct {
@@ -12,108 +18,436 @@ type User struct {
 	Login    string  `json:"login,omitempty"`
 	Password string  `json:"password,omitempty"`
 	Cookie   string  `json:"cookie,omitempty"`
-	Current  float64 `json:"current"`   // (сумма из orders) минус (сумма из withdraw)
+	Current  float64 `json:"current"`   // (сумма из ledger) минус (сумма из withdraw)
 	WithDraw float64 `json:"withdrawn"` // Сумма из withdraw
 }
 
+// Статусы пользователя
+const (
+	UserActive  = "active"
+	UserPending = "pending"
+	UserDeleted = "deleted"
+)
+
+// DeletedLogin - обезличенный логин удаленного пользователя. Пробел в логине запрещен при
+// регистрации, поэтому такой логин не совпадет с логином действующего пользователя.
+func DeletedLogin(userid int64) string {
+	return "deleted " + strconv.FormatInt(userid, 10)
+}
+
 var (
 	// Таблица пользователей users:
-	dbRegistration  = `INSERT INTO users (login, password, cookie) VALUES ($1, $2, $3) ON CONFLICT(login) DO NOTHING`
-	dbAuthorization = `SELECT COALESCE(cookie, '-') FROM users WHERE login = $1 AND password = $2`
-	dbDellCookie    = `UPDATE users SET cookie = NULL WHERE cookie = $1`
-	dbSetCookie     = `UPDATE users SET cookie = $1 WHERE login = $2 AND password = $3`
-	dbGetLogin      = `SELECT login FROM users WHERE cookie = $1`
-	dbGetBalance    = `SELECT login, 
-						COALESCE((SELECT SUM(accrual) FROM orders WHERE login = $1 GROUP BY login), 0) -
-						COALESCE((SELECT SUM(sum) FROM withdraw WHERE login = $1 GROUP BY login), 0),
-						COALESCE((SELECT SUM(sum) FROM withdraw WHERE login = $1 GROUP BY login), 0)
+	dbRegistration  = `INSERT INTO users (login, password) VALUES ($1, $2) ON CONFLICT(login) DO NOTHING`
+	dbAuthorization = `SELECT password, status FROM users WHERE login = $1`
+	dbRehash        = `UPDATE users SET password = $1 WHERE login = $2 AND password = $3`
+	dbSetPassword   = `UPDATE users SET password = $1 WHERE login = $2`
+	dbProvision     = `INSERT INTO users (login, password) VALUES ($1, '') ON CONFLICT(login) DO NOTHING`
+	dbSetTOTP       = `UPDATE users SET totp_secret = $1, totp_enabled = false WHERE login = $2 AND NOT totp_enabled`
+	dbEnableTOTP    = `UPDATE users SET totp_enabled = true WHERE login = $1 AND totp_secret IS NOT NULL`
+	dbGetTOTP       = `SELECT COALESCE(totp_secret, ''), totp_enabled FROM users WHERE login = $1`
+	dbGetUserID     = `SELECT userid FROM users WHERE login = $1`
+	dbRenameUser    = `UPDATE users SET login = $2 WHERE userid = $1`
+	dbGetPrefs      = `SELECT locale, currency FROM users WHERE login = $1`
+	dbSetPrefs      = `UPDATE users SET locale = $1, currency = $2 WHERE login = $3`
+	dbGetBalance    = `SELECT login,
+						COALESCE((SELECT SUM(amount) FROM ledger WHERE userid = users.userid), 0) -
+						COALESCE((SELECT SUM(sum) FROM withdraw WHERE userid = users.userid), 0),
+						COALESCE((SELECT SUM(sum) FROM withdraw WHERE userid = users.userid), 0)
 						FROM users WHERE login = $1`
+
+	// Удаление пользователя:
+	dbLockActive   = `SELECT userid FROM users WHERE login = $1 AND deleted_at IS NULL FOR UPDATE`
+	dbDellUserData = `WITH v AS (DELETE FROM email_verifications WHERE userid = $1),
+						p AS (DELETE FROM notification_preferences WHERE userid = $1),
+						b AS (DELETE FROM balance_snapshots WHERE userid = $1),
+						q AS (DELETE FROM order_quota WHERE owner = 'user:' || $1::integer)
+						DELETE FROM sessions WHERE userid = $1`
+	// журнал аудита ведется по логину и переходит к новому логину при удалении и смене логина
+	dbRelabelAudit = `UPDATE audit_log SET login = $2 WHERE login = $1`
+	dbDeleteUser   = `UPDATE users SET login = $2, password = '', email = NULL, totp_secret = NULL, totp_enabled = false,
+						status = 'deleted', sessions_revoked_at = now(), deleted_at = now() WHERE userid = $1`
+
+	// Окончательное удаление после USER_RETENTION, строки учета - до пользователя (ON DELETE RESTRICT):
+	dbPurgeLedger   = `DELETE FROM ledger WHERE userid IN (SELECT userid FROM users WHERE deleted_at <= $1)`
+	dbPurgeWithdraw = `DELETE FROM withdraw WHERE userid IN (SELECT userid FROM users WHERE deleted_at <= $1)`
+	dbPurgeHolds    = `DELETE FROM withdraw_holds WHERE userid IN (SELECT userid FROM users WHERE deleted_at <= $1)`
+	dbPurgeOrders   = `DELETE FROM orders WHERE userid IN (SELECT userid FROM users WHERE deleted_at <= $1)`
+	dbPurgeArchive  = `DELETE FROM orders_archive WHERE userid IN (SELECT userid FROM users WHERE deleted_at <= $1)`
+	dbPurgeUsers    = `DELETE FROM users WHERE deleted_at <= $1`
 )
 
-func (db *DataBase) Register(login, pass, cookie string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+func (db *DataBase) Register(login, pass, cookie, newCookie string) error {
+	hash, err := db.passwords.Hash(pass)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := db.context("Register")
 	defer cancel()
 
-	if _, err := db.DB.ExecContext(ctx, dbDellCookie, cookie); err != nil {
+	exec, err := db.pool().Exec(ctx, dbRegistration, login, hash)
+	if err != nil {
+		return err
+	}
+
+	if exec.RowsAffected() == 0 {
+		return ErrRegisterConflict
+	}
+
+	return db.upgradeSession(cookie, newCookie, login)
+}
+
+func (db *DataBase) Login(login, pass, cookie, newCookie string) error {
+	if err := db.CheckPassword(login, pass); err != nil {
 		return err
 	}
 
-	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	return db.upgradeSession(cookie, newCookie, login)
+}
+
+// ImportUser создает пользователя с паролем pass и начальным остатком balance из прежней системы
+// лояльности. Существующий пользователь не меняется и возвращается false, поэтому повторный импорт
+// того же файла не зачисляет остатки второй раз.
+func (db *DataBase) ImportUser(login, pass string, balance float64) (bool, error) {
+	hash, err := db.passwords.Hash(pass)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := db.context("ImportUser")
 	defer cancel()
 
-	exec, err := db.DB.ExecContext(ctx, dbRegistration, login, pass, cookie)
+	var created bool
+	err = db.WithTx(ctx, func(tx pgx.Tx) error {
+		exec, err := tx.Exec(ctx, dbRegistration, login, hash)
+		if err != nil {
+			return err
+		}
+
+		if created = exec.RowsAffected() > 0; !created || balance == 0 {
+			return nil
+		}
+
+		_, err = tx.Exec(ctx, dbAddLedger, login, balance, LedgerOpening, nil)
+		return err
+	})
 	if err != nil {
+		return false, err
+	}
+
+	if created {
+		log.Printf("import user: login: %s, balance: %g", login, balance)
+	}
+
+	return created, nil
+}
+
+// OpenSession заменяет сессию cookie сессией newCookie пользователя, пароль которого уже проверен
+// внешним бэкендом аутентификации. Учетная запись создается при первом входе, без локального пароля.
+func (db *DataBase) OpenSession(login, cookie, newCookie string) error {
+	ctx, cancel := db.context("OpenSession")
+	defer cancel()
+
+	if _, err := db.pool().Exec(ctx, dbProvision, login); err != nil {
 		return err
 	}
 
-	affected, err := exec.RowsAffected()
+	return db.upgradeSession(cookie, newCookie, login)
+}
+
+// CheckPassword проверяет пару логин/пароль, не открывая сессию
+func (db *DataBase) CheckPassword(login, pass string) error {
+	ctx, cancel := db.context("CheckPassword")
+	defer cancel()
+
+	var hash, status string
+	err := db.pool().QueryRow(ctx, dbAuthorization, login).Scan(&hash, &status)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	// неизвестный логин и учетная запись без локального пароля (созданная внешним бэкендом)
+	// проверяются по фиктивному хешу, чтобы ответ занимал столько же времени, что и неверный пароль
+	known := err == nil && hash != ""
+	if !known {
+		hash = db.dummyHash
+	}
+
+	ok, rehash, err := db.passwords.Verify(pass, hash)
 	if err != nil {
 		return err
 	}
 
-	if affected == 0 {
-		return ErrRegisterConflict
+	if !ok || !known {
+		return ErrWrongData
+	}
+
+	if rehash {
+		db.rehash(login, pass, hash)
+	}
+
+	if status == UserPending {
+		return ErrNotVerified
 	}
 
 	return nil
 }
 
-func (db *DataBase) Login(login, pass, cookie string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+// ChangePassword заменяет пароль пользователя. Сессии не завершаются, для этого - RevokeAllSessions.
+func (db *DataBase) ChangePassword(login, pass string) error {
+	hash, err := db.passwords.Hash(pass)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := db.context("ChangePassword")
 	defer cancel()
 
-	var cookieDB string
-	if err := db.DB.QueryRowContext(ctx, dbAuthorization, login, pass).Scan(&cookieDB); err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			return err
-		}
+	exec, err := db.pool().Exec(ctx, dbSetPassword, hash, login)
+	if err != nil {
+		return err
+	}
 
-		return ErrWrongData
+	if exec.RowsAffected() == 0 {
+		return ErrNotFound
 	}
 
-	if cookieDB != cookie {
-		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
+	return nil
+}
 
-		if _, err := db.DB.ExecContext(ctx, dbDellCookie, cookie); err != nil {
-			return err
-		}
+// rehash пересчитывает хеш пароля с текущими параметрами KDF. Ошибка не мешает входу:
+// хеш будет пересчитан при следующем входе.
+func (db *DataBase) rehash(login, pass, old string) {
+	hash, err := db.passwords.Hash(pass)
+	if err != nil {
+		log.Printf("rehash password: login: %s, err: %s", login, err.Error())
+		return
+	}
 
-		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
+	ctx, cancel := db.context("rehash")
+	defer cancel()
 
-		if _, err := db.DB.ExecContext(ctx, dbSetCookie, cookie, login, pass); err != nil {
-			return err
-		}
+	if _, err = db.pool().Exec(ctx, dbRehash, hash, login, old); err != nil {
+		log.Printf("rehash password: login: %s, err: %s", login, err.Error())
+	}
+}
+
+// SetTOTPSecret сохраняет секрет двухфакторной аутентификации до его подтверждения кодом.
+// Для пользователя с уже включенной 2FA возвращает ErrDuplicate.
+func (db *DataBase) SetTOTPSecret(login, secret string) error {
+	ctx, cancel := db.context("SetTOTPSecret")
+	defer cancel()
+
+	exec, err := db.pool().Exec(ctx, dbSetTOTP, secret, login)
+	if err != nil {
+		return err
+	}
+
+	if exec.RowsAffected() == 0 {
+		return ErrDuplicate
 	}
 
 	return nil
 }
 
-func (db *DataBase) Authentication(cookie string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+func (db *DataBase) EnableTOTP(login string) error {
+	ctx, cancel := db.context("EnableTOTP")
 	defer cancel()
 
-	var login string
-	if err := db.DB.QueryRowContext(ctx, dbGetLogin, cookie).Scan(&login); err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			return "", err
+	if _, err := db.pool().Exec(ctx, dbEnableTOTP, login); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetTOTP возвращает секрет 2FA пользователя и признак того, что 2FA включена
+func (db *DataBase) GetTOTP(login string) (string, bool, error) {
+	ctx, cancel := db.context("GetTOTP")
+	defer cancel()
+
+	var secret string
+	var enabled bool
+	if err := db.pool().QueryRow(ctx, dbGetTOTP, login).Scan(&secret, &enabled); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return "", false, err
 		}
 
-		return "", nil
+		return "", false, nil
 	}
 
-	return login, nil
+	return secret, enabled, nil
+}
+
+// GetPreferences возвращает настройки отображения сумм пользователя
+func (db *DataBase) GetPreferences(login string) (format.Preferences, error) {
+	ctx, cancel := db.context("GetPreferences")
+	defer cancel()
+
+	var prefs format.Preferences
+	if err := db.pool().QueryRow(ctx, dbGetPrefs, login).Scan(&prefs.Locale, &prefs.Currency); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return format.Preferences{}, err
+		}
+
+		return format.Default, nil
+	}
+
+	return prefs, nil
+}
+
+func (db *DataBase) SetPreferences(login string, prefs format.Preferences) error {
+	ctx, cancel := db.context("SetPreferences")
+	defer cancel()
+
+	if _, err := db.pool().Exec(ctx, dbSetPrefs, prefs.Locale, prefs.Currency, login); err != nil {
+		return err
+	}
+
+	return nil
 }
 
 func (db *DataBase) GetBalance(login string) (User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := db.context("GetBalance")
 	defer cancel()
 
 	var balance User
-	if err := db.DB.QueryRowContext(ctx, dbGetBalance, login).Scan(&balance.Login, &balance.Current, &balance.WithDraw); err != nil {
+	if err := db.pool().QueryRow(ctx, dbGetBalance, login).Scan(&balance.Login, &balance.Current, &balance.WithDraw); err != nil {
 		return User{}, err
 	}
 
 	return balance, nil
 }
+
+// DeleteUser удаляет учетную запись по просьбе пользователя: завершает сессии, отзывает JWT, удаляет
+// настройки и производные данные, стирает пароль и адрес и заменяет логин обезличенным в users
+// и журнале аудита. Заказы, списания и журнал начислений остаются для учета до PurgeDeletedUsers.
+// Неизвестный или уже удаленный пользователь - ErrNotFound.
+func (db *DataBase) DeleteUser(login string) error {
+	ctx, cancel := db.context("DeleteUser")
+	defer cancel()
+
+	var anonymous string
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		var userid int64
+		if err := tx.QueryRow(ctx, dbLockActive, login).Scan(&userid); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+
+			return err
+		}
+
+		var err error
+		anonymous, err = eraseUser(ctx, tx, userid, login)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("delete user: %s", anonymous)
+
+	return nil
+}
+
+// eraseUser удаляет сессии и производные данные пользователя и обезличивает его логин в транзакции tx.
+// Возвращает обезличенный логин.
+func eraseUser(ctx context.Context, tx pgx.Tx, userid int64, login string) (string, error) {
+	anonymous := DeletedLogin(userid)
+	if _, err := tx.Exec(ctx, dbDellUserData, userid); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec(ctx, dbRelabelAudit, login, anonymous); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec(ctx, dbDeleteUser, userid, anonymous); err != nil {
+		return "", err
+	}
+
+	return anonymous, nil
+}
+
+// GetUserID возвращает постоянный идентификатор пользователя: в отличие от логина он не меняется.
+// Неизвестный пользователь - ErrNotFound.
+func (db *DataBase) GetUserID(login string) (int64, error) {
+	ctx, cancel := db.context("GetUserID")
+	defer cancel()
+
+	var userid int64
+	if err := db.pool().QueryRow(ctx, dbGetUserID, login).Scan(&userid); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+
+		return 0, err
+	}
+
+	return userid, nil
+}
+
+// RenameUser меняет логин пользователя. Заказы, списания, сессии и настройки ссылаются на userid
+// и остаются за ним, журнал аудита переходит к новому логину. Занятый логин - ErrRegisterConflict,
+// неизвестный или удаленный пользователь - ErrNotFound.
+func (db *DataBase) RenameUser(login, newLogin string) error {
+	ctx, cancel := db.context("RenameUser")
+	defer cancel()
+
+	return db.WithTx(ctx, func(tx pgx.Tx) error {
+		var userid int64
+		if err := tx.QueryRow(ctx, dbLockActive, login).Scan(&userid); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, dbRenameUser, userid, newLogin); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+				return ErrRegisterConflict
+			}
+
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, dbRelabelAudit, login, newLogin); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx, dbAddAudit, login, newLogin, AuditRename, "from "+login)
+		return err
+	})
+}
+
+// PurgeDeletedUsers окончательно удаляет учетные записи, удаленные до before, вместе с их заказами,
+// списаниями и журналом начислений. Журнал аудита остается. Возвращает число удаленных записей.
+func (db *DataBase) PurgeDeletedUsers(before time.Time) (int64, error) {
+	ctx, cancel := db.context("PurgeDeletedUsers")
+	defer cancel()
+
+	var purged int64
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		for _, sql := range []string{dbPurgeLedger, dbPurgeWithdraw, dbPurgeHolds, dbPurgeOrders, dbPurgeArchive} {
+			if _, err := tx.Exec(ctx, sql, before); err != nil {
+				return err
+			}
+		}
+
+		exec, err := tx.Exec(ctx, dbPurgeUsers, before)
+		if err != nil {
+			return err
+		}
+
+		purged = exec.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"log"
 	"testing"
 	"time"
@@ -23,7 +24,7 @@ func TestUsers(t *testing.T) {
 	}
 
 	defer func() {
-		_ = db.DB.Close()
+		db.Close()
 		log.Print("db closed")
 	}()
 
@@ -33,10 +34,18 @@ func TestUsers(t *testing.T) {
 
 	authentication(t, db)
 
+	logout(t, db)
+
+	renameUser(t, db)
+
+	deleteUser(t, db)
+
+	inactiveUsers(t, db)
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	_, err = db.DB.ExecContext(ctx, `DROP TABLE users, orders, withdraw;`)
+	_, err = db.pool().Exec(ctx, `DROP TABLE users, orders, withdraw, withdraw_holds, sessions, ledger, email_verifications, accrual_quarantine, order_quota, notification_preferences, balance_snapshots, audit_log, settings, orders_archive, schema_migrations;`)
 	if err != nil {
 		log.Print(err)
 		return
@@ -100,7 +109,7 @@ func register(t *testing.T, db *DataBase) {
 
 	for _, tt := range tests {
 		t.Run("Register: "+tt.name, func(t *testing.T) {
-			if err := db.Register(tt.args.login, tt.args.pass, tt.args.cookie); (err != nil) != tt.wantErr {
+			if err := db.Register(tt.args.login, tt.args.pass, "", tt.args.cookie); (err != nil) != tt.wantErr {
 				t.Errorf("Register() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -160,14 +169,38 @@ func login(t *testing.T, db *DataBase) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Несуществующий пользователь",
+			args: user{
+				login:  "username404",
+				pass:   "password",
+				cookie: "5",
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run("Login: "+tt.name, func(t *testing.T) {
-			if err := db.Login(tt.args.login, tt.args.pass, tt.args.cookie); (err != nil) != tt.wantErr {
+			err := db.Login(tt.args.login, tt.args.pass, tt.args.cookie, "login"+tt.args.cookie)
+			if (err != nil) != tt.wantErr {
 				t.Errorf("Login() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			// неизвестный логин и неверный пароль неразличимы
+			if tt.wantErr && !errors.Is(err, ErrWrongData) {
+				t.Errorf("Login() error = %v, want %v", err, ErrWrongData)
+			}
 		})
 	}
+
+	t.Run("Login: Пользователь без локального пароля", func(t *testing.T) {
+		if err := db.OpenSession("external", "", "6"); err != nil {
+			t.Errorf("OpenSession() error = %v", err)
+			return
+		}
+		if err := db.CheckPassword("external", ""); !errors.Is(err, ErrWrongData) {
+			t.Errorf("CheckPassword() error = %v, want %v", err, ErrWrongData)
+		}
+	})
 }
 
 func authentication(t *testing.T, db *DataBase) {
@@ -181,28 +214,35 @@ func authentication(t *testing.T, db *DataBase) {
 	}{
 		{
 			name:    "Пользователь 1",
-			cookie:  "9",
+			cookie:  "login9",
 			want:    "username1",
 			wantErr: false,
 		},
 		{
 			name:    "Пользователь 2",
 			cookie:  "0",
-			want:    "",
+			want:    "username2",
 			wantErr: false,
 		},
 		{
 			name:    "Пользователь 3",
-			cookie:  "1",
+			cookie:  "login1",
 			want:    "username3",
 			wantErr: false,
 		},
 		{
 			name:    "Пользователь 5",
-			cookie:  "4",
+			cookie:  "login4",
 			want:    "username5",
 			wantErr: false,
 		},
+		{
+			// вход выдает новый идентификатор, прежний больше не действует
+			name:    "Сессия до входа",
+			cookie:  "1",
+			want:    "",
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run("Authentication: "+tt.name, func(t *testing.T) {
@@ -217,3 +257,175 @@ func authentication(t *testing.T, db *DataBase) {
 		})
 	}
 }
+
+func logout(t *testing.T, db *DataBase) {
+	log.Print("тест выхода")
+
+	t.Run("Logout: Пользователь 1", func(t *testing.T) {
+		if err := db.Login("username1", "password", "", "10"); err != nil {
+			t.Errorf("Login() error = %v, wantErr %v", err, false)
+			return
+		}
+
+		if err := db.Logout("login9"); err != nil {
+			t.Errorf("Logout() error = %v, wantErr %v", err, false)
+			return
+		}
+
+		for cookie, want := range map[string]string{"login9": "", "10": "username1"} {
+			got, err := db.Authentication(cookie)
+			if err != nil {
+				t.Errorf("Authentication() error = %v, wantErr %v", err, false)
+				return
+			}
+			if got != want {
+				t.Errorf("Authentication(%s) got = %v, want %v", cookie, got, want)
+			}
+		}
+	})
+	t.Run("Logout: все сессии пользователя 1", func(t *testing.T) {
+		if err := db.RevokeAllSessions("username1"); err != nil {
+			t.Errorf("RevokeAllSessions() error = %v, wantErr %v", err, false)
+			return
+		}
+
+		got, err := db.Authentication("10")
+		if err != nil || got != "" {
+			t.Errorf("Authentication() got = %v, %v, want empty login", got, err)
+		}
+
+		revokedAt, err := db.SessionsRevokedAt("username1")
+		if err != nil || revokedAt.IsZero() {
+			t.Errorf("SessionsRevokedAt() got = %v, %v, want revocation time", revokedAt, err)
+		}
+
+		if err = db.RevokeAllSessions("username404"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("RevokeAllSessions() error = %v, want %v", err, ErrNotFound)
+		}
+	})
+}
+
+func renameUser(t *testing.T, db *DataBase) {
+	log.Print("тест смены логина")
+
+	t.Run("RenameUser: Пользователь 3", func(t *testing.T) {
+		if err := db.Login("username3", "password", "", "30"); err != nil {
+			t.Errorf("Login() error = %v, wantErr %v", err, false)
+			return
+		}
+
+		id, err := db.GetUserID("username3")
+		if err != nil {
+			t.Errorf("GetUserID() error = %v", err)
+			return
+		}
+
+		if err = db.RenameUser("username3", "username1"); !errors.Is(err, ErrRegisterConflict) {
+			t.Errorf("RenameUser() taken login error = %v, want %v", err, ErrRegisterConflict)
+		}
+
+		if err = db.RenameUser("username3", "username3r"); err != nil {
+			t.Errorf("RenameUser() error = %v, wantErr %v", err, false)
+			return
+		}
+
+		// сессия и идентификатор остаются за пользователем
+		if got, err := db.Authentication("30"); err != nil || got != "username3r" {
+			t.Errorf("Authentication() got = %v, %v, want username3r", got, err)
+		}
+
+		if got, err := db.GetUserID("username3r"); err != nil || got != id {
+			t.Errorf("GetUserID() got = %d, %v, want %d", got, err, id)
+		}
+
+		if err = db.RenameUser("username3", "username3x"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("RenameUser() old login error = %v, want %v", err, ErrNotFound)
+		}
+	})
+}
+
+func deleteUser(t *testing.T, db *DataBase) {
+	log.Print("тест удаления пользователя")
+
+	t.Run("DeleteUser: Пользователь 2", func(t *testing.T) {
+		if err := db.Login("username2", "password", "", "20"); err != nil {
+			t.Errorf("Login() error = %v, wantErr %v", err, false)
+			return
+		}
+
+		if err := db.DeleteUser("username2"); err != nil {
+			t.Errorf("DeleteUser() error = %v, wantErr %v", err, false)
+			return
+		}
+
+		got, err := db.Authentication("20")
+		if err != nil || got != "" {
+			t.Errorf("Authentication() got = %v, %v, want empty login", got, err)
+		}
+
+		if _, err = db.SessionsRevokedAt("username2"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("SessionsRevokedAt() error = %v, want %v", err, ErrNotFound)
+		}
+
+		if err = db.DeleteUser("username2"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteUser() again error = %v, want %v", err, ErrNotFound)
+		}
+
+		// логин удаленного пользователя свободен
+		if err = db.Register("username2", "password", "", "21"); err != nil {
+			t.Errorf("Register() error = %v, wantErr %v", err, false)
+		}
+	})
+	t.Run("PurgeDeletedUsers", func(t *testing.T) {
+		if purged, err := db.PurgeDeletedUsers(time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+			t.Errorf("PurgeDeletedUsers() before retention = %d, %v, want 0", purged, err)
+		}
+
+		if purged, err := db.PurgeDeletedUsers(time.Now().Add(time.Minute)); err != nil || purged != 1 {
+			t.Errorf("PurgeDeletedUsers() = %d, %v, want 1", purged, err)
+		}
+	})
+}
+
+func inactiveUsers(t *testing.T, db *DataBase) {
+	log.Print("тест очистки неактивных учетных записей")
+
+	if err := db.Register("idle", "password", "", "30"); err != nil {
+		t.Errorf("Register() error = %v, wantErr %v", err, false)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := db.pool().Exec(ctx, `UPDATE users SET last_active_at = now() - interval '1 year' WHERE login = 'idle'`); err != nil {
+		t.Errorf("set last_active_at error = %v", err)
+		return
+	}
+
+	if err := db.SetInactivityExempt("idle", true, "admin"); err != nil {
+		t.Errorf("SetInactivityExempt() error = %v, wantErr %v", err, false)
+	}
+	if flagged, err := db.FlagInactiveUsers(time.Now().AddDate(0, -6, 0)); err != nil || len(flagged) != 0 {
+		t.Errorf("FlagInactiveUsers() exempt = %v, %v, want none", flagged, err)
+	}
+
+	if err := db.SetInactivityExempt("idle", false, "admin"); err != nil {
+		t.Errorf("SetInactivityExempt() error = %v, wantErr %v", err, false)
+	}
+	flagged, err := db.FlagInactiveUsers(time.Now().AddDate(0, -6, 0))
+	if err != nil || len(flagged) != 1 || flagged[0] != "idle" {
+		t.Errorf("FlagInactiveUsers() = %v, %v, want [idle]", flagged, err)
+	}
+
+	id, err := db.GetUserID("idle")
+	if err != nil {
+		t.Errorf("GetUserID() error = %v", err)
+		return
+	}
+
+	anonymized, err := db.AnonymizeInactiveUsers(time.Now().Add(time.Minute))
+	if err != nil || len(anonymized) != 1 || anonymized[0] != DeletedLogin(id) {
+		t.Errorf("AnonymizeInactiveUsers() = %v, %v, want [%s]", anonymized, err, DeletedLogin(id))
+	}
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// Таблица подтверждений адресов email_verifications:
+	dbRegisterPending = `INSERT INTO users (login, password, email, status) VALUES ($1, $2, $3, 'pending') 
+							ON CONFLICT(login) DO NOTHING`
+	dbNewVerification = `INSERT INTO email_verifications (token, userid, expires_at)
+							SELECT $1, userid, $3 FROM users WHERE login = $2`
+	dbUseVerification = `DELETE FROM email_verifications WHERE token = $1 AND expires_at > now() RETURNING userid`
+	dbActivateUser    = `UPDATE users SET status = 'active' WHERE userid = $1 RETURNING login`
+)
+
+// RegisterPending создает пользователя, ожидающего подтверждения адреса, и токен подтверждения.
+// Сессия не открывается до подтверждения.
+func (db *DataBase) RegisterPending(login, pass, email, token string, ttl time.Duration) error {
+	hash, err := db.passwords.Hash(pass)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := db.context("RegisterPending")
+	defer cancel()
+
+	return db.WithTx(ctx, func(tx pgx.Tx) error {
+		exec, err := tx.Exec(ctx, dbRegisterPending, login, hash, email)
+		if err != nil {
+			return err
+		}
+
+		if exec.RowsAffected() == 0 {
+			return ErrRegisterConflict
+		}
+
+		_, err = tx.Exec(ctx, dbNewVerification, token, login, time.Now().Add(ttl))
+		return err
+	})
+}
+
+// Verify активирует пользователя по токену подтверждения и возвращает его логин.
+// Неизвестный, использованный или просроченный токен - ErrNotFound.
+func (db *DataBase) Verify(token string) (string, error) {
+	ctx, cancel := db.context("Verify")
+	defer cancel()
+
+	var login string
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		var userid int64
+		if err := tx.QueryRow(ctx, dbUseVerification, token).Scan(&userid); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+
+			return err
+		}
+
+		return tx.QueryRow(ctx, dbActivateUser, userid).Scan(&login)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return login, nil
+}
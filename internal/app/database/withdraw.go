@@ -2,76 +2,95 @@ package database
 
 import (
 	"context"
-	"database/sql"
-	"errors"
-	"strings"
+	"encoding/json"
 	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
 type WithDraw struct {
-	OrderID     string  `json:"order"`
-	Login       string  `json:"login,omitempty"`
-	Sum         float64 `json:"sum"`
-	ProcessedAt string  `json:"processed_at"`
+	// Seq - порядковый номер списания, выдает база: по нему упорядочен список списаний и идет постраничная выдача
+	Seq         int64     `json:"seq,omitempty"`
+	OrderID     string    `json:"order"`
+	Login       string    `json:"login,omitempty"`
+	Sum         float64   `json:"sum"`
+	ProcessedAt time.Time `json:"-"`
+	Reference   string    `json:"reference,omitempty"`
+}
+
+// MarshalJSON отдает processed_at в RFC3339
+func (w WithDraw) MarshalJSON() ([]byte, error) {
+	type withDraw WithDraw
+
+	return json.Marshal(struct {
+		withDraw
+		ProcessedAt string `json:"processed_at"`
+	}{withDraw: withDraw(w), ProcessedAt: jsonTime(w.ProcessedAt)})
 }
 
 var (
 	// Таблица операций withdraw:
-	dbGetWithDraw = `SELECT orderID, sum, processed_at FROM withdraw WHERE login = $1`
-	dbAddWithDraw = `INSERT INTO withdraw SELECT $1, $2, $3, $4
-						WHERE NOT COALESCE((SELECT SUM(accrual) FROM orders WHERE login = $5 GROUP BY login), 0) -
-						COALESCE((SELECT SUM(sum) FROM withdraw WHERE login = $5 GROUP BY login), 0) - $3 < 0`
+	// $4 - seq последнего списания предыдущей страницы, 0 - с начала списка
+	dbGetWithDraw = `SELECT seq, orderID, sum, processed_at, COALESCE(reference, '') FROM withdraw
+						WHERE userid = (SELECT userid FROM users WHERE login = $1) AND seq > $4 ORDER BY seq
+						LIMIT NULLIF($2, 0) OFFSET $3`
+	dbAddWithDraw = `INSERT INTO withdraw (orderID, userid, sum, processed_at, reference)
+						SELECT $1, userid, $3, $4, NULLIF($5, '') FROM users
+						WHERE login = $2 AND NOT COALESCE((SELECT SUM(amount) FROM ledger WHERE userid = users.userid), 0) -
+						COALESCE((SELECT SUM(sum) FROM withdraw WHERE userid = users.userid), 0) - $3 < 0`
+	dbCountWithDraw = `SELECT COUNT(*) FROM withdraw WHERE userid = (SELECT userid FROM users WHERE login = $1) AND processed_at >= $2`
+	dbHoldWithDraw  = `INSERT INTO withdraw_holds (orderID, userid, sum, reason, reference)
+						VALUES ($1, (SELECT userid FROM users WHERE login = $2), $3, $4, $5)`
+	dbLockUser = `SELECT 1 FROM users WHERE login = $1 FOR UPDATE`
 )
 
-func (db *DataBase) AddWithDraw(login, order string, sum float64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+func (db *DataBase) AddWithDraw(login, order string, sum float64, reference string) error {
+	ctx, cancel := db.context("AddWithDraw")
 	defer cancel()
 
-	exec, err := db.DB.ExecContext(ctx, dbAddWithDraw, order, login, sum, time.Now().Format(time.RFC3339), login)
+	return db.WithTx(ctx, func(tx pgx.Tx) error {
+		return addWithDrawTx(ctx, tx, login, order, sum, reference)
+	})
+}
+
+// addWithDrawTx списывает sum, если ее покрывает баланс. Строка пользователя блокируется до конца
+// транзакции: без блокировки параллельные списания проверяли бы один и тот же баланс и уводили его в минус.
+func addWithDrawTx(ctx context.Context, tx pgx.Tx, login, order string, sum float64, reference string) error {
+	if _, err := tx.Exec(ctx, dbLockUser, login); err != nil {
+		return err
+	}
+
+	exec, err := tx.Exec(ctx, dbAddWithDraw, order, login, sum, time.Now(), reference)
 	if err != nil {
-		if !strings.Contains(err.Error(), "duplicate key value violates unique constraint \"withdraw_pkey\"") {
+		if !uniqueViolation(err, "withdraw_pkey") {
 			return err
 		}
 
 		return ErrBadOrderNumber
 	}
 
-	affected, err := exec.RowsAffected()
-	if err != nil {
-		return err
-	}
-
-	if affected == 0 {
+	if exec.RowsAffected() == 0 {
 		return ErrNoMoney
 	}
 
 	return nil
 }
 
-func (db *DataBase) GetWithDraw(login string) ([]WithDraw, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+// GetWithDraw возвращает списания пользователя по порядку Seq: limit списаний после списания с Seq after,
+// пропустив первые offset. Нулевой limit - все списания, нулевой after - с начала списка.
+func (db *DataBase) GetWithDraw(login string, limit, offset int, after int64) ([]WithDraw, error) {
+	ctx, cancel := db.context("GetWithDraw")
 	defer cancel()
 
-	rows, err := db.DB.QueryContext(ctx, dbGetWithDraw, login)
+	rows, err := db.pool().Query(ctx, dbGetWithDraw, login, limit, offset, after)
 	if err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			return nil, err
-		}
-	}
-
-	var withdraw []WithDraw
-	for rows.Next() {
-		var order WithDraw
-		if err = rows.Scan(&order.OrderID, &order.Sum, &order.ProcessedAt); err != nil {
-			if !errors.Is(err, sql.ErrNoRows) {
-				return nil, err
-			}
-		}
-
-		withdraw = append(withdraw, order)
+		return nil, err
 	}
 
-	if err = rows.Err(); err != nil {
+	withdraw, err := scanRows(rows, func(row pgx.Row, w *WithDraw) error {
+		return row.Scan(&w.Seq, &w.OrderID, &w.Sum, &w.ProcessedAt, &w.Reference)
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -81,3 +100,28 @@ func (db *DataBase) GetWithDraw(login string) ([]WithDraw, error) {
 
 	return withdraw, nil
 }
+
+// CountWithDraw возвращает количество списаний пользователя начиная с since
+func (db *DataBase) CountWithDraw(login string, since time.Time) (int, error) {
+	ctx, cancel := db.context("CountWithDraw")
+	defer cancel()
+
+	var count int
+	if err := db.pool().QueryRow(ctx, dbCountWithDraw, login, since).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// HoldWithDraw откладывает подозрительное списание в очередь ручной проверки
+func (db *DataBase) HoldWithDraw(login, order string, sum float64, reason, reference string) error {
+	ctx, cancel := db.context("HoldWithDraw")
+	defer cancel()
+
+	if _, err := db.pool().Exec(ctx, dbHoldWithDraw, order, login, sum, reason, reference); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -2,12 +2,17 @@ package database
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 func TestWithDraw(t *testing.T) {
@@ -18,12 +23,12 @@ func TestWithDraw(t *testing.T) {
 	}
 
 	defer func() {
-		_ = db.DB.Close()
+		db.Close()
 		log.Print("db closed")
 	}()
 
 	t.Run("Регистрация", func(t *testing.T) {
-		if err := db.Register("username", "password", "0124"); (err != nil) != false {
+		if err := db.Register("username", "password", "", "0124"); (err != nil) != false {
 			t.Errorf("Register() error = %v, wantErr %v", err, false)
 		}
 	})
@@ -35,7 +40,7 @@ func TestWithDraw(t *testing.T) {
 	})
 
 	t.Run("Подтверждение пополнения", func(t *testing.T) {
-		if err := db.UpdateOrder("49927398716", "PROCESSED", 500); (err != nil) != false {
+		if err := db.UpdateOrder("49927398716", "PROCESSED", 500, 0); (err != nil) != false {
 			t.Errorf("UpdateOrder() error = %v, wantErr %v", err, false)
 		}
 	})
@@ -56,10 +61,42 @@ func TestWithDraw(t *testing.T) {
 		}
 	})
 
+	// 339 баллов покрывают 6 списаний по 50: остальные параллельные списания должны получить ErrNoMoney
+	t.Run("Параллельные списания", func(t *testing.T) {
+		var wg sync.WaitGroup
+		errs := make([]error, 10)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = db.AddWithDraw("username", fmt.Sprintf("concurrent-%d", i), 50, "")
+			}(i)
+		}
+		wg.Wait()
+
+		var ok int
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				ok++
+			case !errors.Is(err, ErrNoMoney):
+				t.Errorf("AddWithDraw() error = %v", err)
+			}
+		}
+		if ok != 6 {
+			t.Errorf("AddWithDraw() succeeded %d times, want 6", ok)
+		}
+
+		got, err := db.GetBalance("username")
+		if err != nil || got.Current < 0 {
+			t.Errorf("GetBalance() got = %v, err = %v, want non-negative balance", got, err)
+		}
+	})
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	_, err = db.DB.ExecContext(ctx, `DROP TABLE users, orders, withdraw;`)
+	_, err = db.pool().Exec(ctx, `DROP TABLE users, orders, withdraw, withdraw_holds, sessions, ledger, email_verifications, accrual_quarantine, order_quota, notification_preferences, balance_snapshots, audit_log, settings, orders_archive, schema_migrations;`)
 	if err != nil {
 		log.Print(err)
 		return
@@ -89,7 +126,7 @@ func addWithDraw(t *testing.T, db *DataBase) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := db.AddWithDraw(tt.args.login, tt.args.order, tt.args.sum); (err != nil) != tt.wantErr {
+			if err := db.AddWithDraw(tt.args.login, tt.args.order, tt.args.sum, "01J9Z3KX4W8Q2V6N0T5R7M1B3C"); (err != nil) != tt.wantErr {
 				t.Errorf("AddWithDraw() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -108,9 +145,8 @@ func getWithDraw(t *testing.T, db *DataBase) {
 			login: "username",
 			want: []WithDraw{
 				{
-					OrderID:     "1735735",
-					Sum:         161,
-					ProcessedAt: time.Now().Format(time.RFC3339),
+					OrderID: "1735735",
+					Sum:     161,
 				},
 			},
 			wantErr: false,
@@ -118,14 +154,46 @@ func getWithDraw(t *testing.T, db *DataBase) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := db.GetWithDraw(tt.login)
+			got, err := db.GetWithDraw(tt.login, 0, 0, 0)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetWithDraw() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			for i := range got {
+				if time.Since(got[i].ProcessedAt) > time.Minute {
+					t.Errorf("GetWithDraw() processed_at = %s, want now", got[i].ProcessedAt)
+				}
+				if got[i].Seq <= 0 {
+					t.Errorf("GetWithDraw() seq = %d, want positive", got[i].Seq)
+				}
+				got[i].ProcessedAt, got[i].Seq = time.Time{}, 0
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("GetWithDraw() got = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestUniqueViolation(t *testing.T) {
+	duplicate := &pgconn.PgError{Code: pgerrcode.UniqueViolation, ConstraintName: "withdraw_pkey"}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "withdraw_pkey", err: duplicate, want: true},
+		{name: "wrapped", err: fmt.Errorf("exec: %w", duplicate), want: true},
+		{name: "other constraint", err: &pgconn.PgError{Code: pgerrcode.UniqueViolation, ConstraintName: "withdraw_reference_idx"}},
+		{name: "other code", err: &pgconn.PgError{Code: pgerrcode.ForeignKeyViolation, ConstraintName: "withdraw_pkey"}},
+		{name: "not pg error", err: errors.New(`duplicate key value violates unique constraint "withdraw_pkey"`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := uniqueViolation(tt.err, "withdraw_pkey"); got != tt.want {
+				t.Errorf("uniqueViolation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
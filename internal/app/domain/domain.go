@@ -0,0 +1,222 @@
+package domain
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/token"
+)
+
+// Правила начисления и списания баллов. Обработчики HTTP и опрос системы расчета обращаются
+// к хранилищу через Service, чтобы проверки не дублировались между ними и слоем базы.
+
+var (
+	ErrBadOrderNumber = errors.New("bad order number")
+	ErrBadSum         = errors.New("bad sum")
+	ErrBadStatus      = errors.New("bad order status")
+	// ErrAccrualTooLarge - начисление больше MaxAccrual, скорее всего ошибка системы расчета
+	ErrAccrualTooLarge = errors.New("accrual too large")
+)
+
+// Статусы заказа в системе расчета
+const (
+	StatusNew        = "NEW"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+	// StatusExpired присваивает сервис заказу, который система расчета не обработала за отведенный
+	// срок: такой заказ больше не опрашивается
+	StatusExpired = "EXPIRED"
+)
+
+// Storage - операции хранилища, на которые опирается Service
+type Storage interface {
+	AddOrder(login string, order int) error
+	// AddOrders сохраняет пачку заказов пользователя, ошибки - по каждому номеру, как у AddOrder
+	AddOrders(login string, numbers []string) (map[string]error, error)
+	// UpdateOrder сохраняет статус и начисление и в той же транзакции зачисляет начисление
+	// обработанного заказа на счет; повторное зачисление по тому же заказу игнорируется. Заказ
+	// изменился после чтения версии revision - database.ErrConflict, revision 0 - без проверки.
+	UpdateOrder(number, status string, accrual float64, revision int64) error
+	// AddWithDraw списывает сумму, только если ее покрывает баланс, иначе - database.ErrNoMoney
+	AddWithDraw(login, order string, sum float64, reference string) error
+	HoldWithDraw(login, order string, sum float64, reason, reference string) error
+}
+
+type Service struct {
+	storage Storage
+
+	// references выдает номера списаний, которые пользователь видит в ответе и в списке списаний
+	references token.Source
+
+	// MaxAccrual - наибольшее начисление за один заказ, 0 - без ограничения
+	MaxAccrual float64
+}
+
+func New(s Storage) *Service {
+	return &Service{storage: s, references: &token.ULID{}}
+}
+
+// NormalizeOrderNumber приводит номер заказа к виду, в котором он проверяется и хранится: без
+// пробельных символов и дефисов. Так "1234-5678 903" и "12345678903" считаются одним заказом.
+func NormalizeOrderNumber(number string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, number)
+}
+
+// ValidOrderNumber проверяет номер заказа алгоритмом Луна
+func ValidOrderNumber(number string) bool {
+	if number == "" {
+		return false
+	}
+
+	odd := len(number) & 1
+	var sum int
+	for i, c := range number {
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if i&1 == odd {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+
+	return sum%10 == 0
+}
+
+// ParseOrderNumber приводит номер заказа из пачки к виду хранения. Номер - целое число: ведущие
+// нули отбрасываются, неразобранный номер возвращается как есть. false - номер неверный.
+func ParseOrderNumber(number string) (string, bool) {
+	n, err := strconv.Atoi(NormalizeOrderNumber(number))
+	if err != nil {
+		return number, false
+	}
+
+	number = strconv.Itoa(n)
+	return number, n > 0 && ValidOrderNumber(number)
+}
+
+// UploadOrder принимает номер заказа пользователя к расчету
+func (s *Service) UploadOrder(login string, order int) error {
+	if order <= 0 || !ValidOrderNumber(strconv.Itoa(order)) {
+		return ErrBadOrderNumber
+	}
+
+	return s.storage.AddOrder(login, order)
+}
+
+// UploadOrders принимает к расчету пачку номеров заказов пользователя. Номера приводятся к виду
+// хранения, повторы в пачке схлопываются, неразобранный номер возвращается как есть. Возвращает
+// номера в порядке первого появления и результат по каждому: ErrBadOrderNumber для неверного
+// номера, иначе ответ хранилища.
+func (s *Service) UploadOrders(login string, numbers []string) ([]string, map[string]error, error) {
+	results := make(map[string]error, len(numbers))
+
+	var order, valid []string
+	for _, number := range numbers {
+		number, ok := ParseOrderNumber(number)
+		if _, seen := results[number]; seen {
+			continue
+		}
+
+		order = append(order, number)
+		if !ok {
+			results[number] = ErrBadOrderNumber
+			continue
+		}
+
+		results[number] = nil
+		valid = append(valid, number)
+	}
+
+	if len(valid) == 0 {
+		return order, results, nil
+	}
+
+	stored, err := s.storage.AddOrders(login, valid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, number := range valid {
+		results[number] = stored[number]
+	}
+
+	return order, results, nil
+}
+
+// CheckWithdrawal проверяет списание до обращения к хранилищу и антифроду
+func CheckWithdrawal(order string, sum float64) error {
+	if !ValidOrderNumber(order) {
+		return ErrBadOrderNumber
+	}
+
+	// отрицательное списание пополнило бы баланс
+	if !(sum > 0) {
+		return ErrBadSum
+	}
+
+	return nil
+}
+
+// Withdraw списывает sum в счет заказа order и возвращает номер списания. Достаточность баланса
+// проверяется хранилищем атомарно со списанием, чтобы параллельные списания не увели баланс в минус.
+func (s *Service) Withdraw(login, order string, sum float64) (string, error) {
+	if err := CheckWithdrawal(order, sum); err != nil {
+		return "", err
+	}
+
+	reference, err := s.references.New()
+	if err != nil {
+		return "", err
+	}
+
+	return reference, s.storage.AddWithDraw(login, order, sum, reference)
+}
+
+// HoldWithdrawal откладывает списание до ручной проверки. Номер списания выдается сразу
+// и сохраняется за списанием после подтверждения.
+func (s *Service) HoldWithdrawal(login, order string, sum float64, reason string) (string, error) {
+	if err := CheckWithdrawal(order, sum); err != nil {
+		return "", err
+	}
+
+	reference, err := s.references.New()
+	if err != nil {
+		return "", err
+	}
+
+	return reference, s.storage.HoldWithDraw(login, order, sum, reason, reference)
+}
+
+// ApplyAccrual сохраняет ответ системы расчета по заказу. Начисление бывает только
+// у обработанного заказа и зачисляется на счет один раз. revision - версия заказа на момент
+// запроса к системе расчета.
+func (s *Service) ApplyAccrual(number, status string, accrual float64, revision int64) error {
+	switch status {
+	case StatusNew, StatusProcessing, StatusInvalid:
+		accrual = 0
+	case StatusProcessed:
+		if accrual < 0 {
+			return ErrBadSum
+		}
+		if s.MaxAccrual > 0 && accrual > s.MaxAccrual {
+			return ErrAccrualTooLarge
+		}
+	default:
+		return ErrBadStatus
+	}
+
+	return s.storage.UpdateOrder(number, status, accrual, revision)
+}
@@ -0,0 +1,192 @@
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+var errUsed = errors.New("used")
+
+type storage struct {
+	orders    map[int]string
+	updates   int
+	accrual   float64
+	withdrawn float64
+}
+
+func (s *storage) AddOrder(login string, order int) error {
+	s.orders[order] = login
+	return nil
+}
+
+// AddOrders сохраняет номера, загруженные другим пользователем, не перезаписывая
+func (s *storage) AddOrders(login string, numbers []string) (map[string]error, error) {
+	results := make(map[string]error, len(numbers))
+	for _, number := range numbers {
+		order, _ := strconv.Atoi(number)
+		if owner, ok := s.orders[order]; ok && owner != login {
+			results[number] = errUsed
+			continue
+		}
+
+		s.orders[order] = login
+	}
+
+	return results, nil
+}
+
+func (s *storage) UpdateOrder(_, _ string, accrual float64, _ int64) error {
+	s.updates++
+	s.accrual = accrual
+	return nil
+}
+
+func (s *storage) AddWithDraw(_, _ string, sum float64, _ string) error {
+	s.withdrawn += sum
+	return nil
+}
+
+func (s *storage) HoldWithDraw(string, string, float64, string, string) error {
+	return nil
+}
+
+func TestNormalizeOrderNumber(t *testing.T) {
+	tests := []struct {
+		number string
+		want   string
+	}{
+		{number: "12345678903", want: "12345678903"},
+		{number: "1234-5678-903", want: "12345678903"},
+		{number: " 1234 5678\t903\n", want: "12345678903"},
+		{number: "1234--5678 - 903", want: "12345678903"},
+		{number: "1234_5678", want: "1234_5678"},
+		{number: " - ", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			if got := NormalizeOrderNumber(tt.number); got != tt.want {
+				t.Errorf("NormalizeOrderNumber() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidOrderNumber(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{number: "1", want: false},
+		{number: "49927398716", want: true},
+		{number: "1234567812345670", want: true},
+		{number: "351243", want: false},
+		{number: "4992739871a", want: false},
+		{number: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			if got := ValidOrderNumber(tt.number); got != tt.want {
+				t.Errorf("ValidOrderNumber() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadOrder(t *testing.T) {
+	s := &storage{orders: map[int]string{}}
+	svc := New(s)
+
+	if err := svc.UploadOrder("username", 351243); !errors.Is(err, ErrBadOrderNumber) {
+		t.Errorf("UploadOrder() bad number err = %v, want %v", err, ErrBadOrderNumber)
+	}
+	if err := svc.UploadOrder("username", 49927398716); err != nil {
+		t.Errorf("UploadOrder() err = %v, want nil", err)
+	}
+	if len(s.orders) != 1 {
+		t.Errorf("stored orders = %v, want only the valid ones", s.orders)
+	}
+}
+
+func TestUploadOrders(t *testing.T) {
+	s := &storage{orders: map[int]string{79927398713: "other"}}
+
+	order, results, err := New(s).UploadOrders("username", []string{"4992-7398 716", "351243", "49927398716", "0079927398713", "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"49927398716", "351243", "79927398713", "abc"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("UploadOrders() order = %v, want %v", order, want)
+	}
+	want := map[string]error{"49927398716": nil, "351243": ErrBadOrderNumber, "79927398713": errUsed, "abc": ErrBadOrderNumber}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("UploadOrders() results = %v, want %v", results, want)
+	}
+	if s.orders[49927398716] != "username" || s.orders[79927398713] != "other" {
+		t.Errorf("stored orders = %v", s.orders)
+	}
+}
+
+func TestWithdraw(t *testing.T) {
+	tests := []struct {
+		name  string
+		order string
+		sum   float64
+		want  error
+	}{
+		{name: "Верное списание", order: "2377225624", sum: 751},
+		{name: "Неверный номер", order: "1735735", sum: 10, want: ErrBadOrderNumber},
+		{name: "Отрицательная сумма", order: "2377225624", sum: -10, want: ErrBadSum},
+		{name: "Нулевая сумма", order: "2377225624", sum: 0, want: ErrBadSum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &storage{}
+			reference, err := New(s).Withdraw("username", tt.order, tt.sum)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Withdraw() err = %v, want %v", err, tt.want)
+			}
+			if (tt.want == nil) != (reference != "") {
+				t.Errorf("Withdraw() reference = %q", reference)
+			}
+			if tt.want != nil && s.withdrawn != 0 {
+				t.Errorf("rejected withdrawal reached storage: %g", s.withdrawn)
+			}
+		})
+	}
+}
+
+func TestApplyAccrual(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		accrual float64
+		want    error
+	}{
+		{name: "Обработан", status: StatusProcessed, accrual: 500},
+		{name: "В обработке", status: StatusProcessing},
+		{name: "Начисление не обработанному", status: StatusInvalid, accrual: 10},
+		{name: "Отрицательное начисление", status: StatusProcessed, accrual: -1, want: ErrBadSum},
+		{name: "Неизвестный статус", status: "REGISTERED", want: ErrBadStatus},
+		{name: "Начисление больше максимума", status: StatusProcessed, accrual: 1e9, want: ErrAccrualTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &storage{}
+			svc := New(s)
+			svc.MaxAccrual = 100000
+			err := svc.ApplyAccrual("49927398716", tt.status, tt.accrual, 1)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("ApplyAccrual() err = %v, want %v", err, tt.want)
+			}
+			if (tt.want == nil) != (s.updates == 1) {
+				t.Errorf("storage updates = %d", s.updates)
+			}
+			if tt.status != StatusProcessed && s.accrual != 0 {
+				t.Errorf("accrual %g stored for status %s", s.accrual, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package format
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrUnsupported = errors.New("unsupported locale or currency")
+
+// Preferences - настройки отображения сумм пользователя. Меняется только
+// представление: суммы не пересчитываются по курсу, 1 балл = 1 единица валюты.
+type Preferences struct {
+	Locale   string `json:"locale"`
+	Currency string `json:"currency"`
+}
+
+var Default = Preferences{Locale: "ru-RU", Currency: "RUB"}
+
+type locale struct {
+	decimal     string
+	group       string
+	symbolFirst bool
+}
+
+var locales = map[string]locale{
+	"ru-RU": {decimal: ",", group: " ", symbolFirst: false},
+	"en-US": {decimal: ".", group: ",", symbolFirst: true},
+	"de-DE": {decimal: ",", group: ".", symbolFirst: false},
+}
+
+var currencies = map[string]string{
+	"RUB": "₽",
+	"USD": "$",
+	"EUR": "€",
+}
+
+func (p Preferences) Validate() error {
+	if _, ok := locales[p.Locale]; !ok {
+		return ErrUnsupported
+	}
+
+	if _, ok := currencies[p.Currency]; !ok {
+		return ErrUnsupported
+	}
+
+	return nil
+}
+
+// Amount форматирует сумму с двумя знаками после запятой по правилам локали
+func (p Preferences) Amount(v float64) string {
+	l, ok := locales[p.Locale]
+	if !ok {
+		l = locales[Default.Locale]
+	}
+
+	symbol, ok := currencies[p.Currency]
+	if !ok {
+		symbol = currencies[Default.Currency]
+	}
+
+	sign := ""
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+
+	cents := int64(math.Round(v * 100))
+	whole := strconv.FormatInt(cents/100, 10)
+
+	var b strings.Builder
+	for i, c := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(l.group)
+		}
+		b.WriteRune(c)
+	}
+
+	number := sign + b.String() + l.decimal + fmt.Sprintf("%02d", cents%100)
+	if l.symbolFirst {
+		return symbol + number
+	}
+
+	return number + " " + symbol
+}
@@ -0,0 +1,24 @@
+package format
+
+import "testing"
+
+func TestAmount(t *testing.T) {
+	tests := []struct {
+		name  string
+		prefs Preferences
+		value float64
+		want  string
+	}{
+		{name: "ru-RU RUB", prefs: Default, value: 1234567.5, want: "1 234 567,50 ₽"},
+		{name: "en-US USD", prefs: Preferences{Locale: "en-US", Currency: "USD"}, value: 1234.05, want: "$1,234.05"},
+		{name: "de-DE EUR", prefs: Preferences{Locale: "de-DE", Currency: "EUR"}, value: -999.999, want: "-1.000,00 €"},
+		{name: "Неизвестная локаль", prefs: Preferences{Locale: "xx", Currency: "XXX"}, value: 5, want: "5,00 ₽"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.prefs.Amount(tt.value); got != tt.want {
+				t.Errorf("Amount() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
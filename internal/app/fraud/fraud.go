@@ -0,0 +1,110 @@
+package fraud
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/domain"
+)
+
+type Verdict int
+
+const (
+	Allow Verdict = iota
+	Hold
+	Reject
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Allow:
+		return "ALLOW"
+	case Hold:
+		return "HOLD"
+	case Reject:
+		return "REJECT"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+type Decision struct {
+	Verdict Verdict
+	Reason  string
+}
+
+// Withdrawal - попытка списания, проверяемая до ее записи в базу
+type Withdrawal struct {
+	Login      string
+	Order      string
+	Sum        float64
+	SessionAge time.Duration
+}
+
+// Checker проверяет списание перед его проведением
+type Checker interface {
+	Check(ctx context.Context, w Withdrawal) (Decision, error)
+}
+
+// Chain применяет проверки по очереди и возвращает первое решение, отличное от Allow
+type Chain []Checker
+
+func (c Chain) Check(ctx context.Context, w Withdrawal) (Decision, error) {
+	for _, checker := range c {
+		decision, err := checker.Check(ctx, w)
+		if err != nil {
+			return Decision{}, err
+		}
+
+		if decision.Verdict != Allow {
+			return decision, nil
+		}
+	}
+
+	return Decision{Verdict: Allow}, nil
+}
+
+// History - источник истории списаний пользователя
+type History interface {
+	CountWithDraw(login string, since time.Time) (int, error)
+}
+
+// Rules - встроенные правила, нулевые значения порогов отключают правило
+type Rules struct {
+	History History
+
+	// не более VelocityLimit списаний за VelocityWindow
+	VelocityLimit  int
+	VelocityWindow time.Duration
+
+	// списание не меньше LargeSum из сессии моложе NewSession
+	LargeSum   float64
+	NewSession time.Duration
+
+	// номер заказа списания не проходит проверку Луна
+	CheckOrder bool
+}
+
+func (r Rules) Check(ctx context.Context, w Withdrawal) (Decision, error) {
+	if r.CheckOrder && !domain.ValidOrderNumber(w.Order) {
+		return Decision{Verdict: Hold, Reason: "order number mismatch"}, nil
+	}
+
+	if r.LargeSum > 0 && w.Sum >= r.LargeSum && w.SessionAge < r.NewSession {
+		return Decision{Verdict: Hold, Reason: "large sum from new session"}, nil
+	}
+
+	if r.VelocityLimit > 0 && r.History != nil {
+		count, err := r.History.CountWithDraw(w.Login, time.Now().Add(-r.VelocityWindow))
+		if err != nil {
+			return Decision{}, err
+		}
+
+		if count >= r.VelocityLimit {
+			return Decision{Verdict: Hold, Reason: "velocity " + strconv.Itoa(count) + " per " + r.VelocityWindow.String()}, nil
+		}
+	}
+
+	return Decision{Verdict: Allow}, nil
+}
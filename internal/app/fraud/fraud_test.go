@@ -0,0 +1,68 @@
+package fraud
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type history int
+
+func (h history) CountWithDraw(string, time.Time) (int, error) {
+	return int(h), nil
+}
+
+func TestRules(t *testing.T) {
+	rules := Rules{
+		History:        history(3),
+		VelocityLimit:  3,
+		VelocityWindow: time.Hour,
+		LargeSum:       1000,
+		NewSession:     24 * time.Hour,
+		CheckOrder:     true,
+	}
+
+	tests := []struct {
+		name  string
+		rules Rules
+		args  Withdrawal
+		want  Verdict
+	}{
+		{
+			name:  "Неверный номер заказа",
+			rules: rules,
+			args:  Withdrawal{Login: "username", Order: "1735736", Sum: 10, SessionAge: 48 * time.Hour},
+			want:  Hold,
+		},
+		{
+			name:  "Крупная сумма из новой сессии",
+			rules: rules,
+			args:  Withdrawal{Login: "username", Order: "1735735", Sum: 1000, SessionAge: time.Minute},
+			want:  Hold,
+		},
+		{
+			name:  "Частые списания",
+			rules: rules,
+			args:  Withdrawal{Login: "username", Order: "1735735", Sum: 10, SessionAge: 48 * time.Hour},
+			want:  Hold,
+		},
+		{
+			name:  "Правила отключены",
+			rules: Rules{},
+			args:  Withdrawal{Login: "username", Order: "1735736", Sum: 1000},
+			want:  Allow,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rules.Check(context.Background(), tt.args)
+			if err != nil {
+				t.Errorf("Check() error = %v", err)
+				return
+			}
+			if got.Verdict != tt.want {
+				t.Errorf("Check() got = %v, want %v", got.Verdict, tt.want)
+			}
+		})
+	}
+}
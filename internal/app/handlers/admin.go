@@ -0,0 +1,591 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/accrual"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/auth"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/domain"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/notify"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/settings"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/validation"
+	"github.com/go-chi/chi/v5"
+)
+
+// AdminMiddleware пропускает только пользователей из списка ADMIN_LOGINS
+func (c *Controller) AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, ok := auth.FromContext(r.Context())
+		if !ok {
+			log.Print("AdminMiddleware: no user identification in context")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if cookie.Login == "" {
+			log.Printf("AdminMiddleware: %d, cookie: %s", http.StatusUnauthorized, cookie)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if cookie.Impersonator == "" && c.isAdmin(cookie.Login) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		log.Printf("AdminMiddleware: %d, cookie: %s", http.StatusForbidden, cookie)
+		w.WriteHeader(http.StatusForbidden)
+	})
+}
+
+func (c *Controller) isAdmin(login string) bool {
+	return slices.Contains(c.c.AdminLogins, login)
+}
+
+// GetConfig возвращает действующую конфигурацию с источником каждого значения, секреты скрыты
+func (c *Controller) GetConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	marshal, err := json.Marshal(config.Describe(c.c))
+	if err != nil {
+		log.Print("GetConfig: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(marshal)
+}
+
+func (c *Controller) GetHolds(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	holds, err := c.db.GetHolds()
+	if err != nil {
+		if errors.Is(err, database.ErrEmpty) {
+			log.Printf("GetHolds: %d", http.StatusNoContent)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		log.Print("GetHolds: get holds err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	marshal, err := json.Marshal(holds)
+	if err != nil {
+		log.Print("GetHolds: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, err = w.Write(marshal)
+	if err != nil {
+		log.Print("GetHolds: w write err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("GetHolds: %d", http.StatusOK)
+}
+
+// GetQuarantine возвращает ответы системы расчета, отложенные вместо начисления
+func (c *Controller) GetQuarantine(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	accruals, err := c.db.GetQuarantine()
+	if err != nil {
+		if errors.Is(err, database.ErrEmpty) {
+			log.Printf("GetQuarantine: %d", http.StatusNoContent)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		log.Print("GetQuarantine: get quarantine err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	marshal, err := json.Marshal(accruals)
+	if err != nil {
+		log.Print("GetQuarantine: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, err = w.Write(marshal)
+	if err != nil {
+		log.Print("GetQuarantine: w write err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("GetQuarantine: %d", http.StatusOK)
+}
+
+func (c *Controller) PostApproveHold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	hold, err := c.db.ApproveHold(id)
+	if err != nil {
+		var status int
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, database.ErrNoMoney):
+			status = http.StatusPaymentRequired
+		case errors.Is(err, database.ErrBadOrderNumber):
+			status = http.StatusUnprocessableEntity
+		default:
+			log.Printf("PostApproveHold: %s, id: %d", err.Error(), id)
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		log.Printf("PostApproveHold: %d, id: %d", status, id)
+		w.WriteHeader(status)
+		return
+	}
+
+	err = c.notify.Event(r.Context(), hold.Login, notify.EventWithdrawalProcessed,
+		fmt.Sprintf("Списание %s по заказу %s подтверждено", c.formatAmount(hold.Login, hold.Sum), hold.OrderID))
+	if err != nil {
+		log.Printf("PostApproveHold: notify err: %s, id: %d", err.Error(), id)
+	}
+
+	log.Printf("PostApproveHold: %d, id: %d, login: %s, order: %s, sum: %g",
+		http.StatusOK, id, hold.Login, hold.OrderID, hold.Sum)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) PostRejectHold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	hold, err := c.db.RejectHold(id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			log.Printf("PostRejectHold: %d, id: %d", http.StatusNotFound, id)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		log.Printf("PostRejectHold: %s, id: %d", err.Error(), id)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = c.notify.Event(r.Context(), hold.Login, notify.EventWithdrawalProcessed,
+		fmt.Sprintf("Списание %s по заказу %s отклонено", c.formatAmount(hold.Login, hold.Sum), hold.OrderID))
+	if err != nil {
+		log.Printf("PostRejectHold: notify err: %s, id: %d", err.Error(), id)
+	}
+
+	log.Printf("PostRejectHold: %d, id: %d, login: %s, order: %s, sum: %g",
+		http.StatusOK, id, hold.Login, hold.OrderID, hold.Sum)
+	w.WriteHeader(http.StatusOK)
+}
+
+// PostLogoutUser завершает все сессии пользователя, например при взломе учетной записи
+func (c *Controller) PostLogoutUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	login := chi.URLParam(r, "login")
+
+	err := c.db.RevokeAllSessions(login)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			log.Printf("PostLogoutUser: %d, login: %s", http.StatusNotFound, login)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		log.Printf("PostLogoutUser: %s, login: %s", err.Error(), login)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = c.notify.Notify(r.Context(), login, "Все сессии завершены администратором")
+	if err != nil {
+		log.Printf("PostLogoutUser: notify err: %s, login: %s", err.Error(), login)
+	}
+
+	log.Printf("PostLogoutUser: %d, login: %s", http.StatusOK, login)
+	w.WriteHeader(http.StatusOK)
+}
+
+type transferStruct struct {
+	Login string `json:"login"`
+}
+
+// PostTransferOrder переносит заказ, ошибочно загруженный не в ту учетную запись, вместе с баллами по нему
+func (c *Controller) PostTransferOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	number := domain.NormalizeOrderNumber(chi.URLParam(r, "number"))
+
+	var body transferStruct
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Login == "" {
+		log.Printf("PostTransferOrder: %d, order: %s", http.StatusBadRequest, number)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	transfer, err := c.db.TransferOrder(number, body.Login)
+	if err != nil {
+		var status int
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, database.ErrDuplicate):
+			status = http.StatusConflict
+		case errors.Is(err, database.ErrWrongData):
+			status = http.StatusUnprocessableEntity
+		case errors.Is(err, database.ErrNoMoney):
+			status = http.StatusPaymentRequired
+		default:
+			log.Printf("PostTransferOrder: %s, order: %s, to: %s", err.Error(), number, body.Login)
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		log.Printf("PostTransferOrder: %d, order: %s, to: %s", status, number, body.Login)
+		w.WriteHeader(status)
+		return
+	}
+
+	if transfer.From != "" {
+		err = c.notify.Notify(r.Context(), transfer.From,
+			fmt.Sprintf("Заказ %s перенесен администратором в другую учетную запись", number))
+		if err != nil {
+			log.Printf("PostTransferOrder: notify err: %s, order: %s", err.Error(), number)
+		}
+	}
+
+	err = c.notify.Event(r.Context(), transfer.To, notify.EventAccrualCredited,
+		fmt.Sprintf("Заказ %s перенесен в вашу учетную запись, начислено %s", number, c.formatAmount(transfer.To, transfer.Amount)))
+	if err != nil {
+		log.Printf("PostTransferOrder: notify err: %s, order: %s", err.Error(), number)
+	}
+
+	marshal, err := json.Marshal(transfer)
+	if err != nil {
+		log.Print("PostTransferOrder: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("PostTransferOrder: %d, order: %s, from: %s, to: %s, amount: %g",
+		http.StatusOK, number, transfer.From, transfer.To, transfer.Amount)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(marshal)
+}
+
+type extendStruct struct {
+	PollUntil string `json:"poll_until"`
+}
+
+// PostExtendOrder продлевает срок, до которого заказ ждет расчета, сверх ACCRUAL_MAX_ORDER_AGE.
+// Заказ EXPIRED снова получает статус NEW и возвращается в очередь опроса.
+func (c *Controller) PostExtendOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	number := domain.NormalizeOrderNumber(chi.URLParam(r, "number"))
+
+	var body extendStruct
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		log.Printf("PostExtendOrder: %d, order: %s", http.StatusBadRequest, number)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	until, err := parseDate(body.PollUntil)
+	if err != nil || !until.After(time.Now()) {
+		log.Printf("PostExtendOrder: %d, order: %s, poll until: %s", http.StatusBadRequest, number, body.PollUntil)
+		writeValidationErrors(w, validation.Errors{{Field: "poll_until", Message: "must be a future date or RFC3339 time"}})
+		return
+	}
+
+	order, resumed, err := c.db.ExtendOrder(number, until)
+	if err != nil {
+		var status int
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, database.ErrWrongData):
+			status = http.StatusConflict
+		default:
+			log.Printf("PostExtendOrder: %s, order: %s", err.Error(), number)
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		log.Printf("PostExtendOrder: %d, order: %s", status, number)
+		w.WriteHeader(status)
+		return
+	}
+
+	if resumed {
+		c.accrual.Enqueue(accrual.Order{Number: number, Status: domain.StatusNew})
+	}
+
+	marshal, err := json.Marshal(order)
+	if err != nil {
+		log.Print("PostExtendOrder: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("PostExtendOrder: %d, order: %s, poll until: %s, resumed: %t",
+		http.StatusOK, number, until.Format(time.RFC3339), resumed)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(marshal)
+}
+
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// maxReportBuckets ограничивает длину отчета, чтобы один запрос не агрегировал годы по суткам
+const maxReportBuckets = 366
+
+// GetReports возвращает начисления, списания, активных пользователей и регистрации за [from, to)
+// по суткам или неделям. Отчет за прошедший период не меняется и кешируется клиентом.
+func (c *Controller) GetReports(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	from, err := parseDate(r.URL.Query().Get("from"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	to, err := parseDate(r.URL.Query().Get("to"))
+	if err != nil || !to.After(from) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	groupBy := r.URL.Query().Get("group_by")
+	step := 24 * time.Hour
+	switch groupBy {
+	case "", database.ReportByDay:
+		groupBy = database.ReportByDay
+	case database.ReportByWeek:
+		step *= 7
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if to.Sub(database.ReportStart(from, groupBy)) > maxReportBuckets*step {
+		log.Printf("GetReports: %d, from: %s, to: %s, group by: %s",
+			http.StatusBadRequest, from.Format(time.RFC3339), to.Format(time.RFC3339), groupBy)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	key := fmt.Sprintf("report:%d:%d:%s", from.Unix(), to.Unix(), groupBy)
+	v, err, _ := c.reads.Do(key, func() (interface{}, error) {
+		return c.db.GetReport(from, to, groupBy)
+	})
+	if err != nil {
+		log.Print("GetReports: get report err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	marshal, err := json.Marshal(v.([]database.ReportBucket))
+	if err != nil {
+		log.Print("GetReports: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if to.Before(time.Now()) {
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	_, err = w.Write(marshal)
+	if err != nil {
+		log.Print("GetReports: w write err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("GetReports: %d, from: %s, to: %s, group by: %s",
+		http.StatusOK, from.Format(time.RFC3339), to.Format(time.RFC3339), groupBy)
+}
+
+func (c *Controller) PostBackfill(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	from, err := parseDate(r.URL.Query().Get("from"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	to, err := parseDate(r.URL.Query().Get("to"))
+	if err != nil || !to.After(from) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	fix := r.URL.Query().Get("fix") == "true"
+
+	report, err := c.accrual.Backfill(r.Context(), c.db, from, to, fix)
+	if err != nil {
+		log.Print("PostBackfill: backfill err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	marshal, err := json.Marshal(report)
+	if err != nil {
+		log.Print("PostBackfill: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, err = w.Write(marshal)
+	if err != nil {
+		log.Print("PostBackfill: w write err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("PostBackfill: %d, from: %s, to: %s, fix: %t, checked: %d, mismatches: %d",
+		http.StatusOK, from.Format(time.RFC3339), to.Format(time.RFC3339), fix, report.Checked, len(report.Mismatches))
+}
+
+// GetSettings возвращает бизнес-настройки с действующими значениями и значениями из конфигурации
+func (c *Controller) GetSettings(w http.ResponseWriter, r *http.Request) {
+	c.writeSettings(w, r, "GetSettings", http.StatusOK)
+}
+
+type settingStruct struct {
+	Value json.RawMessage `json:"value"`
+}
+
+// PutSetting задает значение настройки без перезапуска. Значение - строка или число JSON:
+// {"value": 100} или {"value": "2h"}. Другие экземпляры увидят его не позже чем через SETTINGS_TTL.
+func (c *Controller) PutSetting(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, _ := auth.FromContext(r.Context())
+	name := chi.URLParam(r, "name")
+
+	var body settingStruct
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Value) == 0 {
+		log.Printf("PutSetting: %d, name: %s", http.StatusBadRequest, name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	value := string(body.Value)
+	if err := json.Unmarshal(body.Value, &value); err != nil && body.Value[0] == '"' {
+		log.Printf("PutSetting: %d, name: %s", http.StatusBadRequest, name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := settings.Validate(name, value); err != nil {
+		if errors.Is(err, settings.ErrUnknown) {
+			log.Printf("PutSetting: %d, name: %s", http.StatusNotFound, name)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		log.Printf("PutSetting: %d, name: %s, value: %s", http.StatusBadRequest, name, value)
+		writeValidationErrors(w, validation.Errors{{Field: "value", Message: err.Error()}})
+		return
+	}
+
+	if err := c.db.PutSetting(name, value, cookie.Login); err != nil {
+		log.Printf("PutSetting: %s, name: %s", err.Error(), name)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("PutSetting: %d, name: %s, value: %s, by: %s", http.StatusOK, name, value, cookie.Login)
+	c.writeSettings(w, r, "PutSetting", http.StatusOK)
+}
+
+// DeleteSetting возвращает настройке значение из конфигурации
+func (c *Controller) DeleteSetting(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, _ := auth.FromContext(r.Context())
+	name := chi.URLParam(r, "name")
+
+	if !slices.Contains(settings.Names, name) {
+		log.Printf("DeleteSetting: %d, name: %s", http.StatusNotFound, name)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := c.db.DeleteSetting(name, cookie.Login); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			log.Printf("DeleteSetting: %d, name: %s", http.StatusNotFound, name)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		log.Printf("DeleteSetting: %s, name: %s", err.Error(), name)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("DeleteSetting: %d, name: %s, by: %s", http.StatusOK, name, cookie.Login)
+	c.writeSettings(w, r, "DeleteSetting", http.StatusOK)
+}
+
+// writeSettings отдает все настройки с источником значения
+func (c *Controller) writeSettings(w http.ResponseWriter, r *http.Request, name string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	overrides, err := c.db.GetSettings()
+	if err != nil {
+		log.Printf("%s: get settings err: %s", name, err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	marshal, err := json.Marshal(settings.Describe(c.db.SettingsDefaults(), overrides))
+	if err != nil {
+		log.Printf("%s: json marshal err: %s", name, err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(status)
+	_, _ = w.Write(marshal)
+}
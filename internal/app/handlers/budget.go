@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// routeBudget - счетчики маршрута за текущее окно
+type routeBudget struct {
+	started  time.Time
+	requests int64
+	errors   int64
+
+	degradedUntil time.Time
+}
+
+// errorBudgets переводит маршрут в режим только для чтения, когда доля ответов 5xx и паник
+// за окно превышает бюджет. Режим снимается сам через degradeFor.
+type errorBudgets struct {
+	budget      float64
+	window      time.Duration
+	minRequests int64
+	degradeFor  time.Duration
+
+	mu     sync.Mutex
+	routes map[string]*routeBudget
+}
+
+func newErrorBudgets(budget float64, window time.Duration, minRequests int64, degradeFor time.Duration) *errorBudgets {
+	return &errorBudgets{budget: budget, window: window, minRequests: minRequests, degradeFor: degradeFor,
+		routes: map[string]*routeBudget{}}
+}
+
+// degraded возвращает, находится ли маршрут в деградированном режиме и сколько он еще продлится
+func (b *errorBudgets) degraded(route string, now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rb, ok := b.routes[route]
+	if !ok || !now.Before(rb.degradedUntil) {
+		return false, 0
+	}
+
+	return true, rb.degradedUntil.Sub(now)
+}
+
+// observe учитывает ответ маршрута и возвращает true, если этот ответ исчерпал бюджет
+func (b *errorBudgets) observe(route string, now time.Time, failed bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rb, ok := b.routes[route]
+	if !ok {
+		rb = &routeBudget{started: now}
+		b.routes[route] = rb
+	}
+
+	if now.Sub(rb.started) >= b.window {
+		rb.started, rb.requests, rb.errors = now, 0, 0
+	}
+
+	rb.requests++
+	if failed {
+		rb.errors++
+	}
+
+	if now.Before(rb.degradedUntil) || rb.requests < b.minRequests ||
+		float64(rb.errors) <= b.budget*float64(rb.requests) {
+		return false
+	}
+
+	rb.degradedUntil = now.Add(b.degradeFor)
+	rb.started, rb.requests, rb.errors = now, 0, 0
+	return true
+}
+
+// list возвращает маршруты в деградированном режиме
+func (b *errorBudgets) list(now time.Time) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var routes []string
+	for route, rb := range b.routes {
+		if now.Before(rb.degradedUntil) {
+			routes = append(routes, route)
+		}
+	}
+	sort.Strings(routes)
+
+	return routes
+}
+
+// statusWriter запоминает код ответа обработчика
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// ErrorBudgetMiddleware перехватывает паники обработчиков и ведет бюджет ошибок маршрута.
+// Пока маршрут деградирован, изменяющие запросы к нему получают 503 с Retry-After, чтения проходят.
+// Подключается к конечным маршрутам chi, иначе шаблон маршрута еще не известен.
+func (c *Controller) ErrorBudgetMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.Method + " " + chi.RouteContext(r.Context()).RoutePattern()
+
+		if c.budgets != nil && !safeMethod(r.Method) {
+			if ok, wait := c.budgets.degraded(route, time.Now()); ok {
+				log.Printf("ErrorBudgetMiddleware: %d, %s degraded", http.StatusServiceUnavailable, route)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+
+				log.Printf("ErrorBudgetMiddleware: %s panic: %v\n%s", route, p, debug.Stack())
+				if sw.status == 0 {
+					sw.WriteHeader(http.StatusInternalServerError)
+				}
+				sw.status = http.StatusInternalServerError
+			}
+
+			if c.budgets != nil && c.budgets.observe(route, time.Now(), sw.status >= http.StatusInternalServerError) {
+				c.alert(route)
+			}
+		}()
+
+		next.ServeHTTP(sw, r)
+	})
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// alert сообщает администраторам о переводе маршрута в деградированный режим
+func (c *Controller) alert(route string) {
+	message := "route " + route + " exceeded error budget, read-only for " + c.c.DegradedDuration.String()
+	log.Print("ALERT: ", message)
+
+	go func() {
+		for _, admin := range c.c.AdminLogins {
+			if err := c.notify.Notify(context.Background(), admin, message); err != nil {
+				log.Printf("alert: notify %s err: %s", admin, err.Error())
+			}
+		}
+	}()
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	_ "embed"
+	"log"
+	"net/http"
+)
+
+// changelog - список изменений API, встраивается при сборке
+//
+//go:embed changelog.json
+var changelog []byte
+
+func (c *Controller) GetChangelog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_, err := w.Write(changelog)
+	if err != nil {
+		log.Print("GetChangelog: w write err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
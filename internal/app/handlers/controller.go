@@ -1,17 +1,79 @@
 package handlers
 
 import (
+	"sync/atomic"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/accrual"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/anomaly"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/auth"
 	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
-	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
-	"github.com/chazari-x/yandex-pr-diplom/internal/app/worker"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/domain"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/fraud"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/mail"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/notify"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/token"
+	"golang.org/x/sync/singleflight"
 )
 
 type Controller struct {
-	c      config.Config
-	db     *database.DataBase
-	worker chan worker.OrderStr
+	c       config.Config
+	db      Storage
+	accrual *accrual.Pool
+	fraud   fraud.Checker
+	notify  notify.EventNotifier
+	auth    auth.Authenticator
+	mail    mail.Sender
+	tokens  token.Source
+	anomaly anomaly.Hook
+
+	// anomalies - уже сообщенные пары сессия/адрес
+	anomalies *reportedAnomalies
+
+	// orders - правила загрузки заказов и списаний поверх хранилища
+	orders *domain.Service
+
+	// reads объединяет одновременные одинаковые чтения одного пользователя в один запрос к базе
+	reads singleflight.Group
+
+	stats *requestStats
+
+	// queries - вызовы хранилища по методам, nil - хранилище их не учитывает
+	queries *QueryStats
+
+	// outcomes - бизнес-исходы запросов по обработчикам
+	outcomes *outcomeStats
+
+	// authLimiter ограничивает попытки регистрации и входа, nil - без ограничения
+	authLimiter *rateLimiter
+
+	// budgets - бюджеты ошибок маршрутов, nil - без деградации
+	budgets *errorBudgets
+
+	// touches - активность сессий до записи в базу, nil - активность не учитывается
+	touches *sessionTouches
+
+	// draining - сервер останавливается и дорабатывает начатые запросы
+	draining atomic.Bool
+
+	// warmingUp - сервер открывает соединения и проверяет систему расчета до приема трафика
+	warmingUp atomic.Bool
 }
 
-func NewController(c config.Config, db *database.DataBase, w chan worker.OrderStr) *Controller {
-	return &Controller{c: c, db: db, worker: w}
+func NewController(c config.Config, db Storage, p *accrual.Pool, f fraud.Checker, n notify.EventNotifier, a auth.Authenticator,
+	m mail.Sender, t token.Source, h anomaly.Hook, q *QueryStats) *Controller {
+	controller := &Controller{c: c, db: db, accrual: p, fraud: f, notify: n, auth: a, mail: m, tokens: t, anomaly: h,
+		anomalies: newReportedAnomalies(), orders: domain.New(db), stats: newRequestStats(), queries: q,
+		outcomes: newOutcomeStats()}
+	controller.orders.MaxAccrual = c.AccrualMax
+	if c.AuthRateLimit > 0 {
+		controller.authLimiter = newRateLimiter(c.AuthRateLimit, c.AuthRateBurst)
+	}
+	if c.ErrorBudget > 0 {
+		controller.budgets = newErrorBudgets(c.ErrorBudget, c.ErrorBudgetWindow, c.ErrorBudgetMinRequests, c.DegradedDuration)
+	}
+	if c.SessionTouchInterval > 0 {
+		controller.touches = newSessionTouches()
+	}
+
+	return controller
 }
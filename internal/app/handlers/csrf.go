@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+)
+
+// Защита от CSRF двойной отправкой: токен выдается в cookie, доступной скрипту клиента,
+// и для изменяющих запросов должен быть повторен в заголовке X-CSRF-Token.
+var csrfCookie = "csrf_token"
+
+var csrfHeader = "X-CSRF-Token"
+
+// csrfCookieMiddleware выдает CSRF-токен клиенту, у которого его еще нет
+func (c *Controller) csrfCookieMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(csrfCookie); err != nil || cookie.Value == "" {
+			b, err := generateRandom(32)
+			if err != nil {
+				log.Print("csrfCookieMiddleware: generate random err: ", err.Error())
+				c.renderError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+
+			c.setCookie(w, csrfCookie, hex.EncodeToString(b))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CSRFMiddleware отклоняет изменяющие запросы, в которых заголовок X-CSRF-Token не совпадает с cookie.
+// При авторизации по JWT браузер не отправляет учетные данные сам, и проверка не нужна. Не нужна она
+// и клиентам API по спецификации: браузер добавляет к изменяющим запросам Origin или Sec-Fetch-Site,
+// запрос без обоих заголовков отправлен не со страницы.
+func (c *Controller) CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.c.AuthMode == config.AuthModeJWT {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Header.Get("Origin") == "" && r.Header.Get("Sec-Fetch-Site") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(csrfCookie)
+		header := r.Header.Get(csrfHeader)
+		if err != nil || cookie.Value == "" || header == "" ||
+			subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			log.Printf("CSRFMiddleware: %d, %s %s", http.StatusForbidden, r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
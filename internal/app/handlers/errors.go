@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"runtime"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// errorStruct - тело ответа 5xx. В MODE=prod клиент получает только код и номер запроса,
+// по которому причина находится в логе; в MODE=dev - еще причину и место, где она возникла.
+type errorStruct struct {
+	Error     string `json:"error"`
+	Code      int    `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	Cause     string `json:"cause,omitempty"`
+	Hint      string `json:"hint,omitempty"`
+}
+
+// renderError отвечает ошибкой status. err - причина, nil - причина неизвестна или уже записана в лог.
+func (c *Controller) renderError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	body := errorStruct{Error: http.StatusText(status), Code: status, RequestID: middleware.GetReqID(r.Context())}
+	if err != nil {
+		log.Printf("request %s: %d %s %s: %s", body.RequestID, status, r.Method, r.URL.Path, err.Error())
+
+		if c.c.Mode == config.ModeDev {
+			body.Cause = err.Error()
+			body.Hint = caller(2)
+		}
+	}
+
+	marshal, err := json.Marshal(body)
+	if err != nil {
+		log.Print("renderError: json marshal err: ", err.Error())
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	_, _ = w.Write(marshal)
+}
+
+// caller возвращает файл, строку и функцию вызова на skip уровней выше
+func caller(skip int) string {
+	pc, file, line, ok := runtime.Caller(skip)
+	if !ok {
+		return ""
+	}
+
+	name := "?"
+	if f := runtime.FuncForPC(pc); f != nil {
+		name = filepath.Base(f.Name())
+	}
+
+	return fmt.Sprintf("%s:%d %s", filepath.Base(file), line, name)
+}
+
+// errorWriter откладывает заголовок 5xx до тела: если обработчик не отправил тело, ответ
+// дописывает renderError
+type errorWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *errorWriter) WriteHeader(status int) {
+	if w.status != 0 || w.wrote {
+		return
+	}
+
+	w.status = status
+	if status < http.StatusInternalServerError {
+		w.wrote = true
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *errorWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.wrote = true
+		if w.status != 0 {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// errorsMiddleware стоит ближе всех к обработчикам, чтобы тело ошибки проходило через сжатие
+func (c *Controller) errorsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := middleware.GetReqID(r.Context()); id != "" {
+			w.Header().Set(middleware.RequestIDHeader, id)
+		}
+
+		ew := &errorWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+
+		if !ew.wrote && ew.status >= http.StatusInternalServerError {
+			c.renderError(ew, r, ew.status, nil)
+		}
+	})
+}
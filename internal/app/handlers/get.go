@@ -6,17 +6,22 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/auth"
 	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/domain"
+	"github.com/go-chi/chi/v5"
 )
 
 func (c *Controller) GetOrders(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var cookie cookieStruct
-	err := json.Unmarshal([]byte(fmt.Sprintf("%s", r.Context().Value(identification))), &cookie)
-	if err != nil {
-		log.Print("PostRegister: unmarshal cookie err: ", err.Error())
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("GetOrders: no user identification in context")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -27,7 +32,33 @@ func (c *Controller) GetOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	orders, err := c.db.GetOrders(cookie.Login)
+	if r.URL.Query().Has("changed_since") {
+		c.getChangedOrders(w, r, cookie)
+		return
+	}
+
+	limit, offset, after, ok := parsePage(r.URL.Query())
+	if !ok {
+		log.Printf("GetOrders: %d, cookie: %s, query: %s", http.StatusBadRequest, cookie, r.URL.RawQuery)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// archived=true - вместе с давно обработанными заказами из архива
+	var archived bool
+	if v := r.URL.Query().Get("archived"); v != "" {
+		var err error
+		if archived, err = strconv.ParseBool(v); err != nil {
+			log.Printf("GetOrders: %d, cookie: %s, query: %s", http.StatusBadRequest, cookie, r.URL.RawQuery)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	key := fmt.Sprintf("orders:%s:%d:%d:%d:%t", cookie.Login, limit, offset, after, archived)
+	v, err, _ := c.reads.Do(key, func() (interface{}, error) {
+		return c.db.GetOrders(cookie.Login, limit, offset, after, archived)
+	})
 	if err != nil {
 		if errors.Is(err, database.ErrEmpty) {
 			log.Printf("GetOrders: %d, cookie: %s", http.StatusNoContent, cookie)
@@ -36,21 +67,24 @@ func (c *Controller) GetOrders(w http.ResponseWriter, r *http.Request) {
 		}
 
 		log.Printf("GetOrders: %s, cookie: %s", err.Error(), cookie)
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	marshal, err := json.Marshal(orders)
+	orders := v.([]database.Order)
+	marshal, err := json.Marshal(c.flagDelayed(orders))
 	if err != nil {
 		log.Print("GetOrders: json marshal err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
+	setNextLink(w, r, limit, len(orders), orders[len(orders)-1].Seq)
+
 	wr, err := w.Write(marshal)
 	if err != nil {
 		log.Print("GetOrders: w write err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -61,13 +95,56 @@ func (c *Controller) GetOrders(w http.ResponseWriter, r *http.Request) {
 	log.Printf("GetOrders: %d, cookie: %s", http.StatusOK, cookie)
 }
 
-func (c *Controller) GetBalance(w http.ResponseWriter, r *http.Request) {
+// GetOrder отдает один заказ пользователя: по нему клиент опрашивает статус только что загруженного заказа
+func (c *Controller) GetOrder(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var cookie cookieStruct
-	err := json.Unmarshal([]byte(fmt.Sprintf("%s", r.Context().Value(identification))), &cookie)
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("GetOrder: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("GetOrder: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	number := domain.NormalizeOrderNumber(chi.URLParam(r, "number"))
+
+	order, err := c.db.GetOrder(cookie.Login, number)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			log.Printf("GetOrder: %d, cookie: %s, order: %s", http.StatusNotFound, cookie, number)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		log.Printf("GetOrder: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	marshal, err := json.Marshal(c.flagDelayed([]database.Order{order})[0])
 	if err != nil {
-		log.Print("PostRegister: unmarshal cookie err: ", err.Error())
+		log.Print("GetOrder: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("GetOrder: %d, cookie: %s, order: %s, status: %s", http.StatusOK, cookie, number, order.Status)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(marshal)
+}
+
+func (c *Controller) GetBalance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("GetBalance: no user identification in context")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -79,25 +156,28 @@ func (c *Controller) GetBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	balance, err := c.db.GetBalance(cookie.Login)
+	v, err, _ := c.reads.Do("balance:"+cookie.Login, func() (interface{}, error) {
+		return c.db.GetBalance(cookie.Login)
+	})
+	balance, _ := v.(database.User)
 	if err != nil {
 		log.Printf("GetBalance: %s, cookie: %s, current: %g, withdrawn: %g",
 			err.Error(), cookie, balance.Current, balance.WithDraw)
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	marshal, err := json.Marshal(balance)
 	if err != nil {
 		log.Print("GetBalance: json marshal err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	_, err = w.Write(marshal)
 	if err != nil {
 		log.Print("GetBalance: w write err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -108,10 +188,9 @@ func (c *Controller) GetBalance(w http.ResponseWriter, r *http.Request) {
 func (c *Controller) GetWithDrawAls(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var cookie cookieStruct
-	err := json.Unmarshal([]byte(fmt.Sprintf("%s", r.Context().Value(identification))), &cookie)
-	if err != nil {
-		log.Print("PostRegister: unmarshal cookie err: ", err.Error())
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("GetWithDrawAls: no user identification in context")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -122,7 +201,14 @@ func (c *Controller) GetWithDrawAls(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	withdraw, err := c.db.GetWithDraw(cookie.Login)
+	limit, offset, after, ok := parsePage(r.URL.Query())
+	if !ok {
+		log.Printf("GetWithDraw: %d, cookie: %s, query: %s", http.StatusBadRequest, cookie, r.URL.RawQuery)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	withdraw, err := c.db.GetWithDraw(cookie.Login, limit, offset, after)
 	if err != nil {
 		if errors.Is(err, database.ErrEmpty) {
 			log.Printf("GetWithDraw: %d, cookie: %s", http.StatusNoContent, cookie)
@@ -131,23 +217,224 @@ func (c *Controller) GetWithDrawAls(w http.ResponseWriter, r *http.Request) {
 		}
 
 		log.Print("GetWithDraw: add order err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	marshal, err := json.Marshal(withdraw)
 	if err != nil {
 		log.Print("GetWithDraw: json marshal err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
+	setNextLink(w, r, limit, len(withdraw), withdraw[len(withdraw)-1].Seq)
+
 	_, err = w.Write(marshal)
 	if err != nil {
 		log.Print("GetWithDraw: w write err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	log.Printf("GetWithDraw: %d, cookie: %s", http.StatusOK, cookie)
 }
+
+// balanceHistoryDays - период истории остатков по умолчанию, если from не задан
+const balanceHistoryDays = 30
+
+// GetBalanceHistory отдает остатки на конец суток (UTC) за [from, to) по суткам или, с granularity=week,
+// последний остаток каждой недели. По умолчанию - последние balanceHistoryDays суток.
+func (c *Controller) GetBalanceHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("GetBalanceHistory: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("GetBalanceHistory: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+
+	granularity := query.Get("granularity")
+	switch granularity {
+	case "":
+		granularity = database.ReportByDay
+	case database.ReportByDay, database.ReportByWeek:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	to := database.ReportStart(time.Now(), database.ReportByDay).AddDate(0, 0, 1)
+	if s := query.Get("to"); s != "" {
+		t, err := parseDate(s)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+
+	from := to.AddDate(0, 0, -balanceHistoryDays)
+	if s := query.Get("from"); s != "" {
+		t, err := parseDate(s)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+
+	if !to.After(from) || to.Sub(from) > maxReportBuckets*24*time.Hour {
+		log.Printf("GetBalanceHistory: %d, cookie: %s, from: %s, to: %s",
+			http.StatusBadRequest, cookie, from.Format(time.RFC3339), to.Format(time.RFC3339))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	points, err := c.db.GetBalanceHistory(cookie.Login, from, to, granularity)
+	if err != nil {
+		log.Printf("GetBalanceHistory: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(points) == 0 {
+		log.Printf("GetBalanceHistory: %d, cookie: %s", http.StatusNoContent, cookie)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	marshal, err := json.Marshal(points)
+	if err != nil {
+		log.Print("GetBalanceHistory: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, err = w.Write(marshal)
+	if err != nil {
+		log.Print("GetBalanceHistory: w write err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("GetBalanceHistory: %d, cookie: %s, points: %d", http.StatusOK, cookie, len(points))
+}
+
+// parsePage читает необязательные limit (больше 0), offset (не меньше 0) и after (seq последней
+// записи предыдущей страницы, больше 0) списка. Без limit отдается весь список.
+func parsePage(q url.Values) (limit, offset int, after int64, ok bool) {
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return 0, 0, 0, false
+		}
+		limit = n
+	}
+
+	if s := q.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, 0, 0, false
+		}
+		offset = n
+	}
+
+	if s := q.Get("after"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, 0, false
+		}
+		after = n
+	}
+
+	return limit, offset, after, true
+}
+
+// setNextLink ставит заголовок Link со ссылкой rel="next" на следующую страницу списка: после записи
+// с seq last. Неполная страница - последняя, ссылки нет.
+func setNextLink(w http.ResponseWriter, r *http.Request, limit, n int, last int64) {
+	if limit == 0 || n < limit {
+		return
+	}
+
+	q := r.URL.Query()
+	q.Del("offset")
+	q.Set("after", strconv.FormatInt(last, 10))
+	w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, q.Encode()))
+}
+
+// syncCursorHeader - заголовок с курсором для следующего запроса changed_since
+const syncCursorHeader = "X-Sync-Cursor"
+
+// getChangedOrders отдает заказы, изменившиеся после changed_since: момента в RFC 3339
+// или курсора из заголовка X-Sync-Cursor предыдущего ответа
+func (c *Controller) getChangedOrders(w http.ResponseWriter, r *http.Request, cookie auth.UserID) {
+	marker := r.URL.Query().Get("changed_since")
+
+	var cursor int64
+	var since time.Time
+	if t, err := time.Parse(time.RFC3339, marker); err == nil {
+		since = t
+	} else if n, err := strconv.ParseInt(marker, 10, 64); err == nil && n >= 0 {
+		cursor = n
+	} else {
+		log.Printf("GetOrders: %d, cookie: %s, changed_since: %q", http.StatusBadRequest, cookie, marker)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	orders, err := c.db.GetChangedOrders(cookie.Login, cursor, since)
+	if err != nil {
+		log.Printf("GetOrders: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(orders) > 0 {
+		cursor = orders[len(orders)-1].Revision
+	}
+	w.Header().Set(syncCursorHeader, strconv.FormatInt(cursor, 10))
+
+	if len(orders) == 0 {
+		log.Printf("GetOrders: %d, cookie: %s, changed_since: %s", http.StatusNoContent, cookie, marker)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	marshal, err := json.Marshal(c.flagDelayed(orders))
+	if err != nil {
+		log.Print("GetOrders: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("GetOrders: %d, cookie: %s, changed_since: %s, changed: %d", http.StatusOK, cookie, marker, len(orders))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(marshal)
+}
+
+// flagDelayed отмечает заказы, ожидающие расчета, пока система расчета недоступна. Срез
+// может быть общим для объединенных чтений, поэтому отметки ставятся в копии.
+func (c *Controller) flagDelayed(orders []database.Order) []database.Order {
+	if !c.accrual.Down() {
+		return orders
+	}
+
+	flagged := make([]database.Order, len(orders))
+	for i, o := range orders {
+		o.AccrualDelayed = o.Status == domain.StatusNew || o.Status == domain.StatusProcessing
+		flagged[i] = o
+	}
+
+	return flagged
+}
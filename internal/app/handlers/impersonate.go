@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/auth"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// impersonationTTL - срок входа администратора от имени пользователя, не продлевается
+const impersonationTTL = 30 * time.Minute
+
+type impersonateStruct struct {
+	Write bool `json:"write"`
+}
+
+type impersonationStruct struct {
+	Login     string `json:"login"`
+	ReadOnly  bool   `json:"read_only"`
+	ExpiresAt string `json:"expires_at"`
+	// Session - значение cookie user_identification, Authorization - заголовок Authorization
+	// в режиме AUTH_MODE=jwt
+	Session       string `json:"session,omitempty"`
+	Authorization string `json:"authorization,omitempty"`
+}
+
+// PostImpersonate выдает администратору вход от имени пользователя, чтобы воспроизвести проблему,
+// о которой тот сообщил. По умолчанию только для чтения, {"write": true} разрешает изменения.
+// Вход отдается в теле ответа, а не устанавливается администратору, и действует impersonationTTL.
+// Выдача входа и каждый запрос по нему записываются в журнал аудита.
+func (c *Controller) PostImpersonate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	admin, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PostImpersonate: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	login := chi.URLParam(r, "login")
+
+	var body impersonateStruct
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("PostImpersonate: %d, login: %s", http.StatusBadRequest, login)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// вход от имени администратора открыл бы администрирование без аудита его собственных действий
+	if login == admin.Login || c.isAdmin(login) {
+		log.Printf("PostImpersonate: %d, admin: %s, login: %s", http.StatusForbidden, admin.Login, login)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	now := time.Now()
+	impersonation := database.Impersonation{
+		Admin:     admin.Login,
+		Login:     login,
+		ReadOnly:  !body.Write,
+		ExpiresAt: now.Add(impersonationTTL),
+	}
+	impersonation.UserAgent, impersonation.IP = clientInfo(r)
+
+	resp := impersonationStruct{Login: login, ReadOnly: impersonation.ReadOnly, ExpiresAt: impersonation.ExpiresAt.Format(time.RFC3339)}
+	if c.c.AuthMode == config.AuthModeJWT {
+		token, err := signJWT(c.c.SessionKey, jwtClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   login,
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(impersonation.ExpiresAt),
+			},
+			Act:      &jwtActor{Subject: admin.Login},
+			ReadOnly: impersonation.ReadOnly,
+		})
+		if err != nil {
+			log.Print("PostImpersonate: sign jwt err: ", err.Error())
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		resp.Authorization = "Bearer " + token
+	} else {
+		uid, err := c.tokens.New()
+		if err != nil {
+			log.Print("PostImpersonate: new session err: ", err.Error())
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		impersonation.Session = uid
+		resp.Session = signToken(c.c.SessionKey, uid)
+	}
+
+	if err := c.db.Impersonate(impersonation); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			log.Printf("PostImpersonate: %d, login: %s", http.StatusNotFound, login)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		log.Printf("PostImpersonate: %s, login: %s", err.Error(), login)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	marshal, err := json.Marshal(resp)
+	if err != nil {
+		log.Print("PostImpersonate: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("PostImpersonate: %d, admin: %s, login: %s, read only: %t", http.StatusOK, admin.Login, login, impersonation.ReadOnly)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(marshal)
+}
+
+// impersonationMiddleware записывает в журнал аудита каждый запрос администратора от имени
+// пользователя до его выполнения и отклоняет изменения во входе только для чтения. Запрос,
+// который не удалось записать в журнал, не выполняется.
+func (c *Controller) impersonationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := auth.FromContext(r.Context())
+		if !ok || id.Impersonator == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		action := database.AuditRequest
+		if id.ReadOnly && !safeMethod(r.Method) {
+			action = database.AuditDenied
+		}
+
+		if err := c.db.AddAudit(id.Impersonator, id.Login, action, r.Method+" "+r.URL.RequestURI()); err != nil {
+			log.Print("impersonationMiddleware: add audit err: ", err.Error())
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("X-Impersonated-By", id.Impersonator)
+		if action == database.AuditDenied {
+			log.Printf("impersonationMiddleware: %d, admin: %s, login: %s, read only", http.StatusForbidden, id.Impersonator, id.Login)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/validation"
+)
+
+// Статусы строки импорта
+const (
+	importCreated = "created"
+	importExists  = "exists"
+)
+
+type importRow struct {
+	login   string
+	balance float64
+}
+
+type importedStruct struct {
+	Login    string `json:"login"`
+	Status   string `json:"status"`
+	Password string `json:"password,omitempty"`
+}
+
+// PostImportUsers создает пользователей из CSV login,balance (строка заголовка необязательна)
+// с временными паролями и начальными остатками. Файл проверяется целиком до импорта: ошибки -
+// 400 со списком строк. Существующие пользователи пропускаются, повторный импорт безопасен.
+// Временные пароли отдаются в ответе один раз.
+func (c *Controller) PostImportUsers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	rows, errs, err := readImport(r.Body)
+	if err != nil {
+		log.Print("PostImportUsers: read csv err: ", err.Error())
+		writeValidationErrors(w, validation.Errors{{Field: "body", Message: "must be CSV with login,balance rows"}})
+		return
+	}
+
+	if errs != nil {
+		log.Printf("PostImportUsers: %d, errors: %s", http.StatusBadRequest, errs)
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	imported := make([]importedStruct, 0, len(rows))
+	for _, row := range rows {
+		pass := rand.Text()
+
+		created, err := c.db.ImportUser(row.login, pass, row.balance)
+		if err != nil {
+			log.Printf("PostImportUsers: %s, login: %s, imported: %d", err.Error(), row.login, len(imported))
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !created {
+			imported = append(imported, importedStruct{Login: row.login, Status: importExists})
+			continue
+		}
+
+		imported = append(imported, importedStruct{Login: row.login, Status: importCreated, Password: pass})
+	}
+
+	marshal, err := json.Marshal(imported)
+	if err != nil {
+		log.Print("PostImportUsers: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("PostImportUsers: %d, rows: %d", http.StatusOK, len(imported))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(marshal)
+}
+
+// readImport разбирает CSV импорта. Ошибки в значениях возвращаются списком по строкам файла,
+// err - файл не разобрать как CSV из двух колонок.
+func readImport(body io.Reader) ([]importRow, validation.Errors, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	var rows []importRow
+	var errs validation.Errors
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		if line == 1 && strings.EqualFold(record[0], "login") {
+			continue
+		}
+
+		login := record[0]
+		for _, e := range validation.Credentials(login, "-") {
+			errs = append(errs, validation.FieldError{Field: fmt.Sprintf("line %d: %s", line, e.Field), Message: e.Message})
+		}
+
+		balance, err := strconv.ParseFloat(record[1], 64)
+		if err != nil || balance < 0 || math.IsInf(balance, 0) || math.IsNaN(balance) {
+			errs = append(errs, validation.FieldError{Field: fmt.Sprintf("line %d: balance", line), Message: "must be a non-negative number"})
+		}
+
+		rows = append(rows, importRow{login: login, balance: balance})
+	}
+
+	if rows == nil && errs == nil {
+		errs = validation.Errors{{Field: "body", Message: "must contain at least one user"}}
+	}
+
+	return rows, errs, nil
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/auth"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// GetInactivityExempt отдает логины пользователей, которых не касается очистка неактивных учетных записей
+func (c *Controller) GetInactivityExempt(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	logins, err := c.db.GetInactivityExempt()
+	if err != nil {
+		if errors.Is(err, database.ErrEmpty) {
+			log.Printf("GetInactivityExempt: %d", http.StatusNoContent)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		log.Print("GetInactivityExempt: get exempt err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	marshal, err := json.Marshal(logins)
+	if err != nil {
+		log.Print("GetInactivityExempt: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("GetInactivityExempt: %d", http.StatusOK)
+	_, _ = w.Write(marshal)
+}
+
+// PutInactivityExempt исключает пользователя из очистки неактивных учетных записей
+func (c *Controller) PutInactivityExempt(w http.ResponseWriter, r *http.Request) {
+	c.setInactivityExempt(w, r, "PutInactivityExempt", true)
+}
+
+// DeleteInactivityExempt возвращает пользователя в очистку неактивных учетных записей:
+// неактивного пользователя снова предупредят перед обезличиванием
+func (c *Controller) DeleteInactivityExempt(w http.ResponseWriter, r *http.Request) {
+	c.setInactivityExempt(w, r, "DeleteInactivityExempt", false)
+}
+
+func (c *Controller) setInactivityExempt(w http.ResponseWriter, r *http.Request, name string, exempt bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, _ := auth.FromContext(r.Context())
+	login := chi.URLParam(r, "login")
+
+	if err := c.db.SetInactivityExempt(login, exempt, cookie.Login); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			log.Printf("%s: %d, login: %s", name, http.StatusNotFound, login)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		log.Printf("%s: %s, login: %s", name, err.Error(), login)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("%s: %d, login: %s, by: %s", name, http.StatusNoContent, login, cookie.Login)
+	w.WriteHeader(http.StatusNoContent)
+}
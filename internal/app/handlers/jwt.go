@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/auth"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var errBadToken = errors.New("bad token")
+
+const jwtTTL = time.Hour
+
+// jwtClaims - утверждения токена доступа. Act - администратор, действующий от имени пользователя
+// Subject (RFC 8693), ReadOnly - изменения по токену запрещены.
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	Act      *jwtActor `json:"act,omitempty"`
+	ReadOnly bool      `json:"read_only,omitempty"`
+}
+
+type jwtActor struct {
+	Subject string `json:"sub"`
+}
+
+func makeJWT(key, login string) (string, error) {
+	now := time.Now()
+	return signJWT(key, jwtClaims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   login,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(jwtTTL)),
+	}})
+}
+
+func signJWT(key string, claims jwtClaims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+}
+
+// parseJWT возвращает утверждения проверенного токена: логин, время выпуска и администратора,
+// если токен выдан для входа от имени пользователя
+func parseJWT(key, tokenString string) (jwtClaims, error) {
+	var claims jwtClaims
+	token, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errBadToken
+		}
+
+		return []byte(key), nil
+	})
+	if err != nil {
+		return jwtClaims{}, err
+	}
+
+	if !token.Valid || claims.Subject == "" || claims.IssuedAt == nil || (claims.Act != nil && claims.Act.Subject == "") {
+		return jwtClaims{}, errBadToken
+	}
+
+	return claims, nil
+}
+
+// authorization возвращает значение заголовка Authorization для пользователя
+func (c *Controller) authorization(login string) (string, error) {
+	if c.c.AuthMode != config.AuthModeJWT {
+		return login, nil
+	}
+
+	token, err := makeJWT(c.c.SessionKey, login)
+	if err != nil {
+		return "", err
+	}
+
+	return "Bearer " + token, nil
+}
+
+func (c *Controller) jwtMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, err := c.tokens.New()
+		if err != nil {
+			log.Print("jwtMiddleware: set user identification err: ", err.Error())
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		id := auth.UserID{ID: uid}
+		if header := r.Header.Get("Authorization"); header != "" {
+			token := strings.TrimPrefix(header, "Bearer ")
+			if token == header {
+				log.Printf("jwtMiddleware: %d, bad authorization header", http.StatusUnauthorized)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := parseJWT(c.c.SessionKey, token)
+			if err != nil {
+				log.Printf("jwtMiddleware: %d, err: %s", http.StatusUnauthorized, err.Error())
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			login := claims.Subject
+			revokedAt, err := c.db.SessionsRevokedAt(login)
+			if errors.Is(err, database.ErrNotFound) {
+				log.Printf("jwtMiddleware: %d, login: %s, user not found", http.StatusUnauthorized, login)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if err != nil {
+				log.Print("jwtMiddleware: sessions revoked at err: ", err.Error())
+				c.renderError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+
+			// iat хранится с точностью до секунды: токен, выданный в секунду отзыва, считается новым
+			if claims.IssuedAt.Before(revokedAt.Truncate(time.Second)) {
+				log.Printf("jwtMiddleware: %d, login: %s, token revoked", http.StatusUnauthorized, login)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			id.Login = login
+			if claims.Act != nil {
+				id.Impersonator, id.ReadOnly = claims.Act.Subject, claims.ReadOnly
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), id)))
+	})
+}
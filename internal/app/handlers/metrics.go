@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rpsWindow - окно, за которое считается средняя частота запросов, в секундах
+const rpsWindow = 60
+
+// requestStats считает запросы с момента запуска и по секундам за последнее окно
+type requestStats struct {
+	started time.Time
+	total   atomic.Int64
+
+	mu      sync.Mutex
+	buckets [rpsWindow]struct {
+		second int64
+		count  int64
+	}
+}
+
+func newRequestStats() *requestStats {
+	return &requestStats{started: time.Now()}
+}
+
+func (s *requestStats) add(now time.Time) {
+	s.total.Add(1)
+
+	sec := now.Unix()
+	s.mu.Lock()
+	b := &s.buckets[sec%rpsWindow]
+	if b.second != sec {
+		b.second, b.count = sec, 0
+	}
+	b.count++
+	s.mu.Unlock()
+}
+
+// rps возвращает среднюю частоту запросов за последние rpsWindow полных секунд
+func (s *requestStats) rps(now time.Time) float64 {
+	sec := now.Unix()
+
+	var count int64
+	s.mu.Lock()
+	for _, b := range s.buckets {
+		if b.second < sec && b.second >= sec-rpsWindow {
+			count += b.count
+		}
+	}
+	s.mu.Unlock()
+
+	return float64(count) / rpsWindow
+}
+
+func (c *Controller) statsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.stats.add(time.Now())
+		next.ServeHTTP(w, r)
+	})
+}
+
+// QueryStats считает вызовы хранилища по методам с момента запуска: реализует database.MetricsRecorder
+type QueryStats struct {
+	mu      sync.Mutex
+	methods map[string]*queryCounter
+}
+
+type queryCounter struct {
+	calls  int64
+	errors int64
+	rows   int64
+	total  time.Duration
+	max    time.Duration
+}
+
+func NewQueryStats() *QueryStats {
+	return &QueryStats{methods: make(map[string]*queryCounter)}
+}
+
+func (s *QueryStats) ObserveQuery(method string, d time.Duration, rows int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.methods[method]
+	if !ok {
+		q = &queryCounter{}
+		s.methods[method] = q
+	}
+
+	q.calls++
+	q.rows += rows
+	q.total += d
+	q.max = max(q.max, d)
+	if err != nil {
+		q.errors++
+	}
+}
+
+type queryStatsStruct struct {
+	Calls     int64   `json:"calls"`
+	Errors    int64   `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	AvgMs     float64 `json:"avg_ms"`
+	MaxMs     float64 `json:"max_ms"`
+	Rows      int64   `json:"rows"`
+}
+
+// snapshot возвращает показатели по методам, nil - вызовов не было или учет не подключен
+func (s *QueryStats) snapshot() map[string]queryStatsStruct {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.methods) == 0 {
+		return nil
+	}
+
+	stats := make(map[string]queryStatsStruct, len(s.methods))
+	for method, q := range s.methods {
+		stats[method] = queryStatsStruct{
+			Calls:     q.calls,
+			Errors:    q.errors,
+			ErrorRate: float64(q.errors) / float64(q.calls),
+			AvgMs:     float64(q.total.Microseconds()) / float64(q.calls) / 1000,
+			MaxMs:     float64(q.max.Microseconds()) / 1000,
+			Rows:      q.rows,
+		}
+	}
+
+	return stats
+}
+
+// Бизнес-исходы запросов: почему запрос не выполнен, а не только с каким статусом HTTP
+const (
+	outcomeRegisterConflict  = "register_conflict"
+	outcomeOrderDuplicate    = "order_duplicate_same_user"
+	outcomeOrderOwnedByOther = "order_owned_by_other"
+	outcomeInsufficientFunds = "insufficient_funds"
+)
+
+// outcomeStats считает бизнес-исходы по обработчикам с момента запуска
+type outcomeStats struct {
+	mu       sync.Mutex
+	handlers map[string]map[string]int64
+}
+
+func newOutcomeStats() *outcomeStats {
+	return &outcomeStats{handlers: make(map[string]map[string]int64)}
+}
+
+func (s *outcomeStats) add(handler, outcome string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	outcomes, ok := s.handlers[handler]
+	if !ok {
+		outcomes = make(map[string]int64)
+		s.handlers[handler] = outcomes
+	}
+
+	outcomes[outcome]++
+}
+
+// snapshot возвращает копию счетчиков, nil - исходов еще не было
+func (s *outcomeStats) snapshot() map[string]map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.handlers) == 0 {
+		return nil
+	}
+
+	stats := make(map[string]map[string]int64, len(s.handlers))
+	for handler, outcomes := range s.handlers {
+		stats[handler] = maps.Clone(outcomes)
+	}
+
+	return stats
+}
+
+type dbStatsStruct struct {
+	MaxOpen        int   `json:"max_open_connections"`
+	Open           int   `json:"open_connections"`
+	InUse          int   `json:"in_use"`
+	Idle           int   `json:"idle"`
+	WaitCount      int64 `json:"wait_count"`
+	WaitDurationMs int64 `json:"wait_duration_ms"`
+}
+
+type metricsStruct struct {
+	UptimeSeconds int64         `json:"uptime_seconds"`
+	RequestsTotal int64         `json:"requests_total"`
+	RPS           float64       `json:"rps"`
+	QueueDepth    int64         `json:"queue_depth"`
+	DB            dbStatsStruct `json:"db"`
+	Degraded      []string      `json:"degraded_routes,omitempty"`
+	// Queries - вызовы хранилища по методам
+	Queries map[string]queryStatsStruct `json:"queries,omitempty"`
+	// Outcomes - бизнес-исходы по обработчикам
+	Outcomes map[string]map[string]int64 `json:"outcomes,omitempty"`
+}
+
+func (c *Controller) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	now := time.Now()
+	stats := c.db.Stats()
+	var degraded []string
+	if c.budgets != nil {
+		degraded = c.budgets.list(now)
+	}
+
+	marshal, err := json.Marshal(metricsStruct{
+		UptimeSeconds: int64(now.Sub(c.stats.started).Seconds()),
+		RequestsTotal: c.stats.total.Load(),
+		RPS:           c.stats.rps(now),
+		QueueDepth:    c.accrual.Pending(),
+		DB: dbStatsStruct{
+			MaxOpen:        stats.MaxOpenConnections,
+			Open:           stats.OpenConnections,
+			InUse:          stats.InUse,
+			Idle:           stats.Idle,
+			WaitCount:      stats.WaitCount,
+			WaitDurationMs: stats.WaitDuration.Milliseconds(),
+		},
+		Degraded: degraded,
+		Queries:  c.queries.snapshot(),
+		Outcomes: c.outcomes.snapshot(),
+	})
+	if err != nil {
+		log.Print("GetMetrics: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(marshal)
+}
+
+const (
+	readyOK       = "ready"
+	readyDegraded = "degraded"
+	readyDown     = "down"
+	readyDraining = "shutting_down"
+	readyWarmUp   = "warming_up"
+	componentUp   = "up"
+)
+
+type readyStruct struct {
+	Status   string `json:"status"`
+	Database string `json:"database"`
+	Accrual  string `json:"accrual"`
+}
+
+// GetReady сообщает готовность сервиса. Без базы сервис не работает - 503 и down. Без системы
+// расчета заказы принимаются, а начисления задерживаются - 200 и degraded. При прогреве - 503
+// и warming_up, при остановке - 503 и shutting_down, оба без проверки базы.
+func (c *Controller) GetReady(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"status":"` + readyDraining + `"}`))
+		return
+	}
+
+	if c.warmingUp.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"status":"` + readyWarmUp + `"}`))
+		return
+	}
+
+	ready := readyStruct{Status: readyOK, Database: componentUp, Accrual: componentUp}
+	status := http.StatusOK
+	if c.accrual.Down() {
+		ready.Status, ready.Accrual = readyDegraded, readyDown
+	}
+	if !c.db.Healthy() {
+		log.Print("GetReady: database is unhealthy")
+		ready.Status, ready.Database = readyDown, readyDown
+		status = http.StatusServiceUnavailable
+	}
+
+	marshal, err := json.Marshal(ready)
+	if err != nil {
+		log.Print("GetReady: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(status)
+	_, _ = w.Write(marshal)
+}
+
+type statusStruct struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Drain переводит сервис в остановку: GetStatus и GetReady отвечают 503, чтобы балансировщик
+// перестал направлять сюда новые запросы, пока начатые дорабатываются
+func (c *Controller) Drain() {
+	c.draining.Store(true)
+}
+
+// WarmUp сообщает, что экземпляр прогревается: GetStatus и GetReady отвечают 503, пока не вызван Ready
+func (c *Controller) WarmUp() {
+	c.warmingUp.Store(true)
+}
+
+// Ready завершает прогрев
+func (c *Controller) Ready() {
+	c.warmingUp.Store(false)
+}
+
+// GetStatus - проверка экземпляра для балансировщика: 200, пока он принимает запросы, и 503
+// с просьбой повторить запрос на другом экземпляре при прогреве и остановке
+func (c *Controller) GetStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	status := statusStruct{Status: "ok"}
+	code := http.StatusOK
+	switch {
+	case c.draining.Load():
+		status = statusStruct{Status: readyDraining, Message: "shutting down, retry on another instance"}
+		code = http.StatusServiceUnavailable
+	case c.warmingUp.Load():
+		status = statusStruct{Status: readyWarmUp, Message: "warming up, retry on another instance"}
+		code = http.StatusServiceUnavailable
+	}
+
+	marshal, err := json.Marshal(status)
+	if err != nil {
+		log.Print("GetStatus: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(code)
+	_, _ = w.Write(marshal)
+}
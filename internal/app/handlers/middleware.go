@@ -2,46 +2,150 @@ package handlers
 
 import (
 	"compress/gzip"
-	"context"
-	"crypto/aes"
-	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/auth"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 type Middleware func(http.Handler) http.Handler
 
 func (c *Controller) MiddlewaresConveyor(h http.Handler) http.Handler {
-	middlewares := []Middleware{gzipMiddleware, c.cookieMiddleware}
+	middlewares := []Middleware{c.errorsMiddleware, c.gzipMiddleware, c.csrfCookieMiddleware, c.impersonationMiddleware,
+		c.cookieMiddleware, c.statsMiddleware}
+	if c.c.AuthMode == config.AuthModeJWT {
+		middlewares = []Middleware{c.errorsMiddleware, c.gzipMiddleware, c.impersonationMiddleware, c.jwtMiddleware, c.statsMiddleware}
+	}
+	middlewares = append(middlewares, middleware.RequestID)
+
 	for _, middleware := range middlewares {
 		h = middleware(h)
 	}
 	return h
 }
 
+// gzipMinLength - ответы с объявленным Content-Length меньше этого размера не сжимаются
+const gzipMinLength = 256
+
+// gzipWriter решает, сжимать ли ответ, в момент отправки заголовков: ответы,
+// для которых обработчик сам выставил Content-Encoding, пустые и короткие ответы
+// передаются как есть
 type gzipWriter struct {
 	http.ResponseWriter
-	Writer io.Writer
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (w *gzipWriter) decide(status int) error {
+	if w.decided {
+		return nil
+	}
+	w.decided = true
+
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || status < http.StatusOK ||
+		status == http.StatusNoContent || status == http.StatusNotModified {
+		return nil
+	}
+
+	if cl, err := strconv.Atoi(h.Get("Content-Length")); err == nil && cl < gzipMinLength {
+		return nil
+	}
+
+	gz, err := gzip.NewWriterLevel(w.ResponseWriter, gzip.BestSpeed)
+	if err != nil {
+		return err
+	}
+
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	h.Add("Vary", "Accept-Encoding")
+	w.gz = gz
+
+	return nil
 }
 
-func (w gzipWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
+func (w *gzipWriter) WriteHeader(status int) {
+	if err := w.decide(status); err != nil {
+		log.Print("gzipWriter: new writer level err: ", err.Error())
+	}
+
+	w.ResponseWriter.WriteHeader(status)
 }
 
-func gzipMiddleware(next http.Handler) http.Handler {
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if err := w.decide(http.StatusOK); err != nil {
+		return 0, err
+	}
+
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+
+	return w.gz.Write(b)
+}
+
+func (w *gzipWriter) Close() error {
+	if w.gz == nil {
+		return nil
+	}
+
+	return w.gz.Close()
+}
+
+// acceptEncoding разбирает Accept-Encoding с учетом весов q
+func acceptEncoding(header string) (gzipOK, identityOK bool) {
+	gzipQ, identityQ, anyQ := -1.0, -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if f, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64); err == nil {
+				q = f
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "identity":
+			identityQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+
+	if gzipQ < 0 {
+		gzipQ = anyQ
+	}
+
+	if identityQ < 0 {
+		identityQ = 1
+		if anyQ == 0 {
+			identityQ = 0
+		}
+	}
+
+	return gzipQ > 0, identityQ > 0
+}
+
+func (c *Controller) gzipMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
 			gz, err := gzip.NewReader(r.Body)
 			if err != nil {
 				log.Print("gzipMiddleware: new reader err: ", err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
+				c.renderError(w, r, http.StatusInternalServerError, err)
 				return
 			}
 
@@ -52,24 +156,23 @@ func gzipMiddleware(next http.Handler) http.Handler {
 			r.Body = gz
 		}
 
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			next.ServeHTTP(w, r)
-			return
-		}
+		gzipOK, identityOK := acceptEncoding(r.Header.Get("Accept-Encoding"))
+		if !gzipOK {
+			if !identityOK {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
 
-		gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
-		if err != nil {
-			log.Print("gzipMiddleware: new writer level err: ", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+			next.ServeHTTP(w, r)
 			return
 		}
 
+		gw := &gzipWriter{ResponseWriter: w}
 		defer func() {
-			_ = gz.Close()
+			_ = gw.Close()
 		}()
 
-		w.Header().Set("Content-Encoding", "gzip")
-		next.ServeHTTP(gzipWriter{ResponseWriter: w, Writer: gz}, r)
+		next.ServeHTTP(gw, r)
 	})
 }
 
@@ -83,45 +186,81 @@ func generateRandom(size int) ([]byte, error) {
 	return b, nil
 }
 
-func makeUserIdentification() (string, error) {
-	str := time.Now().Format("02012006150405")
-
-	key, err := generateRandom(aes.BlockSize)
-	if err != nil {
-		return "", err
-	}
+// signToken подписывает идентификатор сессии: <id>.<hmac-sha256(id)>
+func signToken(key, id string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(id))
+	return id + "." + hex.EncodeToString(mac.Sum(nil))
+}
 
-	aesblock, err := aes.NewCipher(key)
-	if err != nil {
-		return "", err
+// verifyToken проверяет подпись токена и возвращает идентификатор сессии
+func verifyToken(key, token string) (string, bool) {
+	id, sig, found := strings.Cut(token, ".")
+	if !found || id == "" {
+		return "", false
 	}
 
-	aesgcm, err := cipher.NewGCM(aesblock)
+	want, err := hex.DecodeString(sig)
 	if err != nil {
-		return "", err
+		return "", false
 	}
 
-	nonce, err := generateRandom(aesgcm.NonceSize())
-	if err != nil {
-		return "", err
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(id))
+	if !hmac.Equal(mac.Sum(nil), want) {
+		return "", false
 	}
 
-	id := fmt.Sprintf("%x", aesgcm.Seal(nil, nonce, []byte(str), nil))
-
-	return id, nil
+	return id, true
 }
 
 var userIdentification = "user_identification"
 
 var userLogin = "user_login"
 
-var identification struct {
-	cookie string
+var sameSiteModes = map[string]http.SameSite{
+	config.SameSiteLax:    http.SameSiteLaxMode,
+	config.SameSiteStrict: http.SameSiteStrictMode,
+	config.SameSiteNone:   http.SameSiteNoneMode,
+}
+
+// cookie собирает cookie с атрибутами из конфигурации. HttpOnly применяется только к cookie сессии:
+// логин и CSRF-токен должны оставаться доступными скрипту клиента.
+func (c *Controller) cookie(name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     c.c.CookiePath,
+		Domain:   c.c.CookieDomain,
+		MaxAge:   maxAge,
+		HttpOnly: c.c.CookieHTTPOnly && name == userIdentification,
+		Secure:   c.c.CookieSecure,
+		SameSite: sameSiteModes[c.c.CookieSameSite],
+	}
+}
+
+func (c *Controller) setCookie(w http.ResponseWriter, name, value string) {
+	http.SetCookie(w, c.cookie(name, value, int(c.db.Settings().SessionTTL.Seconds())))
+}
+
+// openSession заменяет сессию cookie сессией пользователя login с новым идентификатором и выдает
+// его в cookie: идентификатор, известный до входа, после него не действует
+func (c *Controller) openSession(w http.ResponseWriter, login, cookie string) error {
+	uid, err := c.tokens.New()
+	if err != nil {
+		return err
+	}
+
+	if err = c.db.OpenSession(login, cookie, uid); err != nil {
+		return err
+	}
+
+	c.setCookie(w, userIdentification, signToken(c.c.SessionKey, uid))
+	return nil
 }
 
-type cookieStruct struct {
-	ID    string `json:"id"`
-	Login string `json:"login"`
+func (c *Controller) clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, c.cookie(name, "", -1))
 }
 
 func (c *Controller) cookieMiddleware(next http.Handler) http.Handler {
@@ -132,55 +271,56 @@ func (c *Controller) cookieMiddleware(next http.Handler) http.Handler {
 		if err != nil {
 			if !errors.Is(err, http.ErrNoCookie) {
 				log.Print("cookieMiddleware: r.Cookie err: ", err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
+				c.renderError(w, r, http.StatusInternalServerError, err)
 				return
 			}
 
-			uid, err = makeUserIdentification()
+			uid, err = c.tokens.New()
 			if err != nil {
 				log.Print("cookieMiddleware: set user identification err: ", err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
+				c.renderError(w, r, http.StatusInternalServerError, err)
 				return
 			}
 
-			http.SetCookie(w, &http.Cookie{
-				Name:     userIdentification,
-				Value:    uid,
-				Path:     "/",
-				MaxAge:   3600,
-				HttpOnly: false,
-				Secure:   false,
-				SameSite: http.SameSiteLaxMode,
-			})
+			ua, ip := clientInfo(r)
+			err = c.db.NewSession(uid, ua, ip)
+			if err != nil {
+				log.Print("cookieMiddleware: new session err: ", err.Error())
+				c.renderError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+
+			c.setCookie(w, userIdentification, signToken(c.c.SessionKey, uid))
 		} else {
-			uid = cookie.Value
+			var ok bool
+			uid, ok = verifyToken(c.c.SessionKey, cookie.Value)
+			if !ok {
+				log.Printf("cookieMiddleware: %d, bad cookie signature: %s", http.StatusUnauthorized, cookie.Value)
+				c.clearCookie(w, userIdentification)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
 		}
 
-		login, err := c.db.Authentication(uid)
+		session, err := c.db.GetSessionClient(uid)
 		if err != nil {
 			log.Print("cookieMiddleware: set user authentication err: ", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+			c.renderError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     userLogin,
-			Value:    login,
-			Path:     "/",
-			MaxAge:   3600,
-			HttpOnly: false,
-			Secure:   false,
-			SameSite: http.SameSiteLaxMode,
-		})
-
-		marshal, err := json.Marshal(cookieStruct{ID: uid, Login: login})
-		if err != nil {
-			log.Print("cookieMiddleware: marshal err: ", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		// запросы администратора от имени пользователя не меняют активность сессий пользователя
+		login := session.Login
+		if login != "" && session.Impersonator == "" {
+			c.checkAnomaly(r, uid, session)
+			if c.touches != nil {
+				c.touches.touch(uid, time.Now())
+			}
 		}
 
-		ctx := context.WithValue(r.Context(), identification, marshal)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		c.setCookie(w, userLogin, login)
+
+		id := auth.UserID{ID: uid, Login: login, Impersonator: session.Impersonator, ReadOnly: session.ReadOnly}
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), id)))
 	})
 }
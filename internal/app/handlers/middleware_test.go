@@ -0,0 +1,685 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestVerifyToken(t *testing.T) {
+	const key = "secret"
+
+	tests := []struct {
+		name   string
+		token  string
+		want   string
+		wantOk bool
+	}{
+		{
+			name:   "Подписанный токен",
+			token:  signToken(key, "0a1b2c"),
+			want:   "0a1b2c",
+			wantOk: true,
+		},
+		{
+			name:   "Другой ключ",
+			token:  signToken("other", "0a1b2c"),
+			want:   "",
+			wantOk: false,
+		},
+		{
+			name:   "Подмененный идентификатор",
+			token:  "ffffff" + signToken(key, "0a1b2c")[6:],
+			want:   "",
+			wantOk: false,
+		},
+		{
+			name:   "Без подписи",
+			token:  "0a1b2c",
+			want:   "",
+			wantOk: false,
+		},
+		{
+			name:   "Подпись не hex",
+			token:  "0a1b2c.zz",
+			want:   "",
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := verifyToken(key, tt.token)
+			if ok != tt.wantOk {
+				t.Errorf("verifyToken() ok = %v, want %v", ok, tt.wantOk)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("verifyToken() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJWT(t *testing.T) {
+	const key = "secret"
+
+	token, err := makeJWT(key, "username")
+	if err != nil {
+		t.Fatalf("makeJWT() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		token   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "Верный токен",
+			key:     key,
+			token:   token,
+			want:    "username",
+			wantErr: false,
+		},
+		{
+			name:    "Другой ключ",
+			key:     "other",
+			token:   token,
+			want:    "",
+			wantErr: true,
+		},
+		{
+			name:    "Мусор",
+			key:     key,
+			token:   "a.b.c",
+			want:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := parseJWT(tt.key, tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseJWT() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got := claims.Subject; got != tt.want {
+				t.Errorf("parseJWT() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("Вход от имени пользователя", func(t *testing.T) {
+		now := time.Now()
+		token, err := signJWT(key, jwtClaims{
+			RegisteredClaims: jwt.RegisteredClaims{Subject: "username", IssuedAt: jwt.NewNumericDate(now), ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute))},
+			Act:              &jwtActor{Subject: "admin"},
+			ReadOnly:         true,
+		})
+		if err != nil {
+			t.Fatalf("signJWT() error = %v", err)
+		}
+
+		claims, err := parseJWT(key, token)
+		if err != nil || claims.Subject != "username" || claims.Act == nil || claims.Act.Subject != "admin" || !claims.ReadOnly {
+			t.Errorf("parseJWT() = %+v, %v, want username acted by admin, read only", claims, err)
+		}
+	})
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		wantGzip  bool
+		wantPlain bool
+	}{
+		{name: "Пустой заголовок", header: "", wantGzip: false, wantPlain: true},
+		{name: "gzip", header: "gzip, deflate", wantGzip: true, wantPlain: true},
+		{name: "gzip запрещен", header: "gzip;q=0, deflate", wantGzip: false, wantPlain: true},
+		{name: "identity запрещен", header: "identity;q=0", wantGzip: false, wantPlain: false},
+		{name: "Любое кодирование", header: "*;q=0.5", wantGzip: true, wantPlain: true},
+		{name: "Только gzip", header: "gzip, *;q=0", wantGzip: true, wantPlain: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotGzip, gotPlain := acceptEncoding(tt.header)
+			if gotGzip != tt.wantGzip || gotPlain != tt.wantPlain {
+				t.Errorf("acceptEncoding() got = %v, %v, want %v, %v", gotGzip, gotPlain, tt.wantGzip, tt.wantPlain)
+			}
+		})
+	}
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	body := strings.Repeat("a", gzipMinLength)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		accept  string
+		want    string
+		status  int
+	}{
+		{
+			name: "Сжатие ответа",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(body))
+			},
+			accept: "gzip",
+			want:   "gzip",
+			status: http.StatusOK,
+		},
+		{
+			name: "Ответ уже сжат обработчиком",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "br")
+				_, _ = w.Write([]byte(body))
+			},
+			accept: "gzip",
+			want:   "br",
+			status: http.StatusOK,
+		},
+		{
+			name: "Пустой ответ",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			accept: "gzip",
+			want:   "",
+			status: http.StatusNoContent,
+		},
+		{
+			name: "Короткий ответ",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "2")
+				_, _ = w.Write([]byte("{}"))
+			},
+			accept: "gzip",
+			want:   "",
+			status: http.StatusOK,
+		},
+		{
+			name: "Нет приемлемого кодирования",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(body))
+			},
+			accept: "identity;q=0",
+			want:   "",
+			status: http.StatusNotAcceptable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.accept)
+			w := httptest.NewRecorder()
+
+			(&Controller{}).gzipMiddleware(tt.handler).ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("gzipMiddleware() status = %v, want %v", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.want {
+				t.Errorf("gzipMiddleware() Content-Encoding = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		authMode string
+		method   string
+		origin   string
+		fetch    string
+		cookie   string
+		header   string
+		want     int
+	}{
+		{name: "Чтение без токена", authMode: "cookie", method: http.MethodGet, origin: "http://shop", want: http.StatusOK},
+		{name: "Совпадающий токен", authMode: "cookie", method: http.MethodPost, origin: "http://shop", cookie: "abc", header: "abc", want: http.StatusAccepted},
+		{name: "Без заголовка", authMode: "cookie", method: http.MethodPost, origin: "http://shop", cookie: "abc", want: http.StatusForbidden},
+		{name: "Без cookie", authMode: "cookie", method: http.MethodPost, origin: "http://shop", header: "abc", want: http.StatusForbidden},
+		{name: "Другой токен", authMode: "cookie", method: http.MethodPut, origin: "http://shop", cookie: "abc", header: "abd", want: http.StatusForbidden},
+		{name: "Браузер без Origin", authMode: "cookie", method: http.MethodPost, fetch: "same-origin", want: http.StatusForbidden},
+		{name: "Клиент по спецификации", authMode: "cookie", method: http.MethodPost, cookie: "abc", want: http.StatusAccepted},
+		{name: "JWT без токена", authMode: "jwt", method: http.MethodPost, origin: "http://shop", want: http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{}
+			c.c.AuthMode = tt.authMode
+			h := c.CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					w.WriteHeader(http.StatusAccepted)
+				}
+			}))
+
+			r := httptest.NewRequest(tt.method, "/api/user/orders", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.fetch != "" {
+				r.Header.Set("Sec-Fetch-Site", tt.fetch)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: csrfCookie, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				r.Header.Set(csrfHeader, tt.header)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("CSRFMiddleware() status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestStats(t *testing.T) {
+	s := newRequestStats()
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 30; i++ {
+		s.add(now.Add(-time.Duration(i%3+1) * time.Second))
+	}
+	s.add(now)
+	s.add(now.Add(-2 * rpsWindow * time.Second))
+
+	if got := s.total.Load(); got != 32 {
+		t.Errorf("total = %d, want 32", got)
+	}
+	if got := s.rps(now); got != 30.0/rpsWindow {
+		t.Errorf("rps() = %g, want %g", got, 30.0/rpsWindow)
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	var s *QueryStats
+	if got := s.snapshot(); got != nil {
+		t.Errorf("nil snapshot() = %v, want nil", got)
+	}
+
+	s = NewQueryStats()
+	s.ObserveQuery("GetOrders", 2*time.Millisecond, 10, nil)
+	s.ObserveQuery("GetOrders", 4*time.Millisecond, 0, errors.New("conn closed"))
+	s.ObserveQuery("UpdateOrder", time.Millisecond, 1, nil)
+
+	want := map[string]queryStatsStruct{
+		"GetOrders":   {Calls: 2, Errors: 1, ErrorRate: 0.5, AvgMs: 3, MaxMs: 4, Rows: 10},
+		"UpdateOrder": {Calls: 1, AvgMs: 1, MaxMs: 1, Rows: 1},
+	}
+	if got := s.snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot() = %v, want %v", got, want)
+	}
+}
+
+func TestOutcomeStats(t *testing.T) {
+	s := newOutcomeStats()
+	if got := s.snapshot(); got != nil {
+		t.Errorf("empty snapshot() = %v, want nil", got)
+	}
+
+	s.add("PostOrders", outcomeOrderOwnedByOther)
+	s.add("PostOrders", outcomeOrderOwnedByOther)
+	s.add("PostOrders", outcomeOrderDuplicate)
+	s.add("PostWithDraw", outcomeInsufficientFunds)
+
+	want := map[string]map[string]int64{
+		"PostOrders":   {outcomeOrderOwnedByOther: 2, outcomeOrderDuplicate: 1},
+		"PostWithDraw": {outcomeInsufficientFunds: 1},
+	}
+	got := s.snapshot()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("snapshot() = %v, want %v", got, want)
+	}
+
+	// снимок - копия: счетчики после него не меняют выданное
+	s.add("PostWithDraw", outcomeInsufficientFunds)
+	if got["PostWithDraw"][outcomeInsufficientFunds] != 1 {
+		t.Errorf("snapshot() changed after add: %v", got)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(60, 2)
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.take(now, "ip:1", "login:a"); !ok {
+			t.Fatalf("take() #%d = false, want true", i)
+		}
+	}
+
+	ok, wait := l.take(now, "ip:1", "login:a")
+	if ok || wait != time.Second {
+		t.Errorf("take() over burst = %v, %s, want false, 1s", ok, wait)
+	}
+
+	if ok, _ = l.take(now, "ip:2", "login:a"); ok {
+		t.Error("take() same login from other ip = true, want false")
+	}
+
+	if ok, _ = l.take(now, "ip:2", "login:b"); !ok {
+		t.Error("take() other login from other ip = false, want true")
+	}
+
+	if ok, _ = l.take(now.Add(time.Second), "ip:1", "login:a"); !ok {
+		t.Error("take() after refill = false, want true")
+	}
+}
+
+func TestErrorBudgets(t *testing.T) {
+	b := newErrorBudgets(0.5, time.Minute, 4, time.Minute)
+	now := time.Unix(1000, 0)
+	const route = "POST /api/user/balance/withdraw"
+
+	for i, failed := range []bool{true, false, true} {
+		if b.observe(route, now, failed) {
+			t.Fatalf("observe() #%d tripped below min requests", i)
+		}
+	}
+
+	if !b.observe(route, now, true) {
+		t.Fatal("observe() = false, want budget exceeded")
+	}
+
+	if ok, wait := b.degraded(route, now.Add(time.Second)); !ok || wait != 59*time.Second {
+		t.Errorf("degraded() = %v, %s, want true, 59s", ok, wait)
+	}
+
+	if ok, _ := b.degraded("GET /api/user/balance", now); ok {
+		t.Error("degraded() other route = true, want false")
+	}
+
+	if ok, _ := b.degraded(route, now.Add(time.Minute)); ok {
+		t.Error("degraded() after degraded duration = true, want false")
+	}
+}
+
+func TestErrorBudgetMiddleware(t *testing.T) {
+	c := &Controller{budgets: newErrorBudgets(0.5, time.Minute, 2, time.Minute)}
+
+	r := chi.NewRouter()
+	r.With(c.ErrorBudgetMiddleware).Post("/api/user/balance/withdraw", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	r.With(c.ErrorBudgetMiddleware).Get("/api/user/balance/withdraw", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, want := range []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", nil))
+		if w.Code != want {
+			t.Errorf("POST status = %d, want %d", w.Code, want)
+		}
+	}
+
+	if degraded := c.budgets.list(time.Now()); len(degraded) != 1 || degraded[0] != "POST /api/user/balance/withdraw" {
+		t.Errorf("list() = %v", degraded)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/user/balance/withdraw", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestReportedAnomalies(t *testing.T) {
+	a := newReportedAnomalies()
+
+	if !a.first("session|10.0.0.1") {
+		t.Error("first() new key = false, want true")
+	}
+	if a.first("session|10.0.0.1") {
+		t.Error("first() repeated key = true, want false")
+	}
+	if !a.first("session|10.9.0.1") {
+		t.Error("first() new address = false, want true")
+	}
+}
+
+func TestSessionTouches(t *testing.T) {
+	touches := newSessionTouches()
+	now := time.Now()
+
+	for i := 0; i < 100; i++ {
+		touches.touch("a", now.Add(time.Duration(i)*time.Millisecond))
+	}
+	touches.touch("b", now)
+
+	seen := touches.take()
+	if len(seen) != 2 {
+		t.Fatalf("take() = %d sessions, want 2", len(seen))
+	}
+	if !seen["a"].Equal(now.Add(99 * time.Millisecond)) {
+		t.Errorf("take() a = %s, want last touch", seen["a"])
+	}
+
+	if seen = touches.take(); len(seen) != 0 {
+		t.Errorf("take() after take = %d sessions, want 0", len(seen))
+	}
+}
+
+type touchStorage struct {
+	Storage
+	seen map[string]time.Time
+}
+
+func (s *touchStorage) TouchSessions(seen map[string]time.Time) error {
+	for id, at := range seen {
+		s.seen[id] = at
+	}
+	return nil
+}
+
+func TestFlushTouches(t *testing.T) {
+	s := &touchStorage{seen: map[string]time.Time{}}
+	c := &Controller{c: config.Config{SessionTouchInterval: time.Hour}, db: s, touches: newSessionTouches()}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() {
+		done <- c.FlushTouches(ctx)
+	}()
+
+	// пачка, накопленная до остановки, записывается при отмене ctx, не дожидаясь интервала
+	now := time.Now()
+	c.touches.touch("a", now)
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatalf("FlushTouches() err = %v", err)
+	}
+	if !s.seen["a"].Equal(now) {
+		t.Errorf("TouchSessions() a = %s, want %s", s.seen["a"], now)
+	}
+}
+
+func TestErrorsMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		handler   func(c *Controller) http.HandlerFunc
+		wantCause string
+	}{
+		{
+			name: "prod",
+			mode: config.ModeProd,
+			handler: func(c *Controller) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					c.renderError(w, r, http.StatusInternalServerError, errors.New("connection refused"))
+				}
+			},
+		},
+		{
+			name: "dev",
+			mode: config.ModeDev,
+			handler: func(c *Controller) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					c.renderError(w, r, http.StatusInternalServerError, errors.New("connection refused"))
+				}
+			},
+			wantCause: "connection refused",
+		},
+		{
+			name: "без тела",
+			mode: config.ModeDev,
+			handler: func(c *Controller) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{c: config.Config{Mode: tt.mode}}
+			h := middleware.RequestID(c.errorsMiddleware(tt.handler(c)))
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/user/orders", nil))
+
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want 500", w.Code)
+			}
+
+			var body errorStruct
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q: %s", w.Body.String(), err)
+			}
+			if body.Code != http.StatusInternalServerError || body.RequestID == "" || body.RequestID != w.Header().Get("X-Request-Id") {
+				t.Errorf("body = %+v, X-Request-Id = %q", body, w.Header().Get("X-Request-Id"))
+			}
+			if body.Cause != tt.wantCause {
+				t.Errorf("cause = %q, want %q", body.Cause, tt.wantCause)
+			}
+			if (body.Hint != "") != (tt.wantCause != "") || (body.Hint != "" && !strings.Contains(body.Hint, "middleware_test.go")) {
+				t.Errorf("hint = %q", body.Hint)
+			}
+		})
+	}
+
+	t.Run("тело обработчика", func(t *testing.T) {
+		c := &Controller{}
+		h := c.errorsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"status":"down"}`))
+		}))
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusServiceUnavailable || w.Body.String() != `{"status":"down"}` {
+			t.Errorf("got %d %q, want handler response", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestDrain(t *testing.T) {
+	c := &Controller{}
+
+	w := httptest.NewRecorder()
+	c.GetStatus(w, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GetStatus() status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	c.Drain()
+
+	w = httptest.NewRecorder()
+	c.GetStatus(w, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+	if want := `{"status":"shutting_down","message":"shutting down, retry on another instance"}`; w.Code != http.StatusServiceUnavailable || w.Body.String() != want {
+		t.Errorf("GetStatus() = %d %s, want %d %s", w.Code, w.Body, http.StatusServiceUnavailable, want)
+	}
+
+	w = httptest.NewRecorder()
+	c.GetReady(w, httptest.NewRequest(http.MethodGet, "/api/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GetReady() status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	c := &Controller{}
+	c.WarmUp()
+
+	w := httptest.NewRecorder()
+	c.GetStatus(w, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+	if want := `{"status":"warming_up","message":"warming up, retry on another instance"}`; w.Code != http.StatusServiceUnavailable || w.Body.String() != want {
+		t.Errorf("GetStatus() = %d %s, want %d %s", w.Code, w.Body, http.StatusServiceUnavailable, want)
+	}
+
+	w = httptest.NewRecorder()
+	c.GetReady(w, httptest.NewRequest(http.MethodGet, "/api/ready", nil))
+	if want := `{"status":"warming_up"}`; w.Code != http.StatusServiceUnavailable || w.Body.String() != want {
+		t.Errorf("GetReady() = %d %s, want %d %s", w.Code, w.Body, http.StatusServiceUnavailable, want)
+	}
+
+	c.Ready()
+
+	w = httptest.NewRecorder()
+	c.GetStatus(w, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GetStatus() after Ready status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestReadImport(t *testing.T) {
+	rows, errs, err := readImport(strings.NewReader("login,balance\nalice,150.5\nbob, 0\n"))
+	if err != nil || errs != nil {
+		t.Fatalf("readImport() errs = %v, err = %v", errs, err)
+	}
+	if want := []importRow{{login: "alice", balance: 150.5}, {login: "bob"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("readImport() = %v, want %v", rows, want)
+	}
+
+	_, errs, err = readImport(strings.NewReader("alice,-1\nbad login,10\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 2 || errs[0].Field != "line 1: balance" || errs[1].Field != "line 2: login" {
+		t.Errorf("readImport() errs = %v", errs)
+	}
+
+	if _, _, err = readImport(strings.NewReader("alice\n")); err == nil {
+		t.Error("readImport() one column err = nil, want error")
+	}
+}
+
+func TestSetNextLink(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		n     int
+		want  string
+	}{
+		{name: "полная страница", limit: 2, n: 2, want: `</api/user/withdrawals?after=7&limit=2>; rel="next"`},
+		{name: "последняя страница", limit: 2, n: 1},
+		{name: "без limit", n: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			setNextLink(w, httptest.NewRequest(http.MethodGet, "/api/user/withdrawals?limit=2&offset=2", nil), tt.limit, tt.n, 7)
+			if got := w.Header().Get("Link"); got != tt.want {
+				t.Errorf("Link = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/auth"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/notify"
+)
+
+// GetNotificationPreferences отдает канал доставки уведомлений для каждого события
+func (c *Controller) GetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("GetNotificationPreferences: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("GetNotificationPreferences: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	prefs, err := c.db.GetNotificationPreferences(cookie.Login)
+	if err != nil {
+		log.Printf("GetNotificationPreferences: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	marshal, err := json.Marshal(prefs)
+	if err != nil {
+		log.Print("GetNotificationPreferences: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, err = w.Write(marshal)
+	if err != nil {
+		log.Print("GetNotificationPreferences: w write err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("GetNotificationPreferences: %d, cookie: %s", http.StatusOK, cookie)
+}
+
+// PutNotificationPreferences меняет каналы событий из тела запроса, остальные события не меняются
+func (c *Controller) PutNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PutNotificationPreferences: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("PutNotificationPreferences: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Print("PutNotificationPreferences: read all err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	var prefs notify.Preferences
+	if err = json.Unmarshal(b, &prefs); err != nil || len(prefs) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err = prefs.Validate(); err != nil {
+		if errors.Is(err, notify.ErrUnsupported) {
+			log.Printf("PutNotificationPreferences: %d, cookie: %s, prefs: %v", http.StatusUnprocessableEntity, cookie, prefs)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		log.Printf("PutNotificationPreferences: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = c.db.SetNotificationPreferences(cookie.Login, prefs)
+	if err != nil {
+		log.Printf("PutNotificationPreferences: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("PutNotificationPreferences: %d, cookie: %s, prefs: %v", http.StatusOK, cookie, prefs)
+	w.WriteHeader(http.StatusOK)
+}
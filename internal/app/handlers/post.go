@@ -8,23 +8,42 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/accrual"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/auth"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
 	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
-	"github.com/chazari-x/yandex-pr-diplom/internal/app/worker"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/domain"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/fraud"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/notify"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/receipt"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/totp"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/validation"
+	"github.com/chazari-x/yandex-pr-diplom/pkg/api"
 )
 
-type userStruct struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+// writeValidationErrors отвечает 400 со списком ошибок в теле
+func writeValidationErrors(w http.ResponseWriter, errs validation.Errors) {
+	marshal, err := json.Marshal(struct {
+		Errors validation.Errors `json:"errors"`
+	}{Errors: errs})
+	if err != nil {
+		log.Print("writeValidationErrors: json marshal err: ", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write(marshal)
 }
 
 func (c *Controller) PostRegister(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var cookie cookieStruct
-	err := json.Unmarshal([]byte(fmt.Sprintf("%s", r.Context().Value(identification))), &cookie)
-	if err != nil {
-		log.Print("PostRegister: unmarshal cookie err: ", err.Error())
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PostRegister: no user identification in context")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -32,7 +51,7 @@ func (c *Controller) PostRegister(w http.ResponseWriter, r *http.Request) {
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Print("PostRegister: read all err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -41,42 +60,74 @@ func (c *Controller) PostRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user := userStruct{}
+	user := api.Credentials{}
 	err = json.Unmarshal(b, &user)
 	if err != nil {
 		log.Print("PostRegister: json unmarshal err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		writeValidationErrors(w, validation.Errors{{Field: "body", Message: "must be a JSON object with login and password"}})
+		return
+	}
+
+	if errs := validation.Credentials(user.Login, user.Password); errs != nil {
+		log.Printf("PostRegister: %d, cookie: %s, err: %s", http.StatusBadRequest, cookie, errs.Error())
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	if c.c.AuthBackend != auth.BackendLocal {
+		// учетными записями управляет внешний провайдер, пользователь создается при первом входе
+		log.Printf("PostRegister: %d, cookie: %s, login: %s, auth backend: %s",
+			http.StatusForbidden, cookie, user.Login, c.c.AuthBackend)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if c.c.EmailVerification {
+		c.registerPending(w, r, cookie, user)
+		return
+	}
+
+	// после регистрации сессия получает новый идентификатор, прежний, известный до входа, не действует
+	uid, err := c.tokens.New()
+	if err != nil {
+		log.Print("PostRegister: make user identification err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	err = c.db.Register(user.Login, user.Password, cookie.ID)
+	err = c.db.Register(user.Login, user.Password, cookie.ID, uid)
 	if err != nil {
 		if errors.Is(err, database.ErrRegisterConflict) {
-			log.Printf("PostRegister: %d, cookie: %s, login: %s, password: %s",
-				http.StatusConflict, cookie, user.Login, user.Password)
+			c.outcomes.add("PostRegister", outcomeRegisterConflict)
+			log.Printf("PostRegister: %d, cookie: %s, login: %s", http.StatusConflict, cookie, user.Login)
 			w.WriteHeader(http.StatusConflict)
 			return
 		}
 
-		log.Printf("PostRegister: %s, cookie: %s, login: %s, password: %s",
-			err.Error(), cookie, user.Login, user.Password)
-		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("PostRegister: %s, cookie: %s, login: %s", err.Error(), cookie, user.Login)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	authorization, err := c.authorization(user.Login)
+	if err != nil {
+		log.Print("PostRegister: authorization err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	w.Header().Set("Authorization", user.Login)
-	log.Printf("PostRegister: %d, cookie: %s, login: %s, password: %s",
-		http.StatusOK, cookie, user.Login, user.Password)
+	c.setCookie(w, userIdentification, signToken(c.c.SessionKey, uid))
+	w.Header().Set("Authorization", authorization)
+	log.Printf("PostRegister: %d, cookie: %s, login: %s", http.StatusOK, cookie, user.Login)
 	w.WriteHeader(http.StatusOK)
 }
 
 func (c *Controller) PostLogin(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var cookie cookieStruct
-	err := json.Unmarshal([]byte(fmt.Sprintf("%s", r.Context().Value(identification))), &cookie)
-	if err != nil {
-		log.Print("PostLogin: unmarshal cookie err: ", err.Error())
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PostLogin: no user identification in context")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -84,7 +135,7 @@ func (c *Controller) PostLogin(w http.ResponseWriter, r *http.Request) {
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Print("PostLogin: read all err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -93,38 +144,179 @@ func (c *Controller) PostLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user := userStruct{}
+	user := api.Credentials{}
 	err = json.Unmarshal(b, &user)
 	if err != nil {
 		log.Print("PostLogin: json unmarshal err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		writeValidationErrors(w, validation.Errors{{Field: "body", Message: "must be a JSON object with login and password"}})
+		return
+	}
+
+	if errs := validation.Credentials(user.Login, user.Password); errs != nil {
+		log.Printf("PostLogin: %d, cookie: %s, err: %s", http.StatusBadRequest, cookie, errs.Error())
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	secret, enabled, err := c.db.GetTOTP(user.Login)
+	if err != nil {
+		log.Printf("PostLogin: get totp err: %s, login: %s", err.Error(), user.Login)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = c.auth.Authenticate(r.Context(), user.Login, user.Password)
+	if errors.Is(err, database.ErrNotVerified) {
+		log.Printf("PostLogin: %d, cookie: %s, login: %s, email not verified", http.StatusForbidden, cookie, user.Login)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if err != nil && !errors.Is(err, auth.ErrInvalidCredentials) {
+		log.Printf("PostLogin: authenticate err: %s, login: %s", err.Error(), user.Login)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if enabled && err == nil && !totp.Validate(secret, user.TOTP, time.Now()) {
+		log.Printf("PostLogin: %d, cookie: %s, login: %s, totp challenge", http.StatusUnauthorized, cookie, user.Login)
+		w.Header().Set("WWW-Authenticate", `TOTP realm="gophermart"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"challenge":"totp"}`))
 		return
 	}
 
 	var status = http.StatusOK
-	err = c.db.Login(user.Login, user.Password, cookie.ID)
 	if err != nil {
-		if !errors.Is(err, database.ErrWrongData) {
-			log.Printf("PostLogin: %s, login: %s, password: %s", err.Error(), user.Login, user.Password)
-			w.WriteHeader(http.StatusInternalServerError)
+		status = http.StatusUnauthorized
+	} else if err = c.openSession(w, user.Login, cookie.ID); err != nil {
+		log.Printf("PostLogin: %s, login: %s", err.Error(), user.Login)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if status == http.StatusOK {
+		ua, ip := clientInfo(r)
+		if err = c.notify.Event(r.Context(), user.Login, notify.EventLoginAlert,
+			fmt.Sprintf("Вход в аккаунт с адреса %s (%s)", ip, ua)); err != nil {
+			log.Printf("PostLogin: notify err: %s, login: %s", err.Error(), user.Login)
+		}
+	}
+
+	if status == http.StatusOK || c.c.AuthMode != config.AuthModeJWT {
+		authorization, err := c.authorization(user.Login)
+		if err != nil {
+			log.Print("PostLogin: authorization err: ", err.Error())
+			c.renderError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		status = http.StatusUnauthorized
+		w.Header().Set("Authorization", authorization)
 	}
 
-	w.Header().Set("Authorization", user.Login)
-	log.Printf("PostLogin: %d, cookie: %s, login: %s, password: %s", status, cookie, user.Login, user.Password)
+	log.Printf("PostLogin: %d, cookie: %s, login: %s", status, cookie, user.Login)
 	w.WriteHeader(status)
 }
 
-func (c *Controller) PostOrders(w http.ResponseWriter, r *http.Request) {
+func (c *Controller) PostLogout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PostLogout: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("PostLogout: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	err := c.db.Logout(cookie.ID)
+	if err != nil {
+		log.Printf("PostLogout: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, name := range []string{userIdentification, userLogin} {
+		c.clearCookie(w, name)
+	}
+
+	log.Printf("PostLogout: %d, cookie: %s", http.StatusOK, cookie)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) PostRefresh(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var cookie cookieStruct
-	err := json.Unmarshal([]byte(fmt.Sprintf("%s", r.Context().Value(identification))), &cookie)
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PostRefresh: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("PostRefresh: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// вход от имени пользователя ограничен сроком, выданным администратору
+	if cookie.Impersonator != "" {
+		log.Printf("PostRefresh: %d, cookie: %s", http.StatusForbidden, cookie)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if c.c.AuthMode == config.AuthModeJWT {
+		authorization, err := c.authorization(cookie.Login)
+		if err != nil {
+			log.Print("PostRefresh: authorization err: ", err.Error())
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Authorization", authorization)
+		log.Printf("PostRefresh: %d, cookie: %s", http.StatusOK, cookie)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	uid, err := c.tokens.New()
 	if err != nil {
-		log.Print("PostRegister: unmarshal cookie err: ", err.Error())
+		log.Print("PostRefresh: make user identification err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = c.db.RefreshSession(cookie.ID, uid)
+	if err != nil {
+		if errors.Is(err, database.ErrWrongData) {
+			log.Printf("PostRefresh: %d, cookie: %s", http.StatusUnauthorized, cookie)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		log.Printf("PostRefresh: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.setCookie(w, userIdentification, signToken(c.c.SessionKey, uid))
+	log.Printf("PostRefresh: %d, cookie: %s", http.StatusOK, cookie)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *Controller) PostOrders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PostOrders: no user identification in context")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -138,68 +330,306 @@ func (c *Controller) PostOrders(w http.ResponseWriter, r *http.Request) {
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Print("PostOrders: read all err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	if string(b) == "" {
+	// номер принимается и в записи с пробелами и дефисами, хранится без них
+	number := domain.NormalizeOrderNumber(string(b))
+	if number == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	var order int
-	err = json.Unmarshal(b, &order)
+	order, err := strconv.Atoi(number)
 	if err != nil {
-		log.Print("PostOrders: json unmarshal err: ", err.Error())
+		log.Printf("PostOrders: %d, cookie: %s, order: %q", http.StatusUnprocessableEntity, cookie, b)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	c.uploadOrder(w, r, "PostOrders", cookie, order)
+}
+
+// maxOrdersBatch - наибольшее число номеров в одной пачке заказов
+const maxOrdersBatch = 1000
+
+// PostOrdersBatch загружает пачку номеров заказов пользователя одним запросом, чтобы импорт не
+// требовал запроса на каждый номер. Тело - JSON-массив номеров, ответ - результат по каждому номеру
+// в порядке запроса, повторы схлопываются. 202, если хоть один заказ принят к расчету, иначе 200.
+// Суточная квота расходуется только на верные номера, которых у сервиса еще не было.
+func (c *Controller) PostOrdersBatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PostOrdersBatch: no user identification in context")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	err = c.db.AddOrder(cookie.Login, order)
+	if cookie.Login == "" {
+		log.Printf("PostOrdersBatch: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var numbers []string
+	if err := json.NewDecoder(r.Body).Decode(&numbers); err != nil || len(numbers) == 0 {
+		log.Printf("PostOrdersBatch: %d, cookie: %s", http.StatusBadRequest, cookie)
+		writeValidationErrors(w, validation.Errors{{Field: "body", Message: "must be a non-empty JSON array of order numbers"}})
+		return
+	}
+
+	if len(numbers) > maxOrdersBatch {
+		log.Printf("PostOrdersBatch: %d, cookie: %s, orders: %d", http.StatusBadRequest, cookie, len(numbers))
+		writeValidationErrors(w, validation.Errors{{Field: "body", Message: fmt.Sprintf("must contain at most %d orders", maxOrdersBatch)}})
+		return
+	}
+
+	valid := make(map[string]bool, len(numbers))
+	for _, number := range numbers {
+		if number, ok := domain.ParseOrderNumber(number); ok {
+			valid[number] = true
+		}
+	}
+
+	limit := c.db.Settings().OrdersDailyLimit
+	if limit > 0 && len(valid) > 0 {
+		ok, err := c.db.TakeOrderQuota(cookie.Login, len(valid), limit)
+		if err != nil {
+			log.Print("PostOrdersBatch: take order quota err: ", err.Error())
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !ok {
+			now := time.Now().UTC()
+			reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+
+			marshal, err := json.Marshal(api.Quota{Limit: limit, ResetAt: reset.Format(time.RFC3339)})
+			if err != nil {
+				log.Print("PostOrdersBatch: json marshal err: ", err.Error())
+				c.renderError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+
+			log.Printf("PostOrdersBatch: %d, cookie: %s, orders: %d", http.StatusTooManyRequests, cookie, len(valid))
+			w.Header().Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write(marshal)
+			return
+		}
+	}
+
+	order, results, err := c.orders.UploadOrders(cookie.Login, numbers)
 	if err != nil {
-		if errors.Is(err, database.ErrBadOrderNumber) {
-			log.Printf("PostOrders: %d, cookie: %s, order: %d", http.StatusUnprocessableEntity, cookie, order)
+		if limit > 0 {
+			c.returnOrderQuota("PostOrdersBatch", cookie.Login, len(valid))
+		}
+
+		log.Printf("PostOrdersBatch: add orders err: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := make([]api.OrderResult, 0, len(order))
+	var accepted []accrual.Order
+	for _, number := range order {
+		result := api.OrderResult{Number: number, Result: api.OrderAccepted}
+		switch err = results[number]; {
+		case err == nil:
+			accepted = append(accepted, accrual.Order{Number: number, Status: "NEW"})
+		case errors.Is(err, domain.ErrBadOrderNumber):
+			result.Result = api.OrderInvalid
+		case errors.Is(err, database.ErrDuplicate):
+			c.outcomes.add("PostOrdersBatch", outcomeOrderDuplicate)
+			result.Result = api.OrderUploaded
+		default:
+			c.outcomes.add("PostOrdersBatch", outcomeOrderOwnedByOther)
+			result.Result = api.OrderConflict
+		}
+
+		resp = append(resp, result)
+	}
+
+	// номера, которые уже были у сервиса, квоту не расходуют
+	if limit > 0 && len(accepted) < len(valid) {
+		c.returnOrderQuota("PostOrdersBatch", cookie.Login, len(valid)-len(accepted))
+	}
+
+	for _, o := range accepted {
+		c.accrual.Enqueue(o)
+	}
+
+	marshal, err := json.Marshal(resp)
+	if err != nil {
+		log.Print("PostOrdersBatch: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	status := http.StatusOK
+	if len(accepted) > 0 {
+		status = http.StatusAccepted
+	}
+
+	log.Printf("PostOrdersBatch: %d, cookie: %s, orders: %d, accepted: %d", status, cookie, len(resp), len(accepted))
+	w.WriteHeader(status)
+	_, _ = w.Write(marshal)
+}
+
+func (c *Controller) PostOrderReceipt(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PostOrderReceipt: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("PostOrderReceipt: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Print("PostOrderReceipt: read all err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	rec, err := receipt.Parse(string(b))
+	if err != nil {
+		log.Printf("PostOrderReceipt: %d, cookie: %s, payload: %q", http.StatusBadRequest, cookie, b)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	order, err := strconv.Atoi(rec.Number)
+	if err != nil {
+		log.Printf("PostOrderReceipt: %d, cookie: %s, order: %s", http.StatusUnprocessableEntity, cookie, rec.Number)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	log.Printf("PostOrderReceipt: cookie: %s, order: %d, sum: %g, fn: %s", cookie, order, rec.Sum, rec.FN)
+	c.uploadOrder(w, r, "PostOrderReceipt", cookie, order)
+}
+
+// uploadOrder проверяет номер, учитывает квоту, сохраняет заказ и ставит его в очередь опроса системы
+// расчета. Неверный номер и номер, который уже был у сервиса, квоту не расходуют.
+func (c *Controller) uploadOrder(w http.ResponseWriter, r *http.Request, name string, cookie auth.UserID, order int) {
+	if order <= 0 || !domain.ValidOrderNumber(strconv.Itoa(order)) {
+		log.Printf("%s: %d, cookie: %s, order: %d", name, http.StatusUnprocessableEntity, cookie, order)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	limit := c.db.Settings().OrdersDailyLimit
+	if limit > 0 {
+		ok, err := c.db.TakeOrderQuota(cookie.Login, 1, limit)
+		if err != nil {
+			log.Printf("%s: take order quota err: %s", name, err.Error())
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if !ok {
+			now := time.Now().UTC()
+			reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+
+			marshal, err := json.Marshal(api.Quota{Limit: limit, ResetAt: reset.Format(time.RFC3339)})
+			if err != nil {
+				log.Printf("%s: json marshal err: %s", name, err.Error())
+				c.renderError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+
+			log.Printf("%s: %d, cookie: %s, order: %d", name, http.StatusTooManyRequests, cookie, order)
+			w.Header().Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write(marshal)
+			return
+		}
+	}
+
+	if err := c.orders.UploadOrder(cookie.Login, order); err != nil {
+		if limit > 0 {
+			c.returnOrderQuota(name, cookie.Login, 1)
+		}
+
+		if errors.Is(err, domain.ErrBadOrderNumber) {
+			log.Printf("%s: %d, cookie: %s, order: %d", name, http.StatusUnprocessableEntity, cookie, order)
 			w.WriteHeader(http.StatusUnprocessableEntity)
 			return
 		}
 
 		if errors.Is(err, database.ErrDuplicate) {
-			log.Printf("PostOrders: %d, cookie: %s, order: %d", http.StatusOK, cookie, order)
+			c.outcomes.add(name, outcomeOrderDuplicate)
+			log.Printf("%s: %d, cookie: %s, order: %d", name, http.StatusOK, cookie, order)
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 
 		if errors.Is(err, database.ErrUsed) {
-			log.Printf("PostOrders: %d, cookie: %s, order: %d", http.StatusConflict, cookie, order)
+			c.outcomes.add(name, outcomeOrderOwnedByOther)
+			log.Printf("%s: %d, cookie: %s, order: %d", name, http.StatusConflict, cookie, order)
 			w.WriteHeader(http.StatusConflict)
 			return
 		}
 
-		log.Print("PostOrders: add order err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("%s: add order err: %s", name, err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	go func() {
-		c.worker <- worker.OrderStr{Number: strconv.Itoa(order), Status: "NEW"}
-	}()
+	number := strconv.Itoa(order)
+	position := c.accrual.Enqueue(accrual.Order{Number: number, Status: "NEW"})
 
-	log.Printf("PostOrders: %d, cookie: %s, order: %d", http.StatusAccepted, cookie, order)
+	// клиенту сообщается ожидаемое время обработки и адрес, по которому опрашивать статус заказа
+	delayed := c.accrual.Down()
+	estimate := int64(c.accrual.Estimate(position).Seconds()) + 1
+	backlog := api.Backlog{Position: position, EstimatedSeconds: estimate, EstimatedProcessingSeconds: estimate, AccrualDelayed: delayed,
+		PollURL: "/api/user/orders/" + number}
+	w.Header().Set("Location", backlog.PollURL)
+
+	marshal, err := json.Marshal(backlog)
+	if err != nil {
+		log.Printf("%s: json marshal err: %s", name, err.Error())
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// очередь опроса переполнена или система расчета недоступна: заказ сохранен,
+	// но повторять запрос раньше ожидаемого времени нет смысла
+	if delayed || c.c.QueueSaturation > 0 && position >= c.c.QueueSaturation {
+		w.Header().Set("Retry-After", strconv.FormatInt(estimate, 10))
+	}
+
+	log.Printf("%s: %d, cookie: %s, order: %d, queue position: %d, accrual delayed: %t",
+		name, http.StatusAccepted, cookie, order, position, delayed)
 	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write(marshal)
 }
 
-type withdraw struct {
-	Order string  `json:"order"`
-	Sum   float64 `json:"sum"`
+// returnOrderQuota возвращает в квоту попытки, взятые под заказы, которые не были приняты.
+// Ошибка только записывается в журнал: ответ клиенту от нее не зависит.
+func (c *Controller) returnOrderQuota(name, login string, n int) {
+	if err := c.db.ReturnOrderQuota(login, n); err != nil {
+		log.Printf("%s: return order quota err: %s, login: %s", name, err.Error(), login)
+	}
 }
 
 func (c *Controller) PostWithDraw(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var cookie cookieStruct
-	err := json.Unmarshal([]byte(fmt.Sprintf("%s", r.Context().Value(identification))), &cookie)
-	if err != nil {
-		log.Print("PostRegister: unmarshal cookie err: ", err.Error())
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PostWithDraw: no user identification in context")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -214,7 +644,7 @@ func (c *Controller) PostWithDraw(w http.ResponseWriter, r *http.Request) {
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
 		log.Print("PostWithDraw: read all err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -223,17 +653,79 @@ func (c *Controller) PostWithDraw(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	withdraw := withdraw{}
+	withdraw := api.WithdrawRequest{}
 	err = json.Unmarshal(b, &withdraw)
 	if err != nil {
 		log.Print("PostWithDraw: json unmarshal err: ", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	withdraw.Order = domain.NormalizeOrderNumber(withdraw.Order)
+
+	err = domain.CheckWithdrawal(withdraw.Order, withdraw.Sum)
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		if errors.Is(err, domain.ErrBadSum) {
+			status = http.StatusBadRequest
+		}
+
+		log.Printf("PostWithDraw: %d, cookie: %s, order: %s, sum: %g",
+			status, cookie, withdraw.Order, withdraw.Sum)
+		w.WriteHeader(status)
+		return
+	}
+
+	if minimum := c.db.Settings().MinWithdrawal; withdraw.Sum < minimum {
+		log.Printf("PostWithDraw: %d, cookie: %s, order: %s, sum: %g, min: %g",
+			http.StatusBadRequest, cookie, withdraw.Order, withdraw.Sum, minimum)
+		writeValidationErrors(w, validation.Errors{{Field: "sum", Message: fmt.Sprintf("must be at least %g", minimum)}})
+		return
+	}
+
+	age, err := c.db.SessionAge(cookie.ID)
+	if err != nil {
+		log.Print("PostWithDraw: session age err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	err = c.db.AddWithDraw(cookie.Login, withdraw.Order, withdraw.Sum)
+	decision, err := c.fraud.Check(r.Context(), fraud.Withdrawal{
+		Login:      cookie.Login,
+		Order:      withdraw.Order,
+		Sum:        withdraw.Sum,
+		SessionAge: age,
+	})
+	if err != nil {
+		log.Print("PostWithDraw: fraud check err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	switch decision.Verdict {
+	case fraud.Hold:
+		reference, err := c.orders.HoldWithdrawal(cookie.Login, withdraw.Order, withdraw.Sum, decision.Reason)
+		if err != nil {
+			log.Print("PostWithDraw: hold withdraw err: ", err.Error())
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		log.Printf("PostWithDraw: %d, cookie: %s, order: %s, sum: %g, held: %s, reference: %s",
+			http.StatusAccepted, cookie, withdraw.Order, withdraw.Sum, decision.Reason, reference)
+		writeReference(w, http.StatusAccepted, reference)
+		return
+	case fraud.Reject:
+		log.Printf("PostWithDraw: %d, cookie: %s, order: %s, sum: %g, rejected: %s",
+			http.StatusForbidden, cookie, withdraw.Order, withdraw.Sum, decision.Reason)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	reference, err := c.orders.Withdraw(cookie.Login, withdraw.Order, withdraw.Sum)
 	if err != nil {
 		if errors.Is(err, database.ErrNoMoney) {
+			c.outcomes.add("PostWithDraw", outcomeInsufficientFunds)
 			log.Printf("PostWithDraw: %d, cookie: %s, order: %s, sum: %g",
 				http.StatusPaymentRequired, cookie, withdraw.Order, withdraw.Sum)
 			w.WriteHeader(http.StatusPaymentRequired)
@@ -249,11 +741,30 @@ func (c *Controller) PostWithDraw(w http.ResponseWriter, r *http.Request) {
 
 		log.Printf("PostWithDraw: %s, cookie: %s, order: %s, sum: %g",
 			err.Error(), cookie, withdraw.Order, withdraw.Sum)
-		w.WriteHeader(http.StatusInternalServerError)
+		c.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	log.Printf("PostWithDraw: %d, cookie: %s, order: %s, sum: %g",
-		http.StatusOK, cookie, withdraw.Order, withdraw.Sum)
-	w.WriteHeader(http.StatusOK)
+	err = c.notify.Event(r.Context(), cookie.Login, notify.EventWithdrawalProcessed,
+		fmt.Sprintf("Списано %s в счет заказа %s", c.formatAmount(cookie.Login, withdraw.Sum), withdraw.Order))
+	if err != nil {
+		log.Printf("PostWithDraw: notify err: %s, cookie: %s", err.Error(), cookie)
+	}
+
+	log.Printf("PostWithDraw: %d, cookie: %s, order: %s, sum: %g, reference: %s",
+		http.StatusOK, cookie, withdraw.Order, withdraw.Sum, reference)
+	writeReference(w, http.StatusOK, reference)
+}
+
+// writeReference отдает номер списания, по которому пользователь найдет его в списке списаний
+func writeReference(w http.ResponseWriter, status int, reference string) {
+	marshal, err := json.Marshal(api.Reference{Reference: reference})
+	if err != nil {
+		log.Print("PostWithDraw: json marshal err: ", err.Error())
+		w.WriteHeader(status)
+		return
+	}
+
+	w.WriteHeader(status)
+	_, _ = w.Write(marshal)
 }
@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/auth"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/format"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/validation"
+)
+
+// profileStruct - профиль пользователя. ID не меняется вместе с логином, по нему клиенты
+// связывают данные пользователя между сменами логина.
+type profileStruct struct {
+	ID    int64  `json:"id"`
+	Login string `json:"login"`
+	format.Preferences
+}
+
+func (c *Controller) GetProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("GetProfile: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("GetProfile: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	id, err := c.db.GetUserID(cookie.Login)
+	if err != nil {
+		log.Printf("GetProfile: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	prefs, err := c.db.GetPreferences(cookie.Login)
+	if err != nil {
+		log.Printf("GetProfile: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	marshal, err := json.Marshal(profileStruct{ID: id, Login: cookie.Login, Preferences: prefs})
+	if err != nil {
+		log.Print("GetProfile: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, err = w.Write(marshal)
+	if err != nil {
+		log.Print("GetProfile: w write err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("GetProfile: %d, cookie: %s", http.StatusOK, cookie)
+}
+
+func (c *Controller) PutProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PutProfile: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("PutProfile: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Print("PutProfile: read all err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	var prefs format.Preferences
+	if err = json.Unmarshal(b, &prefs); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err = prefs.Validate(); err != nil {
+		if errors.Is(err, format.ErrUnsupported) {
+			log.Printf("PutProfile: %d, cookie: %s, prefs: %v", http.StatusUnprocessableEntity, cookie, prefs)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		log.Printf("PutProfile: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = c.db.SetPreferences(cookie.Login, prefs)
+	if err != nil {
+		log.Printf("PutProfile: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("PutProfile: %d, cookie: %s, prefs: %v", http.StatusOK, cookie, prefs)
+	w.WriteHeader(http.StatusOK)
+}
+
+type passwordStruct struct {
+	Current  string `json:"current_password"`
+	Password string `json:"password"`
+}
+
+// PutPassword меняет пароль после проверки текущего и завершает все сессии пользователя,
+// кроме той, из которой пароль изменен
+func (c *Controller) PutPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PutPassword: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("PutPassword: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// пароли внешних бэкендов меняются в самом бэкенде
+	if c.c.AuthBackend != auth.BackendLocal {
+		log.Printf("PutPassword: %d, cookie: %s, auth backend: %s", http.StatusForbidden, cookie, c.c.AuthBackend)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Print("PutPassword: read all err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	var passwords passwordStruct
+	if err = json.Unmarshal(b, &passwords); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if errs := validation.Credentials(cookie.Login, passwords.Password); errs != nil {
+		log.Printf("PutPassword: %d, cookie: %s, err: %s", http.StatusBadRequest, cookie, errs.Error())
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	err = c.db.CheckPassword(cookie.Login, passwords.Current)
+	if err != nil {
+		if errors.Is(err, database.ErrWrongData) {
+			log.Printf("PutPassword: %d, cookie: %s, wrong current password", http.StatusForbidden, cookie)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		log.Printf("PutPassword: check password err: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err = c.db.ChangePassword(cookie.Login, passwords.Password); err != nil {
+		log.Printf("PutPassword: change password err: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err = c.db.RevokeAllSessions(cookie.Login); err != nil {
+		log.Printf("PutPassword: revoke all sessions err: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	// текущая сессия продолжается: в cookie-режиме она заново открывается с новым идентификатором,
+	// в JWT-режиме выдается токен новее отзыва
+	if c.c.AuthMode == config.AuthModeJWT {
+		authorization, err := c.authorization(cookie.Login)
+		if err != nil {
+			log.Print("PutPassword: authorization err: ", err.Error())
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Authorization", authorization)
+	} else if err = c.openSession(w, cookie.Login, cookie.ID); err != nil {
+		log.Printf("PutPassword: open session err: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = c.notify.Notify(r.Context(), cookie.Login, "Пароль изменен, остальные сессии завершены")
+	if err != nil {
+		log.Printf("PutPassword: notify err: %s, cookie: %s", err.Error(), cookie)
+	}
+
+	log.Printf("PutPassword: %d, cookie: %s", http.StatusOK, cookie)
+	w.WriteHeader(http.StatusOK)
+}
+
+type loginStruct struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+// PutLogin меняет логин пользователя. Заказы, списания, баланс и сессии остаются за учетной записью,
+// в JWT-режиме выдается токен с новым логином: прежние токены перестают действовать.
+func (c *Controller) PutLogin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PutLogin: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("PutLogin: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// логин внешнего бэкенда задает сам бэкенд, логин меняет только сам пользователь
+	if c.c.AuthBackend != auth.BackendLocal || cookie.Impersonator != "" {
+		log.Printf("PutLogin: %d, cookie: %s, auth backend: %s", http.StatusForbidden, cookie, c.c.AuthBackend)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var body loginStruct
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if errs := validation.Login(body.Login); errs != nil {
+		log.Printf("PutLogin: %d, cookie: %s, err: %s", http.StatusBadRequest, cookie, errs.Error())
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	if body.Login == cookie.Login {
+		log.Printf("PutLogin: %d, cookie: %s, same login", http.StatusBadRequest, cookie)
+		writeValidationErrors(w, validation.Errors{{Field: "login", Message: "must differ from the current login"}})
+		return
+	}
+
+	err := c.db.CheckPassword(cookie.Login, body.Password)
+	if err != nil {
+		if errors.Is(err, database.ErrWrongData) {
+			log.Printf("PutLogin: %d, cookie: %s, wrong password", http.StatusForbidden, cookie)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		log.Printf("PutLogin: check password err: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err = c.db.RenameUser(cookie.Login, body.Login); err != nil {
+		switch {
+		case errors.Is(err, database.ErrRegisterConflict):
+			log.Printf("PutLogin: %d, cookie: %s, login: %s", http.StatusConflict, cookie, body.Login)
+			w.WriteHeader(http.StatusConflict)
+		case errors.Is(err, database.ErrNotFound):
+			log.Printf("PutLogin: %d, cookie: %s", http.StatusNotFound, cookie)
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			log.Printf("PutLogin: rename user err: %s, cookie: %s", err.Error(), cookie)
+			c.renderError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if c.c.AuthMode == config.AuthModeJWT {
+		authorization, err := c.authorization(body.Login)
+		if err != nil {
+			log.Print("PutLogin: authorization err: ", err.Error())
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Authorization", authorization)
+	}
+
+	err = c.notify.Notify(r.Context(), body.Login, "Логин изменен, прежний логин: "+cookie.Login)
+	if err != nil {
+		log.Printf("PutLogin: notify err: %s, cookie: %s", err.Error(), cookie)
+	}
+
+	log.Printf("PutLogin: %d, cookie: %s, login: %s", http.StatusOK, cookie, body.Login)
+	w.WriteHeader(http.StatusOK)
+}
+
+// formatAmount форматирует сумму для текстов, адресованных пользователю login
+func (c *Controller) formatAmount(login string, v float64) string {
+	prefs, err := c.db.GetPreferences(login)
+	if err != nil {
+		log.Printf("formatAmount: get preferences err: %s, login: %s", err.Error(), login)
+		prefs = format.Default
+	}
+
+	return prefs.Amount(v)
+}
+
+type deleteUserStruct struct {
+	Password string `json:"password"`
+}
+
+// DeleteUser удаляет учетную запись по просьбе пользователя. С локальным бэкендом аутентификации
+// удаление подтверждается паролем. Сессии завершаются сразу, заказы и списания обезличиваются
+// и удаляются окончательно через USER_RETENTION.
+func (c *Controller) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("DeleteUser: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("DeleteUser: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// учетную запись удаляет только сам пользователь, не администратор от его имени
+	if cookie.Impersonator != "" {
+		log.Printf("DeleteUser: %d, cookie: %s", http.StatusForbidden, cookie)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if c.c.AuthBackend == auth.BackendLocal {
+		var body deleteUserStruct
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Password == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		err := c.db.CheckPassword(cookie.Login, body.Password)
+		if err != nil {
+			if errors.Is(err, database.ErrWrongData) {
+				log.Printf("DeleteUser: %d, cookie: %s, wrong password", http.StatusForbidden, cookie)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			log.Printf("DeleteUser: check password err: %s, cookie: %s", err.Error(), cookie)
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if err := c.db.DeleteUser(cookie.Login); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			log.Printf("DeleteUser: %d, cookie: %s", http.StatusNotFound, cookie)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		log.Printf("DeleteUser: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("DeleteUser: %d, cookie: %s", http.StatusOK, cookie)
+	w.WriteHeader(http.StatusOK)
+}
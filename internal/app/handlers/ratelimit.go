@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/pkg/api"
+)
+
+// maxBuckets - при превышении из лимитера удаляются полностью восстановившиеся корзины
+const maxBuckets = 10000
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter - token bucket на ключ: burst запросов сразу, далее rate запросов в секунду
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func newRateLimiter(perMinute float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: perMinute / 60, burst: float64(burst), buckets: map[string]*bucket{}}
+}
+
+// take забирает по токену из корзин всех ключей. Если хотя бы в одной пусто, ничего не забирает
+// и возвращает, через сколько появится недостающий токен.
+func (l *rateLimiter) take(now time.Time, keys ...string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.buckets) > maxBuckets {
+		l.prune(now)
+	}
+
+	var wait time.Duration
+	for _, key := range keys {
+		b := l.refill(now, key)
+		if b.tokens < 1 {
+			if d := time.Duration((1 - b.tokens) / l.rate * float64(time.Second)); d > wait {
+				wait = d
+			}
+		}
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, key := range keys {
+		l.buckets[key].tokens--
+	}
+
+	return true, 0
+}
+
+func (l *rateLimiter) refill(now time.Time, key string) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	return b
+}
+
+func (l *rateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// AuthRateLimitMiddleware ограничивает частоту попыток регистрации и входа отдельно
+// для каждого адреса клиента и каждого логина из тела запроса
+func (c *Controller) AuthRateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.authLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		keys := []string{"ip:" + ip}
+
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Print("AuthRateLimitMiddleware: read all err: ", err.Error())
+			c.renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(b))
+
+		var user api.Credentials
+		if json.Unmarshal(b, &user) == nil && user.Login != "" {
+			keys = append(keys, "login:"+user.Login)
+		}
+
+		ok, wait := c.authLimiter.take(time.Now(), keys...)
+		if !ok {
+			log.Printf("AuthRateLimitMiddleware: %d, %s, ip: %s, login: %s", http.StatusTooManyRequests, r.URL.Path, ip, user.Login)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
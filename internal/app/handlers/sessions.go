@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/anomaly"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/auth"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxUserAgentLength - User-Agent длиннее этого обрезается перед сохранением в сессии
+const maxUserAgentLength = 256
+
+// clientInfo возвращает User-Agent и адрес клиента для записи в сессию
+func clientInfo(r *http.Request) (string, string) {
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgentLength {
+		ua = ua[:maxUserAgentLength]
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return ua, ip
+}
+
+// reportedAnomalies запоминает пары сессия/адрес, о которых уже сообщено, чтобы не сообщать
+// о каждом запросе. При переполнении очищается целиком.
+type reportedAnomalies struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+func newReportedAnomalies() *reportedAnomalies {
+	return &reportedAnomalies{seen: map[string]struct{}{}}
+}
+
+// first возвращает true при первом обращении с ключом key
+func (a *reportedAnomalies) first(key string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.seen[key]; ok {
+		return false
+	}
+
+	if len(a.seen) >= maxBuckets {
+		a.seen = map[string]struct{}{}
+	}
+	a.seen[key] = struct{}{}
+
+	return true
+}
+
+// sessionTouches копит время последнего запроса по сессиям между записями в базу, чтобы
+// учет активности не добавлял запись на каждый запрос. Сессия попадает в пачку один раз.
+type sessionTouches struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newSessionTouches() *sessionTouches {
+	return &sessionTouches{seen: map[string]time.Time{}}
+}
+
+func (t *sessionTouches) touch(id string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.seen[id] = at
+}
+
+// take возвращает накопленную пачку и начинает новую
+func (t *sessionTouches) take() map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seen := t.seen
+	t.seen = make(map[string]time.Time, len(seen))
+
+	return seen
+}
+
+// FlushTouches раз в SessionTouchInterval записывает накопленную активность сессий, пока не отменен
+// ctx, и записывает последнюю пачку при отмене. Пачка, которую не удалось записать, теряется:
+// следующая пачка содержит более позднее время тех же активных сессий. Запускается, только если
+// SessionTouchInterval задан.
+func (c *Controller) FlushTouches(ctx context.Context) error {
+	ticker := time.NewTicker(c.c.SessionTouchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.flushTouches()
+			return nil
+		case <-ticker.C:
+			c.flushTouches()
+		}
+	}
+}
+
+func (c *Controller) flushTouches() {
+	seen := c.touches.take()
+	if len(seen) == 0 {
+		return
+	}
+
+	if err := c.db.TouchSessions(seen); err != nil {
+		log.Printf("FlushTouches: touch %d sessions err: %s", len(seen), err.Error())
+	}
+}
+
+// checkAnomaly сообщает хуку, если сессия используется из сети, далекой от той, где она создана
+func (c *Controller) checkAnomaly(r *http.Request, uid string, session database.SessionClient) {
+	if c.anomaly == nil {
+		return
+	}
+
+	ua, ip := clientInfo(r)
+	if !anomaly.Distant(session.IP, ip) || !c.anomalies.first(uid+"|"+ip) {
+		return
+	}
+
+	e := anomaly.Event{
+		Login:            session.Login,
+		SessionID:        session.ID,
+		SessionIP:        session.IP,
+		SessionUserAgent: session.UserAgent,
+		IP:               ip,
+		UserAgent:        ua,
+	}
+	go c.anomaly.SessionAnomaly(context.Background(), e)
+}
+
+func (c *Controller) GetSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("GetSessions: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("GetSessions: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := c.db.GetSessions(cookie.Login, cookie.ID)
+	if err != nil {
+		log.Printf("GetSessions: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	marshal, err := json.Marshal(sessions)
+	if err != nil {
+		log.Print("GetSessions: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("GetSessions: %d, cookie: %s", http.StatusOK, cookie)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(marshal)
+}
+
+func (c *Controller) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("DeleteSession: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("DeleteSession: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = c.db.RevokeSession(cookie.Login, id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			log.Printf("DeleteSession: %d, cookie: %s, id: %d", http.StatusNotFound, cookie, id)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		log.Printf("DeleteSession: %s, cookie: %s, id: %d", err.Error(), cookie, id)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("DeleteSession: %d, cookie: %s, id: %d", http.StatusOK, cookie, id)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/accrual"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/domain"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/format"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/notify"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/settings"
+)
+
+// Storage - хранилище, с которым работают обработчики. Реализация на Postgres - *database.DataBase,
+// в тестах и для других хранилищ подставляется своя. Ошибки - из пакета database.
+type Storage interface {
+	domain.Storage
+	accrual.BackfillStorage
+
+	// Учетные записи
+	Register(login, pass, cookie, newCookie string) error
+	RegisterPending(login, pass, email, token string, ttl time.Duration) error
+	Verify(token string) (string, error)
+	Login(login, pass, cookie, newCookie string) error
+	OpenSession(login, cookie, newCookie string) error
+	CheckPassword(login, pass string) error
+	ChangePassword(login, pass string) error
+	SetTOTPSecret(login, secret string) error
+	EnableTOTP(login string) error
+	GetTOTP(login string) (string, bool, error)
+	GetPreferences(login string) (format.Preferences, error)
+	SetPreferences(login string, prefs format.Preferences) error
+	GetNotificationPreferences(login string) (notify.Preferences, error)
+	SetNotificationPreferences(login string, prefs notify.Preferences) error
+	GetEmail(login string) (string, error)
+	DeleteUser(login string) error
+	GetUserID(login string) (int64, error)
+	RenameUser(login, newLogin string) error
+
+	// Сессии
+	NewSession(cookie, userAgent, ip string) error
+	GetSessionClient(cookie string) (database.SessionClient, error)
+	RefreshSession(cookie, newCookie string) error
+	SessionAge(cookie string) (time.Duration, error)
+	Logout(cookie string) error
+	GetSessions(login, cookie string) ([]database.Session, error)
+	RevokeSession(login string, id int64) error
+	RevokeAllSessions(login string) error
+	SessionsRevokedAt(login string) (time.Time, error)
+	TouchSessions(seen map[string]time.Time) error
+
+	// Заказы и баланс
+	TakeOrderQuota(login string, n, limit int) (bool, error)
+	ReturnOrderQuota(login string, n int) error
+	GetOrders(login string, limit, offset int, after int64, archived bool) ([]database.Order, error)
+	GetOrder(login, number string) (database.Order, error)
+	GetChangedOrders(login string, revision int64, since time.Time) ([]database.Order, error)
+	GetBalance(login string) (database.User, error)
+	GetWithDraw(login string, limit, offset int, after int64) ([]database.WithDraw, error)
+	GetBalanceHistory(login string, from, to time.Time, granularity string) ([]database.BalancePoint, error)
+
+	// Администрирование
+	GetHolds() ([]database.WithDrawHold, error)
+	ApproveHold(id int) (database.WithDrawHold, error)
+	RejectHold(id int) (database.WithDrawHold, error)
+	GetQuarantine() ([]database.QuarantinedAccrual, error)
+	TransferOrder(number, to string) (database.Transfer, error)
+	ExtendOrder(number string, until time.Time) (database.Order, bool, error)
+	ImportUser(login, pass string, balance float64) (bool, error)
+	GetReport(from, to time.Time, groupBy string) ([]database.ReportBucket, error)
+	Impersonate(i database.Impersonation) error
+	AddAudit(actor, login, action, detail string) error
+	SetInactivityExempt(login string, exempt bool, by string) error
+	GetInactivityExempt() ([]string, error)
+
+	// Настройки
+	Settings() settings.Values
+	SettingsDefaults() settings.Values
+	GetSettings() ([]settings.Override, error)
+	PutSetting(name, value, by string) error
+	DeleteSetting(name, by string) error
+
+	// Healthy и Stats - доступность базы и состояние пула соединений для проверки готовности и метрик
+	Healthy() bool
+	Stats() sql.DBStats
+}
+
+var _ Storage = (*database.DataBase)(nil)
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/auth"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/totp"
+)
+
+const totpIssuer = "Gophermart"
+
+type totpEnrollStruct struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+}
+
+type totpConfirmStruct struct {
+	Code string `json:"code"`
+}
+
+func (c *Controller) PostTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PostTOTPEnroll: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("PostTOTPEnroll: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		log.Print("PostTOTPEnroll: new secret err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = c.db.SetTOTPSecret(cookie.Login, secret)
+	if err != nil {
+		if errors.Is(err, database.ErrDuplicate) {
+			log.Printf("PostTOTPEnroll: %d, cookie: %s", http.StatusConflict, cookie)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		log.Printf("PostTOTPEnroll: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	marshal, err := json.Marshal(totpEnrollStruct{Secret: secret, URL: totp.URL(totpIssuer, cookie.Login, secret)})
+	if err != nil {
+		log.Print("PostTOTPEnroll: json marshal err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	_, err = w.Write(marshal)
+	if err != nil {
+		log.Print("PostTOTPEnroll: w write err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("PostTOTPEnroll: %d, cookie: %s", http.StatusOK, cookie)
+}
+
+func (c *Controller) PostTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	cookie, ok := auth.FromContext(r.Context())
+	if !ok {
+		log.Print("PostTOTPConfirm: no user identification in context")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if cookie.Login == "" {
+		log.Printf("PostTOTPConfirm: %d, cookie: %s", http.StatusUnauthorized, cookie)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Print("PostTOTPConfirm: read all err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	var confirm totpConfirmStruct
+	if err = json.Unmarshal(b, &confirm); err != nil || confirm.Code == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	secret, enabled, err := c.db.GetTOTP(cookie.Login)
+	if err != nil {
+		log.Printf("PostTOTPConfirm: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if secret == "" || enabled {
+		log.Printf("PostTOTPConfirm: %d, cookie: %s", http.StatusConflict, cookie)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	if !totp.Validate(secret, confirm.Code, time.Now()) {
+		log.Printf("PostTOTPConfirm: %d, cookie: %s", http.StatusUnprocessableEntity, cookie)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	err = c.db.EnableTOTP(cookie.Login)
+	if err != nil {
+		log.Printf("PostTOTPConfirm: %s, cookie: %s", err.Error(), cookie)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("PostTOTPConfirm: %d, cookie: %s", http.StatusOK, cookie)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/auth"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/validation"
+	"github.com/chazari-x/yandex-pr-diplom/pkg/api"
+)
+
+// registerPending регистрирует пользователя без открытия сессии и отправляет ссылку подтверждения адреса
+func (c *Controller) registerPending(w http.ResponseWriter, r *http.Request, cookie auth.UserID, user api.Credentials) {
+	if errs := validation.Email(user.Email); errs != nil {
+		log.Printf("PostRegister: %d, cookie: %s, err: %s", http.StatusBadRequest, cookie, errs.Error())
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	b, err := generateRandom(32)
+	if err != nil {
+		log.Print("PostRegister: generate random err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	token := hex.EncodeToString(b)
+	err = c.db.RegisterPending(user.Login, user.Password, user.Email, token, c.c.EmailVerificationTTL)
+	if err != nil {
+		if errors.Is(err, database.ErrRegisterConflict) {
+			c.outcomes.add("PostRegister", outcomeRegisterConflict)
+			log.Printf("PostRegister: %d, cookie: %s, login: %s", http.StatusConflict, cookie, user.Login)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		log.Printf("PostRegister: %s, cookie: %s, login: %s", err.Error(), cookie, user.Login)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	link := c.c.PublicURL + "/api/user/verify?token=" + url.QueryEscape(token)
+	err = c.mail.Send(r.Context(), user.Email, "Подтверждение адреса",
+		"Чтобы завершить регистрацию "+user.Login+", перейдите по ссылке: "+link)
+	if err != nil {
+		log.Printf("PostRegister: send mail err: %s, login: %s", err.Error(), user.Login)
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("PostRegister: %d, cookie: %s, login: %s, verification sent", http.StatusAccepted, cookie, user.Login)
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte(`{"status":"pending"}`))
+}
+
+func (c *Controller) GetVerify(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	login, err := c.db.Verify(token)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			log.Printf("GetVerify: %d, unknown or expired token", http.StatusNotFound)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		log.Print("GetVerify: verify err: ", err.Error())
+		c.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("GetVerify: %d, login: %s", http.StatusOK, login)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,57 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// Sender отправляет письмо на адрес пользователя
+type Sender interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// Log пишет письма в журнал, используется, пока не настроен SMTP-сервер
+type Log struct{}
+
+func (Log) Send(_ context.Context, to, subject, body string) error {
+	log.Printf("mail: to: %s, subject: %s, body: %s", to, subject, body)
+	return nil
+}
+
+// SMTP отправляет письма через SMTP-сервер. Без Username отправка идет без аутентификации.
+type SMTP struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s SMTP) Send(_ context.Context, to, subject, body string) error {
+	// адрес и тема попадают в заголовки письма и не должны переносить строку
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("mail: header contains line break")
+	}
+
+	var a smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+
+		a = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	msg := "From: " + s.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body + "\r\n"
+
+	return smtp.SendMail(s.Addr, a, s.From, []string{to}, []byte(msg))
+}
@@ -0,0 +1,129 @@
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/domain"
+)
+
+// FlagInactiveUsers отмечает действующие учетные записи без входов и запросов с before, кроме
+// исключений, и записывает отметку в журнал аудита. Возвращает логины отмеченных.
+func (s *Storage) FlagInactiveUsers(before time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var flagged []string
+	for login, u := range s.users {
+		if u.status != database.UserActive || !u.deletedAt.IsZero() || u.exempt || !u.notifiedAt.IsZero() ||
+			!u.lastActive.Before(before) {
+			continue
+		}
+
+		u.notifiedAt = now
+		s.audit = append(s.audit, auditEntry{actor: database.AuditSystem, login: login, action: database.AuditInactiveFlagged,
+			detail: "last active at " + u.lastActive.UTC().Format(time.RFC3339), createdAt: now})
+		flagged = append(flagged, login)
+	}
+
+	sort.Strings(flagged)
+
+	return flagged, nil
+}
+
+// AnonymizeInactiveUsers обезличивает учетные записи, отмеченные неактивными не позже notifiedBefore,
+// с нулевым остатком и без заказов и списаний в обработке. Возвращает обезличенные логины.
+func (s *Storage) AnonymizeInactiveUsers(notifiedBefore time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var candidates []*user
+	for _, u := range s.users {
+		if u.deletedAt.IsZero() && !u.exempt && !u.notifiedAt.IsZero() && !u.notifiedAt.After(notifiedBefore) &&
+			s.settled(u.login) {
+			candidates = append(candidates, u)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].id < candidates[j].id
+	})
+
+	var anonymized []string
+	for _, u := range candidates {
+		notifiedAt := u.notifiedAt
+		anonymous := s.deleteUser(u)
+		s.audit = append(s.audit, auditEntry{actor: database.AuditSystem, login: anonymous, action: database.AuditInactiveAnonymized,
+			detail: "notified at " + notifiedAt.UTC().Format(time.RFC3339), createdAt: time.Now()})
+		anonymized = append(anonymized, anonymous)
+	}
+
+	return anonymized, nil
+}
+
+// settled сообщает, что остаток пользователя нулевой и его не изменят заказы и списания в обработке,
+// вызывается под s.mu
+func (s *Storage) settled(login string) bool {
+	if current, _ := s.balance(login); current != 0 {
+		return false
+	}
+
+	for _, o := range s.orderList {
+		if o.Login == login && (o.Status == domain.StatusNew || o.Status == domain.StatusProcessing) {
+			return false
+		}
+	}
+
+	for _, h := range s.holds {
+		if h.Login == login && h.Status == database.HoldHeld {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SetInactivityExempt добавляет пользователя в исключения очистки неактивных учетных записей
+// или убирает из них и снимает отметку о неактивности. Неизвестный пользователь - database.ErrNotFound.
+func (s *Storage) SetInactivityExempt(login string, exempt bool, by string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok || !u.deletedAt.IsZero() {
+		return database.ErrNotFound
+	}
+
+	u.exempt, u.notifiedAt = exempt, time.Time{}
+
+	action := database.AuditInactivityExempt
+	if !exempt {
+		action = database.AuditInactivityUnexempt
+	}
+	s.audit = append(s.audit, auditEntry{actor: by, login: login, action: action, createdAt: time.Now()})
+
+	return nil
+}
+
+// GetInactivityExempt возвращает логины пользователей из исключений по алфавиту
+func (s *Storage) GetInactivityExempt() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var logins []string
+	for login, u := range s.users {
+		if u.exempt && u.deletedAt.IsZero() {
+			logins = append(logins, login)
+		}
+	}
+
+	if logins == nil {
+		return nil, database.ErrEmpty
+	}
+
+	sort.Strings(logins)
+
+	return logins, nil
+}
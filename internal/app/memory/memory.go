@@ -0,0 +1,206 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/format"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/notify"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/password"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/settings"
+)
+
+// Storage - хранилище в памяти процесса для запуска без Postgres: демонстраций, локальной
+// разработки и тестов обработчиков. Повторяет поведение database.DataBase, включая ошибки
+// из пакета database; данные теряются при перезапуске.
+type Storage struct {
+	mu sync.Mutex
+
+	users         map[string]*user
+	verifications map[string]verification
+	sessions      map[string]*session
+	orders        map[string]*order
+	orderList     []*order // порядок загрузки
+	credited      map[string]bool
+	ledger        []entry
+	withdraws     map[string]*withdraw
+	withdrawList  []*withdraw
+	holds         []*hold
+	quarantine    []database.QuarantinedAccrual
+	quota         map[string]int
+	snapshots     map[snapshot]float64
+	audit         []auditEntry
+
+	sid      int64
+	revision int64
+	userid   int64
+	seq      int64
+
+	// settingsMu защищает overrides отдельно от mu: настройки читаются и под mu
+	settingsMu sync.Mutex
+	overrides  map[string]settings.Override
+	settings   *settings.Cache
+
+	maxAge    time.Duration
+	passwords password.Hasher
+	dummyHash string
+}
+
+type user struct {
+	login       string
+	password    string
+	email       string
+	status      string
+	totpSecret  string
+	totpEnabled bool
+	prefs       format.Preferences
+	notify      notify.Preferences
+	revokedAt   time.Time
+	createdAt   time.Time
+	deletedAt   time.Time
+	id          int64
+	// очистка неактивных учетных записей, см. FlagInactiveUsers
+	lastActive time.Time
+	notifiedAt time.Time
+	exempt     bool
+}
+
+type verification struct {
+	login     string
+	expiresAt time.Time
+}
+
+type session struct {
+	id           string
+	sid          int64
+	login        string
+	createdAt    time.Time
+	expiresAt    time.Time
+	lastSeen     time.Time
+	userAgent    string
+	ip           string
+	impersonator string
+	readOnly     bool
+}
+
+type auditEntry struct {
+	actor     string
+	login     string
+	action    string
+	detail    string
+	createdAt time.Time
+}
+
+type order struct {
+	database.Order
+	pollUntil time.Time // продленный администратором срок опроса
+	nextPoll  time.Time // отложенный опрос, см. DeferPoll
+	archived  bool      // перенесен в архив ArchiveOrders
+	createdAt time.Time
+	updatedAt time.Time
+}
+
+type entry struct {
+	login     string
+	amount    float64
+	kind      string
+	order     string
+	accrual   float64
+	rate      float64
+	createdAt time.Time
+}
+
+type withdraw struct {
+	database.WithDraw
+	createdAt time.Time
+}
+
+type snapshot struct {
+	login string
+	day   time.Time
+}
+
+type hold struct {
+	database.WithDrawHold
+	createdAt time.Time
+}
+
+func New(c config.Config) (*Storage, error) {
+	kdf, err := password.NewKDF(c.PasswordKDF, c.PasswordKDFCost)
+	if err != nil {
+		return nil, err
+	}
+
+	passwords := password.Hasher{Pepper: []byte(c.PasswordPepper), KDF: kdf}
+	dummyHash, err := passwords.Hash("")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Storage{
+		users:         make(map[string]*user),
+		verifications: make(map[string]verification),
+		sessions:      make(map[string]*session),
+		orders:        make(map[string]*order),
+		credited:      make(map[string]bool),
+		withdraws:     make(map[string]*withdraw),
+		quota:         make(map[string]int),
+		snapshots:     make(map[snapshot]float64),
+		overrides:     make(map[string]settings.Override),
+		maxAge:        c.AccrualMaxOrderAge,
+		passwords:     passwords,
+		dummyHash:     dummyHash,
+	}
+	// экземпляр один, изменения видны сразу: кешировать нечего
+	s.settings = settings.NewCache(settings.Defaults(c), s, 0)
+
+	return s, nil
+}
+
+// WarmUp только читает настройки: соединений с базой нет
+func (s *Storage) WarmUp(context.Context) error {
+	s.Settings()
+	return nil
+}
+
+// Healthy всегда true: хранилище в памяти доступно, пока жив процесс
+func (s *Storage) Healthy() bool {
+	return true
+}
+
+// Stats возвращает пустую статистику: пула соединений нет
+func (s *Storage) Stats() sql.DBStats {
+	return sql.DBStats{}
+}
+
+// balance - сумма записей журнала за вычетом списаний, вызывается под s.mu
+func (s *Storage) balance(login string) (current, withdrawn float64) {
+	for _, e := range s.ledger {
+		if e.login == login {
+			current += e.amount
+		}
+	}
+
+	for _, w := range s.withdrawList {
+		if w.Login == login {
+			withdrawn += w.Sum
+		}
+	}
+
+	return current - withdrawn, withdrawn
+}
+
+func (s *Storage) nextRevision() int64 {
+	s.revision++
+	return s.revision
+}
+
+// nextSeq выдает порядковый номер заказа или списания, как последовательности orders_seq и withdraw_seq
+func (s *Storage) nextSeq() int64 {
+	s.seq++
+	return s.seq
+}
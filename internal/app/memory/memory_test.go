@@ -0,0 +1,655 @@
+package memory
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/accrual"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/handlers"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/notify"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/password"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/settings"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/worker"
+)
+
+var (
+	_ handlers.Storage       = (*Storage)(nil)
+	_ accrual.Storage        = (*Storage)(nil)
+	_ worker.SnapshotStorage = (*Storage)(nil)
+	_ worker.InactiveStorage = (*Storage)(nil)
+)
+
+func TestStorage(t *testing.T) {
+	s, err := New(config.Config{PasswordKDF: password.KindBcrypt, PasswordKDFCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.NewSession("anon", "agent", "127.0.0.1"); err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	if err = s.Register("username", "password", "anon", "cookie"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err = s.Register("username", "password", "", "other"); !errors.Is(err, database.ErrRegisterConflict) {
+		t.Errorf("Register() again error = %v, want %v", err, database.ErrRegisterConflict)
+	}
+	if err = s.Login("username", "wrong", "", "other"); !errors.Is(err, database.ErrWrongData) {
+		t.Errorf("Login() error = %v, want %v", err, database.ErrWrongData)
+	}
+
+	// после регистрации действует только новый идентификатор сессии, клиент сохраняется
+	client, _ := s.GetSessionClient("cookie")
+	if client.Login != "username" || client.UserAgent != "agent" {
+		t.Errorf("GetSessionClient() = %+v, want username with agent", client)
+	}
+	if client, _ = s.GetSessionClient("anon"); client.Login != "" {
+		t.Errorf("GetSessionClient() old session login = %q, want none", client.Login)
+	}
+
+	if err = s.AddOrder("username", 1234567812345670); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+	if err = s.AddOrder("username", 1234567812345670); !errors.Is(err, database.ErrDuplicate) {
+		t.Errorf("AddOrder() again error = %v, want %v", err, database.ErrDuplicate)
+	}
+	if err = s.AddOrder("username2", 1234567812345670); !errors.Is(err, database.ErrUsed) {
+		t.Errorf("AddOrder() other user error = %v, want %v", err, database.ErrUsed)
+	}
+
+	if err = s.UpdateOrder("1234567812345670", "PROCESSED", 500, 0); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+	// повторное обновление не зачисляет начисление второй раз
+	if err = s.UpdateOrder("1234567812345670", "PROCESSED", 500, 0); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+
+	if err = s.AddWithDraw("username", "2377225624", 600, ""); !errors.Is(err, database.ErrNoMoney) {
+		t.Errorf("AddWithDraw() error = %v, want %v", err, database.ErrNoMoney)
+	}
+	if err = s.AddWithDraw("username", "2377225624", 200, "ref"); err != nil {
+		t.Errorf("AddWithDraw() error = %v", err)
+	}
+	if err = s.AddWithDraw("username", "2377225624", 1, ""); !errors.Is(err, database.ErrBadOrderNumber) {
+		t.Errorf("AddWithDraw() again error = %v, want %v", err, database.ErrBadOrderNumber)
+	}
+
+	balance, err := s.GetBalance("username")
+	if err != nil || balance.Current != 300 || balance.WithDraw != 200 {
+		t.Errorf("GetBalance() = %+v, %v, want current 300, withdrawn 200", balance, err)
+	}
+
+	if err = s.Register("username2", "password", "", "cookie2"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err = s.AddOrder("username2", 79927398713); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+	if err = s.UpdateOrder("79927398713", "PROCESSED", 100, 0); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+
+	balance, _ = s.GetBalance("username2")
+	if balance.Current != 100 {
+		t.Errorf("GetBalance() current = %g, want 100", balance.Current)
+	}
+
+	transfer, err := s.TransferOrder("79927398713", "username")
+	if err != nil || transfer.Amount != 100 || transfer.From != "username2" {
+		t.Errorf("TransferOrder() = %+v, %v", transfer, err)
+	}
+
+	balance, _ = s.GetBalance("username")
+	if balance.Current != 400 {
+		t.Errorf("GetBalance() current = %g, want 400", balance.Current)
+	}
+
+	// импорт не меняет существующего пользователя, новому зачисляется начальный остаток
+	if created, err := s.ImportUser("username", "password", 1000); err != nil || created {
+		t.Errorf("ImportUser() existing = %v, %v, want false", created, err)
+	}
+	if created, err := s.ImportUser("legacy", "password", 250); err != nil || !created {
+		t.Errorf("ImportUser() = %v, %v, want true", created, err)
+	}
+	if balance, _ = s.GetBalance("legacy"); balance.Current != 250 {
+		t.Errorf("GetBalance() imported current = %g, want 250", balance.Current)
+	}
+
+	// заказы отдаются от старых к новым, перенесенный заказ загружен позже
+	for offset, want := range []string{"1234567812345670", "79927398713"} {
+		orders, err := s.GetOrders("username", 1, offset, 0, false)
+		if err != nil || len(orders) != 1 || orders[0].Number != want {
+			t.Errorf("GetOrders(1, %d) = %v, %v, want %s", offset, orders, err, want)
+		}
+	}
+
+	// страница после заказа по его seq
+	first, _ := s.GetOrders("username", 1, 0, 0, false)
+	if orders, err := s.GetOrders("username", 1, 0, first[0].Seq, false); err != nil || len(orders) != 1 ||
+		orders[0].Number != "79927398713" {
+		t.Errorf("GetOrders(1, after %d) = %v, %v, want 79927398713", first[0].Seq, orders, err)
+	}
+
+	// настройки уведомлений меняются по событиям, остальные события остаются по умолчанию
+	if err = s.SetNotificationPreferences("username", notify.Preferences{notify.EventLoginAlert: notify.ChannelNone}); err != nil {
+		t.Fatalf("SetNotificationPreferences() error = %v", err)
+	}
+	prefs, _ := s.GetNotificationPreferences("username")
+	if prefs.Channel(notify.EventLoginAlert) != notify.ChannelNone || prefs.Channel(notify.EventAccrualCredited) != notify.ChannelEmail {
+		t.Errorf("GetNotificationPreferences() = %v", prefs)
+	}
+
+	// снимок за сегодня учитывает все операции, неделя сводится к последнему снимку
+	today := time.Now().UTC()
+	for _, day := range []time.Time{today.AddDate(0, 0, -1), today} {
+		if err = s.SnapshotBalances(day); err != nil {
+			t.Fatalf("SnapshotBalances() error = %v", err)
+		}
+	}
+	if last, _ := s.LastBalanceSnapshot(); !last.Equal(database.ReportStart(today, database.ReportByDay)) {
+		t.Errorf("LastBalanceSnapshot() = %s, want %s", last, database.ReportStart(today, database.ReportByDay))
+	}
+	points, err := s.GetBalanceHistory("username", today.AddDate(0, 0, -7), today.AddDate(0, 0, 1), database.ReportByWeek)
+	if err != nil || len(points) == 0 || points[len(points)-1].Balance != 400 {
+		t.Errorf("GetBalanceHistory() = %+v, %v, want last balance 400", points, err)
+	}
+}
+
+func TestPointRate(t *testing.T) {
+	s, err := New(config.Config{PasswordKDF: password.KindBcrypt, PasswordKDFCost: 4, AccrualPointRate: 0.1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Register("username", "password", "", "cookie"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err = s.AddOrder("username", 1234567812345670); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+	if err = s.UpdateOrder("1234567812345670", "PROCESSED", 500, 0); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+	if err = s.CorrectAccrual("1234567812345670", 520); err != nil {
+		t.Fatalf("CorrectAccrual() error = %v", err)
+	}
+
+	if balance, _ := s.GetBalance("username"); balance.Current != 52 {
+		t.Errorf("GetBalance() current = %g, want 52", balance.Current)
+	}
+}
+
+func TestOrderExpiry(t *testing.T) {
+	s, err := New(config.Config{PasswordKDF: password.KindBcrypt, PasswordKDFCost: 4, AccrualMaxOrderAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Register("username", "password", "", "cookie"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	for _, number := range []int{49927398716, 1234567812345670} {
+		if err = s.AddOrder("username", number); err != nil {
+			t.Fatalf("AddOrder() error = %v", err)
+		}
+	}
+	if err = s.UpdateOrder("1234567812345670", "PROCESSED", 500, 0); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+
+	orders, _ := s.GetOrders("username", 0, 0, 0, false)
+	for _, o := range orders {
+		if want := o.Status == "NEW"; want != !o.PollUntil.IsZero() {
+			t.Errorf("GetOrders() %s poll until = %v", o.Number, o.PollUntil)
+		}
+	}
+
+	if expired, err := s.ExpireOrder("49927398716"); err != nil || expired {
+		t.Errorf("ExpireOrder() before deadline = %v, %v, want false", expired, err)
+	}
+
+	s.orders["49927398716"].UploadedAt = time.Now().Add(-2 * time.Hour)
+	if expired, err := s.ExpireOrder("49927398716"); err != nil || !expired {
+		t.Errorf("ExpireOrder() = %v, %v, want true", expired, err)
+	}
+	if expired, err := s.ExpireOrder("1234567812345670"); err != nil || expired {
+		t.Errorf("ExpireOrder() processed = %v, %v, want false", expired, err)
+	}
+
+	// продление возвращает просроченный заказ в очередь, обработанный заказ не продлевается
+	until := time.Now().Add(24 * time.Hour)
+	order, resumed, err := s.ExtendOrder("49927398716", until)
+	if err != nil || !resumed || order.Status != "NEW" || !order.PollUntil.Equal(until) {
+		t.Errorf("ExtendOrder() = %+v, %v, %v, want NEW until %v", order, resumed, err, until)
+	}
+	if expired, err := s.ExpireOrder("49927398716"); err != nil || expired {
+		t.Errorf("ExpireOrder() after extension = %v, %v, want false", expired, err)
+	}
+	if _, _, err = s.ExtendOrder("1234567812345670", until); !errors.Is(err, database.ErrWrongData) {
+		t.Errorf("ExtendOrder() processed error = %v, want %v", err, database.ErrWrongData)
+	}
+	if _, _, err = s.ExtendOrder("79927398713", until); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("ExtendOrder() unknown error = %v, want %v", err, database.ErrNotFound)
+	}
+}
+
+func TestImpersonation(t *testing.T) {
+	s, err := New(config.Config{PasswordKDF: password.KindBcrypt, PasswordKDFCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Register("username", "password", "", "cookie"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	if err = s.Impersonate(database.Impersonation{Admin: "admin", Login: "missing", Session: "other", ReadOnly: true, ExpiresAt: expiresAt}); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Impersonate() unknown user error = %v, want %v", err, database.ErrNotFound)
+	}
+
+	if err = s.Impersonate(database.Impersonation{Admin: "admin", Login: "username", Session: "impersonated", ReadOnly: true, ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("Impersonate() error = %v", err)
+	}
+
+	client, err := s.GetSessionClient("impersonated")
+	if err != nil || client.Login != "username" || client.Impersonator != "admin" || !client.ReadOnly {
+		t.Errorf("GetSessionClient() = %+v, %v, want username impersonated by admin, read only", client, err)
+	}
+
+	// вход от имени пользователя не продлевается
+	if err = s.RefreshSession("impersonated", "refreshed"); !errors.Is(err, database.ErrWrongData) {
+		t.Errorf("RefreshSession() impersonated error = %v, want %v", err, database.ErrWrongData)
+	}
+
+	if err = s.AddAudit("admin", "username", database.AuditRequest, "GET /api/user/orders"); err != nil {
+		t.Fatalf("AddAudit() error = %v", err)
+	}
+	if len(s.audit) != 2 || s.audit[0].action != database.AuditImpersonate || s.audit[1].detail != "GET /api/user/orders" {
+		t.Errorf("audit = %+v, want impersonate and request entries", s.audit)
+	}
+}
+
+func TestArchiveOrders(t *testing.T) {
+	s, err := New(config.Config{PasswordKDF: password.KindBcrypt, PasswordKDFCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, number := range []int{49927398716, 2377225624} {
+		if err = s.AddOrder("username", number); err != nil {
+			t.Fatalf("AddOrder() error = %v", err)
+		}
+	}
+	if err = s.UpdateOrder("49927398716", "PROCESSED", 500, 0); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+
+	if archived, err := s.ArchiveOrders(time.Now().Add(-time.Hour)); err != nil || archived != 0 {
+		t.Errorf("ArchiveOrders() recent = %d, %v, want 0", archived, err)
+	}
+	if archived, err := s.ArchiveOrders(time.Now().Add(time.Minute)); err != nil || archived != 1 {
+		t.Errorf("ArchiveOrders() = %d, %v, want 1", archived, err)
+	}
+
+	if orders, _ := s.GetOrders("username", 0, 0, 0, false); len(orders) != 1 || orders[0].Number != "2377225624" {
+		t.Errorf("GetOrders() = %v, want only the new order", orders)
+	}
+	if orders, _ := s.GetOrders("username", 0, 0, 0, true); len(orders) != 2 {
+		t.Errorf("GetOrders() archived = %v, want 2 orders", orders)
+	}
+	if err = s.AddOrder("username", 49927398716); !errors.Is(err, database.ErrDuplicate) {
+		t.Errorf("AddOrder() archived error = %v, want %v", err, database.ErrDuplicate)
+	}
+}
+
+func TestRenameUser(t *testing.T) {
+	s, err := New(config.Config{PasswordKDF: password.KindBcrypt, PasswordKDFCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Register("username", "password", "", "cookie"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err = s.Register("other", "password", "", "other"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err = s.AddOrder("username", 49927398716); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+	if err = s.UpdateOrder("49927398716", "PROCESSED", 500, 0); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+
+	id, err := s.GetUserID("username")
+	if err != nil {
+		t.Fatalf("GetUserID() error = %v", err)
+	}
+
+	if err = s.RenameUser("username", "other"); !errors.Is(err, database.ErrRegisterConflict) {
+		t.Errorf("RenameUser() taken login error = %v, want %v", err, database.ErrRegisterConflict)
+	}
+	if err = s.RenameUser("unknown", "renamed"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("RenameUser() unknown error = %v, want %v", err, database.ErrNotFound)
+	}
+	if err = s.RenameUser("username", "renamed"); err != nil {
+		t.Fatalf("RenameUser() error = %v", err)
+	}
+
+	if client, err := s.GetSessionClient("cookie"); err != nil || client.Login != "renamed" {
+		t.Errorf("GetSessionClient() = %+v, %v, want renamed", client, err)
+	}
+	if got, err := s.GetUserID("renamed"); err != nil || got != id {
+		t.Errorf("GetUserID() = %d, %v, want %d", got, err, id)
+	}
+	if orders, _ := s.GetOrders("renamed", 0, 0, 0, false); len(orders) != 1 {
+		t.Errorf("GetOrders() = %v, want 1 order", orders)
+	}
+	if balance, err := s.GetBalance("renamed"); err != nil || balance.Current != 500 {
+		t.Errorf("GetBalance() = %+v, %v, want 500", balance, err)
+	}
+	if err = s.CheckPassword("renamed", "password"); err != nil {
+		t.Errorf("CheckPassword() error = %v", err)
+	}
+	if _, err = s.GetUserID("username"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("GetUserID() old login error = %v, want %v", err, database.ErrNotFound)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	s, err := New(config.Config{PasswordKDF: password.KindBcrypt, PasswordKDFCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Register("username", "password", "", "cookie"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err = s.AddOrder("username", 49927398716); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+	if err = s.UpdateOrder("49927398716", "PROCESSED", 500, 0); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+
+	if err = s.DeleteUser("username"); err != nil {
+		t.Fatalf("DeleteUser() error = %v", err)
+	}
+
+	if client, err := s.GetSessionClient("cookie"); err != nil || client.Login != "" {
+		t.Errorf("GetSessionClient() = %+v, %v, want empty login", client, err)
+	}
+	if _, err = s.SessionsRevokedAt("username"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("SessionsRevokedAt() error = %v, want %v", err, database.ErrNotFound)
+	}
+	if err = s.CheckPassword("username", "password"); !errors.Is(err, database.ErrWrongData) {
+		t.Errorf("CheckPassword() error = %v, want %v", err, database.ErrWrongData)
+	}
+
+	// заказы и начисления остаются за обезличенным логином
+	anonymous := database.DeletedLogin(1)
+	if orders, _ := s.GetOrders(anonymous, 0, 0, 0, false); len(orders) != 1 {
+		t.Errorf("GetOrders() anonymized = %v, want 1 order", orders)
+	}
+	if balance, err := s.GetBalance(anonymous); err != nil || balance.Current != 500 {
+		t.Errorf("GetBalance() anonymized = %+v, %v, want 500", balance, err)
+	}
+	if err = s.DeleteUser(anonymous); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("DeleteUser() deleted error = %v, want %v", err, database.ErrNotFound)
+	}
+
+	if err = s.Register("username", "password", "", "other"); err != nil {
+		t.Errorf("Register() freed login error = %v", err)
+	}
+
+	if purged, err := s.PurgeDeletedUsers(time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+		t.Errorf("PurgeDeletedUsers() before retention = %d, %v, want 0", purged, err)
+	}
+	if purged, err := s.PurgeDeletedUsers(time.Now()); err != nil || purged != 1 {
+		t.Errorf("PurgeDeletedUsers() = %d, %v, want 1", purged, err)
+	}
+	if _, err = s.GetBalance(anonymous); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("GetBalance() purged error = %v, want %v", err, database.ErrNotFound)
+	}
+	if orders, _ := s.GetOrders(anonymous, 0, 0, 0, false); len(orders) != 0 {
+		t.Errorf("GetOrders() purged = %v, want none", orders)
+	}
+}
+
+func TestAddOrders(t *testing.T) {
+	s, err := New(config.Config{PasswordKDF: password.KindBcrypt, PasswordKDFCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.AddOrder("other", 2377225624); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+	if err = s.AddOrder("username", 49927398716); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+
+	results, err := s.AddOrders("username", []string{"49927398716", "2377225624", "1234567812345670"})
+	if err != nil {
+		t.Fatalf("AddOrders() error = %v", err)
+	}
+	want := map[string]error{"49927398716": database.ErrDuplicate, "2377225624": database.ErrUsed, "1234567812345670": nil}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("AddOrders() = %v, want %v", results, want)
+	}
+
+	if ok, err := s.TakeOrderQuota("username", 3, 4); err != nil || !ok {
+		t.Errorf("TakeOrderQuota() = %v, %v, want true", ok, err)
+	}
+	if ok, err := s.TakeOrderQuota("username", 2, 4); err != nil || ok {
+		t.Errorf("TakeOrderQuota() over limit = %v, %v, want false", ok, err)
+	}
+	if err = s.ReturnOrderQuota("username", 1); err != nil {
+		t.Fatalf("ReturnOrderQuota() error = %v", err)
+	}
+	if ok, err := s.TakeOrderQuota("username", 2, 4); err != nil || !ok {
+		t.Errorf("TakeOrderQuota() after return = %v, %v, want true", ok, err)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	s, err := New(config.Config{PasswordKDF: password.KindBcrypt, PasswordKDFCost: 4, AccrualPointRate: 0.1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.PutSetting(settings.AccrualPointRate, "2", "admin"); err != nil {
+		t.Fatalf("PutSetting() error = %v", err)
+	}
+
+	if err = s.Register("username", "password", "", "cookie"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err = s.AddOrder("username", 1234567812345670); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+	if err = s.UpdateOrder("1234567812345670", "PROCESSED", 500, 0); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+	if balance, _ := s.GetBalance("username"); balance.Current != 1000 {
+		t.Errorf("GetBalance() current = %g, want 1000", balance.Current)
+	}
+
+	if err = s.DeleteSetting(settings.AccrualPointRate, "admin"); err != nil {
+		t.Fatalf("DeleteSetting() error = %v", err)
+	}
+	if rate := s.Settings().AccrualPointRate; rate != 0.1 {
+		t.Errorf("Settings() rate after reset = %g, want 0.1", rate)
+	}
+	if err = s.DeleteSetting(settings.AccrualPointRate, "admin"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("DeleteSetting() again error = %v, want %v", err, database.ErrNotFound)
+	}
+
+	if len(s.audit) != 2 || s.audit[0].action != database.AuditSetting || s.audit[0].detail != "accrual_point_rate = 2" {
+		t.Errorf("audit = %+v, want setting change and reset", s.audit)
+	}
+}
+
+func TestOrderRevision(t *testing.T) {
+	s, err := New(config.Config{PasswordKDF: password.KindBcrypt, PasswordKDFCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.AddOrder("username", 49927398716); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+
+	revision, err := s.GetOrderRevision("49927398716")
+	if err != nil {
+		t.Fatalf("GetOrderRevision() error = %v", err)
+	}
+
+	// администратор продлил опрос, пока воркер ждал ответа системы расчета
+	if _, _, err = s.ExtendOrder("49927398716", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("ExtendOrder() error = %v", err)
+	}
+
+	if err = s.UpdateOrder("49927398716", "PROCESSED", 500, revision); !errors.Is(err, database.ErrConflict) {
+		t.Errorf("UpdateOrder() stale revision error = %v, want %v", err, database.ErrConflict)
+	}
+
+	if revision, err = s.GetOrderRevision("49927398716"); err != nil {
+		t.Fatalf("GetOrderRevision() error = %v", err)
+	}
+	if err = s.UpdateOrder("49927398716", "PROCESSED", 500, revision); err != nil {
+		t.Errorf("UpdateOrder() error = %v", err)
+	}
+
+	if _, err = s.GetOrderRevision("2377225624"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("GetOrderRevision() unknown error = %v, want %v", err, database.ErrNotFound)
+	}
+}
+
+func TestDeferPoll(t *testing.T) {
+	s, err := New(config.Config{PasswordKDF: password.KindBcrypt, PasswordKDFCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, number := range []int{49927398716, 2377225624, 79927398713} {
+		if err = s.AddOrder("username", number); err != nil {
+			t.Fatalf("AddOrder() error = %v", err)
+		}
+	}
+
+	// отложенный заказ не опрашивается до срока, не отложенные опрашиваются раньше отложенных
+	if err = s.DeferPoll("49927398716", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("DeferPoll() error = %v", err)
+	}
+	if err = s.DeferPoll("79927398713", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("DeferPoll() error = %v", err)
+	}
+
+	orders, err := s.GetNotCheckedOrders()
+	if err != nil {
+		t.Fatalf("GetNotCheckedOrders() error = %v", err)
+	}
+	if want := []string{"2377225624", "79927398713"}; !reflect.DeepEqual(orders, want) {
+		t.Errorf("GetNotCheckedOrders() = %v, want %v", orders, want)
+	}
+}
+
+func TestInactiveUsers(t *testing.T) {
+	s, err := New(config.Config{PasswordKDF: password.KindBcrypt, PasswordKDFCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, login := range []string{"idle", "rich", "exempt", "active"} {
+		if err = s.Register(login, "password", "", "cookie"+strconv.Itoa(i)); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	}
+
+	now := time.Now()
+	for _, login := range []string{"idle", "rich", "exempt"} {
+		s.users[login].lastActive = now.AddDate(0, -7, 0)
+	}
+
+	if err = s.AddOrder("rich", 49927398716); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+	if err = s.UpdateOrder("49927398716", "PROCESSED", 500, 0); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+
+	if err = s.SetInactivityExempt("exempt", true, "admin"); err != nil {
+		t.Fatalf("SetInactivityExempt() error = %v", err)
+	}
+	if err = s.SetInactivityExempt("unknown", true, "admin"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("SetInactivityExempt() unknown error = %v, want %v", err, database.ErrNotFound)
+	}
+	if exempt, _ := s.GetInactivityExempt(); !reflect.DeepEqual(exempt, []string{"exempt"}) {
+		t.Errorf("GetInactivityExempt() = %v, want [exempt]", exempt)
+	}
+
+	flagged, err := s.FlagInactiveUsers(now.AddDate(0, -6, 0))
+	if err != nil || !reflect.DeepEqual(flagged, []string{"idle", "rich"}) {
+		t.Fatalf("FlagInactiveUsers() = %v, %v, want [idle rich]", flagged, err)
+	}
+	// предупрежденных повторно не отмечают
+	if flagged, _ = s.FlagInactiveUsers(now.AddDate(0, -6, 0)); flagged != nil {
+		t.Errorf("FlagInactiveUsers() again = %v, want none", flagged)
+	}
+
+	// до конца срока после предупреждения учетные записи не обезличиваются
+	if anonymized, _ := s.AnonymizeInactiveUsers(now.Add(-time.Hour)); anonymized != nil {
+		t.Errorf("AnonymizeInactiveUsers() before grace = %v, want none", anonymized)
+	}
+
+	// учетная запись с баллами остается
+	id, _ := s.GetUserID("idle")
+	anonymized, err := s.AnonymizeInactiveUsers(time.Now())
+	if err != nil || !reflect.DeepEqual(anonymized, []string{database.DeletedLogin(id)}) {
+		t.Fatalf("AnonymizeInactiveUsers() = %v, %v, want [%s]", anonymized, err, database.DeletedLogin(id))
+	}
+	if _, err = s.GetUserID("idle"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("GetUserID() anonymized error = %v, want %v", err, database.ErrNotFound)
+	}
+
+	// вход снимает отметку
+	if err = s.Login("rich", "password", "", "cookie9"); err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if !s.users["rich"].notifiedAt.IsZero() {
+		t.Error("Login() kept inactivity flag")
+	}
+}
+
+func TestDeleteExpiredSessions(t *testing.T) {
+	s, err := New(config.Config{PasswordKDF: password.KindBcrypt, PasswordKDFCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cookie := range []string{"anon", "expired"} {
+		if err = s.NewSession(cookie, "agent", "127.0.0.1"); err != nil {
+			t.Fatalf("NewSession() error = %v", err)
+		}
+	}
+	if err = s.Register("username", "password", "", "cookie"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	s.sessions["expired"].expiresAt = time.Now().Add(-time.Minute)
+	s.sessions["cookie"].expiresAt = time.Now().Add(-time.Minute)
+
+	if deleted, err := s.DeleteExpiredSessions(); err != nil || deleted != 2 {
+		t.Fatalf("DeleteExpiredSessions() = %d, %v, want 2", deleted, err)
+	}
+	if _, ok := s.sessions["anon"]; !ok || len(s.sessions) != 1 {
+		t.Errorf("sessions = %v, want only anon", s.sessions)
+	}
+}
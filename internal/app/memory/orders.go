@@ -0,0 +1,470 @@
+package memory
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/domain"
+)
+
+func (s *Storage) AddOrder(login string, order int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.putOrder(login, strconv.Itoa(order))
+}
+
+// AddOrders сохраняет пачку заказов пользователя, ошибки - по каждому номеру, как у AddOrder
+func (s *Storage) AddOrders(login string, numbers []string) (map[string]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make(map[string]error, len(numbers))
+	for _, number := range numbers {
+		results[number] = s.putOrder(login, number)
+	}
+
+	return results, nil
+}
+
+// putOrder вызывается под s.mu
+func (s *Storage) putOrder(login, key string) error {
+	if o, ok := s.orders[key]; ok {
+		if o.Login != login {
+			return database.ErrUsed
+		}
+
+		return database.ErrDuplicate
+	}
+
+	now := time.Now()
+	o := &order{
+		Order: database.Order{Seq: s.nextSeq(), Number: key, Login: login, Status: domain.StatusNew, UploadedAt: now,
+			Revision: s.nextRevision()},
+		createdAt: now,
+		updatedAt: now,
+	}
+	s.orders[key] = o
+	s.orderList = append(s.orderList, o)
+
+	return nil
+}
+
+// GetOrderOwner возвращает логин владельца заказа
+func (s *Storage) GetOrderOwner(number string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok {
+		return "", database.ErrNotFound
+	}
+
+	return o.Login, nil
+}
+
+// TakeOrderQuota учитывает n попыток загрузки заказа в дневной квоте пользователя
+func (s *Storage) TakeOrderQuota(login string, n, limit int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := login + "/" + time.Now().UTC().Format("2006-01-02")
+	if s.quota[key]+n > limit {
+		return false, nil
+	}
+
+	s.quota[key] += n
+
+	return true, nil
+}
+
+// ReturnOrderQuota возвращает в дневную квоту пользователя n попыток
+func (s *Storage) ReturnOrderQuota(login string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := login + "/" + time.Now().UTC().Format("2006-01-02")
+	s.quota[key] = max(s.quota[key]-n, 0)
+
+	return nil
+}
+
+// GetNotCheckedOrders возвращает заказы NEW и PROCESSING, которые пора опросить (см. DeferPoll):
+// сначала ни разу не отложенные, затем по времени опроса
+func (s *Storage) GetNotCheckedOrders() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	quarantined := make(map[string]bool, len(s.quarantine))
+	for _, q := range s.quarantine {
+		quarantined[q.Number] = true
+	}
+
+	now := time.Now()
+	var due []*order
+	for _, o := range s.orderList {
+		if (o.Status == domain.StatusNew || o.Status == domain.StatusProcessing) && !quarantined[o.Number] && !o.nextPoll.After(now) {
+			due = append(due, o)
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].nextPoll.Before(due[j].nextPoll)
+	})
+
+	var orders []string
+	for _, o := range due {
+		orders = append(orders, o.Number)
+	}
+
+	return orders, nil
+}
+
+// DeferPoll откладывает следующий опрос заказа NEW или PROCESSING до at
+func (s *Storage) DeferPoll(number string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if o, ok := s.orders[number]; ok && (o.Status == domain.StatusNew || o.Status == domain.StatusProcessing) {
+		o.nextPoll = at
+	}
+
+	return nil
+}
+
+// UpdateOrder сохраняет статус и начисление заказа версии revision и зачисляет начисление
+// обработанного заказа. Другая версия - ErrConflict, revision 0 - без проверки версии.
+func (s *Storage) UpdateOrder(number, status string, accrual float64, revision int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok {
+		return database.ErrNotFound
+	}
+
+	if revision != 0 && o.Revision != revision {
+		return database.ErrConflict
+	}
+
+	o.Status, o.Accrual = status, accrual
+	o.updatedAt, o.Revision = time.Now(), s.nextRevision()
+
+	if status == domain.StatusProcessed {
+		s.creditAccrual(o)
+	}
+
+	log.Printf("update order: number: %s, status: %s, accrual: %g", number, status, accrual)
+
+	return nil
+}
+
+// GetOrderRevision возвращает версию заказа для UpdateOrder
+func (s *Storage) GetOrderRevision(number string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok {
+		return 0, database.ErrNotFound
+	}
+
+	return o.Revision, nil
+}
+
+// creditAccrual зачисляет начисление по заказу один раз, анонимный заказ ждет владельца; вызывается под s.mu
+func (s *Storage) creditAccrual(o *order) {
+	if o.Login == "" || o.Accrual <= 0 || s.credited[o.Number] {
+		return
+	}
+
+	s.credited[o.Number] = true
+	s.addConversion(o.Login, o.Accrual, database.LedgerAccrual, o.Number)
+}
+
+func (s *Storage) addLedger(login string, amount float64, kind, order string) {
+	s.ledger = append(s.ledger, entry{login: login, amount: amount, kind: kind, order: order, createdAt: time.Now()})
+}
+
+// addConversion записывает в журнал сумму системы расчета accrual, переведенную в баллы
+func (s *Storage) addConversion(login string, accrual float64, kind, order string) {
+	s.ledger = append(s.ledger, entry{login: login, amount: database.Points(accrual, s.pointRate()), kind: kind, order: order,
+		accrual: accrual, rate: s.pointRate(), createdAt: time.Now()})
+}
+
+// GetOrders возвращает заказы пользователя от старых к новым: limit заказов после заказа с Seq after,
+// пропустив первые offset. Нулевой limit - все заказы, нулевой after - с начала списка.
+// archived - вместе с заказами из архива.
+func (s *Storage) GetOrders(login string, limit, offset int, after int64, archived bool) ([]database.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var orders []database.Order
+	for _, o := range s.orderList {
+		if limit > 0 && len(orders) == limit {
+			break
+		}
+
+		if o.Login != login || o.archived && !archived || o.Seq <= after {
+			continue
+		}
+
+		if offset > 0 {
+			offset--
+			continue
+		}
+
+		orders = append(orders, database.Order{Seq: o.Seq, Number: o.Number, Status: o.Status, Accrual: o.Accrual,
+			UploadedAt: o.UploadedAt, PollUntil: s.deadline(o)})
+	}
+
+	if orders == nil {
+		return nil, database.ErrEmpty
+	}
+
+	return orders, nil
+}
+
+// GetOrder возвращает заказ number пользователя login, в том числе из архива. Чужой или
+// незагруженный заказ - database.ErrNotFound.
+func (s *Storage) GetOrder(login, number string) (database.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok || login == "" || o.Login != login {
+		return database.Order{}, database.ErrNotFound
+	}
+
+	return database.Order{Seq: o.Seq, Number: o.Number, Status: o.Status, Accrual: o.Accrual, UploadedAt: o.UploadedAt,
+		PollUntil: s.deadline(o)}, nil
+}
+
+// ArchiveOrders помечает архивными обработанные и отклоненные заказы пользователей, загруженные
+// до before: GetOrders отдает их только с archived. Номера остаются занятыми.
+func (s *Storage) ArchiveOrders(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var archived int64
+	for _, o := range s.orderList {
+		if o.archived || o.Login == "" || !o.UploadedAt.Before(before) ||
+			o.Status != domain.StatusProcessed && o.Status != domain.StatusInvalid {
+			continue
+		}
+
+		o.archived = true
+		archived++
+	}
+
+	return archived, nil
+}
+
+// deadline возвращает срок опроса заказа, ждущего расчета, нулевой - без срока; вызывается под s.mu
+func (s *Storage) deadline(o *order) time.Time {
+	switch {
+	case o.Status != domain.StatusNew && o.Status != domain.StatusProcessing:
+		return time.Time{}
+	case !o.pollUntil.IsZero():
+		return o.pollUntil
+	case s.maxAge > 0:
+		return o.UploadedAt.Add(s.maxAge)
+	}
+
+	return time.Time{}
+}
+
+// ExpireOrder переводит заказ NEW или PROCESSING в EXPIRED, если срок его опроса прошел
+func (s *Storage) ExpireOrder(number string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok {
+		return false, nil
+	}
+
+	deadline := s.deadline(o)
+	if deadline.IsZero() || !deadline.Before(time.Now()) {
+		return false, nil
+	}
+
+	o.Status = domain.StatusExpired
+	o.updatedAt, o.Revision = time.Now(), s.nextRevision()
+
+	log.Printf("update order: number: %s, status: EXPIRED", number)
+
+	return true, nil
+}
+
+// ExtendOrder продлевает опрос заказа до until, заказ EXPIRED снова получает статус NEW
+func (s *Storage) ExtendOrder(number string, until time.Time) (database.Order, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok || o.archived {
+		return database.Order{}, false, database.ErrNotFound
+	}
+
+	resumed := o.Status == domain.StatusExpired
+	if !resumed && o.Status != domain.StatusNew && o.Status != domain.StatusProcessing {
+		return database.Order{}, false, database.ErrWrongData
+	}
+
+	if resumed {
+		o.Status = domain.StatusNew
+	}
+	o.pollUntil = until
+	o.updatedAt, o.Revision = time.Now(), s.nextRevision()
+
+	return database.Order{Number: o.Number, Login: o.Login, Status: o.Status, UploadedAt: o.UploadedAt, PollUntil: until}, resumed, nil
+}
+
+// GetChangedOrders возвращает заказы пользователя, изменившиеся после курсора revision и после since
+func (s *Storage) GetChangedOrders(login string, revision int64, since time.Time) ([]database.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var orders []database.Order
+	for _, o := range s.orderList {
+		if o.Login == login && o.Revision > revision && o.updatedAt.After(since) {
+			orders = append(orders, database.Order{Seq: o.Seq, Number: o.Number, Status: o.Status, Accrual: o.Accrual,
+				UploadedAt: o.UploadedAt, Revision: o.Revision})
+		}
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].Revision < orders[j].Revision
+	})
+
+	return orders, nil
+}
+
+// GetProcessedOrders возвращает обработанные заказы, загруженные в промежутке [from, to)
+func (s *Storage) GetProcessedOrders(from, to time.Time) ([]database.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var orders []database.Order
+	for _, o := range s.orderList {
+		if o.Status == domain.StatusProcessed && o.Login != "" && !o.createdAt.Before(from) && o.createdAt.Before(to) {
+			orders = append(orders, database.Order{Number: o.Number, Login: o.Login, Status: o.Status,
+				Accrual: o.Accrual, UploadedAt: o.UploadedAt})
+		}
+	}
+
+	return orders, nil
+}
+
+// CorrectAccrual исправляет начисление по заказу и записывает разницу в журнал
+func (s *Storage) CorrectAccrual(number string, accrual float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok {
+		return database.ErrNotFound
+	}
+
+	if o.Accrual == accrual {
+		return nil
+	}
+
+	s.addConversion(o.Login, accrual-o.Accrual, database.LedgerCorrection, number)
+	o.Accrual = accrual
+	o.updatedAt, o.Revision = time.Now(), s.nextRevision()
+
+	return nil
+}
+
+// TransferOrder переносит заказ к пользователю to вместе с зачисленными по нему баллами
+func (s *Storage) TransferOrder(number, to string) (database.Transfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok || o.archived {
+		return database.Transfer{}, database.ErrNotFound
+	}
+
+	if o.Login == to {
+		return database.Transfer{}, database.ErrDuplicate
+	}
+
+	if _, ok = s.users[to]; !ok {
+		return database.Transfer{}, database.ErrWrongData
+	}
+
+	transfer := database.Transfer{Number: number, From: o.Login, To: to}
+	credit := s.orderCredit(number, o.Login)
+	if credit > 0 {
+		if current, _ := s.balance(o.Login); current < credit {
+			return database.Transfer{}, database.ErrNoMoney
+		}
+	}
+
+	o.Login = to
+	o.updatedAt, o.Revision = time.Now(), s.nextRevision()
+
+	if credit != 0 {
+		s.addLedger(transfer.From, -credit, database.LedgerTransfer, number)
+		s.addLedger(to, credit, database.LedgerTransfer, number)
+	}
+
+	if o.Status == domain.StatusProcessed {
+		s.creditAccrual(o)
+	}
+
+	transfer.Amount = s.orderCredit(number, to)
+	log.Printf("transfer order: number: %s, from: %s, to: %s, amount: %g", number, transfer.From, to, transfer.Amount)
+
+	return transfer, nil
+}
+
+// orderCredit - баллы по заказу, числящиеся за login, вызывается под s.mu
+func (s *Storage) orderCredit(number, login string) float64 {
+	if login == "" {
+		return 0
+	}
+
+	var credit float64
+	for _, e := range s.ledger {
+		if e.order == number && e.login == login {
+			credit += e.amount
+		}
+	}
+
+	return credit
+}
+
+// Quarantine сохраняет подозрительный ответ системы расчета вместо начисления
+func (s *Storage) Quarantine(number, status string, accrual float64, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, q := range s.quarantine {
+		if q.Number == number {
+			return nil
+		}
+	}
+
+	s.quarantine = append(s.quarantine, database.QuarantinedAccrual{Number: number, Status: status, Accrual: accrual,
+		Reason: reason, CreatedAt: time.Now().Format(time.RFC3339)})
+
+	return nil
+}
+
+func (s *Storage) GetQuarantine() ([]database.QuarantinedAccrual, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.quarantine) == 0 {
+		return nil, database.ErrEmpty
+	}
+
+	return append([]database.QuarantinedAccrual(nil), s.quarantine...), nil
+}
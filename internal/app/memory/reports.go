@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+)
+
+// GetReport возвращает показатели за [from, to) по суткам или неделям, как database.DataBase.GetReport
+func (s *Storage) GetReport(from, to time.Time, groupBy string) ([]database.ReportBucket, error) {
+	step := 1
+	if groupBy == database.ReportByWeek {
+		step = 7
+	}
+
+	var buckets []database.ReportBucket
+	for start := database.ReportStart(from, groupBy); start.Before(to); start = start.AddDate(0, 0, step) {
+		buckets = append(buckets, database.ReportBucket{Start: start})
+	}
+
+	index := make(map[int64]*database.ReportBucket, len(buckets))
+	for i := range buckets {
+		index[buckets[i].Start.Unix()] = &buckets[i]
+	}
+
+	bucket := func(t time.Time) *database.ReportBucket {
+		if t.Before(from) || !t.Before(to) {
+			return nil
+		}
+
+		return index[database.ReportStart(t, groupBy).Unix()]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.ledger {
+		if b := bucket(e.createdAt); b != nil && e.kind != database.LedgerTransfer && e.kind != database.LedgerOpening {
+			b.Accrued += e.amount
+		}
+	}
+
+	active := make(map[*database.ReportBucket]map[string]bool)
+	activity := func(login string, t time.Time) {
+		b := bucket(t)
+		if b == nil || login == "" {
+			return
+		}
+
+		if active[b] == nil {
+			active[b] = make(map[string]bool)
+		}
+		active[b][login] = true
+	}
+
+	for _, o := range s.orderList {
+		activity(o.Login, o.createdAt)
+	}
+
+	for _, w := range s.withdrawList {
+		if b := bucket(w.createdAt); b != nil {
+			b.Withdrawn += w.Sum
+		}
+		activity(w.Login, w.createdAt)
+	}
+
+	for b, logins := range active {
+		b.ActiveUsers = int64(len(logins))
+	}
+
+	for _, u := range s.users {
+		if b := bucket(u.createdAt); b != nil {
+			b.Registrations++
+		}
+	}
+
+	return buckets, nil
+}
@@ -0,0 +1,258 @@
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+)
+
+// NewSession создает анонимную сессию для только что выданной cookie
+func (s *Storage) NewSession(cookie, userAgent, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[cookie]; ok {
+		return nil
+	}
+
+	s.sid++
+	now := time.Now()
+	s.sessions[cookie] = &session{id: cookie, sid: s.sid, createdAt: now, expiresAt: now.Add(s.sessionTTL()),
+		userAgent: userAgent, ip: ip}
+
+	return nil
+}
+
+// upgradeSession заменяет сессию cookie сессией newCookie пользователя login, вызывается под s.mu
+func (s *Storage) upgradeSession(cookie, newCookie, login string) {
+	now := time.Now()
+	for id, sess := range s.sessions {
+		if sess.login == login && !sess.expiresAt.After(now) {
+			delete(s.sessions, id)
+		}
+	}
+
+	s.sid++
+	sess := &session{id: newCookie, sid: s.sid, login: login, createdAt: now, expiresAt: now.Add(s.sessionTTL())}
+	if old, ok := s.sessions[cookie]; ok {
+		sess.userAgent, sess.ip = old.userAgent, old.ip
+		delete(s.sessions, cookie)
+	}
+	s.sessions[newCookie] = sess
+
+	if u, ok := s.users[login]; ok {
+		u.lastActive, u.notifiedAt = now, time.Time{}
+	}
+}
+
+// active возвращает действующую сессию пользователя, вызывается под s.mu
+func (s *Storage) active(cookie string) (*session, bool) {
+	sess, ok := s.sessions[cookie]
+	if !ok || sess.login == "" || !sess.expiresAt.After(time.Now()) {
+		return nil, false
+	}
+
+	return sess, true
+}
+
+func (s *Storage) GetSessionClient(cookie string) (database.SessionClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.active(cookie)
+	if !ok {
+		return database.SessionClient{}, nil
+	}
+
+	return database.SessionClient{Login: sess.login, ID: sess.sid, UserAgent: sess.userAgent, IP: sess.ip,
+		Impersonator: sess.impersonator, ReadOnly: sess.readOnly}, nil
+}
+
+// RefreshSession заменяет идентификатор действующей сессии cookie на newCookie и продлевает ее
+func (s *Storage) RefreshSession(cookie, newCookie string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.active(cookie)
+	if !ok || sess.impersonator != "" {
+		return database.ErrWrongData
+	}
+
+	delete(s.sessions, cookie)
+	sess.id = newCookie
+	sess.expiresAt = time.Now().Add(s.sessionTTL())
+	s.sessions[newCookie] = sess
+
+	return nil
+}
+
+func (s *Storage) SessionAge(cookie string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[cookie]
+	if !ok {
+		return 0, nil
+	}
+
+	return time.Since(sess.createdAt), nil
+}
+
+func (s *Storage) Logout(cookie string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, cookie)
+
+	return nil
+}
+
+// DeleteExpiredSessions удаляет истекшие сессии всех пользователей и анонимные
+func (s *Storage) DeleteExpiredSessions() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	now := time.Now()
+	for id, sess := range s.sessions {
+		if !sess.expiresAt.After(now) {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *Storage) GetSessions(login, cookie string) ([]database.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	sessions := []database.Session{}
+	for _, sess := range s.sessions {
+		if sess.login != login || !sess.expiresAt.After(now) {
+			continue
+		}
+
+		session := database.Session{ID: sess.sid, Current: sess.id == cookie, CreatedAt: sess.createdAt,
+			ExpiresAt: sess.expiresAt, UserAgent: sess.userAgent, IP: sess.ip}
+		if !sess.lastSeen.IsZero() {
+			lastSeen := sess.lastSeen
+			session.LastSeenAt = &lastSeen
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+func (s *Storage) RevokeSession(login string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for cookie, sess := range s.sessions {
+		if sess.sid == id && sess.login == login {
+			delete(s.sessions, cookie)
+			return nil
+		}
+	}
+
+	return database.ErrNotFound
+}
+
+func (s *Storage) RevokeAllSessions(login string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return database.ErrNotFound
+	}
+
+	u.revokedAt = time.Now()
+	for cookie, sess := range s.sessions {
+		if sess.login == login {
+			delete(s.sessions, cookie)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) SessionsRevokedAt(login string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return time.Time{}, database.ErrNotFound
+	}
+
+	if u.createdAt.After(u.revokedAt) {
+		return u.createdAt, nil
+	}
+
+	return u.revokedAt, nil
+}
+
+// TouchSessions записывает время последнего запроса для пачки сессий
+func (s *Storage) TouchSessions(seen map[string]time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, t := range seen {
+		if sess, ok := s.sessions[id]; ok && sess.lastSeen.Before(t) {
+			sess.lastSeen = t
+
+			// запрос в своей сессии продлевает активность пользователя и снимает отметку о неактивности
+			if u, ok := s.users[sess.login]; ok && sess.impersonator == "" && u.lastActive.Before(t) {
+				u.lastActive, u.notifiedAt = t, time.Time{}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Impersonate записывает в журнал аудита вход администратора от имени пользователя и создает
+// сессию этого входа
+func (s *Storage) Impersonate(i database.Impersonation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[i.Login]; !ok || !u.deletedAt.IsZero() {
+		return database.ErrNotFound
+	}
+
+	if i.Session != "" {
+		s.sid++
+		s.sessions[i.Session] = &session{id: i.Session, sid: s.sid, login: i.Login, createdAt: time.Now(), expiresAt: i.ExpiresAt,
+			userAgent: i.UserAgent, ip: i.IP, impersonator: i.Admin, readOnly: i.ReadOnly}
+	}
+
+	detail := "read-write until " + i.ExpiresAt.UTC().Format(time.RFC3339)
+	if i.ReadOnly {
+		detail = "read-only until " + i.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	s.audit = append(s.audit, auditEntry{actor: i.Admin, login: i.Login, action: database.AuditImpersonate, detail: detail,
+		createdAt: time.Now()})
+
+	return nil
+}
+
+// AddAudit записывает действие администратора actor от имени пользователя login
+func (s *Storage) AddAudit(actor, login, action, detail string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.audit = append(s.audit, auditEntry{actor: actor, login: login, action: action, detail: detail, createdAt: time.Now()})
+
+	return nil
+}
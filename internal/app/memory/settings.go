@@ -0,0 +1,65 @@
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/settings"
+)
+
+// Settings возвращает действующие значения настроек
+func (s *Storage) Settings() settings.Values {
+	return s.settings.Get()
+}
+
+// SettingsDefaults возвращает значения настроек из конфигурации
+func (s *Storage) SettingsDefaults() settings.Values {
+	return s.settings.Defaults()
+}
+
+func (s *Storage) pointRate() float64 {
+	return s.Settings().AccrualPointRate
+}
+
+func (s *Storage) sessionTTL() time.Duration {
+	return s.Settings().SessionTTL
+}
+
+// GetSettings возвращает значения, заданные администратором, по имени
+func (s *Storage) GetSettings() ([]settings.Override, error) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	overrides := make([]settings.Override, 0, len(s.overrides))
+	for _, o := range s.overrides {
+		overrides = append(overrides, o)
+	}
+
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].Name < overrides[j].Name })
+
+	return overrides, nil
+}
+
+// PutSetting сохраняет значение настройки и записывает изменение в журнал аудита
+func (s *Storage) PutSetting(name, value, by string) error {
+	s.settingsMu.Lock()
+	s.overrides[name] = settings.Override{Name: name, Value: value, UpdatedAt: time.Now(), UpdatedBy: by}
+	s.settingsMu.Unlock()
+
+	return s.AddAudit(by, "", database.AuditSetting, name+" = "+value)
+}
+
+// DeleteSetting возвращает настройке значение из конфигурации. Значение не задавалось - ErrNotFound.
+func (s *Storage) DeleteSetting(name, by string) error {
+	s.settingsMu.Lock()
+	_, ok := s.overrides[name]
+	delete(s.overrides, name)
+	s.settingsMu.Unlock()
+
+	if !ok {
+		return database.ErrNotFound
+	}
+
+	return s.AddAudit(by, "", database.AuditSetting, name+" reset")
+}
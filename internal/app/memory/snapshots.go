@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+)
+
+// SnapshotBalances записывает остатки всех пользователей на конец суток day (UTC)
+func (s *Storage) SnapshotBalances(day time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	day = database.ReportStart(day, database.ReportByDay)
+	end := day.AddDate(0, 0, 1)
+
+	balances := make(map[string]float64)
+	for _, e := range s.ledger {
+		if e.createdAt.Before(end) {
+			balances[e.login] += e.amount
+		}
+	}
+
+	for _, w := range s.withdrawList {
+		if w.createdAt.Before(end) {
+			balances[w.Login] -= w.Sum
+		}
+	}
+
+	for login, balance := range balances {
+		s.snapshots[snapshot{login: login, day: day}] = balance
+	}
+
+	return nil
+}
+
+func (s *Storage) LastBalanceSnapshot() (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var last time.Time
+	for k := range s.snapshots {
+		if k.day.After(last) {
+			last = k.day
+		}
+	}
+
+	return last, nil
+}
+
+// GetBalanceHistory возвращает остатки пользователя за сутки [from, to), как database.DataBase.GetBalanceHistory
+func (s *Storage) GetBalanceHistory(login string, from, to time.Time, granularity string) ([]database.BalancePoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from = database.ReportStart(from, database.ReportByDay)
+
+	last := make(map[time.Time]database.BalancePoint)
+	for k, balance := range s.snapshots {
+		if k.login != login || k.day.Before(from) || !k.day.Before(to) {
+			continue
+		}
+
+		bucket := database.ReportStart(k.day, granularity)
+		if p, ok := last[bucket]; !ok || k.day.After(p.Day) {
+			last[bucket] = database.BalancePoint{Day: k.day, Balance: balance}
+		}
+	}
+
+	points := make([]database.BalancePoint, 0, len(last))
+	for _, p := range last {
+		points = append(points, p)
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Day.Before(points[j].Day)
+	})
+
+	if len(points) == 0 {
+		return nil, nil
+	}
+
+	return points, nil
+}
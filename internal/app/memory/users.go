@@ -0,0 +1,519 @@
+package memory
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/format"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/notify"
+)
+
+func (s *Storage) Register(login, pass, cookie, newCookie string) error {
+	hash, err := s.passwords.Hash(pass)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[login]; ok {
+		return database.ErrRegisterConflict
+	}
+
+	s.addUser(login, hash, database.UserActive)
+	s.upgradeSession(cookie, newCookie, login)
+
+	return nil
+}
+
+// ImportUser создает пользователя с паролем pass и начальным остатком balance, существующий - false
+func (s *Storage) ImportUser(login, pass string, balance float64) (bool, error) {
+	hash, err := s.passwords.Hash(pass)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[login]; ok {
+		return false, nil
+	}
+
+	s.addUser(login, hash, database.UserActive)
+	if balance != 0 {
+		s.addLedger(login, balance, database.LedgerOpening, "")
+	}
+
+	return true, nil
+}
+
+// RegisterPending создает пользователя, ожидающего подтверждения адреса, и токен подтверждения
+func (s *Storage) RegisterPending(login, pass, email, token string, ttl time.Duration) error {
+	hash, err := s.passwords.Hash(pass)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[login]; ok {
+		return database.ErrRegisterConflict
+	}
+
+	s.addUser(login, hash, database.UserPending).email = email
+	s.verifications[token] = verification{login: login, expiresAt: time.Now().Add(ttl)}
+
+	return nil
+}
+
+// Verify активирует пользователя по токену подтверждения и возвращает его логин
+func (s *Storage) Verify(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.verifications[token]
+	if !ok || !v.expiresAt.After(time.Now()) {
+		return "", database.ErrNotFound
+	}
+
+	delete(s.verifications, token)
+	if u, ok := s.users[v.login]; ok {
+		u.status = database.UserActive
+	}
+
+	return v.login, nil
+}
+
+func (s *Storage) Login(login, pass, cookie, newCookie string) error {
+	if err := s.CheckPassword(login, pass); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.upgradeSession(cookie, newCookie, login)
+
+	return nil
+}
+
+// OpenSession заменяет сессию cookie сессией newCookie пользователя, проверенного внешним бэкендом
+// аутентификации
+func (s *Storage) OpenSession(login, cookie, newCookie string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[login]; !ok {
+		s.addUser(login, "", database.UserActive)
+	}
+
+	s.upgradeSession(cookie, newCookie, login)
+
+	return nil
+}
+
+// CheckPassword проверяет пару логин/пароль, не открывая сессию
+func (s *Storage) CheckPassword(login, pass string) error {
+	s.mu.Lock()
+	var hash, status string
+	if u, ok := s.users[login]; ok {
+		hash, status = u.password, u.status
+	}
+	s.mu.Unlock()
+
+	// как и в базе, неизвестный логин проверяется по фиктивному хешу
+	known := hash != ""
+	if !known {
+		hash = s.dummyHash
+	}
+
+	ok, rehash, err := s.passwords.Verify(pass, hash)
+	if err != nil {
+		return err
+	}
+
+	if !ok || !known {
+		return database.ErrWrongData
+	}
+
+	if rehash {
+		if updated, err := s.passwords.Hash(pass); err == nil {
+			s.mu.Lock()
+			if u, ok := s.users[login]; ok && u.password == hash {
+				u.password = updated
+			}
+			s.mu.Unlock()
+		}
+	}
+
+	if status == database.UserPending {
+		return database.ErrNotVerified
+	}
+
+	return nil
+}
+
+func (s *Storage) ChangePassword(login, pass string) error {
+	hash, err := s.passwords.Hash(pass)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return database.ErrNotFound
+	}
+
+	u.password = hash
+
+	return nil
+}
+
+// SetTOTPSecret сохраняет секрет 2FA до подтверждения, при включенной 2FA - ErrDuplicate
+func (s *Storage) SetTOTPSecret(login, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok || u.totpEnabled {
+		return database.ErrDuplicate
+	}
+
+	u.totpSecret = secret
+
+	return nil
+}
+
+func (s *Storage) EnableTOTP(login string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[login]; ok && u.totpSecret != "" {
+		u.totpEnabled = true
+	}
+
+	return nil
+}
+
+func (s *Storage) GetTOTP(login string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return "", false, nil
+	}
+
+	return u.totpSecret, u.totpEnabled, nil
+}
+
+func (s *Storage) GetPreferences(login string) (format.Preferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return format.Default, nil
+	}
+
+	return u.prefs, nil
+}
+
+func (s *Storage) SetPreferences(login string, prefs format.Preferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[login]; ok {
+		u.prefs = prefs
+	}
+
+	return nil
+}
+
+func (s *Storage) GetNotificationPreferences(login string) (notify.Preferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefs := notify.Default()
+	if u, ok := s.users[login]; ok {
+		for event, channel := range u.notify {
+			prefs[event] = channel
+		}
+	}
+
+	return prefs, nil
+}
+
+func (s *Storage) SetNotificationPreferences(login string, prefs notify.Preferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return nil
+	}
+
+	if u.notify == nil {
+		u.notify = make(notify.Preferences, len(prefs))
+	}
+	for event, channel := range prefs {
+		u.notify[event] = channel
+	}
+
+	return nil
+}
+
+func (s *Storage) GetEmail(login string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[login]; ok {
+		return u.email, nil
+	}
+
+	return "", nil
+}
+
+func (s *Storage) GetBalance(login string) (database.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[login]; !ok {
+		return database.User{}, database.ErrNotFound
+	}
+
+	current, withdrawn := s.balance(login)
+
+	return database.User{Login: login, Current: current, WithDraw: withdrawn}, nil
+}
+
+// addUser вызывается под s.mu
+func (s *Storage) addUser(login, hash, status string) *user {
+	s.userid++
+	now := time.Now()
+	u := &user{id: s.userid, login: login, password: hash, status: status, prefs: format.Default, createdAt: now, lastActive: now}
+	s.users[login] = u
+
+	return u
+}
+
+// DeleteUser удаляет учетную запись: сессии, настройки и производные данные удаляются, логин
+// в заказах, списаниях и журналах заменяется обезличенным. Неизвестный или уже удаленный
+// пользователь - ErrNotFound.
+func (s *Storage) DeleteUser(login string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok || !u.deletedAt.IsZero() {
+		return database.ErrNotFound
+	}
+
+	s.deleteUser(u)
+
+	return nil
+}
+
+// deleteUser удаляет учетную запись u и возвращает обезличенный логин, вызывается под s.mu
+func (s *Storage) deleteUser(u *user) string {
+	login := u.login
+	anonymous := database.DeletedLogin(u.id)
+	for token, v := range s.verifications {
+		if v.login == login {
+			delete(s.verifications, token)
+		}
+	}
+	for cookie, sess := range s.sessions {
+		if sess.login == login {
+			delete(s.sessions, cookie)
+		}
+	}
+	for key := range s.quota {
+		if strings.HasPrefix(key, login+"/") {
+			delete(s.quota, key)
+		}
+	}
+	for k := range s.snapshots {
+		if k.login == login {
+			delete(s.snapshots, k)
+		}
+	}
+
+	s.relabel(login, anonymous)
+
+	now := time.Now()
+	s.users[anonymous] = &user{id: u.id, login: anonymous, status: database.UserDeleted, prefs: u.prefs,
+		createdAt: u.createdAt, revokedAt: now, deletedAt: now}
+
+	log.Printf("delete user: %s", anonymous)
+
+	return anonymous
+}
+
+// GetUserID возвращает постоянный идентификатор пользователя. Неизвестный пользователь - ErrNotFound.
+func (s *Storage) GetUserID(login string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok {
+		return 0, database.ErrNotFound
+	}
+
+	return u.id, nil
+}
+
+// RenameUser меняет логин пользователя вместе с его заказами, списаниями, сессиями и журналами.
+// Занятый логин - ErrRegisterConflict, неизвестный или удаленный пользователь - ErrNotFound.
+func (s *Storage) RenameUser(login, newLogin string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[login]
+	if !ok || !u.deletedAt.IsZero() {
+		return database.ErrNotFound
+	}
+
+	if _, ok = s.users[newLogin]; ok {
+		return database.ErrRegisterConflict
+	}
+
+	s.relabel(login, newLogin)
+	s.audit = append(s.audit, auditEntry{actor: login, login: newLogin, action: database.AuditRename,
+		detail: "from " + login, createdAt: time.Now()})
+
+	return nil
+}
+
+// relabel переносит все данные пользователя from на логин to, вызывается под s.mu. В базе данные
+// ссылаются на userid, здесь - на логин, поэтому логин меняется в каждой записи.
+func (s *Storage) relabel(from, to string) {
+	u := s.users[from]
+	delete(s.users, from)
+	u.login = to
+	s.users[to] = u
+
+	for token, v := range s.verifications {
+		if v.login == from {
+			v.login = to
+			s.verifications[token] = v
+		}
+	}
+	for _, sess := range s.sessions {
+		if sess.login == from {
+			sess.login = to
+		}
+	}
+	for key, count := range s.quota {
+		if day, ok := strings.CutPrefix(key, from+"/"); ok {
+			delete(s.quota, key)
+			s.quota[to+"/"+day] = count
+		}
+	}
+	for k, balance := range s.snapshots {
+		if k.login == from {
+			delete(s.snapshots, k)
+			s.snapshots[snapshot{login: to, day: k.day}] = balance
+		}
+	}
+
+	for _, o := range s.orderList {
+		if o.Login == from {
+			o.Login = to
+		}
+	}
+	for i := range s.ledger {
+		if s.ledger[i].login == from {
+			s.ledger[i].login = to
+		}
+	}
+	for _, w := range s.withdrawList {
+		if w.Login == from {
+			w.Login = to
+		}
+	}
+	for _, h := range s.holds {
+		if h.Login == from {
+			h.Login = to
+		}
+	}
+	for i := range s.audit {
+		if s.audit[i].login == from {
+			s.audit[i].login = to
+		}
+	}
+}
+
+// PurgeDeletedUsers окончательно удаляет учетные записи, удаленные до before, с их заказами,
+// списаниями и журналом начислений
+func (s *Storage) PurgeDeletedUsers(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purge := make(map[string]bool)
+	for login, u := range s.users {
+		if !u.deletedAt.IsZero() && !u.deletedAt.After(before) {
+			purge[login] = true
+			delete(s.users, login)
+		}
+	}
+
+	if len(purge) == 0 {
+		return 0, nil
+	}
+
+	orders := s.orderList[:0]
+	for _, o := range s.orderList {
+		if !purge[o.Login] {
+			orders = append(orders, o)
+			continue
+		}
+
+		delete(s.orders, o.Number)
+		delete(s.credited, o.Number)
+	}
+	s.orderList = orders
+
+	ledger := s.ledger[:0]
+	for _, e := range s.ledger {
+		if !purge[e.login] {
+			ledger = append(ledger, e)
+		}
+	}
+	s.ledger = ledger
+
+	withdraws := s.withdrawList[:0]
+	for _, w := range s.withdrawList {
+		if !purge[w.Login] {
+			withdraws = append(withdraws, w)
+			continue
+		}
+
+		delete(s.withdraws, w.OrderID)
+	}
+	s.withdrawList = withdraws
+
+	holds := s.holds[:0]
+	for _, h := range s.holds {
+		if !purge[h.Login] {
+			holds = append(holds, h)
+		}
+	}
+	s.holds = holds
+
+	for k := range s.snapshots {
+		if purge[k.login] {
+			delete(s.snapshots, k)
+		}
+	}
+
+	return int64(len(purge)), nil
+}
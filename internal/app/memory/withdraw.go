@@ -0,0 +1,159 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+)
+
+func (s *Storage) AddWithDraw(login, order string, sum float64, reference string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addWithDraw(login, order, sum, reference)
+}
+
+// addWithDraw списывает сумму, если ее покрывает баланс, вызывается под s.mu
+func (s *Storage) addWithDraw(login, order string, sum float64, reference string) error {
+	if _, ok := s.withdraws[order]; ok {
+		return database.ErrBadOrderNumber
+	}
+
+	if current, _ := s.balance(login); current-sum < 0 {
+		return database.ErrNoMoney
+	}
+
+	now := time.Now()
+	w := &withdraw{
+		WithDraw:  database.WithDraw{Seq: s.nextSeq(), OrderID: order, Login: login, Sum: sum, ProcessedAt: now, Reference: reference},
+		createdAt: now,
+	}
+	s.withdraws[order] = w
+	s.withdrawList = append(s.withdrawList, w)
+
+	return nil
+}
+
+// GetWithDraw возвращает списания пользователя по порядку: limit списаний после списания с Seq after,
+// пропустив первые offset. Нулевой limit - все списания, нулевой after - с начала списка.
+func (s *Storage) GetWithDraw(login string, limit, offset int, after int64) ([]database.WithDraw, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var withdraw []database.WithDraw
+	for _, w := range s.withdrawList {
+		if w.Login != login || w.Seq <= after {
+			continue
+		}
+
+		if offset > 0 {
+			offset--
+			continue
+		}
+
+		if limit > 0 && len(withdraw) == limit {
+			break
+		}
+
+		withdraw = append(withdraw, database.WithDraw{Seq: w.Seq, OrderID: w.OrderID, Sum: w.Sum, ProcessedAt: w.ProcessedAt, Reference: w.Reference})
+	}
+
+	if withdraw == nil {
+		return nil, database.ErrEmpty
+	}
+
+	return withdraw, nil
+}
+
+// CountWithDraw возвращает количество списаний пользователя начиная с since
+func (s *Storage) CountWithDraw(login string, since time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int
+	for _, w := range s.withdrawList {
+		if w.Login == login && !w.createdAt.Before(since) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// HoldWithDraw откладывает подозрительное списание в очередь ручной проверки
+func (s *Storage) HoldWithDraw(login, order string, sum float64, reason, reference string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.holds = append(s.holds, &hold{
+		WithDrawHold: database.WithDrawHold{ID: len(s.holds) + 1, OrderID: order, Login: login, Sum: sum, Reason: reason,
+			Status: database.HoldHeld, Reference: reference},
+		createdAt: time.Now(),
+	})
+
+	return nil
+}
+
+func (s *Storage) GetHolds() ([]database.WithDrawHold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var holds []database.WithDrawHold
+	for _, h := range s.holds {
+		if h.Status == database.HoldHeld {
+			hold := h.WithDrawHold
+			hold.CreatedAt = h.createdAt.Format(time.RFC3339)
+			holds = append(holds, hold)
+		}
+	}
+
+	if holds == nil {
+		return nil, database.ErrEmpty
+	}
+
+	return holds, nil
+}
+
+// ApproveHold проводит отложенное списание с проверкой баланса
+func (s *Storage) ApproveHold(id int) (database.WithDrawHold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.held(id)
+	if !ok {
+		return database.WithDrawHold{}, database.ErrNotFound
+	}
+
+	if err := s.addWithDraw(h.Login, h.OrderID, h.Sum, h.Reference); err != nil {
+		return database.WithDrawHold{}, err
+	}
+
+	h.Status = database.HoldApproved
+
+	return database.WithDrawHold{ID: h.ID, OrderID: h.OrderID, Login: h.Login, Sum: h.Sum, Reason: h.Reason,
+		Status: h.Status, Reference: h.Reference}, nil
+}
+
+func (s *Storage) RejectHold(id int) (database.WithDrawHold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.held(id)
+	if !ok {
+		return database.WithDrawHold{}, database.ErrNotFound
+	}
+
+	h.Status = database.HoldRejected
+
+	return database.WithDrawHold{ID: h.ID, OrderID: h.OrderID, Login: h.Login, Sum: h.Sum, Reason: h.Reason,
+		Status: h.Status}, nil
+}
+
+// held возвращает ожидающее проверки списание id, вызывается под s.mu
+func (s *Storage) held(id int) (*hold, bool) {
+	if id < 1 || id > len(s.holds) || s.holds[id-1].Status != database.HoldHeld {
+		return nil, false
+	}
+
+	return s.holds[id-1], true
+}
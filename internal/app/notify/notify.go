@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/mail"
+)
+
+// Notifier доставляет пользователю сообщение о событии в его аккаунте
+type Notifier interface {
+	Notify(ctx context.Context, login, message string) error
+}
+
+// EventNotifier дополнительно доставляет сообщения о событиях из Events в канал, выбранный
+// пользователем. Notify остается для служебных сообщений, которые нельзя отключить.
+type EventNotifier interface {
+	Notifier
+	Event(ctx context.Context, login, event, message string) error
+}
+
+// Log пишет уведомления в журнал, используется, пока не настроен внешний канал доставки
+type Log struct{}
+
+func (Log) Notify(_ context.Context, login, message string) error {
+	log.Printf("notify: login: %s, message: %s", login, message)
+	return nil
+}
+
+func (Log) Event(_ context.Context, login, event, message string) error {
+	log.Printf("notify: login: %s, event: %s, message: %s", login, event, message)
+	return nil
+}
+
+// PreferenceStore - хранилище настроек уведомлений пользователей
+type PreferenceStore interface {
+	GetNotificationPreferences(login string) (Preferences, error)
+}
+
+// Dispatcher выбирает канал по настройкам пользователя и передает сообщение его Notifier.
+// Служебные сообщения уходят в ChannelEmail.
+type Dispatcher struct {
+	Preferences PreferenceStore
+	Channels    map[string]Notifier
+}
+
+func (d Dispatcher) Notify(ctx context.Context, login, message string) error {
+	return d.send(ctx, ChannelEmail, login, message)
+}
+
+func (d Dispatcher) Event(ctx context.Context, login, event, message string) error {
+	prefs, err := d.Preferences.GetNotificationPreferences(login)
+	if err != nil {
+		return err
+	}
+
+	return d.send(ctx, prefs.Channel(event), login, message)
+}
+
+func (d Dispatcher) send(ctx context.Context, channel, login, message string) error {
+	if channel == ChannelNone {
+		return nil
+	}
+
+	n, ok := d.Channels[channel]
+	if !ok {
+		return fmt.Errorf("notify: channel %s is not configured", channel)
+	}
+
+	return n.Notify(ctx, login, message)
+}
+
+// AddressBook возвращает адрес почты пользователя, пустой - адрес не указан
+type AddressBook interface {
+	GetEmail(login string) (string, error)
+}
+
+// Mail отправляет уведомления письмом на адрес пользователя. Если адрес не указан,
+// уведомление пишется в журнал.
+type Mail struct {
+	Addresses AddressBook
+	Sender    mail.Sender
+}
+
+func (m Mail) Notify(ctx context.Context, login, message string) error {
+	email, err := m.Addresses.GetEmail(login)
+	if err != nil {
+		return err
+	}
+
+	if email == "" {
+		return Log{}.Notify(ctx, login, message)
+	}
+
+	return m.Sender.Send(ctx, email, "Уведомление", message)
+}
@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPreferencesValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefs   Preferences
+		wantErr error
+	}{
+		{name: "default", prefs: Default()},
+		{name: "partial", prefs: Preferences{EventLoginAlert: ChannelTelegram, EventAccrualCredited: ChannelNone}},
+		{name: "unknown event", prefs: Preferences{"birthday": ChannelEmail}, wantErr: ErrUnsupported},
+		{name: "unknown channel", prefs: Preferences{EventLoginAlert: "sms"}, wantErr: ErrUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.prefs.Validate(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+type store Preferences
+
+func (s store) GetNotificationPreferences(string) (Preferences, error) {
+	return Preferences(s), nil
+}
+
+type recorder []string
+
+func (r *recorder) Notify(_ context.Context, login, message string) error {
+	*r = append(*r, login+": "+message)
+	return nil
+}
+
+func TestDispatcher(t *testing.T) {
+	var email, telegram recorder
+	d := Dispatcher{
+		Preferences: store{EventLoginAlert: ChannelTelegram, EventAccrualCredited: ChannelNone},
+		Channels:    map[string]Notifier{ChannelEmail: &email, ChannelTelegram: &telegram},
+	}
+
+	ctx := context.Background()
+	for _, event := range Events {
+		if err := d.Event(ctx, "username", event, event); err != nil {
+			t.Fatalf("Event(%s) error = %v", event, err)
+		}
+	}
+	if err := d.Notify(ctx, "username", "service"); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	if want := []string{"username: " + EventWithdrawalProcessed, "username: service"}; !reflect.DeepEqual([]string(email), want) {
+		t.Errorf("email = %v, want %v", email, want)
+	}
+	if want := []string{"username: " + EventLoginAlert}; !reflect.DeepEqual([]string(telegram), want) {
+		t.Errorf("telegram = %v, want %v", telegram, want)
+	}
+
+	delete(d.Channels, ChannelTelegram)
+	if err := d.Event(ctx, "username", EventLoginAlert, "login"); err == nil {
+		t.Error("Event() to unconfigured channel error = nil, want error")
+	}
+}
@@ -0,0 +1,65 @@
+package notify
+
+import "errors"
+
+var ErrUnsupported = errors.New("unsupported event or channel")
+
+// События, о которых пользователь выбирает, куда присылать уведомления
+const (
+	EventAccrualCredited     = "accrual_credited"
+	EventWithdrawalProcessed = "withdrawal_processed"
+	EventLoginAlert          = "login_alert"
+)
+
+// Каналы доставки. ChannelNone отключает уведомления о событии.
+const (
+	ChannelEmail    = "email"
+	ChannelTelegram = "telegram"
+	ChannelNone     = "none"
+)
+
+var Events = []string{EventAccrualCredited, EventWithdrawalProcessed, EventLoginAlert}
+
+var channels = map[string]bool{ChannelEmail: true, ChannelTelegram: true, ChannelNone: true}
+
+// Preferences - канал доставки для каждого события. Событие без настройки уходит в ChannelEmail.
+type Preferences map[string]string
+
+// Default возвращает настройки нового пользователя: все уведомления по почте
+func Default() Preferences {
+	prefs := make(Preferences, len(Events))
+	for _, event := range Events {
+		prefs[event] = ChannelEmail
+	}
+
+	return prefs
+}
+
+func (p Preferences) Validate() error {
+	for event, channel := range p {
+		if !known(event) || !channels[channel] {
+			return ErrUnsupported
+		}
+	}
+
+	return nil
+}
+
+// Channel возвращает канал доставки уведомлений о событии event
+func (p Preferences) Channel(event string) string {
+	if channel, ok := p[event]; ok {
+		return channel
+	}
+
+	return ChannelEmail
+}
+
+func known(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,241 @@
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Хеш хранится в виде $<kdf>$<параметры>$<соль>$<ключ>, у bcrypt - в его собственном формате $2a$<cost>$...
+// Пароль перед KDF подписывается HMAC-SHA256 с перцем: без перца из конфигурации утечка базы
+// не позволяет подбирать пароли.
+
+var ErrUnknownHash = errors.New("unknown password hash")
+
+// KDF - функция растяжения ключа с фиксированными параметрами
+type KDF interface {
+	// Hash возвращает закодированный хеш с параметрами и солью
+	Hash(secret []byte) (string, error)
+	// Verify проверяет secret по хешу, параметры берутся из самого хеша
+	Verify(secret []byte, encoded string) (bool, error)
+	// Prefix - начало хешей, полученных с текущими параметрами
+	Prefix() string
+}
+
+const (
+	KindPBKDF2 = "pbkdf2-sha256"
+	KindScrypt = "scrypt"
+	KindBcrypt = "bcrypt"
+)
+
+// NewKDF возвращает KDF по названию; нулевые параметры заменяются рекомендуемыми значениями
+func NewKDF(kind string, cost int) (KDF, error) {
+	switch kind {
+	case KindPBKDF2:
+		if cost == 0 {
+			cost = 600000
+		}
+		return PBKDF2{Iterations: cost}, nil
+	case "", KindScrypt:
+		if cost == 0 {
+			cost = 1 << 15
+		}
+		return Scrypt{N: cost, R: 8, P: 1}, nil
+	case KindBcrypt:
+		if cost == 0 {
+			cost = bcrypt.DefaultCost
+		}
+		return Bcrypt{Cost: cost}, nil
+	default:
+		return nil, fmt.Errorf("password: unknown kdf %s", kind)
+	}
+}
+
+// Hasher хеширует пароли текущей KDF и проверяет хеши любой поддерживаемой KDF
+type Hasher struct {
+	Pepper []byte
+	KDF    KDF
+}
+
+func (h Hasher) secret(password string) []byte {
+	mac := hmac.New(sha256.New, h.Pepper)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+func (h Hasher) Hash(password string) (string, error) {
+	return h.KDF.Hash(h.secret(password))
+}
+
+// Verify проверяет пароль по хешу. rehash - пароль верен, но хеш получен с другими параметрами
+// или хранится открытым текстом (до появления хеширования) и его нужно пересчитать.
+func (h Hasher) Verify(password, encoded string) (ok bool, rehash bool, err error) {
+	if !strings.HasPrefix(encoded, "$") {
+		ok = subtle.ConstantTimeCompare([]byte(password), []byte(encoded)) == 1
+		return ok, ok, nil
+	}
+
+	var kdf KDF
+	switch {
+	case strings.HasPrefix(encoded, "$"+KindPBKDF2+"$"):
+		kdf = PBKDF2{}
+	case strings.HasPrefix(encoded, "$"+KindScrypt+"$"):
+		kdf = Scrypt{}
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		kdf = Bcrypt{}
+	default:
+		return false, false, ErrUnknownHash
+	}
+
+	ok, err = kdf.Verify(h.secret(password), encoded)
+	if err != nil || !ok {
+		return false, false, err
+	}
+
+	return true, !strings.HasPrefix(encoded, h.KDF.Prefix()), nil
+}
+
+func salt() ([]byte, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+var b64 = base64.RawStdEncoding
+
+// split разбирает $<kdf>$<параметры>$<соль>$<ключ>
+func split(encoded string) (params map[string]int, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return nil, nil, nil, ErrUnknownHash
+	}
+
+	params = map[string]int{}
+	for _, kv := range strings.Split(parts[2], ",") {
+		k, v, found := strings.Cut(kv, "=")
+		if !found {
+			return nil, nil, nil, ErrUnknownHash
+		}
+
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, nil, nil, ErrUnknownHash
+		}
+		params[k] = n
+	}
+
+	if salt, err = b64.DecodeString(parts[3]); err != nil {
+		return nil, nil, nil, ErrUnknownHash
+	}
+	if key, err = b64.DecodeString(parts[4]); err != nil {
+		return nil, nil, nil, ErrUnknownHash
+	}
+
+	return params, salt, key, nil
+}
+
+type PBKDF2 struct {
+	Iterations int
+}
+
+func (p PBKDF2) Prefix() string {
+	return fmt.Sprintf("$%s$i=%d$", KindPBKDF2, p.Iterations)
+}
+
+func (p PBKDF2) Hash(secret []byte) (string, error) {
+	s, err := salt()
+	if err != nil {
+		return "", err
+	}
+
+	key := pbkdf2.Key(secret, s, p.Iterations, 32, sha256.New)
+	return p.Prefix() + b64.EncodeToString(s) + "$" + b64.EncodeToString(key), nil
+}
+
+func (PBKDF2) Verify(secret []byte, encoded string) (bool, error) {
+	params, s, key, err := split(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	got := pbkdf2.Key(secret, s, params["i"], len(key), sha256.New)
+	return subtle.ConstantTimeCompare(got, key) == 1, nil
+}
+
+type Scrypt struct {
+	N, R, P int
+}
+
+func (p Scrypt) Prefix() string {
+	return fmt.Sprintf("$%s$n=%d,r=%d,p=%d$", KindScrypt, p.N, p.R, p.P)
+}
+
+func (p Scrypt) Hash(secret []byte) (string, error) {
+	s, err := salt()
+	if err != nil {
+		return "", err
+	}
+
+	key, err := scrypt.Key(secret, s, p.N, p.R, p.P, 32)
+	if err != nil {
+		return "", err
+	}
+
+	return p.Prefix() + b64.EncodeToString(s) + "$" + b64.EncodeToString(key), nil
+}
+
+func (Scrypt) Verify(secret []byte, encoded string) (bool, error) {
+	params, s, key, err := split(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	got, err := scrypt.Key(secret, s, params["n"], params["r"], params["p"], len(key))
+	if err != nil {
+		return false, ErrUnknownHash
+	}
+
+	return subtle.ConstantTimeCompare(got, key) == 1, nil
+}
+
+type Bcrypt struct {
+	Cost int
+}
+
+func (p Bcrypt) Prefix() string {
+	return fmt.Sprintf("$2a$%02d$", p.Cost)
+}
+
+func (p Bcrypt) Hash(secret []byte) (string, error) {
+	b, err := bcrypt.GenerateFromPassword(secret, p.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func (Bcrypt) Verify(secret []byte, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), secret)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, ErrUnknownHash
+	}
+
+	return true, nil
+}
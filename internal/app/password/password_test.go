@@ -0,0 +1,79 @@
+package password
+
+import (
+	"testing"
+)
+
+func TestHasher(t *testing.T) {
+	kdfs := []KDF{PBKDF2{Iterations: 1000}, Scrypt{N: 1024, R: 8, P: 1}, Bcrypt{Cost: 4}}
+	for _, kdf := range kdfs {
+		t.Run(kdf.Prefix(), func(t *testing.T) {
+			h := Hasher{Pepper: []byte("pepper"), KDF: kdf}
+
+			encoded, err := h.Hash("password")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ok, rehash, err := h.Verify("password", encoded); !ok || rehash || err != nil {
+				t.Errorf("Verify() = %v, %v, %v, want true, false, nil", ok, rehash, err)
+			}
+
+			if ok, _, err := h.Verify("Password", encoded); ok || err != nil {
+				t.Errorf("Verify() wrong password = %v, %v, want false, nil", ok, err)
+			}
+
+			other := Hasher{Pepper: []byte("other"), KDF: kdf}
+			if ok, _, _ := other.Verify("password", encoded); ok {
+				t.Error("Verify() with other pepper = true, want false")
+			}
+		})
+	}
+}
+
+func TestRehash(t *testing.T) {
+	old := Hasher{KDF: PBKDF2{Iterations: 1000}}
+	encoded, err := old.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		kdf        KDF
+		wantRehash bool
+	}{
+		{name: "Те же параметры", kdf: PBKDF2{Iterations: 1000}, wantRehash: false},
+		{name: "Больше итераций", kdf: PBKDF2{Iterations: 2000}, wantRehash: true},
+		{name: "Другая KDF", kdf: Scrypt{N: 1024, R: 8, P: 1}, wantRehash: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash, err := Hasher{KDF: tt.kdf}.Verify("password", encoded)
+			if !ok || err != nil || rehash != tt.wantRehash {
+				t.Errorf("Verify() = %v, %v, %v, want true, %v, nil", ok, rehash, err, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestLegacyPlaintext(t *testing.T) {
+	h := Hasher{KDF: Bcrypt{Cost: 4}}
+
+	if ok, rehash, err := h.Verify("password", "password"); !ok || !rehash || err != nil {
+		t.Errorf("Verify() plaintext = %v, %v, %v, want true, true, nil", ok, rehash, err)
+	}
+
+	if ok, rehash, _ := h.Verify("other", "password"); ok || rehash {
+		t.Errorf("Verify() wrong plaintext = %v, %v, want false, false", ok, rehash)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	h := Hasher{KDF: Bcrypt{Cost: 4}}
+	for _, encoded := range []string{"$md5$x", "$scrypt$n=0,r=8,p=1$AAAA$AAAA", "$pbkdf2-sha256$i$AAAA$AAAA"} {
+		if ok, _, err := h.Verify("password", encoded); ok || err == nil {
+			t.Errorf("Verify(%q) = %v, %v, want false, error", encoded, ok, err)
+		}
+	}
+}
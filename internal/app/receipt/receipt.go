@@ -0,0 +1,72 @@
+package receipt
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrBadPayload = errors.New("bad receipt payload")
+
+// Receipt - реквизиты кассового чека из QR-кода
+// (t=20190325T1530&s=1234.56&fn=9288000100123456&i=12345&fp=1234567890&n=1)
+type Receipt struct {
+	Time   time.Time // t - дата и время покупки
+	Sum    float64   // s - сумма чека
+	FN     string    // fn - номер фискального накопителя
+	Number string    // i - номер фискального документа, используется как номер заказа
+	FP     string    // fp - фискальный признак документа
+	Type   int       // n - признак расчета
+}
+
+func digits(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Parse разбирает строку QR-кода кассового чека
+func Parse(payload string) (Receipt, error) {
+	values, err := url.ParseQuery(strings.TrimSpace(payload))
+	if err != nil {
+		return Receipt{}, ErrBadPayload
+	}
+
+	var r Receipt
+	for _, layout := range []string{"20060102T150405", "20060102T1504"} {
+		if r.Time, err = time.Parse(layout, values.Get("t")); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return Receipt{}, ErrBadPayload
+	}
+
+	r.Sum, err = strconv.ParseFloat(values.Get("s"), 64)
+	if err != nil || r.Sum <= 0 {
+		return Receipt{}, ErrBadPayload
+	}
+
+	r.FN, r.Number, r.FP = values.Get("fn"), values.Get("i"), values.Get("fp")
+	if !digits(r.FN) || !digits(r.Number) || !digits(r.FP) {
+		return Receipt{}, ErrBadPayload
+	}
+
+	if n := values.Get("n"); n != "" {
+		if r.Type, err = strconv.Atoi(n); err != nil {
+			return Receipt{}, ErrBadPayload
+		}
+	}
+
+	return r, nil
+}
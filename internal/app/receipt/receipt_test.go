@@ -0,0 +1,69 @@
+package receipt
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    Receipt
+		wantErr bool
+	}{
+		{
+			name:    "Полный чек",
+			payload: "t=20190325T153012&s=1234.56&fn=9288000100123456&i=49927398716&fp=1234567890&n=1",
+			want: Receipt{
+				Time:   time.Date(2019, 3, 25, 15, 30, 12, 0, time.UTC),
+				Sum:    1234.56,
+				FN:     "9288000100123456",
+				Number: "49927398716",
+				FP:     "1234567890",
+				Type:   1,
+			},
+			wantErr: false,
+		},
+		{
+			name:    "Время без секунд",
+			payload: "t=20190325T1530&s=10&fn=1&i=2&fp=3",
+			want: Receipt{
+				Time:   time.Date(2019, 3, 25, 15, 30, 0, 0, time.UTC),
+				Sum:    10,
+				FN:     "1",
+				Number: "2",
+				FP:     "3",
+			},
+			wantErr: false,
+		},
+		{
+			name:    "Нет номера документа",
+			payload: "t=20190325T1530&s=10&fn=1&fp=3",
+			wantErr: true,
+		},
+		{
+			name:    "Отрицательная сумма",
+			payload: "t=20190325T1530&s=-10&fn=1&i=2&fp=3",
+			wantErr: true,
+		},
+		{
+			name:    "Мусор",
+			payload: "12345678903",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.payload)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package selftest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/pkg/api"
+	"github.com/chazari-x/yandex-pr-diplom/pkg/client"
+)
+
+// pollInterval - пауза между проверками статуса загруженного заказа
+const pollInterval = 500 * time.Millisecond
+
+// Run прогоняет на запущенном сервисе сценарий регистрация → загрузка заказа → начисление → списание.
+// Система расчета должна начислять баллы по любому номеру заказа (достаточно заглушки).
+// Ожидание начисления ограничено timeout, начисление переводится в баллы по курсу pointRate.
+func Run(base string, timeout time.Duration, pointRate float64) error {
+	c, err := client.New(base, nil)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	suffix, err := randomHex(6)
+	if err != nil {
+		return err
+	}
+	password, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+
+	login := "selftest-" + suffix
+	if err = c.Register(ctx, api.Credentials{Login: login, Password: password}); err != nil {
+		return fmt.Errorf("register: %w", err)
+	}
+	log.Printf("selftest: registered %s", login)
+
+	order, err := orderNumber()
+	if err != nil {
+		return err
+	}
+
+	accepted, err := c.UploadOrder(ctx, order)
+	if err != nil {
+		return fmt.Errorf("upload order: %w", err)
+	}
+	if !accepted {
+		return fmt.Errorf("upload order: %s already uploaded", order)
+	}
+	log.Printf("selftest: uploaded order %s", order)
+
+	accrual, err := waitAccrual(ctx, c, order, timeout)
+	if err != nil {
+		return fmt.Errorf("accrual: %w", err)
+	}
+	log.Printf("selftest: order %s processed, accrual %g", order, accrual)
+
+	balance, err := c.Balance(ctx)
+	if err != nil {
+		return fmt.Errorf("balance: %w", err)
+	}
+	points := math.Round(accrual*pointRate*100) / 100
+	if balance.Current < points {
+		return fmt.Errorf("balance: current %g is less than accrual %g points", balance.Current, points)
+	}
+
+	sum := points
+	if sum > 1 {
+		sum = 1
+	}
+
+	withdrawOrder, err := orderNumber()
+	if err != nil {
+		return err
+	}
+
+	_, held, err := c.Withdraw(ctx, api.WithdrawRequest{Order: withdrawOrder, Sum: sum})
+	if err != nil {
+		return fmt.Errorf("withdraw: %w", err)
+	}
+
+	if held {
+		// списание отложено правилами антифрода: сценарий пройден, баланс не меняется
+		log.Printf("selftest: withdrawal %g held for review", sum)
+		return nil
+	}
+
+	after, err := c.Balance(ctx)
+	if err != nil {
+		return fmt.Errorf("balance: %w", err)
+	}
+	if math.Abs(after.Current-(balance.Current-sum)) > 1e-6 {
+		return fmt.Errorf("balance: after withdrawal %g, want %g", after.Current, balance.Current-sum)
+	}
+	log.Printf("selftest: withdrew %g, balance %g", sum, after.Current)
+
+	return nil
+}
+
+// waitAccrual ждет окончательного статуса заказа и возвращает начисление
+func waitAccrual(ctx context.Context, c *client.Client, number string, timeout time.Duration) (float64, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		orders, err := c.Orders(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, o := range orders {
+			if o.Number != number {
+				continue
+			}
+
+			switch o.Status {
+			case api.StatusProcessed:
+				if o.Accrual <= 0 {
+					return 0, fmt.Errorf("order %s processed without accrual", number)
+				}
+				return o.Accrual, nil
+			case api.StatusInvalid:
+				return 0, fmt.Errorf("order %s rejected by accrual system", number)
+			}
+		}
+
+		time.Sleep(pollInterval)
+	}
+
+	return 0, fmt.Errorf("order %s not processed in %s", number, timeout)
+}
+
+func randomHex(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// orderNumber возвращает случайный номер заказа с корректной контрольной цифрой Луна
+func orderNumber() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1e11))
+	if err != nil {
+		return "", err
+	}
+
+	digits := strconv.FormatInt(n.Int64()+1e11, 10)
+
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+
+	return digits + strconv.Itoa((10-sum%10)%10), nil
+}
@@ -0,0 +1,34 @@
+package selftest
+
+import (
+	"testing"
+)
+
+func luhn(number string) bool {
+	sum := 0
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if (len(number)-i)%2 == 0 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+
+	return sum%10 == 0
+}
+
+func TestOrderNumber(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		number, err := orderNumber()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(number) != 13 || !luhn(number) {
+			t.Fatalf("orderNumber() = %s, want 13 digits passing Luhn check", number)
+		}
+	}
+}
@@ -1,61 +1,413 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/accrual"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/anomaly"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/auth"
 	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
 	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/fraud"
 	"github.com/chazari-x/yandex-pr-diplom/internal/app/handlers"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/mail"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/memory"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/notify"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/selftest"
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/token"
 	"github.com/chazari-x/yandex-pr-diplom/internal/app/worker"
 	"github.com/go-chi/chi/v5"
 )
 
+// storage - хранилище сервиса: Postgres или, без DATABASE_URI, память процесса
+type storage interface {
+	handlers.Storage
+	accrual.Storage
+	worker.SnapshotStorage
+	worker.PurgeStorage
+	worker.ArchiveStorage
+	worker.InactiveStorage
+	worker.SessionStorage
+	fraud.History
+	WarmUp(ctx context.Context) error
+}
+
 func StartServer() error {
 	conf, err := config.GetConfig()
 	if err != nil {
 		return err
 	}
 
-	db, err := database.StartDB(conf)
+	database.JSONCompat = conf.JSONCompat
+
+	// фоновые компоненты: ошибка одного останавливает сервис
+	sv := newSupervisor()
+
+	var db storage
+	var queries *handlers.QueryStats
+	if conf.DataBaseURI == "" {
+		log.Print("server: database uri is not set, using in-memory storage")
+		if db, err = memory.New(conf); err != nil {
+			return err
+		}
+	} else {
+		pg, err := database.StartDB(conf)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			pg.Close()
+			log.Print("DB closed")
+		}()
+
+		if conf.DBHealthInterval > 0 {
+			sv.Go("db health", func(ctx context.Context) error {
+				return pg.RunHealthCheck(ctx, conf.DBHealthInterval, conf.DBReopenAfter)
+			})
+		}
+
+		queries = handlers.NewQueryStats()
+		pg.SetMetrics(queries)
+
+		db = pg
+	}
+
+	f := fraud.Rules{
+		History:        db,
+		VelocityLimit:  conf.FraudVelocityLimit,
+		VelocityWindow: conf.FraudVelocityWindow,
+		LargeSum:       conf.FraudLargeSum,
+		NewSession:     conf.FraudNewSession,
+		CheckOrder:     conf.FraudCheckOrder,
+	}
+
+	a, err := auth.New(conf, db)
 	if err != nil {
-		return err
+		return errors.Join(err, sv.Stop())
 	}
 
-	defer func() {
-		_ = db.DB.Close()
-		log.Print("DB closed")
-	}()
+	var m mail.Sender = mail.Log{}
+	if conf.SMTPAddr != "" {
+		m = mail.SMTP{Addr: conf.SMTPAddr, From: conf.SMTPFrom, Username: conf.SMTPUsername, Password: conf.SMTPPassword}
+	}
+
+	// бот Telegram не подключен: уведомления для этого канала пишутся в журнал
+	n := notify.Dispatcher{
+		Preferences: db,
+		Channels: map[string]notify.Notifier{
+			notify.ChannelEmail:    notify.Mail{Addresses: db, Sender: m},
+			notify.ChannelTelegram: notify.Log{},
+		},
+	}
 
-	w, err := worker.StartWorker(conf, db)
+	p, err := accrual.New(conf, db, &http.Client{Timeout: conf.AccrualTimeout}, n)
 	if err != nil {
-		return err
+		return errors.Join(err, sv.Stop())
+	}
+
+	sv.Go("accrual pool", p.Run)
+
+	if conf.BalanceSnapshotInterval > 0 {
+		sv.Go("balance snapshots", func(ctx context.Context) error {
+			return worker.Snapshots(ctx, db, conf.BalanceSnapshotInterval)
+		})
 	}
 
-	c := handlers.NewController(conf, db, w)
+	sv.Go("expired sessions", func(ctx context.Context) error {
+		return worker.Sessions(ctx, db, time.Hour)
+	})
+
+	if conf.UserRetention > 0 {
+		sv.Go("purge", func(ctx context.Context) error {
+			return worker.Purge(ctx, db, conf.UserRetention, time.Hour)
+		})
+	}
+
+	if conf.OrderArchiveAge > 0 {
+		sv.Go("archive", func(ctx context.Context) error {
+			return worker.Archive(ctx, db, conf.OrderArchiveAge, time.Hour)
+		})
+	}
+
+	if conf.InactiveUserMonths > 0 {
+		sv.Go("inactive users", func(ctx context.Context) error {
+			return worker.Inactive(ctx, db, n, conf.InactiveUserMonths, conf.InactiveUserGrace, time.Hour)
+		})
+	}
+
+	t, err := token.New(conf.TokenSource, conf.SessionKey)
+	if err != nil {
+		return errors.Join(err, sv.Stop())
+	}
+
+	c := handlers.NewController(conf, db, p, f, n, a, m, t, anomaly.Log{}, queries)
+	if conf.SessionTouchInterval > 0 {
+		sv.Go("session touches", c.FlushTouches)
+	}
 
 	r := chi.NewRouter()
 
-	r.Post("/api/user/register", c.PostRegister)
+	// api - пользовательские маршруты под бюджетом ошибок, админские остаются доступны при деградации
+	api := r.With(c.ErrorBudgetMiddleware)
+
+	api.With(c.AuthRateLimitMiddleware).Post("/api/user/register", c.PostRegister)
 	//регистрация пользователя
 
-	r.Post("/api/user/login", c.PostLogin)
+	api.With(c.AuthRateLimitMiddleware).Post("/api/user/login", c.PostLogin)
 	//аутентификация пользователя
 
-	r.Post("/api/user/orders", c.PostOrders)
-	//загрузка пользователем номера заказа для расчета
+	api.Get("/api/user/verify", c.GetVerify)
+	//подтверждение адреса электронной почты по токену из письма
+
+	api.Get("/api/user/profile", c.GetProfile)
+	//получение профиля пользователя с настройками отображения сумм
+
+	api.Get("/api/user/notifications/preferences", c.GetNotificationPreferences)
+	//получение каналов доставки уведомлений о событиях
 
-	r.Get("/api/user/orders", c.GetOrders)
+	api.Get("/api/user/orders", c.GetOrders)
 	//получение списка загруженные пользователем номеров заказов, статусов их обработки и информации о начислениях
 
-	r.Get("/api/user/balance", c.GetBalance)
-	//получение текущего баланса счета баллов лояльности пользователя
+	api.Get("/api/user/orders/{number}", c.GetOrder)
+	//получение одного заказа пользователя: статус загруженного заказа по poll_url
 
-	r.Post("/api/user/balance/withdraw", c.PostWithDraw)
-	//запрос на списание баллов с накопительного счета в счет оплаты нового заказа
+	api.Get("/api/user/balance", c.GetBalance)
+	//получение текущего баланса счета баллов лояльности пользователя
 
-	r.Get("/api/user/withdrawals", c.GetWithDrawAls)
+	api.Get("/api/user/withdrawals", c.GetWithDrawAls)
 	//получение информации о выводе средств накопительного счета пользователем
 
-	return http.ListenAndServe(conf.RunAddress, c.MiddlewaresConveyor(r))
+	api.Get("/api/user/balance/history", c.GetBalanceHistory)
+	//получение остатков на конец суток для графика баланса
+
+	api.Get("/api/user/sessions", c.GetSessions)
+	//получение списка действующих сессий пользователя
+
+	api.Group(func(r chi.Router) {
+		r.Use(c.CSRFMiddleware)
+
+		r.Post("/api/user/logout", c.PostLogout)
+		//завершение сессии пользователя
+
+		r.Post("/api/user/refresh", c.PostRefresh)
+		//продление сессии с заменой ее идентификатора
+
+		r.Post("/api/user/orders", c.PostOrders)
+		//загрузка пользователем номера заказа для расчета
+
+		r.Put("/api/user/profile", c.PutProfile)
+		//изменение локали и валюты отображения сумм
+
+		r.Put("/api/user/notifications/preferences", c.PutNotificationPreferences)
+		//выбор канала доставки уведомлений о событиях: email, telegram или none
+
+		r.Put("/api/user/password", c.PutPassword)
+		//смена пароля с завершением остальных сессий пользователя
+
+		r.Put("/api/user/login", c.PutLogin)
+		//смена логина с сохранением заказов, баланса и сессий
+
+		r.Post("/api/user/2fa/enroll", c.PostTOTPEnroll)
+		//выпуск секрета двухфакторной аутентификации
+
+		r.Post("/api/user/2fa/confirm", c.PostTOTPConfirm)
+		//включение двухфакторной аутентификации после проверки кода
+
+		r.Post("/api/user/orders/batch", c.PostOrdersBatch)
+		//загрузка пачки номеров заказов одним запросом
+
+		r.Post("/api/user/orders/receipt", c.PostOrderReceipt)
+		//загрузка номера заказа из QR-кода кассового чека
+
+		r.Post("/api/user/balance/withdraw", c.PostWithDraw)
+		//запрос на списание баллов с накопительного счета в счет оплаты нового заказа
+
+		r.Delete("/api/user/sessions/{id}", c.DeleteSession)
+		//завершение одной из сессий пользователя
+
+		r.Delete("/api/user", c.DeleteUser)
+		//удаление учетной записи по просьбе пользователя
+	})
+
+	r.Get("/api/changelog", c.GetChangelog)
+	//получение списка изменений API
+
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(c.AdminMiddleware, c.CSRFMiddleware)
+
+		r.Get("/metrics", c.GetMetrics)
+		//снимок счетчиков сервиса: время работы, частота запросов, очередь опроса, соединения с базой
+
+		r.Get("/config", c.GetConfig)
+		//действующая конфигурация с источниками значений, секреты скрыты
+
+		r.Get("/settings", c.GetSettings)
+		//бизнес-настройки: действующие значения и значения из конфигурации
+
+		r.Put("/settings/{name}", c.PutSetting)
+		//изменение бизнес-настройки без перезапуска
+
+		r.Delete("/settings/{name}", c.DeleteSetting)
+		//возврат бизнес-настройки к значению из конфигурации
+
+		r.Get("/holds", c.GetHolds)
+		//получение очереди отложенных списаний
+
+		r.Get("/quarantine", c.GetQuarantine)
+		//получение ответов системы расчета, отложенных вместо начисления
+
+		r.Post("/holds/{id}/approve", c.PostApproveHold)
+		//подтверждение отложенного списания
+
+		r.Post("/holds/{id}/reject", c.PostRejectHold)
+		//отклонение отложенного списания
+
+		r.Post("/users/{login}/logout", c.PostLogoutUser)
+		//завершение всех сессий пользователя
+
+		r.Post("/impersonate/{login}", c.PostImpersonate)
+		//вход от имени пользователя для воспроизведения проблемы
+
+		r.Post("/orders/{number}/transfer", c.PostTransferOrder)
+		//перенос заказа с начислением в другую учетную запись
+
+		r.Post("/orders/{number}/extend", c.PostExtendOrder)
+		//продление срока ожидания расчета по заказу
+
+		r.Get("/inactivity/exempt", c.GetInactivityExempt)
+		//исключения очистки неактивных учетных записей
+
+		r.Put("/inactivity/exempt/{login}", c.PutInactivityExempt)
+		//исключение пользователя из очистки неактивных учетных записей
+
+		r.Delete("/inactivity/exempt/{login}", c.DeleteInactivityExempt)
+		//возврат пользователя в очистку неактивных учетных записей
+
+		r.Post("/users/import", c.PostImportUsers)
+		//импорт пользователей с начальными остатками из CSV прежней системы лояльности
+
+		r.Get("/reports", c.GetReports)
+		//начисления, списания, активные пользователи и регистрации по суткам или неделям
+
+		r.Post("/backfill", c.PostBackfill)
+		//сверка начислений по обработанным заказам за период
+	})
+
+	// проверка готовности минует middleware сессий: пробы не создают сессий и отвечают без базы
+	root := chi.NewRouter()
+	root.Get("/api/ready", c.GetReady)
+	root.Get("/api/status", c.GetStatus)
+	root.Mount("/", c.MiddlewaresConveyor(r))
+
+	srv := newHTTPServer(conf, root)
+
+	if conf.SelfTest {
+		return errors.Join(runSelfTest(srv, conf.SelfTestTimeout, db.Settings().AccrualPointRate), sv.Stop())
+	}
+
+	if conf.WarmUpTimeout > 0 {
+		c.WarmUp()
+		go func() {
+			warmUp(db, conf.AccrualSystemAddress, conf.WarmUpTimeout)
+			c.Ready()
+		}()
+	}
+
+	srv.Addr = conf.RunAddress
+	err = listenAndDrain(srv, c, conf.ShutdownDrain, sv.Failed())
+	return errors.Join(err, sv.Stop())
+}
+
+// shutdownTimeout ограничивает ожидание начатых запросов при остановке
+const shutdownTimeout = 30 * time.Second
+
+// listenAndDrain обслуживает запросы до SIGINT, SIGTERM или закрытия failed (отказ фонового компонента).
+// После этого /api/status и /api/ready отвечают 503 в течение drain, запросы при этом обслуживаются.
+// Затем сервер перестает принимать соединения и дожидается начатых запросов.
+func listenAndDrain(srv *http.Server, c *handlers.Controller, drain time.Duration, failed <-chan struct{}) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-stop:
+		log.Printf("server: %s, draining for %s", sig, drain)
+	case <-failed:
+		log.Printf("server: background component failed, draining for %s", drain)
+	}
+
+	c.Drain()
+	// без keep-alive клиенты открывают новые соединения, которые балансировщик направит на другой экземпляр
+	srv.SetKeepAlivesEnabled(false)
+	time.Sleep(drain)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	log.Print("server: stopped")
+	return nil
+}
+
+// newHTTPServer настраивает публичный listener. Клиенты, опрашивающие статусы заказов, держат
+// много соединений: HTTP/2 без TLS (h2c) мультиплексирует их запросы в одно соединение,
+// а IdleTimeout закрывает простаивающие keep-alive соединения.
+func newHTTPServer(conf config.Config, h http.Handler) *http.Server {
+	srv := &http.Server{
+		Handler:        h,
+		IdleTimeout:    conf.HTTPIdleTimeout,
+		MaxHeaderBytes: conf.HTTPMaxHeaderBytes,
+		Protocols:      new(http.Protocols),
+	}
+
+	srv.Protocols.SetHTTP1(true)
+	if conf.HTTP2 {
+		srv.Protocols.SetUnencryptedHTTP2(true)
+		srv.HTTP2 = &http.HTTP2Config{MaxConcurrentStreams: conf.HTTP2MaxStreams}
+	}
+
+	srv.SetKeepAlivesEnabled(conf.HTTPKeepAlive)
+	return srv
+}
+
+// runSelfTest поднимает сервис на случайном локальном порту и прогоняет по нему сценарий самопроверки
+func runSelfTest(srv *http.Server, timeout time.Duration, pointRate float64) error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	defer func() {
+		_ = srv.Close()
+	}()
+
+	if err = selftest.Run("http://"+l.Addr().String(), timeout, pointRate); err != nil {
+		return fmt.Errorf("selftest failed: %w", err)
+	}
+
+	log.Print("selftest passed")
+	return nil
 }
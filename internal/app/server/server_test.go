@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+)
+
+var listenerConfig = config.Config{
+	HTTP2:              true,
+	HTTP2MaxStreams:    250,
+	HTTPKeepAlive:      true,
+	HTTPIdleTimeout:    2 * time.Minute,
+	HTTPMaxHeaderBytes: 1 << 20,
+}
+
+// serve поднимает newHTTPServer на случайном локальном порту и возвращает адрес
+func serve(tb testing.TB, conf config.Config) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	srv := newHTTPServer(conf, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+		_, _ = w.Write([]byte(`[{"number":"12345678903","status":"PROCESSING"}]`))
+	}))
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	tb.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	return "http://" + l.Addr().String()
+}
+
+// client возвращает клиента, который ходит по HTTP/1.1 или, при h2c, по HTTP/2 без TLS
+func client(h2c bool) *http.Client {
+	t := &http.Transport{MaxIdleConnsPerHost: 256, Protocols: new(http.Protocols)}
+	if h2c {
+		t.Protocols.SetUnencryptedHTTP2(true)
+	} else {
+		t.Protocols.SetHTTP1(true)
+	}
+
+	return &http.Client{Transport: t}
+}
+
+// poll запрашивает url и возвращает протокол ответа. Ошибки отмечаются через Error: poll
+// вызывается и из горутин RunParallel, где Fatal недопустим.
+func poll(tb testing.TB, c *http.Client, url string) string {
+	resp, err := c.Get(url)
+	if err != nil {
+		tb.Error(err)
+		return ""
+	}
+	defer resp.Body.Close()
+
+	if _, err = io.Copy(io.Discard, resp.Body); err != nil {
+		tb.Error(err)
+	}
+
+	return resp.Header.Get("X-Proto")
+}
+
+func TestNewHTTPServer(t *testing.T) {
+	tests := []struct {
+		name  string
+		http2 bool
+		h2c   bool
+		want  string
+	}{
+		{name: "http1", http2: true, h2c: false, want: "HTTP/1.1"},
+		{name: "h2c", http2: true, h2c: true, want: "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := listenerConfig
+			conf.HTTP2 = tt.http2
+			url := serve(t, conf)
+
+			if got := poll(t, client(tt.h2c), url); got != tt.want {
+				t.Errorf("proto = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("h2c disabled", func(t *testing.T) {
+		conf := listenerConfig
+		conf.HTTP2 = false
+		url := serve(t, conf)
+
+		if _, err := client(true).Get(url); err == nil {
+			t.Error("h2c request err = nil, want error")
+		}
+	})
+}
+
+// BenchmarkListener сравнивает опрос статусов многими параллельными клиентами по HTTP/1.1
+// с прежними настройками сервера по умолчанию и по h2c с настройками из конфигурации:
+//
+//	go test ./internal/app/server -run ^$ -bench Listener -cpu 1,8 -benchtime 5000x
+func BenchmarkListener(b *testing.B) {
+	defaults := config.Config{HTTPKeepAlive: true}
+
+	benchmarks := []struct {
+		name string
+		conf config.Config
+		h2c  bool
+	}{
+		{name: "defaults", conf: defaults},
+		{name: "http1", conf: listenerConfig},
+		{name: "h2c", conf: listenerConfig, h2c: true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			url := serve(b, bm.conf)
+			c := client(bm.h2c)
+
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					poll(b, c, url)
+				}
+			})
+		})
+	}
+}
+
+func TestWaitAccrual(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := waitAccrual(ctx, serve(t, listenerConfig)); err != nil {
+		t.Errorf("waitAccrual() reachable err = %v, want nil", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := "http://" + l.Addr().String()
+	_ = l.Close()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err = waitAccrual(ctx, addr); err == nil {
+		t.Error("waitAccrual() unreachable err = nil, want error")
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	// wait работает до отмены контекста, как исправный компонент
+	wait := func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}
+
+	errBroken := errors.New("broken")
+
+	tests := []struct {
+		name    string
+		run     func(ctx context.Context) error
+		wantErr error
+		wantMsg string
+	}{
+		{name: "ошибка", run: func(context.Context) error { return errBroken }, wantErr: errBroken},
+		{name: "паника", run: func(context.Context) error { panic("boom") }, wantMsg: "failing: panic: boom"},
+		{name: "завершился сам", run: func(context.Context) error { return nil }, wantErr: errStopped},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSupervisor()
+			s.Go("healthy", wait)
+			s.Go("failing", tt.run)
+
+			select {
+			case <-s.Failed():
+			case <-time.After(time.Second):
+				t.Fatal("supervisor did not notice the failure")
+			}
+
+			err := s.Stop()
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Stop() err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantMsg != "" && (err == nil || err.Error() != tt.wantMsg) {
+				t.Errorf("Stop() err = %v, want %q", err, tt.wantMsg)
+			}
+		})
+	}
+
+	s := newSupervisor()
+	s.Go("healthy", wait)
+
+	select {
+	case <-s.Failed():
+		t.Fatal("Failed() closed without a failure")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Errorf("Stop() err = %v, want nil", err)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// errStopped - компонент завершился сам, хотя его не останавливали
+var errStopped = errors.New("stopped unexpectedly")
+
+// supervisor держит фоновые компоненты сервиса в одной группе: ошибка, паника или самовольное
+// завершение одного компонента отменяет контекст остальных, сервис останавливается и выходит
+// с этой ошибкой
+type supervisor struct {
+	group  *errgroup.Group
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newSupervisor() *supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	group, ctx := errgroup.WithContext(ctx)
+
+	return &supervisor{group: group, ctx: ctx, cancel: cancel}
+}
+
+// Go запускает компонент name. run работает до отмены ctx и возвращает ошибку, если продолжать не может.
+func (s *supervisor) Go(name string, run func(ctx context.Context) error) {
+	s.group.Go(func() (err error) {
+		defer func() {
+			if x := recover(); x != nil {
+				err = fmt.Errorf("%s: panic: %v", name, x)
+			}
+
+			if err != nil {
+				log.Print("supervisor: ", err.Error())
+			}
+		}()
+
+		if err = run(s.ctx); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		if s.ctx.Err() == nil {
+			return fmt.Errorf("%s: %w", name, errStopped)
+		}
+
+		return nil
+	})
+}
+
+// Failed закрывается, когда один из компонентов завершился с ошибкой
+func (s *supervisor) Failed() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Stop останавливает компоненты, дожидается их завершения и возвращает первую ошибку компонента
+func (s *supervisor) Stop() error {
+	s.cancel()
+	return s.group.Wait()
+}
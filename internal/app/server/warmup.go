@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// warmUpRetry - пауза между проверками доступности системы расчета при прогреве
+const warmUpRetry = time.Second
+
+// warmUp готовит экземпляр к приему трафика: открывает соединения с базой, читает настройки
+// и дожидается ответа системы расчета. После timeout экземпляр начинает принимать трафик
+// без завершенного прогрева, чтобы недоступная система расчета не останавливала запуск.
+func warmUp(db storage, accrual string, timeout time.Duration) {
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := db.WarmUp(ctx); err != nil {
+		log.Printf("server: warm-up db err: %s", err.Error())
+	}
+
+	if err := waitAccrual(ctx, accrual); err != nil {
+		log.Printf("server: warm-up accrual err: %s", err.Error())
+	}
+
+	log.Printf("server: warmed up in %s", time.Since(start).Round(time.Millisecond))
+}
+
+// waitAccrual повторяет запрос к системе расчета, пока она не ответит или не истечет ctx.
+// Любой HTTP-ответ, в том числе 204 для неизвестного заказа, означает, что система доступна.
+func waitAccrual(ctx context.Context, accrual string) error {
+	for {
+		err := pingAccrual(ctx, accrual)
+		if err == nil {
+			return nil
+		}
+
+		timer := time.NewTimer(warmUpRetry)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("accrual system is not reachable: %w", err)
+		case <-timer.C:
+		}
+	}
+}
+
+func pingAccrual(ctx context.Context, accrual string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, accrual+"/api/orders/0", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
@@ -0,0 +1,232 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/config"
+)
+
+// Имена настроек в таблице settings и в API администратора
+const (
+	MinWithdrawal    = "min_withdrawal"
+	OrdersDailyLimit = "orders_daily_limit"
+	AccrualPointRate = "accrual_point_rate"
+	SessionTTL       = "session_ttl"
+)
+
+// Names - все настройки в порядке вывода
+var Names = []string{MinWithdrawal, OrdersDailyLimit, AccrualPointRate, SessionTTL}
+
+// Источники значения настройки
+const (
+	SourceDefault  = "default"
+	SourceDatabase = "database"
+)
+
+var (
+	ErrUnknown = errors.New("unknown setting")
+	ErrInvalid = errors.New("invalid setting value")
+)
+
+// Values - бизнес-константы, которые оператор меняет без перезапуска сервиса. Значения
+// по умолчанию - из конфигурации, таблица settings их переопределяет.
+type Values struct {
+	MinWithdrawal    float64
+	OrdersDailyLimit int
+	AccrualPointRate float64
+	SessionTTL       time.Duration
+}
+
+// Override - значение настройки, заданное администратором
+type Override struct {
+	Name      string    `json:"name"`
+	Value     string    `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UpdatedBy string    `json:"updated_by"`
+}
+
+// Setting - действующее значение настройки, значение по умолчанию и источник
+type Setting struct {
+	Name      string     `json:"name"`
+	Value     string     `json:"value"`
+	Default   string     `json:"default"`
+	Source    string     `json:"source"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	UpdatedBy string     `json:"updated_by,omitempty"`
+}
+
+// Defaults возвращает значения из конфигурации. Курс начисления и время жизни сессии
+// без настройки - 1 и час.
+func Defaults(c config.Config) Values {
+	v := Values{
+		MinWithdrawal:    c.MinWithdrawal,
+		OrdersDailyLimit: c.OrdersDailyLimit,
+		AccrualPointRate: c.AccrualPointRate,
+		SessionTTL:       c.SessionTTL,
+	}
+
+	if v.AccrualPointRate <= 0 {
+		v.AccrualPointRate = 1
+	}
+	if v.SessionTTL <= 0 {
+		v.SessionTTL = time.Hour
+	}
+
+	return v
+}
+
+// Set разбирает значение value настройки name
+func (v *Values) Set(name, value string) error {
+	switch name {
+	case MinWithdrawal:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 || math.IsInf(f, 0) {
+			return fmt.Errorf("%w: %s must be a non-negative number", ErrInvalid, name)
+		}
+		v.MinWithdrawal = f
+	case OrdersDailyLimit:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%w: %s must be a non-negative integer, 0 - unlimited", ErrInvalid, name)
+		}
+		v.OrdersDailyLimit = n
+	case AccrualPointRate:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || !(f > 0) || math.IsInf(f, 0) {
+			return fmt.Errorf("%w: %s must be a positive number", ErrInvalid, name)
+		}
+		v.AccrualPointRate = f
+	case SessionTTL:
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("%w: %s must be a positive duration, e.g. 1h", ErrInvalid, name)
+		}
+		v.SessionTTL = d
+	default:
+		return ErrUnknown
+	}
+
+	return nil
+}
+
+// Validate проверяет значение настройки до сохранения
+func Validate(name, value string) error {
+	var v Values
+	return v.Set(name, value)
+}
+
+// Get возвращает значение настройки name строкой, которую принимает Set
+func (v Values) Get(name string) string {
+	switch name {
+	case MinWithdrawal:
+		return strconv.FormatFloat(v.MinWithdrawal, 'f', -1, 64)
+	case OrdersDailyLimit:
+		return strconv.Itoa(v.OrdersDailyLimit)
+	case AccrualPointRate:
+		return strconv.FormatFloat(v.AccrualPointRate, 'f', -1, 64)
+	case SessionTTL:
+		return v.SessionTTL.String()
+	}
+
+	return ""
+}
+
+// Apply переопределяет defaults сохраненными значениями. Неизвестные и неразобранные значения,
+// например записанные в таблицу вручную, пропускаются с записью в журнал.
+func Apply(defaults Values, overrides []Override) Values {
+	v := defaults
+	for _, o := range overrides {
+		if err := v.Set(o.Name, o.Value); err != nil {
+			log.Printf("settings: %s = %q ignored: %s", o.Name, o.Value, err.Error())
+		}
+	}
+
+	return v
+}
+
+// Describe возвращает все настройки с действующим значением и его источником
+func Describe(defaults Values, overrides []Override) []Setting {
+	values := Apply(defaults, overrides)
+
+	byName := make(map[string]Override, len(overrides))
+	for _, o := range overrides {
+		byName[o.Name] = o
+	}
+
+	settings := make([]Setting, 0, len(Names))
+	for _, name := range Names {
+		s := Setting{Name: name, Value: values.Get(name), Default: defaults.Get(name), Source: SourceDefault}
+		if o, ok := byName[name]; ok && Validate(name, o.Value) == nil {
+			updatedAt := o.UpdatedAt
+			s.Source, s.UpdatedAt, s.UpdatedBy = SourceDatabase, &updatedAt, o.UpdatedBy
+		}
+
+		settings = append(settings, s)
+	}
+
+	return settings
+}
+
+// Source - хранилище значений, заданных администратором
+type Source interface {
+	GetSettings() ([]Override, error)
+}
+
+// Cache - действующие значения настроек в памяти процесса. Хранилище перечитывается не чаще
+// раза в ttl, изменение на другом экземпляре доходит до этого не позже чем через ttl. Если
+// хранилище недоступно, действуют последние прочитанные значения.
+type Cache struct {
+	defaults Values
+	source   Source
+	ttl      time.Duration
+
+	mu       sync.Mutex
+	values   Values
+	loadedAt time.Time
+}
+
+func NewCache(defaults Values, source Source, ttl time.Duration) *Cache {
+	return &Cache{defaults: defaults, source: source, ttl: ttl, values: defaults}
+}
+
+// Get возвращает действующие значения
+func (c *Cache) Get() Values {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.loadedAt.IsZero() && time.Since(c.loadedAt) < c.ttl {
+		return c.values
+	}
+
+	// при ошибке следующая попытка - тоже через ttl, а не на каждом вызове
+	c.loadedAt = time.Now()
+
+	overrides, err := c.source.GetSettings()
+	if err != nil {
+		log.Print("settings: load err: ", err.Error())
+		return c.values
+	}
+
+	c.values = Apply(c.defaults, overrides)
+
+	return c.values
+}
+
+// Defaults возвращает значения по умолчанию, которые переопределяют сохраненные
+func (c *Cache) Defaults() Values {
+	return c.defaults
+}
+
+// Invalidate сбрасывает кеш после изменения настроек этим экземпляром
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.loadedAt = time.Time{}
+}
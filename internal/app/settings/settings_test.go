@@ -0,0 +1,103 @@
+package settings
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type source struct {
+	overrides []Override
+	err       error
+	calls     int
+}
+
+func (s *source) GetSettings() ([]Override, error) {
+	s.calls++
+	return s.overrides, s.err
+}
+
+func TestValuesSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		setting string
+		value   string
+		wantErr error
+	}{
+		{name: "Минимальное списание", setting: MinWithdrawal, value: "10.5"},
+		{name: "Отрицательное списание", setting: MinWithdrawal, value: "-1", wantErr: ErrInvalid},
+		{name: "Без лимита", setting: OrdersDailyLimit, value: "0"},
+		{name: "Дробный лимит", setting: OrdersDailyLimit, value: "1.5", wantErr: ErrInvalid},
+		{name: "Нулевой курс", setting: AccrualPointRate, value: "0", wantErr: ErrInvalid},
+		{name: "Курс NaN", setting: AccrualPointRate, value: "NaN", wantErr: ErrInvalid},
+		{name: "Время жизни сессии", setting: SessionTTL, value: "2h0m0s"},
+		{name: "Время жизни без единиц", setting: SessionTTL, value: "3600", wantErr: ErrInvalid},
+		{name: "Неизвестная настройка", setting: "max_withdrawal", value: "1", wantErr: ErrUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v Values
+			err := v.Set(tt.setting, tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Set() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && v.Get(tt.setting) != tt.value {
+				t.Errorf("Get() = %s, want %s", v.Get(tt.setting), tt.value)
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	defaults := Values{OrdersDailyLimit: 10, AccrualPointRate: 1, SessionTTL: time.Hour}
+	updatedAt := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	overrides := []Override{
+		{Name: AccrualPointRate, Value: "0.5", UpdatedAt: updatedAt, UpdatedBy: "admin"},
+		{Name: SessionTTL, Value: "forever", UpdatedAt: updatedAt, UpdatedBy: "admin"},
+	}
+
+	got := Describe(defaults, overrides)
+	if len(got) != len(Names) {
+		t.Fatalf("Describe() = %d settings, want %d", len(got), len(Names))
+	}
+
+	for _, s := range got {
+		switch s.Name {
+		case AccrualPointRate:
+			if s.Value != "0.5" || s.Default != "1" || s.Source != SourceDatabase || s.UpdatedBy != "admin" {
+				t.Errorf("Describe() %s = %+v, want 0.5 from database", s.Name, s)
+			}
+		case SessionTTL:
+			// неразобранное значение не действует
+			if s.Value != "1h0m0s" || s.Source != SourceDefault || s.UpdatedAt != nil {
+				t.Errorf("Describe() %s = %+v, want default", s.Name, s)
+			}
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	defaults := Values{AccrualPointRate: 1, SessionTTL: time.Hour}
+	src := &source{overrides: []Override{{Name: AccrualPointRate, Value: "2"}}}
+	c := NewCache(defaults, src, time.Hour)
+
+	if got := c.Get().AccrualPointRate; got != 2 {
+		t.Errorf("Get() rate = %g, want 2", got)
+	}
+
+	src.overrides = nil
+	if got := c.Get().AccrualPointRate; got != 2 || src.calls != 1 {
+		t.Errorf("Get() within ttl = %g after %d loads, want cached 2 after 1", got, src.calls)
+	}
+
+	c.Invalidate()
+	if got := c.Get().AccrualPointRate; got != 1 {
+		t.Errorf("Get() after Invalidate() = %g, want default 1", got)
+	}
+
+	src.overrides, src.err = []Override{{Name: AccrualPointRate, Value: "3"}}, errors.New("db down")
+	c.Invalidate()
+	if got := c.Get().AccrualPointRate; got != 1 {
+		t.Errorf("Get() with source error = %g, want last loaded 1", got)
+	}
+}
@@ -0,0 +1,168 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Source выдает идентификаторы сессий
+type Source interface {
+	New() (string, error)
+}
+
+const (
+	KindRandom = "random"
+	KindUUIDv7 = "uuidv7"
+	KindSigned = "signed"
+)
+
+// New возвращает источник идентификаторов по названию из конфигурации.
+// key используется только источником signed.
+func New(kind, key string) (Source, error) {
+	switch kind {
+	case "", KindRandom:
+		return Random{}, nil
+	case KindUUIDv7:
+		return &UUIDv7{}, nil
+	case KindSigned:
+		if key == "" {
+			return nil, fmt.Errorf("token: signed source requires a key")
+		}
+
+		return Signed{Key: []byte(key)}, nil
+	default:
+		return nil, fmt.Errorf("token: unknown source %s", kind)
+	}
+}
+
+// Random - 256 случайных бит в hex
+type Random struct{}
+
+func (Random) New() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// UUIDv7 - UUID версии 7 (RFC 9562): 48 бит миллисекунд и 74 случайных бита.
+// Идентификаторы, выданные одним источником, возрастают, что удобно для индекса сессий.
+type UUIDv7 struct {
+	mu   sync.Mutex
+	last int64
+}
+
+func (u *UUIDv7) New() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+
+	// в пределах одной миллисекунды отметка времени сдвигается вперед, сохраняя порядок
+	u.mu.Lock()
+	ms := time.Now().UnixMilli()
+	if ms <= u.last {
+		ms = u.last + 1
+	}
+	u.last = ms
+	u.mu.Unlock()
+
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(ms))
+	copy(b[:6], ts[2:])
+
+	b[6] = b[6]&0x0f | 0x70 // версия 7
+	b[8] = b[8]&0x3f | 0x80 // вариант RFC 9562
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// crockford - алфавит Base32 Крокфорда без I, L, O и U
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID - 48 бит миллисекунд и 80 случайных бит в Base32 Крокфорда, 26 символов.
+// Удобен как номер операции, который пользователь диктует в поддержку: без похожих букв
+// и без учета регистра. Идентификаторы одного источника возрастают.
+type ULID struct {
+	mu   sync.Mutex
+	last int64
+}
+
+func (u *ULID) New() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+
+	u.mu.Lock()
+	ms := time.Now().UnixMilli()
+	if ms <= u.last {
+		ms = u.last + 1
+	}
+	u.last = ms
+	u.mu.Unlock()
+
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(ms))
+	copy(b[:6], ts[2:])
+
+	// 128 бит кодируются 26 символами по 5 бит, старшие 2 бита первого символа нулевые
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	var out [26]byte
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+
+	return string(out[:]), nil
+}
+
+// Signed - 128 случайных бит и отметка времени выдачи с HMAC-SHA256 подписью ключом сессий:
+// <время><случайная часть>-<подпись>. Подлинность идентификатора проверяется без обращения к базе.
+type Signed struct {
+	Key []byte
+}
+
+func (s Signed) New() (string, error) {
+	b := make([]byte, 24)
+	binary.BigEndian.PutUint64(b, uint64(time.Now().Unix()))
+	if _, err := rand.Read(b[8:]); err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write(b)
+
+	return hex.EncodeToString(b) + "-" + hex.EncodeToString(mac.Sum(nil)[:16]), nil
+}
+
+// Verify проверяет подпись идентификатора, выданного New
+func (s Signed) Verify(id string) bool {
+	if len(id) != 48+1+32 || id[48] != '-' {
+		return false
+	}
+
+	b, err := hex.DecodeString(id[:48])
+	if err != nil {
+		return false
+	}
+
+	sig, err := hex.DecodeString(id[49:])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write(b)
+
+	return hmac.Equal(mac.Sum(nil)[:16], sig)
+}
@@ -0,0 +1,152 @@
+package token
+
+import (
+	"encoding/hex"
+	"math/bits"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const samples = 2000
+
+func TestUnique(t *testing.T) {
+	for _, kind := range []string{KindRandom, KindUUIDv7, KindSigned} {
+		t.Run(kind, func(t *testing.T) {
+			s, err := New(kind, "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			seen := make(map[string]bool, samples)
+			for i := 0; i < samples; i++ {
+				id, err := s.New()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if seen[id] {
+					t.Fatalf("New() returned duplicate %s", id)
+				}
+				seen[id] = true
+			}
+		})
+	}
+}
+
+// TestRandomEntropy проверяет, что биты случайных идентификаторов распределены равномерно:
+// доля единиц в каждой позиции не отклоняется от половины больше чем на 5 сигм
+func TestRandomEntropy(t *testing.T) {
+	var ones [256]int
+	for i := 0; i < samples; i++ {
+		id, err := Random{}.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := hex.DecodeString(id)
+		if err != nil || len(b) != 32 {
+			t.Fatalf("New() = %s, want 64 hex chars", id)
+		}
+
+		for j, c := range b {
+			for k := 0; k < 8; k++ {
+				ones[j*8+k] += int(c>>k) & 1
+			}
+		}
+	}
+
+	// σ = sqrt(n/4) ≈ 22 для 2000 выборок
+	for bit, n := range ones {
+		if n < samples/2-110 || n > samples/2+110 {
+			t.Errorf("bit %d set in %d of %d tokens", bit, n, samples)
+		}
+	}
+}
+
+func TestRandomHammingDistance(t *testing.T) {
+	prev, _ := Random{}.New()
+	for i := 0; i < 100; i++ {
+		id, _ := Random{}.New()
+		a, _ := hex.DecodeString(prev)
+		b, _ := hex.DecodeString(id)
+
+		distance := 0
+		for j := range a {
+			distance += bits.OnesCount8(a[j] ^ b[j])
+		}
+		if distance < 64 {
+			t.Fatalf("tokens %s and %s differ in %d bits only", prev, id, distance)
+		}
+		prev = id
+	}
+}
+
+func TestUUIDv7(t *testing.T) {
+	format := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	u := &UUIDv7{}
+	prev := ""
+	for i := 0; i < samples; i++ {
+		id, err := u.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(id) {
+			t.Fatalf("New() = %s, want UUIDv7", id)
+		}
+		if strings.Compare(id[:13], prev) < 0 {
+			t.Fatalf("New() = %s is ordered before %s", id, prev)
+		}
+		prev = id[:13]
+	}
+}
+
+func TestULID(t *testing.T) {
+	format := regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+
+	u := &ULID{}
+	prev := ""
+	for i := 0; i < samples; i++ {
+		id, err := u.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(id) {
+			t.Fatalf("New() = %s, want ULID", id)
+		}
+		if strings.Compare(id[:10], prev) < 0 {
+			t.Fatalf("New() = %s is ordered before %s", id, prev)
+		}
+		prev = id[:10]
+	}
+}
+
+func TestSigned(t *testing.T) {
+	s := Signed{Key: []byte("secret")}
+
+	id, err := s.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Verify(id) {
+		t.Errorf("Verify(%s) = false, want true", id)
+	}
+	if (Signed{Key: []byte("other")}).Verify(id) {
+		t.Errorf("Verify() with other key = true, want false")
+	}
+
+	tampered := []byte(id)
+	tampered[10] ^= 1
+	if s.Verify(string(tampered)) {
+		t.Errorf("Verify(%s) = true, want false", tampered)
+	}
+}
+
+func TestNewUnknown(t *testing.T) {
+	if _, err := New("aes", ""); err == nil {
+		t.Error("New(aes) err = nil, want error")
+	}
+	if _, err := New(KindSigned, ""); err == nil {
+		t.Error("New(signed) without key err = nil, want error")
+	}
+}
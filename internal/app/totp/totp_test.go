@@ -0,0 +1,58 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// Секрет "12345678901234567890" из RFC 6238, приложение B
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		name string
+		time int64
+		want string
+	}{
+		{name: "59", time: 59, want: "287082"},
+		{name: "1111111109", time: 1111111109, want: "081804"},
+		{name: "1234567890", time: 1234567890, want: "005924"},
+		{name: "2000000000", time: 2000000000, want: "279037"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Code(rfcSecret, time.Unix(tt.time, 0))
+			if err != nil {
+				t.Errorf("Code() error = %v", err)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Code() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	tests := []struct {
+		name     string
+		passcode string
+		at       time.Time
+		want     bool
+	}{
+		{name: "Текущий код", passcode: "005924", at: now, want: true},
+		{name: "Код предыдущего шага", passcode: "005924", at: now.Add(Period), want: true},
+		{name: "Устаревший код", passcode: "005924", at: now.Add(3 * Period), want: false},
+		{name: "Неверный код", passcode: "000000", at: now, want: false},
+		{name: "Пустой код", passcode: "", at: now, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Validate(rfcSecret, tt.passcode, tt.at); got != tt.want {
+				t.Errorf("Validate() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
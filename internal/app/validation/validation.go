@@ -0,0 +1,84 @@
+package validation
+
+import (
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MaxLoginLength    = 64
+	MaxPasswordLength = 128
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	s := make([]string, 0, len(e))
+	for _, err := range e {
+		s = append(s, err.Field+": "+err.Message)
+	}
+
+	return strings.Join(s, "; ")
+}
+
+// Credentials проверяет пару логин/пароль, возвращает nil, если ошибок нет
+func Credentials(login, password string) Errors {
+	errs := Login(login)
+
+	switch {
+	case password == "":
+		errs = append(errs, FieldError{Field: "password", Message: "must not be empty"})
+	case !utf8.ValidString(password):
+		errs = append(errs, FieldError{Field: "password", Message: "must be valid UTF-8"})
+	case utf8.RuneCountInString(password) > MaxPasswordLength:
+		errs = append(errs, FieldError{Field: "password", Message: "must be at most 128 characters"})
+	case strings.IndexFunc(password, unicode.IsControl) >= 0:
+		errs = append(errs, FieldError{Field: "password", Message: "must not contain control characters"})
+	}
+
+	return errs
+}
+
+// Login проверяет логин, возвращает nil, если ошибок нет
+func Login(login string) Errors {
+	switch {
+	case login == "":
+		return Errors{{Field: "login", Message: "must not be empty"}}
+	case !utf8.ValidString(login):
+		return Errors{{Field: "login", Message: "must be valid UTF-8"}}
+	case utf8.RuneCountInString(login) > MaxLoginLength:
+		return Errors{{Field: "login", Message: "must be at most 64 characters"}}
+	case strings.IndexFunc(login, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0:
+		return Errors{{Field: "login", Message: "must not contain whitespace or control characters"}}
+	}
+
+	return nil
+}
+
+// MaxEmailLength - максимальная длина адреса по RFC 5321
+const MaxEmailLength = 254
+
+// Email проверяет адрес электронной почты, возвращает nil, если ошибок нет
+func Email(email string) Errors {
+	if email == "" {
+		return Errors{{Field: "email", Message: "must not be empty"}}
+	}
+
+	if len(email) > MaxEmailLength {
+		return Errors{{Field: "email", Message: "must be at most 254 characters"}}
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return Errors{{Field: "email", Message: "must be a plain address like user@example.com"}}
+	}
+
+	return nil
+}
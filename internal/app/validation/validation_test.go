@@ -0,0 +1,87 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		want     []string
+	}{
+		{
+			name:     "Верные данные",
+			login:    "username",
+			password: "pass word",
+			want:     nil,
+		},
+		{
+			name:     "Пустые поля",
+			login:    "",
+			password: "",
+			want:     []string{"login", "password"},
+		},
+		{
+			name:     "Пробел в логине",
+			login:    "user name",
+			password: "password",
+			want:     []string{"login"},
+		},
+		{
+			name:     "Управляющий символ",
+			login:    "username",
+			password: "pass\x00word",
+			want:     []string{"password"},
+		},
+		{
+			name:     "Длинный логин",
+			login:    strings.Repeat("л", MaxLoginLength+1),
+			password: "password",
+			want:     []string{"login"},
+		},
+		{
+			name:     "Не UTF-8",
+			login:    "user\xffname",
+			password: "password",
+			want:     []string{"login"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Credentials(tt.login, tt.password)
+			if len(got) != len(tt.want) {
+				t.Errorf("Credentials() got = %v, want fields %v", got, tt.want)
+				return
+			}
+			for i, field := range tt.want {
+				if got[i].Field != field {
+					t.Errorf("Credentials() got = %v, want fields %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEmail(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		ok    bool
+	}{
+		{name: "Верный адрес", email: "user@example.com", ok: true},
+		{name: "Пустой адрес", email: "", ok: false},
+		{name: "Без домена", email: "user", ok: false},
+		{name: "С именем", email: "User <user@example.com>", ok: false},
+		{name: "Перенос строки", email: "user@example.com\r\nBcc: x@example.com", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Email(tt.email); (got == nil) != tt.ok {
+				t.Errorf("Email() = %v, want ok %v", got, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// ArchiveStorage - заказы, которые Archive переносит в архив
+type ArchiveStorage interface {
+	ArchiveOrders(before time.Time) (int64, error)
+}
+
+// Archive раз в interval переносит в архив обработанные и отклоненные заказы, загруженные
+// раньше чем age назад. Работает до отмены ctx.
+func Archive(ctx context.Context, db ArchiveStorage, age, interval time.Duration) error {
+	return repeat(ctx, interval, func() {
+		archived, err := db.ArchiveOrders(time.Now().Add(-age))
+		if err != nil {
+			log.Print("archive orders err: ", err.Error())
+		} else if archived > 0 {
+			log.Printf("archive orders: %d", archived)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/notify"
+)
+
+// InactiveStorage - учетные записи без входов и запросов, которые отмечает и обезличивает Inactive
+type InactiveStorage interface {
+	FlagInactiveUsers(before time.Time) ([]string, error)
+	AnonymizeInactiveUsers(notifiedBefore time.Time) ([]string, error)
+}
+
+// Inactive раз в interval отмечает учетные записи без входов и запросов дольше months месяцев
+// и предупреждает их владельцев. Через grace после предупреждения учетная запись с нулевым остатком
+// обезличивается, как при удалении по просьбе пользователя. Вход или запрос снимает отметку,
+// исключения ведет администратор. Работает до отмены ctx.
+func Inactive(ctx context.Context, db InactiveStorage, n notify.Notifier, months int, grace, interval time.Duration) error {
+	return repeat(ctx, interval, func() {
+		now := time.Now()
+
+		flagged, err := db.FlagInactiveUsers(now.AddDate(0, -months, 0))
+		if err != nil {
+			log.Print("flag inactive users err: ", err.Error())
+		}
+
+		message := fmt.Sprintf("Вы не заходили в сервис больше %d мес. Если не войти до %s, учетная запись "+
+			"с нулевым остатком баллов будет обезличена", months, now.Add(grace).Format("02.01.2006"))
+		for _, login := range flagged {
+			if err = n.Notify(ctx, login, message); err != nil {
+				log.Printf("inactive users: notify err: %s, login: %s", err.Error(), login)
+			}
+		}
+
+		anonymized, err := db.AnonymizeInactiveUsers(now.Add(-grace))
+		if err != nil {
+			log.Print("anonymize inactive users err: ", err.Error())
+		}
+
+		if len(flagged) > 0 || len(anonymized) > 0 {
+			log.Printf("inactive users: flagged %d, anonymized %d", len(flagged), len(anonymized))
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package worker
+
+import (
+	"context"
+	"time"
+)
+
+// repeat вызывает fn сразу и затем раз в interval, пока не отменен ctx
+func repeat(ctx context.Context, interval time.Duration, fn func()) error {
+	for {
+		fn()
+
+		if !sleep(ctx, interval) {
+			return nil
+		}
+	}
+}
+
+// sleep ждет d и сообщает false, если ожидание прервала отмена ctx
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
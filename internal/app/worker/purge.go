@@ -0,0 +1,25 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// PurgeStorage - учетные записи, удаленные пользователями, которые окончательно удаляет Purge
+type PurgeStorage interface {
+	PurgeDeletedUsers(before time.Time) (int64, error)
+}
+
+// Purge раз в interval окончательно удаляет учетные записи, удаленные раньше чем retention назад,
+// вместе с их заказами и списаниями. Работает до отмены ctx.
+func Purge(ctx context.Context, db PurgeStorage, retention, interval time.Duration) error {
+	return repeat(ctx, interval, func() {
+		purged, err := db.PurgeDeletedUsers(time.Now().Add(-retention))
+		if err != nil {
+			log.Print("purge deleted users err: ", err.Error())
+		} else if purged > 0 {
+			log.Printf("purge deleted users: %d", purged)
+		}
+	})
+}
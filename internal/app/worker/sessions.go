@@ -0,0 +1,25 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// SessionStorage - сессии, которые Sessions удаляет по истечении срока
+type SessionStorage interface {
+	DeleteExpiredSessions() (int64, error)
+}
+
+// Sessions раз в interval удаляет истекшие сессии, в том числе анонимные, которые не удаляются
+// при входе пользователя. Работает до отмены ctx.
+func Sessions(ctx context.Context, db SessionStorage, interval time.Duration) error {
+	return repeat(ctx, interval, func() {
+		deleted, err := db.DeleteExpiredSessions()
+		if err != nil {
+			log.Print("delete expired sessions err: ", err.Error())
+		} else if deleted > 0 {
+			log.Printf("delete expired sessions: %d", deleted)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/internal/app/database"
+)
+
+// snapshotCatchUp - за сколько прошедших суток записываются снимки при первом запуске
+// или после долгого простоя
+const snapshotCatchUp = 31
+
+// SnapshotStorage - остатки на конец суток, которые записывает Snapshots
+type SnapshotStorage interface {
+	SnapshotBalances(day time.Time) error
+	LastBalanceSnapshot() (time.Time, error)
+}
+
+// Snapshots раз в interval записывает остатки пользователей на конец завершившихся суток,
+// по которым снимка еще нет. Работает до отмены ctx.
+func Snapshots(ctx context.Context, db SnapshotStorage, interval time.Duration) error {
+	return repeat(ctx, interval, func() {
+		if err := snapshotBalances(db, time.Now()); err != nil {
+			log.Print("balance snapshots err: ", err.Error())
+		}
+	})
+}
+
+// snapshotBalances записывает снимки за сутки после последнего снимка до вчерашних (UTC) включительно
+func snapshotBalances(db SnapshotStorage, now time.Time) error {
+	last, err := db.LastBalanceSnapshot()
+	if err != nil {
+		return err
+	}
+
+	yesterday := database.ReportStart(now, database.ReportByDay).AddDate(0, 0, -1)
+
+	day := yesterday.AddDate(0, 0, 1-snapshotCatchUp)
+	if next := database.ReportStart(last, database.ReportByDay).AddDate(0, 0, 1); next.After(day) {
+		day = next
+	}
+
+	for ; !day.After(yesterday); day = day.AddDate(0, 0, 1) {
+		if err = db.SnapshotBalances(day); err != nil {
+			return err
+		}
+
+		log.Printf("balance snapshot: %s", day.Format("2006-01-02"))
+	}
+
+	return nil
+}
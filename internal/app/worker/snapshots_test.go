@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type snapshotStorage struct {
+	last time.Time
+	days []string
+}
+
+func (s *snapshotStorage) SnapshotBalances(day time.Time) error {
+	s.days = append(s.days, day.Format("2006-01-02"))
+	return nil
+}
+
+func (s *snapshotStorage) LastBalanceSnapshot() (time.Time, error) {
+	return s.last, nil
+}
+
+func TestSnapshotBalances(t *testing.T) {
+	now := time.Date(2026, 10, 15, 1, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		last      time.Time
+		wantFirst string
+		wantLen   int
+	}{
+		{name: "up to date", last: time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC), wantLen: 0},
+		{name: "missed days", last: time.Date(2026, 10, 11, 0, 0, 0, 0, time.UTC), wantFirst: "2026-10-12", wantLen: 3},
+		{name: "first run", wantFirst: "2026-09-14", wantLen: snapshotCatchUp},
+		{name: "long downtime", last: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), wantFirst: "2026-09-14", wantLen: snapshotCatchUp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &snapshotStorage{last: tt.last}
+			if err := snapshotBalances(s, now); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(s.days) != tt.wantLen {
+				t.Fatalf("snapshots = %v, want %d days", s.days, tt.wantLen)
+			}
+			if tt.wantLen == 0 {
+				return
+			}
+			if got := []string{s.days[0], s.days[len(s.days)-1]}; !reflect.DeepEqual(got, []string{tt.wantFirst, "2026-10-14"}) {
+				t.Errorf("snapshots from %s to %s, want from %s to 2026-10-14", got[0], got[1], tt.wantFirst)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Package api - запросы и ответы HTTP API накопительной системы лояльности.
+// Типы общие для сервиса и клиента pkg/client.
+package api
+
+import "time"
+
+// Статусы заказа
+const (
+	StatusNew        = "NEW"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+	// StatusExpired - система расчета не обработала заказ за срок опроса, заказ больше не опрашивается
+	StatusExpired = "EXPIRED"
+)
+
+// Credentials - тело регистрации и входа. TOTP - код второго фактора, если он включен,
+// Email - адрес для уведомлений, только при регистрации.
+type Credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	TOTP     string `json:"totp,omitempty"`
+	Email    string `json:"email,omitempty"`
+}
+
+// Order - заказ пользователя. Accrual - начисление в баллах, есть только у обработанного заказа.
+// PollUntil - до какого момента заказ NEW или PROCESSING ждет расчета, nil - без срока. Seq - порядковый
+// номер заказа на сервере: список отдается по возрастанию Seq, следующая страница - параметр after.
+type Order struct {
+	Seq            int64      `json:"seq,omitempty"`
+	Number         string     `json:"number"`
+	Status         string     `json:"status"`
+	Accrual        float64    `json:"accrual,omitempty"`
+	UploadedAt     time.Time  `json:"uploaded_at"`
+	PollUntil      *time.Time `json:"poll_until,omitempty"`
+	AccrualDelayed bool       `json:"accrual_delayed,omitempty"`
+}
+
+// Backlog - ответ 202 на загрузку нового заказа: место в очереди на расчет, ожидаемое время и адрес,
+// по которому опрашивается статус заказа.
+type Backlog struct {
+	Position int64 `json:"queue_position"`
+	// Deprecated: всегда равен EstimatedProcessingSeconds, будет удален не раньше 2027-04-15.
+	EstimatedSeconds           int64  `json:"estimated_seconds"`
+	EstimatedProcessingSeconds int64  `json:"estimated_processing_seconds"`
+	PollURL                    string `json:"poll_url,omitempty"`
+	AccrualDelayed             bool   `json:"accrual_delayed,omitempty"`
+}
+
+// Результаты загрузки номера из пачки заказов
+const (
+	OrderAccepted = "accepted" // заказ принят к расчету
+	OrderUploaded = "uploaded" // заказ уже загружен этим пользователем
+	OrderConflict = "conflict" // заказ загружен другим пользователем
+	OrderInvalid  = "invalid"  // номер не проходит проверку
+)
+
+// OrderResult - результат загрузки одного номера из пачки заказов
+type OrderResult struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+}
+
+// Quota - ответ 429 на загрузку заказа сверх суточного лимита
+type Quota struct {
+	Limit   int    `json:"limit"`
+	ResetAt string `json:"reset_at"`
+}
+
+// Balance - текущий остаток и сумма списаний за все время
+type Balance struct {
+	Current   float64 `json:"current"`
+	Withdrawn float64 `json:"withdrawn"`
+}
+
+// WithdrawRequest - тело списания баллов в счет заказа
+type WithdrawRequest struct {
+	Order string  `json:"order"`
+	Sum   float64 `json:"sum"`
+}
+
+// Reference - ответ на списание: номер операции, по нему списание находится в журнале
+// и в отложенных списаниях
+type Reference struct {
+	Reference string `json:"reference"`
+}
+
+// Withdrawal - выполненное списание. Seq - порядковый номер списания на сервере: список отдается
+// по возрастанию Seq, следующая страница - параметр after.
+type Withdrawal struct {
+	Seq         int64     `json:"seq,omitempty"`
+	Order       string    `json:"order"`
+	Sum         float64   `json:"sum"`
+	ProcessedAt time.Time `json:"processed_at"`
+	Reference   string    `json:"reference,omitempty"`
+}
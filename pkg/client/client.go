@@ -0,0 +1,216 @@
+// Package client - клиент HTTP API накопительной системы лояльности.
+// Клиент хранит cookie сессии, отправляет CSRF-токен и заголовок Authorization, если сервис
+// выдал токен доступа, поэтому после Register или Login остальные методы работают от имени
+// пользователя.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/pkg/api"
+)
+
+// timeout - ограничение на один запрос по умолчанию
+const timeout = 10 * time.Second
+
+// StatusError - сервис ответил неожиданным статусом. Body - тело ответа, у ошибок проверки
+// это список ошибок по полям.
+type StatusError struct {
+	Method string
+	Path   string
+	Status int
+	Body   []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: status %d", e.Method, e.Path, e.Status)
+}
+
+// Client выполняет запросы от имени одного пользователя
+type Client struct {
+	base string
+	http *http.Client
+
+	mu            sync.Mutex
+	authorization string
+}
+
+// New создает клиент сервиса по адресу base (например, http://localhost:8080).
+// httpClient nil - клиент с таймаутом 10 секунд, своя cookie-банка ставится, если ее нет.
+func New(base string, httpClient *http.Client) (*Client, error) {
+	if _, err := url.Parse(base); err != nil {
+		return nil, err
+	}
+
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: timeout}
+	}
+
+	if httpClient.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		c := *httpClient
+		c.Jar = jar
+		httpClient = &c
+	}
+
+	return &Client{base: strings.TrimRight(base, "/"), http: httpClient}, nil
+}
+
+// Register регистрирует пользователя и входит от его имени
+func (c *Client) Register(ctx context.Context, credentials api.Credentials) error {
+	_, err := c.doJSON(ctx, http.MethodPost, "/api/user/register", credentials, nil, http.StatusOK)
+	return err
+}
+
+// Login входит от имени пользователя
+func (c *Client) Login(ctx context.Context, credentials api.Credentials) error {
+	_, err := c.doJSON(ctx, http.MethodPost, "/api/user/login", credentials, nil, http.StatusOK)
+	return err
+}
+
+// UploadOrder загружает номер заказа на расчет. accepted - заказ новый и поставлен в очередь,
+// false - пользователь уже загружал этот номер.
+func (c *Client) UploadOrder(ctx context.Context, number string) (accepted bool, err error) {
+	status, err := c.do(ctx, http.MethodPost, "/api/user/orders", "text/plain", []byte(number), nil,
+		http.StatusOK, http.StatusAccepted)
+	if err != nil {
+		return false, err
+	}
+
+	return status == http.StatusAccepted, nil
+}
+
+// UploadOrders загружает пачку номеров одним запросом и возвращает результат по каждому номеру
+func (c *Client) UploadOrders(ctx context.Context, numbers []string) ([]api.OrderResult, error) {
+	var results []api.OrderResult
+	if _, err := c.doJSON(ctx, http.MethodPost, "/api/user/orders/batch", numbers, &results,
+		http.StatusOK, http.StatusAccepted); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Orders возвращает заказы пользователя, новые первыми
+func (c *Client) Orders(ctx context.Context) ([]api.Order, error) {
+	var orders []api.Order
+	if _, err := c.do(ctx, http.MethodGet, "/api/user/orders", "", nil, &orders,
+		http.StatusOK, http.StatusNoContent); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// Order возвращает заказ пользователя по номеру, например по poll_url из ответа на загрузку.
+// Чужой или незагруженный заказ - StatusError со статусом 404.
+func (c *Client) Order(ctx context.Context, number string) (api.Order, error) {
+	var order api.Order
+	_, err := c.do(ctx, http.MethodGet, "/api/user/orders/"+url.PathEscape(number), "", nil, &order, http.StatusOK)
+
+	return order, err
+}
+
+// Balance возвращает остаток пользователя
+func (c *Client) Balance(ctx context.Context) (api.Balance, error) {
+	var balance api.Balance
+	_, err := c.do(ctx, http.MethodGet, "/api/user/balance", "", nil, &balance, http.StatusOK)
+
+	return balance, err
+}
+
+// Withdraw списывает баллы в счет заказа. held - списание отложено на проверку и баланс
+// пока не изменился. Нехватка баллов - StatusError со статусом 402.
+func (c *Client) Withdraw(ctx context.Context, request api.WithdrawRequest) (reference api.Reference, held bool, err error) {
+	status, err := c.doJSON(ctx, http.MethodPost, "/api/user/balance/withdraw", request, &reference,
+		http.StatusOK, http.StatusAccepted)
+	if err != nil {
+		return api.Reference{}, false, err
+	}
+
+	return reference, status == http.StatusAccepted, nil
+}
+
+func (c *Client) doJSON(ctx context.Context, method, path string, request, response any, statuses ...int) (int, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.do(ctx, method, path, "application/json", body, response, statuses...)
+}
+
+// do выполняет запрос и разбирает тело ответа в response, если статус из statuses и тело есть
+func (c *Client) do(ctx context.Context, method, path, contentType string, body []byte, response any, statuses ...int) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	c.mu.Lock()
+	authorization := c.authorization
+	c.mu.Unlock()
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	// двойная отправка CSRF-токена, выданного сервисом в cookie
+	for _, cookie := range c.http.Jar.Cookies(req.URL) {
+		if cookie.Name == "csrf_token" {
+			req.Header.Set("X-CSRF-Token", cookie.Value)
+		}
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if auth := resp.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		c.mu.Lock()
+		c.authorization = auth
+		c.mu.Unlock()
+	}
+
+	for _, status := range statuses {
+		if resp.StatusCode != status {
+			continue
+		}
+
+		if response != nil && len(b) != 0 {
+			if err = json.Unmarshal(b, response); err != nil {
+				return 0, fmt.Errorf("%s %s: %w", method, path, err)
+			}
+		}
+
+		return resp.StatusCode, nil
+	}
+
+	return resp.StatusCode, &StatusError{Method: method, Path: path, Status: resp.StatusCode, Body: b}
+}
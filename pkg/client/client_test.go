@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chazari-x/yandex-pr-diplom/pkg/api"
+)
+
+// stub - сервис с одним пользователем: выдает cookie сессии, CSRF-токен и токен доступа
+// при регистрации и требует их в остальных запросах
+func stub(t *testing.T) *httptest.Server {
+	uploadedAt := time.Date(2026, 10, 15, 12, 30, 5, 0, time.UTC)
+	current := 500.0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/user/register", func(w http.ResponseWriter, r *http.Request) {
+		var credentials api.Credentials
+		if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil || credentials.Login != "user" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", Path: "/"})
+		http.SetCookie(w, &http.Cookie{Name: "csrf_token", Value: "t1", Path: "/"})
+		w.Header().Set("Authorization", "Bearer a1")
+	})
+
+	authorized := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			session, err := r.Cookie("session")
+			if err != nil || session.Value != "s1" || r.Header.Get("Authorization") != "Bearer a1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.Method == http.MethodPost && r.Header.Get("X-CSRF-Token") != "t1" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			next(w, r)
+		}
+	}
+
+	mux.HandleFunc("POST /api/user/orders", authorized(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		switch string(b) {
+		case "2377225624":
+			w.WriteHeader(http.StatusAccepted)
+		case "49927398716":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+	}))
+	mux.HandleFunc("GET /api/user/orders", authorized(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"number":"2377225624","status":"PROCESSED","accrual":500,"uploaded_at":"` +
+			uploadedAt.Format(time.RFC3339) + `"}]`))
+	}))
+	mux.HandleFunc("GET /api/user/orders/{number}", authorized(func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("number") != "2377225624" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"number":"2377225624","status":"PROCESSING","uploaded_at":"` +
+			uploadedAt.Format(time.RFC3339) + `"}`))
+	}))
+	mux.HandleFunc("GET /api/user/balance", authorized(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(api.Balance{Current: current})
+	}))
+	mux.HandleFunc("POST /api/user/balance/withdraw", authorized(func(w http.ResponseWriter, r *http.Request) {
+		var request api.WithdrawRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case request.Sum > current:
+			w.WriteHeader(http.StatusPaymentRequired)
+			return
+		case request.Sum > 100:
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(api.Reference{Reference: "held"})
+			return
+		}
+
+		current -= request.Sum
+		_ = json.NewEncoder(w).Encode(api.Reference{Reference: "ref"})
+	}))
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	srv := stub(t)
+
+	c, err := New(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = c.Balance(ctx); !isStatus(err, http.StatusUnauthorized) {
+		t.Fatalf("Balance() before Register err = %v, want status %d", err, http.StatusUnauthorized)
+	}
+
+	if err = c.Register(ctx, api.Credentials{Login: "user", Password: "password"}); err != nil {
+		t.Fatalf("Register() err = %v", err)
+	}
+
+	t.Run("Загрузка заказа", func(t *testing.T) {
+		for number, want := range map[string]bool{"2377225624": true, "49927398716": false} {
+			accepted, err := c.UploadOrder(ctx, number)
+			if err != nil || accepted != want {
+				t.Errorf("UploadOrder(%s) = %v, %v, want %v", number, accepted, err, want)
+			}
+		}
+
+		if _, err := c.UploadOrder(ctx, "123"); !isStatus(err, http.StatusUnprocessableEntity) {
+			t.Errorf("UploadOrder(123) err = %v, want status %d", err, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("Заказы", func(t *testing.T) {
+		orders, err := c.Orders(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []api.Order{{Number: "2377225624", Status: api.StatusProcessed, Accrual: 500,
+			UploadedAt: time.Date(2026, 10, 15, 12, 30, 5, 0, time.UTC)}}
+		if !reflect.DeepEqual(orders, want) {
+			t.Errorf("Orders() = %v, want %v", orders, want)
+		}
+
+		order, err := c.Order(ctx, "2377225624")
+		if err != nil || order.Status != api.StatusProcessing {
+			t.Errorf("Order() = %v, %v, want PROCESSING", order, err)
+		}
+
+		if _, err = c.Order(ctx, "49927398716"); !isStatus(err, http.StatusNotFound) {
+			t.Errorf("Order() err = %v, want status %d", err, http.StatusNotFound)
+		}
+	})
+
+	t.Run("Списание", func(t *testing.T) {
+		reference, held, err := c.Withdraw(ctx, api.WithdrawRequest{Order: "2377225624", Sum: 100})
+		if err != nil || held || reference.Reference != "ref" {
+			t.Fatalf("Withdraw() = %v, %v, %v, want ref", reference, held, err)
+		}
+
+		reference, held, err = c.Withdraw(ctx, api.WithdrawRequest{Order: "2377225624", Sum: 200})
+		if err != nil || !held || reference.Reference != "held" {
+			t.Fatalf("Withdraw() = %v, %v, %v, want held", reference, held, err)
+		}
+
+		if _, _, err = c.Withdraw(ctx, api.WithdrawRequest{Order: "2377225624", Sum: 1000}); !isStatus(err, http.StatusPaymentRequired) {
+			t.Errorf("Withdraw() err = %v, want status %d", err, http.StatusPaymentRequired)
+		}
+
+		balance, err := c.Balance(ctx)
+		if err != nil || balance.Current != 400 {
+			t.Errorf("Balance() = %v, %v, want current 400", balance, err)
+		}
+	})
+}
+
+func isStatus(err error, status int) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.Status == status
+}